@@ -0,0 +1,43 @@
+package client
+
+import "net/http"
+
+// Authenticator adds credentials to the requests sent to the API, it's
+// called before every attempt so that short lived tokens can be refreshed
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthenticatorFunc is a function used as an Authenticator
+type AuthenticatorFunc func(req *http.Request) error
+
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken authenticates requests with an OAuth 2.0 bearer token
+func BearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		return nil
+	})
+}
+
+// BasicAuth authenticates requests with a username and a password
+func BasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+
+		return nil
+	})
+}
+
+// APIKey authenticates requests with a key sent in header
+func APIKey(header, key string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set(header, key)
+
+		return nil
+	})
+}
@@ -0,0 +1,278 @@
+// Package client is a typed client of the User API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// basePath is where the API is served from
+const basePath = "/api/v1"
+
+// Client calls the User API served at a base URL. It's safe for concurrent
+// use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	auth       Authenticator
+	actor      string
+	retry      RetryPolicy
+}
+
+// Option customizes the client built by New
+type Option func(*Client)
+
+// WithHTTPClient sets the client requests are sent with,
+// http.DefaultClient is used without it
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAuth sets how requests are authenticated
+func WithAuth(auth Authenticator) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithActor sets who the changes are made on behalf of, it's recorded in
+// the audit log as the claimed actor. The actor is who the server
+// authenticates the requests as.
+func WithActor(actor string) Option {
+	return func(c *Client) {
+		c.actor = actor
+	}
+}
+
+// WithRetryPolicy sets how failed requests are retried, DefaultRetryPolicy
+// is used without it
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// New returns a client of the API served at baseURL, e.g.
+// "http://localhost:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: expected an http or https URL", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// RetryPolicy tells how requests answered with a 5xx status, or failing
+// before getting an answer, are retried. Only the requests that can be
+// applied twice are retried, unless the server was unavailable: GET, PUT
+// and DELETE requests, and any request answered with 503.
+type RetryPolicy struct {
+	// MaxRetries is how many times a request is retried, 0 disables retries
+	MaxRetries int
+	// MinBackoff is the wait before the first retry, it doubles with every
+	// retry up to MaxBackoff. A random jitter of up to half the wait is
+	// added.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries 3 times, waiting from 100ms to 2s
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+}
+
+// backoff returns the wait before the retry numbered attempt, from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.MinBackoff << (attempt - 1)
+	if wait > p.MaxBackoff || wait <= 0 {
+		wait = p.MaxBackoff
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	return wait + rand.N(wait/2+1)
+}
+
+func retryable(method string, resp *http.Response) bool {
+	if resp != nil && resp.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+
+	if resp != nil && resp.StatusCode < http.StatusInternalServerError {
+		return false
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// request describes a call to the API
+type request struct {
+	method string
+	// path is relative to basePath and escaped
+	path   string
+	query  url.Values
+	header http.Header
+	// body is sent as is, it's only read once so the request isn't retried
+	body io.Reader
+	// payload is sent as JSON, as application/json unless header tells
+	// otherwise
+	payload any
+}
+
+// do sends req, retrying it as the policy tells, and returns the response
+// once its status is a success. Any other status is returned as an *Error.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	var (
+		payload []byte
+		err     error
+	)
+
+	if req.payload != nil {
+		if payload, err = json.Marshal(req.payload); err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := c.newRequest(ctx, req, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+
+		canRetry := attempt < c.retry.MaxRetries && req.body == nil && retryable(req.method, resp)
+
+		if err != nil && !canRetry {
+			return nil, err
+		}
+
+		if err == nil {
+			// read either way, so that the connection can be reused
+			if apiErr := newError(resp); !canRetry {
+				return nil, apiErr
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.retry.backoff(attempt + 1)):
+		}
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, req request, payload []byte) (*http.Request, error) {
+	// path is escaped already, names in it may hold slashes
+	u := *c.baseURL
+	u.RawPath = c.baseURL.EscapedPath() + basePath + req.path
+	u.RawQuery = req.query.Encode()
+
+	var err error
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, err
+	}
+
+	body := req.body
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+
+	if payload != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	if httpReq.Header.Get("Accept") == "" {
+		httpReq.Header.Set("Accept", "application/json")
+	}
+
+	if c.actor != "" {
+		httpReq.Header.Set("X-Actor", c.actor)
+	}
+
+	if c.auth != nil {
+		if err := c.auth.Authenticate(httpReq); err != nil {
+			return nil, fmt.Errorf("failed to authenticate request: %w", err)
+		}
+	}
+
+	return httpReq, nil
+}
+
+// call sends req and decodes the JSON response into out, unless it's nil
+func (c *Client) call(ctx context.Context, req request, out any) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	return nil
+}
+
+// ifMatch returns the header expecting a user at version, none when it's 0
+func ifMatch(version int64) http.Header {
+	if version == 0 {
+		return nil
+	}
+
+	return http.Header{"If-Match": {strconv.Quote(strconv.FormatInt(version, 10))}}
+}
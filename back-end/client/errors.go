@@ -0,0 +1,84 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// maxErrorBody bounds how much of an error response is read
+const maxErrorBody = 64 << 10
+
+// Error is a response of the API with an error status. It unwraps to the
+// repository error the server reported, so that callers can check for
+// model.ErrUserNotFound or model.ErrAlreadyExist with errors.Is.
+type Error struct {
+	StatusCode int
+	// Message is the message of the body, or the body itself when it's not
+	// an error message
+	Message string
+
+	err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("users api: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// repositoryErrors are the errors the API reports with their message, along
+// with the status it reports them with
+var repositoryErrors = []struct {
+	status int
+	err    error
+}{
+	{http.StatusNotFound, model.ErrUserNotFound},
+	{http.StatusNotFound, model.ErrVersionNotFound},
+	{http.StatusNotFound, model.ErrWebhookNotFound},
+	{http.StatusNotFound, model.ErrDeliveryNotFound},
+	{http.StatusConflict, model.ErrAlreadyExist},
+	{http.StatusConflict, model.ErrEmailInUse},
+	{http.StatusPreconditionFailed, model.ErrVersionConflict},
+	{http.StatusBadRequest, model.ErrInvalidSort},
+}
+
+// newError reads the echo.HTTPError body of resp and closes it
+func newError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+
+	var httpErr struct {
+		Message any `json:"message"`
+	}
+
+	if json.Unmarshal(body, &httpErr) == nil && httpErr.Message != nil {
+		e.Message = fmt.Sprint(httpErr.Message)
+	}
+
+	for _, known := range repositoryErrors {
+		if known.status == e.StatusCode && strings.HasPrefix(e.Message, known.err.Error()) {
+			e.err = known.err
+
+			break
+		}
+	}
+
+	// a version conflict is reported as such by every endpoint, whatever the
+	// message is
+	if e.err == nil && e.StatusCode == http.StatusPreconditionFailed {
+		e.err = model.ErrVersionConflict
+	}
+
+	return e
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// EventReset is the type of the event sent instead of the changes a watcher
+// missed when they're no longer kept, the watcher has to reload the users
+const EventReset = "reset"
+
+// maxEventSize bounds the size of an event of the stream
+const maxEventSize = 1 << 20
+
+// WatchOptions tells which changes are watched
+type WatchOptions struct {
+	// Department only watches the changes of users in this department
+	Department string
+	// LastEventID resumes the stream after this event, only new changes are
+	// watched without it. A reset event comes first when the changes after
+	// it are no longer kept.
+	LastEventID int64
+}
+
+// Watch calls fn with every change made to users, as streamed by the
+// /users/events endpoint. When the stream is closed, Watch reconnects and
+// resumes after the last event it got. It returns when ctx is done or at the
+// first error fn returns.
+func (c *Client) Watch(ctx context.Context, opts WatchOptions, fn func(model.Event) error) error {
+	lastEventID := opts.LastEventID
+
+	for attempt := 1; ; attempt++ {
+		got, err := c.watch(ctx, opts.Department, &lastEventID, fn)
+		if err != nil {
+			return err
+		}
+
+		if got {
+			attempt = 1
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.retry.backoff(attempt)):
+		}
+	}
+}
+
+// watch streams the events after lastEventID to fn until the stream is
+// closed, it tells whether any event was streamed
+func (c *Client) watch(ctx context.Context, department string, lastEventID *int64, fn func(model.Event) error) (bool, error) {
+	query := url.Values{}
+	if department != "" {
+		query.Set("department", department)
+	}
+
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if *lastEventID > 0 {
+		header.Set("Last-Event-ID", strconv.FormatInt(*lastEventID, 10))
+	}
+
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "/users/events", query: query, header: header})
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	got := false
+
+	err = readEvents(resp.Body, func(id, eventType, data string) error {
+		got = true
+
+		event := model.Event{Type: eventType}
+		if eventType != EventReset {
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				return fmt.Errorf("failed to decode event %s: %w", id, err)
+			}
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+
+		if id != "" {
+			*lastEventID, _ = strconv.ParseInt(id, 10, 64)
+		}
+
+		return nil
+	})
+
+	if ctx.Err() != nil {
+		return got, ctx.Err()
+	}
+
+	return got, err
+}
+
+// readEvents parses the Server-Sent Events read from r and calls fn with
+// each of them, until r ends
+func readEvents(r io.Reader, fn func(id, eventType, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+
+	var (
+		id, eventType string
+		data          []string
+	)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if len(data) > 0 {
+				if err := fn(id, eventType, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+
+			id, eventType, data = "", "", nil
+
+			continue
+		}
+
+		// comments keep the stream alive
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			eventType = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	// a stream that breaks is resumed like one that ends, unless it holds
+	// an event too big to ever be read
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return fmt.Errorf("failed to read event: %w", scanner.Err())
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// ListOptions filters, sorts and pages a users listing, zero fields are
+// left to the server defaults
+type ListOptions struct {
+	Limit       int
+	Offset      int
+	Status      string
+	Department  string
+	EmailDomain string
+	// Sort is a comma separated list of columns, '-' prefix for descending,
+	// e.g. "last_name,-user_id"
+	Sort string
+	// Cursor continues a listing from the NextCursor of a page
+	Cursor string
+	// AsOf lists the users as they were at this time
+	AsOf time.Time
+}
+
+func (opts ListOptions) query() url.Values {
+	query := url.Values{}
+
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+
+	for key, value := range map[string]string{
+		"user_status":  opts.Status,
+		"department":   opts.Department,
+		"email_domain": opts.EmailDomain,
+		"sort":         opts.Sort,
+		"cursor":       opts.Cursor,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	if !opts.AsOf.IsZero() {
+		query.Set("as_of", opts.AsOf.Format(time.RFC3339Nano))
+	}
+
+	return query
+}
+
+// ListUsers gets a page of users
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) (*model.UserPage, error) {
+	var page model.UserPage
+
+	err := c.call(ctx, request{method: http.MethodGet, path: "/users", query: opts.query()}, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// ListTrash gets a page of the users in the trash
+func (c *Client) ListTrash(ctx context.Context, opts ListOptions) (*model.UserPage, error) {
+	var page model.UserPage
+
+	err := c.call(ctx, request{method: http.MethodGet, path: "/users/trash", query: opts.query()}, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// EachUser calls fn with every user listed with opts, walking the pages by
+// cursor. It stops at the first error fn returns.
+func (c *Client) EachUser(ctx context.Context, opts ListOptions, fn func(model.User) error) error {
+	for {
+		page, err := c.ListUsers(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range page.Items {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+
+		opts.Offset, opts.Cursor = 0, page.NextCursor
+	}
+}
+
+// GetUser gets a user by id
+func (c *Client) GetUser(ctx context.Context, id int64) (*model.User, error) {
+	return c.user(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/users/%d", id)})
+}
+
+// GetUserByUserName gets a user by username, regardless of case
+func (c *Client) GetUserByUserName(ctx context.Context, userName string) (*model.User, error) {
+	return c.user(ctx, request{method: http.MethodGet, path: "/users/by-username/" + url.PathEscape(userName)})
+}
+
+// CreateUser creates user and returns it with its id and version
+func (c *Client) CreateUser(ctx context.Context, user model.User) (*model.User, error) {
+	return c.user(ctx, request{method: http.MethodPost, path: "/users", payload: user})
+}
+
+// UpdateUser replaces the user with id. When version isn't 0 the update
+// fails with model.ErrVersionConflict unless the user is at that version.
+func (c *Client) UpdateUser(ctx context.Context, id int64, user model.User, version int64) (*model.User, error) {
+	return c.user(ctx, request{
+		method:  http.MethodPut,
+		path:    fmt.Sprintf("/users/%d", id),
+		header:  ifMatch(version),
+		payload: user,
+	})
+}
+
+// MergePatchUser changes the fields of the user with id that are set in
+// patch, a JSON Merge Patch (RFC 7396) such as map[string]any{"department":
+// "Sales"}. version is checked like by UpdateUser.
+func (c *Client) MergePatchUser(ctx context.Context, id int64, patch any, version int64) (*model.User, error) {
+	return c.patchUser(ctx, id, "application/merge-patch+json", patch, version)
+}
+
+// PatchOperation is an operation of a JSON Patch (RFC 6902)
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// JSONPatchUser applies the operations of a JSON Patch to the user with id.
+// version is checked like by UpdateUser.
+func (c *Client) JSONPatchUser(ctx context.Context, id int64, operations []PatchOperation, version int64) (*model.User, error) {
+	return c.patchUser(ctx, id, "application/json-patch+json", operations, version)
+}
+
+func (c *Client) patchUser(ctx context.Context, id int64, contentType string, patch any, version int64) (*model.User, error) {
+	header := ifMatch(version)
+	if header == nil {
+		header = http.Header{}
+	}
+
+	header.Set("Content-Type", contentType)
+
+	return c.user(ctx, request{
+		method:  http.MethodPatch,
+		path:    fmt.Sprintf("/users/%d", id),
+		header:  header,
+		payload: patch,
+	})
+}
+
+// DeleteUser moves the user with id to the trash. version is checked like
+// by UpdateUser.
+func (c *Client) DeleteUser(ctx context.Context, id int64, version int64) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/users/%d", id), header: ifMatch(version)}, nil)
+}
+
+// RestoreUser brings the user with id back from the trash
+func (c *Client) RestoreUser(ctx context.Context, id int64) (*model.User, error) {
+	return c.user(ctx, request{method: http.MethodPost, path: fmt.Sprintf("/users/%d/restore", id)})
+}
+
+// RevertUser restores the fields the user with id had at toVersion, as a
+// new version. version is checked like by UpdateUser.
+func (c *Client) RevertUser(ctx context.Context, id int64, toVersion int64, version int64) (*model.User, error) {
+	return c.user(ctx, request{
+		method: http.MethodPost,
+		path:   fmt.Sprintf("/users/%d/revert", id),
+		query:  url.Values{"to_version": {strconv.FormatInt(toVersion, 10)}},
+		header: ifMatch(version),
+	})
+}
+
+func (c *Client) user(ctx context.Context, req request) (*model.User, error) {
+	var user model.User
+	if err := c.call(ctx, req, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Batch applies operations in order. An atomic batch applies every
+// operation or none of them.
+func (c *Client) Batch(ctx context.Context, operations []model.BatchOperation, atomic bool) (*model.BatchResponse, error) {
+	var resp model.BatchResponse
+
+	err := c.call(ctx, request{
+		method:  http.MethodPost,
+		path:    "/users:batch",
+		payload: model.BatchRequest{Atomic: atomic, Operations: operations},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ImportOptions tells how a CSV file is imported
+type ImportOptions struct {
+	// Mapping maps columns to user fields, columns named after a field are
+	// mapped to it anyway
+	Mapping map[string]string
+	// DryRun reports what the import would do without creating any user,
+	// for files of up to 10000 rows
+	DryRun bool
+}
+
+// ImportUsers creates a user from every row of the CSV file read from r.
+// The file is streamed, so the request isn't retried.
+func (c *Client) ImportUsers(ctx context.Context, r io.Reader, opts ImportOptions) (*model.ImportReport, error) {
+	query := url.Values{}
+
+	for column, field := range opts.Mapping {
+		query.Add("map", column+"="+field)
+	}
+
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+
+	var report model.ImportReport
+
+	err := c.call(ctx, request{
+		method: http.MethodPost,
+		path:   "/users/import",
+		query:  query,
+		header: http.Header{"Content-Type": {"text/csv"}},
+		body:   r,
+	}, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ExportUsers streams the users listed with opts as a file of format, one
+// of text/csv, application/x-ndjson or the XLSX media type. The whole
+// listing is exported unless opts.Limit is set. The caller closes the file.
+func (c *Client) ExportUsers(ctx context.Context, format string, opts ListOptions) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/users/export",
+		query:  opts.query(),
+		header: http.Header{"Accept": {format}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// AuditFilter narrows the audit log entries, zero fields are ignored.
+// Entries come oldest first, After continues the log after an entry id.
+type AuditFilter struct {
+	// Actor matches who the server authenticated the changes as,
+	// ClaimedActor who they were claimed to be made on behalf of
+	Actor        string
+	ClaimedActor string
+	Since        time.Time
+	After        int64
+	Limit        int
+}
+
+func (f AuditFilter) query() url.Values {
+	query := url.Values{}
+
+	if f.Actor != "" {
+		query.Set("actor", f.Actor)
+	}
+
+	if f.ClaimedActor != "" {
+		query.Set("claimed_actor", f.ClaimedActor)
+	}
+
+	if !f.Since.IsZero() {
+		query.Set("since", f.Since.Format(time.RFC3339))
+	}
+
+	if f.After > 0 {
+		query.Set("after", strconv.FormatInt(f.After, 10))
+	}
+
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+
+	return query
+}
+
+// UserHistory gets the audit log of the user with id, the actor and since
+// of filter are ignored
+func (c *Client) UserHistory(ctx context.Context, id int64, filter AuditFilter) (*model.AuditPage, error) {
+	filter.Actor, filter.Since = "", time.Time{}
+
+	return c.audit(ctx, fmt.Sprintf("/users/%d/history", id), filter)
+}
+
+// Audit gets the audit log of every user
+func (c *Client) Audit(ctx context.Context, filter AuditFilter) (*model.AuditPage, error) {
+	return c.audit(ctx, "/audit", filter)
+}
+
+func (c *Client) audit(ctx context.Context, path string, filter AuditFilter) (*model.AuditPage, error) {
+	var page model.AuditPage
+	if err := c.call(ctx, request{method: http.MethodGet, path: path, query: filter.query()}, &page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
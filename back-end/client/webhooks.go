@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// ListWebhooks gets every webhook, their secrets are left out
+func (c *Client) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	var list model.WebhookList
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/webhooks"}, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// CreateWebhook subscribes webhook to user events. The webhook returned holds
+// its secret, which is generated when it's not set.
+func (c *Client) CreateWebhook(ctx context.Context, webhook model.Webhook) (*model.Webhook, error) {
+	return c.webhook(ctx, request{method: http.MethodPost, path: "/webhooks", payload: webhook})
+}
+
+// GetWebhook gets a webhook by id, its secret is left out
+func (c *Client) GetWebhook(ctx context.Context, id int64) (*model.Webhook, error) {
+	return c.webhook(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/webhooks/%d", id)})
+}
+
+// DeleteWebhook deletes the webhook with id along with its deliveries
+func (c *Client) DeleteWebhook(ctx context.Context, id int64) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/webhooks/%d", id)}, nil)
+}
+
+func (c *Client) webhook(ctx context.Context, req request) (*model.Webhook, error) {
+	var webhook model.Webhook
+	if err := c.call(ctx, req, &webhook); err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// WebhookDeliveries gets the deliveries of the webhook with id, newest first.
+// status is one of the model.Delivery* states, every delivery is returned
+// when it's empty.
+func (c *Client) WebhookDeliveries(ctx context.Context, id int64, status string) ([]model.Delivery, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	return c.deliveries(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/webhooks/%d/deliveries", id), query: query})
+}
+
+// ReplayDelivery sends the delivery with deliveryID of the webhook with id
+// again
+func (c *Client) ReplayDelivery(ctx context.Context, id int64, deliveryID int64) (*model.Delivery, error) {
+	var delivery model.Delivery
+
+	err := c.call(ctx, request{
+		method: http.MethodPost,
+		path:   fmt.Sprintf("/webhooks/%d/deliveries/%d/replay", id, deliveryID),
+	}, &delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// ReplayFailedDeliveries sends every failed delivery of the webhook with id
+// again and returns them
+func (c *Client) ReplayFailedDeliveries(ctx context.Context, id int64) ([]model.Delivery, error) {
+	return c.deliveries(ctx, request{method: http.MethodPost, path: fmt.Sprintf("/webhooks/%d/deliveries/replay", id)})
+}
+
+func (c *Client) deliveries(ctx context.Context, req request) ([]model.Delivery, error) {
+	var list model.DeliveryList
+	if err := c.call(ctx, req, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
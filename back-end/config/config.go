@@ -1,8 +1,11 @@
 package config
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -10,12 +13,70 @@ import (
 type Config struct {
 	Server   *Server
 	Database *Database
+	Auth     *Auth
+	Trash    *Trash
+	Events   *Events
 }
 
 type Server struct {
-	Port string
+	Port         string
+	CursorSecret string
+	// GRPCPort serves the gRPC UserService when set
+	GRPCPort string
+	// ValidateRequests and ValidateResponses check the REST API traffic
+	// against its OpenAPI spec
+	ValidateRequests  bool
+	ValidateResponses bool
 }
 
+// Auth holds the API tokens the callers authenticate with, by the identity
+// recorded in the audit log for their changes. It's read from AUTH_TOKENS,
+// e.g. "alice:s3cret,hr-sync:t0ken".
+type Auth struct {
+	Tokens map[string]string
+}
+
+// Identity returns who the bearer token of an Authorization header value
+// authenticates, "" when it's missing or unknown
+func (a *Auth) Identity(authorization string) string {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+
+	var identity string
+
+	// every token is compared so that the time taken tells nothing
+	for name, known := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			identity = name
+		}
+	}
+
+	return identity
+}
+
+// Trash configures how long deleted users are kept before being purged
+type Trash struct {
+	Retention time.Duration
+}
+
+// defaultTrashRetention is used when TRASH_RETENTION isn't set
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// Events configures where the user events are delivered: "stdout",
+// "file:<path>" or "none", and how often the outbox is polled
+type Events struct {
+	Sink         string
+	PollInterval time.Duration
+}
+
+// Defaults used when EVENTS_SINK and EVENTS_POLL_INTERVAL aren't set
+const (
+	defaultEventsSink         = "stdout"
+	defaultEventsPollInterval = time.Second
+)
+
 type Database struct {
 	Driver   string
 	Host     string
@@ -34,9 +95,62 @@ func Load(envFile string) (*Config, error) {
 		return nil, err
 	}
 
+	retention := defaultTrashRetention
+
+	if env := os.Getenv("TRASH_RETENTION"); env != "" {
+		var err error
+		if retention, err = time.ParseDuration(env); err != nil || retention <= 0 {
+			return nil, fmt.Errorf("invalid TRASH_RETENTION %q: expected a positive duration", env)
+		}
+	}
+
+	sink := defaultEventsSink
+	if env := os.Getenv("EVENTS_SINK"); env != "" {
+		sink = env
+	}
+
+	pollInterval := defaultEventsPollInterval
+
+	if env := os.Getenv("EVENTS_POLL_INTERVAL"); env != "" {
+		var err error
+		if pollInterval, err = time.ParseDuration(env); err != nil || pollInterval <= 0 {
+			return nil, fmt.Errorf("invalid EVENTS_POLL_INTERVAL %q: expected a positive duration", env)
+		}
+	}
+
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort != "" && grpcPort == os.Getenv("SERVER_PORT") {
+		return nil, fmt.Errorf("invalid GRPC_PORT %q: expected a port other than SERVER_PORT", grpcPort)
+	}
+
+	tokens, err := parseAuthTokens(os.Getenv("AUTH_TOKENS"))
+	if err != nil {
+		return nil, err
+	}
+
+	var validateRequests, validateResponses bool
+
+	// OPENAPI_VALIDATION lists what is validated, e.g. "requests,responses"
+	if env := os.Getenv("OPENAPI_VALIDATION"); env != "" {
+		for _, checked := range strings.Split(env, ",") {
+			switch strings.TrimSpace(checked) {
+			case "requests":
+				validateRequests = true
+			case "responses":
+				validateResponses = true
+			default:
+				return nil, fmt.Errorf("invalid OPENAPI_VALIDATION %q: expected requests, responses or both", env)
+			}
+		}
+	}
+
 	return &Config{
 		Server: &Server{
-			Port: os.Getenv("SERVER_PORT"),
+			Port:              os.Getenv("SERVER_PORT"),
+			CursorSecret:      os.Getenv("CURSOR_SECRET"),
+			GRPCPort:          grpcPort,
+			ValidateRequests:  validateRequests,
+			ValidateResponses: validateResponses,
 		},
 		Database: &Database{
 			Driver:   os.Getenv("DB_DRIVER"),
@@ -46,15 +160,36 @@ func Load(envFile string) (*Config, error) {
 			Name:     os.Getenv("DB_NAME"),
 			SSLMode:  os.Getenv("DB_SSLMODE"),
 		},
+		Auth: &Auth{
+			Tokens: tokens,
+		},
+		Trash: &Trash{
+			Retention: retention,
+		},
+		Events: &Events{
+			Sink:         sink,
+			PollInterval: pollInterval,
+		},
 	}, nil
 }
 
 func getMissingEnvs() error {
 	var (
 		missingEnvs []string
-		envs        = []string{"SERVER_PORT", "DB_DRIVER", "DB_HOST", "DB_USERNAME", "DB_PASSWORD", "DB_NAME", "DB_SSLMODE"}
+		envs        = []string{"SERVER_PORT", "DB_DRIVER"}
 	)
 
+	switch os.Getenv("DB_DRIVER") {
+	case "memory":
+		// the in-memory storage doesn't connect anywhere, nor keeps an audit
+		// log worth authenticating
+	case "sqlite":
+		// the database is the file named by DB_NAME
+		envs = append(envs, "DB_NAME", "AUTH_TOKENS")
+	default:
+		envs = append(envs, "DB_HOST", "DB_USERNAME", "DB_PASSWORD", "DB_NAME", "DB_SSLMODE", "AUTH_TOKENS")
+	}
+
 	for _, env := range envs {
 		if val := os.Getenv(env); len(val) == 0 {
 			missingEnvs = append(missingEnvs, env)
@@ -67,3 +202,33 @@ func getMissingEnvs() error {
 
 	return nil
 }
+
+// parseAuthTokens parses the comma separated name:token pairs of AUTH_TOKENS
+func parseAuthTokens(env string) (map[string]string, error) {
+	tokens := map[string]string{}
+
+	if env == "" {
+		return tokens, nil
+	}
+
+	for _, pair := range strings.Split(env, ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid AUTH_TOKENS: expected comma separated name:token pairs")
+		}
+
+		if _, ok := tokens[name]; ok {
+			return nil, fmt.Errorf("invalid AUTH_TOKENS: %q is given more than one token", name)
+		}
+
+		for other, known := range tokens {
+			if known == token {
+				return nil, fmt.Errorf("invalid AUTH_TOKENS: %q and %q share a token", other, name)
+			}
+		}
+
+		tokens[name] = token
+	}
+
+	return tokens, nil
+}
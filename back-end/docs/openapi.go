@@ -0,0 +1,107 @@
+package docs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/swaggo/swag"
+)
+
+// OpenAPIInstanceName is the swag instance serving the OpenAPI 3 document,
+// for the Swagger UI
+const OpenAPIInstanceName = "openapi"
+
+// OpenAPI returns the spec of the API as an OpenAPI 3 document. It's
+// converted from the Swagger 2.0 document swag generates from the handler
+// annotations, with the servers made relative so that it's valid on any host.
+var OpenAPI = sync.OnceValues(func() (*openapi3.T, error) {
+	// the converter misses the references held by additionalProperties, they
+	// are pointed at the OpenAPI 3 schemas beforehand
+	swagger := strings.ReplaceAll(SwaggerInfo.ReadDoc(), `"#/definitions/`, `"#/components/schemas/`)
+
+	var doc2 openapi2.T
+	if err := json.Unmarshal([]byte(swagger), &doc2); err != nil {
+		return nil, fmt.Errorf("failed to parse the Swagger document: %w", err)
+	}
+
+	doc, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the Swagger document: %w", err)
+	}
+
+	doc.Servers = openapi3.Servers{{URL: SwaggerInfo.BasePath}}
+
+	// Swagger 2.0 has a single schema for every media type of a request body
+	// and a single list of media types for every response
+	for _, path := range doc.Paths.Map() {
+		for _, operation := range path.Operations() {
+			if body := operation.RequestBody; body != nil && body.Value.Content.Get(jsonPatchMIME) != nil {
+				body.Value.Content[jsonPatchMIME] = openapi3.NewMediaType().WithSchema(jsonPatchSchema)
+			}
+
+			for _, response := range operation.Responses.Map() {
+				jsonErrorContent(response.Value)
+			}
+		}
+	}
+
+	return doc, nil
+})
+
+const jsonPatchMIME = "application/json-patch+json"
+
+// jsonPatchSchema is a JSON Patch (RFC 6902) document
+var jsonPatchSchema = openapi3.NewArraySchema().WithItems(&openapi3.Schema{
+	Type:     &openapi3.Types{openapi3.TypeObject},
+	Required: []string{"op", "path"},
+	Properties: openapi3.Schemas{
+		"op":    openapi3.NewStringSchema().WithEnum("add", "remove", "replace", "move", "copy", "test").NewRef(),
+		"path":  openapi3.NewStringSchema().NewRef(),
+		"from":  openapi3.NewStringSchema().NewRef(),
+		"value": openapi3.NewSchema().NewRef(),
+	},
+})
+
+// httpErrorRef is the schema of the error bodies
+const httpErrorRef = "#/components/schemas/echo.HTTPError"
+
+// jsonErrorContent makes response an application/json one when it's an
+// error, whatever the operation produces otherwise
+func jsonErrorContent(response *openapi3.Response) {
+	for _, mediaType := range response.Content {
+		if mediaType.Schema != nil && mediaType.Schema.Ref == httpErrorRef {
+			response.Content = openapi3.NewContentWithJSONSchemaRef(mediaType.Schema)
+
+			return
+		}
+	}
+}
+
+// OpenAPIJSON returns the OpenAPI 3 document served to the Swagger UI
+var OpenAPIJSON = sync.OnceValues(func() ([]byte, error) {
+	doc, err := OpenAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.MarshalJSON()
+})
+
+// openAPIDoc hands the OpenAPI 3 document to swag. swag has no way to report
+// an error, the router refuses to start when the document can't be built.
+type openAPIDoc struct{}
+
+func (openAPIDoc) ReadDoc() string {
+	body, _ := OpenAPIJSON()
+
+	return string(body)
+}
+
+func init() {
+	swag.Register(OpenAPIInstanceName, openAPIDoc{})
+}
@@ -0,0 +1,143 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before it's
+// dropped, it can then resume from the broker buffer
+const subscriberBuffer = 64
+
+// Broker fans the user changes out to the subscribers of the live stream.
+// It numbers the events it publishes and keeps the latest ones in a ring
+// buffer, so that a subscriber coming back can resume where it left off.
+type Broker struct {
+	mu          sync.Mutex
+	buffer      []model.Event
+	start       int
+	lastID      int64
+	subscribers map[chan model.Event]struct{}
+}
+
+// NewBroker returns a broker keeping the last size events
+func NewBroker(size int) *Broker {
+	return &Broker{
+		buffer:      make([]model.Event, 0, size),
+		subscribers: map[chan model.Event]struct{}{},
+	}
+}
+
+// Publish numbers event, keeps it and hands it to every subscriber. A
+// subscriber too slow to take it is dropped, its channel is closed.
+func (b *Broker) Publish(event model.Event) model.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event.EventID = b.lastID
+
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, event)
+	} else if cap(b.buffer) > 0 {
+		b.buffer[b.start] = event
+		b.start = (b.start + 1) % cap(b.buffer)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return event
+}
+
+// PublishChange publishes an event of eventType for user, made by the
+// request whose audit info ctx holds. The REST, GraphQL and gRPC APIs all
+// publish their changes through it.
+func (b *Broker) PublishChange(ctx context.Context, eventType string, user *model.User) model.Event {
+	return b.Publish(model.Event{
+		Type:       eventType,
+		UserID:     user.UserID,
+		OccurredAt: time.Now().UTC(),
+		RequestID:  storage.AuditInfoFrom(ctx).RequestID,
+		User:       *user,
+	})
+}
+
+// PublishUpdate publishes a UserUpdated event for the update of before
+// into after, telling the department the user moved out of
+func (b *Broker) PublishUpdate(ctx context.Context, before, after *model.User) model.Event {
+	event := model.Event{
+		Type:       model.EventUserUpdated,
+		UserID:     after.UserID,
+		OccurredAt: time.Now().UTC(),
+		RequestID:  storage.AuditInfoFrom(ctx).RequestID,
+		User:       *after,
+	}
+
+	if before != nil && before.Department != after.Department {
+		event.PreviousDepartment = before.Department
+	}
+
+	return b.Publish(event)
+}
+
+// Subscribe returns the events kept after lastEventID along with a channel
+// of the events published from now on, until cancel is called. A zero
+// lastEventID only subscribes to new events. Resumed reports false when
+// events after lastEventID were already dropped from the buffer, or when
+// lastEventID was never handed out, like after a restart.
+func (b *Broker) Subscribe(lastEventID int64) (missed []model.Event, events <-chan model.Event, cancel func(), resumed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	resumed = true
+
+	if lastEventID > 0 {
+		kept := b.kept()
+		oldest := b.lastID + 1
+		if len(kept) > 0 {
+			oldest = kept[0].EventID
+		}
+
+		resumed = lastEventID <= b.lastID && lastEventID >= oldest-1
+
+		for _, event := range kept {
+			if event.EventID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	ch := make(chan model.Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return missed, ch, cancel, resumed
+}
+
+// kept returns the buffered events, oldest first, b.mu must be held
+func (b *Broker) kept() []model.Event {
+	kept := make([]model.Event, 0, len(b.buffer))
+	kept = append(kept, b.buffer[b.start:]...)
+
+	return append(kept, b.buffer[:b.start]...)
+}
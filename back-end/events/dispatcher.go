@@ -0,0 +1,111 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// batchSize is how many events are taken out of the outbox at once
+const batchSize = 100
+
+// Dispatcher delivers the pending events of an outbox to its sinks. An
+// event is acknowledged once every sink got it, so a failing sink holds the
+// following events back and gets them all again on the next attempt. With
+// several replicas a single dispatcher holds the lease on the outbox at a
+// time, the others take over when it stops.
+type Dispatcher struct {
+	logger   *slog.Logger
+	outbox   storage.Outbox
+	sinks    []Sink
+	interval time.Duration
+	holder   string
+}
+
+// NewDispatcher returns a dispatcher polling outbox every interval
+func NewDispatcher(logger *slog.Logger, outbox storage.Outbox, interval time.Duration, sinks ...Sink) *Dispatcher {
+	return &Dispatcher{
+		logger:   logger,
+		outbox:   outbox,
+		sinks:    sinks,
+		interval: interval,
+		holder:   newHolder(),
+	}
+}
+
+// newHolder returns a random name for a lease holder
+func newHolder() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Run dispatches the pending events every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		dispatched, err := d.Dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error("failed to dispatch events", slog.String("err", err.Error()))
+		} else if dispatched > 0 {
+			d.logger.Debug("dispatched events", slog.Int("count", dispatched))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch delivers the events pending in the outbox, oldest first, and
+// returns how many were acknowledged. It stops at the first event a sink
+// fails to take, and delivers nothing while another dispatcher holds the
+// lease on the outbox.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	var dispatched int
+
+	for {
+		events, err := d.outbox.ClaimEvents(ctx, d.holder, time.Now(), batchSize)
+		if err != nil {
+			return dispatched, err
+		}
+
+		var (
+			delivered []int64
+			sendErr   error
+		)
+
+	send:
+		for _, event := range events {
+			for _, sink := range d.sinks {
+				if sendErr = sink.Send(ctx, event); sendErr != nil {
+					sendErr = fmt.Errorf("failed to send event %d: %w", event.EventID, sendErr)
+
+					break send
+				}
+			}
+
+			delivered = append(delivered, event.EventID)
+		}
+
+		if err = d.outbox.AckEvents(ctx, delivered...); err != nil {
+			return dispatched, err
+		}
+
+		dispatched += len(delivered)
+
+		if sendErr != nil || len(events) < batchSize {
+			return dispatched, sendErr
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package events delivers the user events recorded in the storage outbox to
+// the systems reacting to them.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// Sink receives the events taken out of the outbox. Delivery is at least
+// once, a sink may see the same event again after a failure and can tell
+// duplicates apart by their id.
+type Sink interface {
+	Send(ctx context.Context, event model.Event) error
+}
+
+// WriterSink writes every event as a line of JSON
+type WriterSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// NewWriterSink returns a sink writing events to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewFileSink returns a sink appending events to the file at path, every
+// event is synced to disk before it's acknowledged.
+func NewFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+
+	return &WriterSink{w: file, file: file}, nil
+}
+
+func (s *WriterSink) Send(ctx context.Context, event model.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	if s.file != nil {
+		return s.file.Sync()
+	}
+
+	return nil
+}
+
+// Close closes the file of a file sink
+func (s *WriterSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
+
+// OpenSink returns the sink described by spec: "stdout", "file:<path>" or
+// "none", which returns a nil sink.
+func OpenSink(spec string) (*WriterSink, error) {
+	switch {
+	case spec == "none":
+		return nil, nil
+	case spec == "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:") && len(spec) > len("file:"):
+		return NewFileSink(strings.TrimPrefix(spec, "file:"))
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", spec)
+	}
+}
@@ -0,0 +1,339 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// Headers sent along with every webhook delivery. The signature is the hex
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook
+// secret and prefixed with "sha256=".
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Delivery attempts back off exponentially from minBackoff up to maxBackoff,
+// a delivery is moved to the dead-letter list after maxAttempts.
+const (
+	maxAttempts  = 8
+	minBackoff   = 30 * time.Second
+	maxBackoff   = time.Hour
+	deliverLimit = 100
+	// deliverTimeout bounds a single attempt
+	deliverTimeout = 10 * time.Second
+)
+
+// Sign returns the signature of body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSink queues a delivery of every event to the webhooks it matches,
+// the deliveries are made by a WebhookDeliverer
+type WebhookSink struct {
+	repo storage.WebhookRepository
+}
+
+func NewWebhookSink(repo storage.WebhookRepository) *WebhookSink {
+	return &WebhookSink{repo: repo}
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event model.Event) error {
+	webhooks, err := s.repo.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []model.Delivery
+
+	for _, webhook := range webhooks {
+		if !matches(webhook, event) {
+			continue
+		}
+
+		deliveries = append(deliveries, model.Delivery{
+			WebhookID:     webhook.WebhookID,
+			EventID:       event.EventID,
+			EventType:     event.Type,
+			Payload:       payload,
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return s.repo.EnqueueDeliveries(ctx, deliveries...)
+}
+
+// matches tells whether webhook subscribed to event
+func matches(webhook model.Webhook, event model.Event) bool {
+	return (len(webhook.EventTypes) == 0 || slices.Contains(webhook.EventTypes, event.Type)) &&
+		(webhook.Department == "" || event.InDepartment(webhook.Department)) &&
+		(webhook.Status == "" || webhook.Status == event.User.Status)
+}
+
+// ErrForbiddenAddress is returned for the webhooks resolving to an address
+// of the internal network, anyone creating webhooks could reach it otherwise
+var ErrForbiddenAddress = errors.New("webhook address isn't public")
+
+// NewWebhookClient returns the client webhooks are delivered with by
+// default. It only connects to public addresses, checked once the host is
+// resolved so that DNS can't get round it, and doesn't follow redirects, the
+// attempt fails with the redirect status instead.
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: deliverTimeout, Control: publicAddressOnly}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be the address checked instead of the webhook
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deniedPrefixes are the special purpose ranges (RFC 6890) not covered by
+// the netip.Addr predicates that a webhook could still reach internal hosts
+// through
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // this network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved and broadcast
+	netip.MustParsePrefix("::/96"),          // IPv4-compatible
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("100::/64"),       // discard-only
+	netip.MustParsePrefix("2001::/23"),      // IETF protocol assignments
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("fec0::/10"),      // site-local
+}
+
+// nat64 and sixToFour carry an IPv4 address, in the last 32 bits and after
+// the 16 bit prefix, that a gateway or relay forwards to
+var (
+	nat64     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour = netip.MustParsePrefix("2002::/16")
+)
+
+// publicAddressOnly refuses to connect to loopback, private, link-local,
+// multicast and the other special purpose addresses, including the IPv4
+// ones mapped into IPv6 or reached through NAT64 and 6to4
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	addr := addrPort.Addr().Unmap()
+	if !isPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+	}
+
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	bytes := addr.As16()
+
+	if nat64.Contains(addr) {
+		return isPublic(netip.AddrFrom4([4]byte(bytes[12:16])))
+	}
+
+	if sixToFour.Contains(addr) {
+		return isPublic(netip.AddrFrom4([4]byte(bytes[2:6])))
+	}
+
+	return true
+}
+
+// WebhookDeliverer posts the queued deliveries to their webhooks
+type WebhookDeliverer struct {
+	logger   *slog.Logger
+	repo     storage.WebhookRepository
+	client   *http.Client
+	interval time.Duration
+}
+
+// NewWebhookDeliverer returns a deliverer looking for due deliveries every
+// interval, the client of NewWebhookClient is used when client is nil
+func NewWebhookDeliverer(logger *slog.Logger, repo storage.WebhookRepository, client *http.Client, interval time.Duration) *WebhookDeliverer {
+	if client == nil {
+		client = NewWebhookClient()
+	}
+
+	return &WebhookDeliverer{
+		logger:   logger,
+		repo:     repo,
+		client:   client,
+		interval: interval,
+	}
+}
+
+// Run makes the due deliveries every interval until ctx is done
+func (d *WebhookDeliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Deliver(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			d.logger.Error("failed to deliver webhooks", slog.String("err", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver claims the deliveries due at now, so that other replicas leave
+// them alone, attempts them and returns how many succeeded. A failed attempt is retried later, after maxAttempts the
+// delivery is marked as failed.
+func (d *WebhookDeliverer) Deliver(ctx context.Context, now time.Time) (int, error) {
+	due, err := d.repo.ClaimDeliveries(ctx, now, deliverLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	webhooks := map[int64]*model.Webhook{}
+
+	var delivered int
+
+	for _, delivery := range due {
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			webhook, err = d.repo.GetWebhook(ctx, delivery.WebhookID)
+			if errors.Is(err, storage.ErrWebhookNotFound) {
+				// deleted since, along with its deliveries
+				continue
+			}
+
+			if err != nil {
+				return delivered, err
+			}
+
+			webhooks[delivery.WebhookID] = webhook
+		}
+
+		delivery.Attempts++
+
+		if err := d.post(ctx, webhook, delivery, now); err != nil {
+			delivery.LastError = err.Error()
+			delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+
+			if delivery.Attempts >= maxAttempts {
+				delivery.Status = model.DeliveryFailed
+			}
+
+			d.logger.Warn("webhook delivery failed",
+				slog.Int64("webhook_id", webhook.WebhookID),
+				slog.Int64("delivery_id", delivery.DeliveryID),
+				slog.Int("attempts", delivery.Attempts),
+				slog.String("err", err.Error()))
+		} else {
+			delivered++
+			deliveredAt := now
+			delivery.Status = model.DeliveryDelivered
+			delivery.LastError = ""
+			delivery.DeliveredAt = &deliveredAt
+		}
+
+		if err := d.repo.SaveDelivery(ctx, &delivery); err != nil {
+			return delivered, err
+		}
+	}
+
+	return delivered, nil
+}
+
+func (d *WebhookDeliverer) post(ctx context.Context, webhook *model.Webhook, delivery model.Delivery, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, deliverTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := now.Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.DeliveryID, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// backoff returns how long to wait after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	wait := minBackoff << (attempts - 1)
+	if wait <= 0 || wait > maxBackoff {
+		return maxBackoff
+	}
+
+	return wait
+}
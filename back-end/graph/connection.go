@@ -0,0 +1,14 @@
+package graph
+
+import "github.com/andrii-stp/users-crud/storage"
+
+// UserConnection is a page of users. The listing options are kept so that
+// the total is only counted when it's asked for, unless it was counted along
+// the page already.
+type UserConnection struct {
+	Edges    []*UserEdge
+	PageInfo *PageInfo
+
+	opts  storage.ListOptions
+	total *int64
+}
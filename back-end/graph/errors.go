@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/andrii-stp/users-crud/storage"
+
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/labstack/echo/v4"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Codes set in the extensions of the errors, they tell clients apart the
+// repository errors the REST API returns as distinct statuses
+const (
+	CodeBadUserInput    = "BAD_USER_INPUT"
+	CodeNotFound        = "NOT_FOUND"
+	CodeAlreadyExists   = "ALREADY_EXISTS"
+	CodeVersionConflict = "VERSION_CONFLICT"
+	CodeDepthLimit      = "DEPTH_LIMIT_EXCEEDED"
+	CodeInternal        = "INTERNAL_SERVER_ERROR"
+)
+
+// badUserInput returns an error about the arguments of a field
+func badUserInput(message string) error {
+	return codedError(message, CodeBadUserInput)
+}
+
+func codedError(message, code string) error {
+	err := gqlerror.Errorf("%s", message)
+	errcode.Set(err, code)
+
+	return err
+}
+
+// resolverError maps a repository error to the error returned to the client,
+// unexpected errors are logged and hidden behind message
+func (r *Resolver) resolverError(err error, message string) error {
+	var (
+		gqlErr  *gqlerror.Error
+		httpErr *echo.HTTPError
+	)
+
+	switch {
+	case errors.As(err, &gqlErr):
+		return err
+	case errors.As(err, &httpErr):
+		// the validator reports invalid users as HTTP errors
+		return badUserInput(fmt.Sprint(httpErr.Message))
+	case errors.Is(err, storage.ErrUserNotFound):
+		return codedError(err.Error(), CodeNotFound)
+	case errors.Is(err, storage.ErrAlreadyExist), errors.Is(err, storage.ErrEmailInUse):
+		return codedError(err.Error(), CodeAlreadyExists)
+	case errors.Is(err, storage.ErrVersionConflict):
+		return codedError(err.Error(), CodeVersionConflict)
+	case errors.Is(err, storage.ErrInvalidSort), errors.Is(err, storage.ErrInvalidCursor):
+		return badUserInput(err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+
+	r.logger.Error(message, slog.String("err", err.Error()))
+
+	return codedError(message, CodeInternal)
+}
@@ -0,0 +1,150 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+type Mutation struct {
+}
+
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+}
+
+type Query struct {
+}
+
+type Subscription struct {
+}
+
+type UserEdge struct {
+	Cursor string      `json:"cursor"`
+	Node   *model.User `json:"node"`
+}
+
+// Narrows a users listing, unset fields are ignored
+type UserFilter struct {
+	UserStatus  *string `json:"userStatus,omitempty"`
+	Department  *string `json:"department,omitempty"`
+	EmailDomain *string `json:"emailDomain,omitempty"`
+	// Lists the users in the trash instead
+	Deleted *bool `json:"deleted,omitempty"`
+	// Lists the users as they were at this time
+	AsOf *time.Time `json:"asOf,omitempty"`
+}
+
+type UserInput struct {
+	UserName   string  `json:"userName"`
+	FirstName  string  `json:"firstName"`
+	LastName   string  `json:"lastName"`
+	Email      string  `json:"email"`
+	UserStatus string  `json:"userStatus"`
+	Department *string `json:"department,omitempty"`
+}
+
+type UserOrder struct {
+	Field     UserSortField `json:"field"`
+	Direction SortDirection `json:"direction"`
+}
+
+type SortDirection string
+
+const (
+	SortDirectionAsc  SortDirection = "ASC"
+	SortDirectionDesc SortDirection = "DESC"
+)
+
+var AllSortDirection = []SortDirection{
+	SortDirectionAsc,
+	SortDirectionDesc,
+}
+
+func (e SortDirection) IsValid() bool {
+	switch e {
+	case SortDirectionAsc, SortDirectionDesc:
+		return true
+	}
+	return false
+}
+
+func (e SortDirection) String() string {
+	return string(e)
+}
+
+func (e *SortDirection) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SortDirection(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SortDirection", str)
+	}
+	return nil
+}
+
+func (e SortDirection) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type UserSortField string
+
+const (
+	UserSortFieldID         UserSortField = "ID"
+	UserSortFieldUserName   UserSortField = "USER_NAME"
+	UserSortFieldFirstName  UserSortField = "FIRST_NAME"
+	UserSortFieldLastName   UserSortField = "LAST_NAME"
+	UserSortFieldEmail      UserSortField = "EMAIL"
+	UserSortFieldUserStatus UserSortField = "USER_STATUS"
+	UserSortFieldDepartment UserSortField = "DEPARTMENT"
+)
+
+var AllUserSortField = []UserSortField{
+	UserSortFieldID,
+	UserSortFieldUserName,
+	UserSortFieldFirstName,
+	UserSortFieldLastName,
+	UserSortFieldEmail,
+	UserSortFieldUserStatus,
+	UserSortFieldDepartment,
+}
+
+func (e UserSortField) IsValid() bool {
+	switch e {
+	case UserSortFieldID, UserSortFieldUserName, UserSortFieldFirstName, UserSortFieldLastName, UserSortFieldEmail, UserSortFieldUserStatus, UserSortFieldDepartment:
+		return true
+	}
+	return false
+}
+
+func (e UserSortField) String() string {
+	return string(e)
+}
+
+func (e *UserSortField) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = UserSortField(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid UserSortField", str)
+	}
+	return nil
+}
+
+func (e UserSortField) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -0,0 +1,230 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+var emailDomainPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+// sortColumns maps the sort fields of the schema to the repository columns
+var sortColumns = map[UserSortField]string{
+	UserSortFieldID:         "user_id",
+	UserSortFieldUserName:   "user_name",
+	UserSortFieldFirstName:  "first_name",
+	UserSortFieldLastName:   "last_name",
+	UserSortFieldEmail:      "email",
+	UserSortFieldUserStatus: "user_status",
+	UserSortFieldDepartment: "department",
+}
+
+// pageArgs are the Relay pagination arguments of a connection
+type pageArgs struct {
+	first  *int
+	after  *string
+	last   *int
+	before *string
+}
+
+// backward tells whether the page is read backward, from before
+func (args pageArgs) backward() bool {
+	return args.last != nil || args.before != nil
+}
+
+// size returns the number of users asked for
+func (args pageArgs) size() (int, error) {
+	if args.first != nil && args.last != nil {
+		return 0, badUserInput(`'first' can't be combined with 'last'`)
+	}
+
+	if (args.first != nil || args.after != nil) && args.backward() {
+		return 0, badUserInput(`'first' and 'after' can't be combined with 'last' and 'before'`)
+	}
+
+	size, name := args.first, "first"
+	if args.last != nil {
+		size, name = args.last, "last"
+	}
+
+	if size == nil {
+		return defaultPageSize, nil
+	}
+
+	if *size < 0 || *size > maxPageSize {
+		return 0, badUserInput(fmt.Sprintf(`'%s' must be between 0 and %d`, name, maxPageSize))
+	}
+
+	return *size, nil
+}
+
+// listOptions checks filter the same way the REST API checks its query
+func listOptions(filter *UserFilter) (storage.ListOptions, error) {
+	var opts storage.ListOptions
+
+	if filter == nil {
+		return opts, nil
+	}
+
+	if filter.UserStatus != nil {
+		opts.Filter.Status = *filter.UserStatus
+
+		if s := opts.Filter.Status; s != "A" && s != "I" && s != "T" {
+			return opts, badUserInput(`'userStatus' is invalid`)
+		}
+	}
+
+	if filter.Department != nil {
+		opts.Filter.Department = *filter.Department
+	}
+
+	if filter.EmailDomain != nil {
+		opts.Filter.EmailDomain = *filter.EmailDomain
+
+		if !emailDomainPattern.MatchString(opts.Filter.EmailDomain) {
+			return opts, badUserInput(`'emailDomain' is invalid`)
+		}
+	}
+
+	if filter.Deleted != nil {
+		opts.Filter.Deleted = *filter.Deleted
+	}
+
+	if filter.AsOf != nil {
+		opts.AsOf = *filter.AsOf
+	}
+
+	return opts, nil
+}
+
+// sortField returns the repository sort of orderBy, users are ordered by id
+// when it's not given
+func sortField(orderBy *UserOrder) (storage.SortField, error) {
+	if orderBy == nil {
+		return storage.SortField{Column: "user_id"}, nil
+	}
+
+	column, ok := sortColumns[orderBy.Field]
+	if !ok {
+		return storage.SortField{}, badUserInput(`'orderBy' is invalid`)
+	}
+
+	return storage.SortField{Column: column, Desc: orderBy.Direction == SortDirectionDesc}, nil
+}
+
+// listUsers reads a page of users by keyset. A backward page is read in the
+// reverse order from before, then turned around.
+func (r *Resolver) listUsers(ctx context.Context, filter *UserFilter, orderBy *UserOrder, args pageArgs) (*UserConnection, error) {
+	opts, err := listOptions(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := sortField(orderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := args.size()
+	if err != nil {
+		return nil, err
+	}
+
+	token, name := args.after, "after"
+	if args.backward() {
+		token, name = args.before, "before"
+	}
+
+	if token != nil {
+		if opts.After, err = r.cursors.Decode(*token); err != nil {
+			return nil, badUserInput(fmt.Sprintf(`'%s' is invalid`, name))
+		}
+
+		if opts.After.Sort()[0] != key {
+			return nil, badUserInput(fmt.Sprintf(`'%s' doesn't match 'orderBy'`, name))
+		}
+	}
+
+	opts.Sort = []storage.SortField{key}
+
+	if args.backward() {
+		opts.Sort[0].Desc = !key.Desc
+
+		if opts.After != nil {
+			opts.After.Desc = !key.Desc
+		}
+	}
+
+	// one more user tells whether there is another page
+	opts.Limit = size + 1
+
+	users, total, err := r.repository.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	more := len(users) > size
+	if more {
+		users = users[:size]
+	}
+
+	if args.backward() {
+		slices.Reverse(users)
+	}
+
+	conn := &UserConnection{
+		Edges: make([]*UserEdge, 0, len(users)),
+		PageInfo: &PageInfo{
+			HasNextPage:     more,
+			HasPreviousPage: token != nil,
+		},
+		opts: storage.ListOptions{Filter: opts.Filter, AsOf: opts.AsOf},
+	}
+
+	if args.backward() {
+		conn.PageInfo.HasNextPage, conn.PageInfo.HasPreviousPage = token != nil, more
+	}
+
+	// the users are counted along the first page only
+	if opts.After == nil {
+		conn.total = &total
+	}
+
+	for i := range users {
+		cursor, err := r.cursors.Encode(storage.NewCursor(key, users[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		conn.Edges = append(conn.Edges, &UserEdge{Cursor: cursor, Node: &users[i]})
+	}
+
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+
+	return conn, nil
+}
+
+// totalCount counts the users matching the filter of conn
+func (r *Resolver) totalCount(ctx context.Context, conn *UserConnection) (int64, error) {
+	if conn.total != nil {
+		return *conn.total, nil
+	}
+
+	opts := conn.opts
+	opts.Limit = 1
+
+	_, total, err := r.repository.List(ctx, opts)
+
+	return total, err
+}
@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"log/slog"
+
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/handler"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Resolver resolves the GraphQL schema from the same repository as the REST
+// API. Users are checked with validator and changes are published to broker,
+// the same way the REST handlers do.
+type Resolver struct {
+	logger     *slog.Logger
+	repository storage.UserRepository
+	cursors    *handler.CursorCodec
+	broker     *events.Broker
+	validator  echo.Validator
+}
+
+// NewResolver returns a resolver reading and changing the users of repo
+func NewResolver(logger *slog.Logger, repo storage.UserRepository, cursors *handler.CursorCodec, broker *events.Broker, validator echo.Validator) *Resolver {
+	return &Resolver{logger: logger, repository: repo, cursors: cursors, broker: broker, validator: validator}
+}
+
+// userFromInput returns the user described by input
+func userFromInput(input UserInput) model.User {
+	user := model.User{
+		UserName:  input.UserName,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Email:     input.Email,
+		Status:    input.UserStatus,
+	}
+
+	if input.Department != nil {
+		user.Department = *input.Department
+	}
+
+	return user
+}
@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// MarshalInt64ID writes the ids of users and events, which are int64 in the
+// repository, as GraphQL IDs
+func MarshalInt64ID(id int64) graphql.Marshaler {
+	return graphql.WriterFunc(func(w io.Writer) {
+		_, _ = io.WriteString(w, strconv.Quote(strconv.FormatInt(id, 10)))
+	})
+}
+
+// UnmarshalInt64ID reads an ID sent either as a string or as a number
+func UnmarshalInt64ID(v any) (int64, error) {
+	switch v := v.(type) {
+	case string:
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an id", v)
+		}
+
+		return id, nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	default:
+		return graphql.UnmarshalInt64(v)
+	}
+}
@@ -0,0 +1,162 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.49
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+func (r *mutationResolver) CreateUser(ctx context.Context, input UserInput) (*model.User, error) {
+	user := userFromInput(input)
+
+	if err := r.validator.Validate(user); err != nil {
+		return nil, r.resolverError(err, "Failed to create user")
+	}
+
+	if err := r.repository.Create(ctx, &user); err != nil {
+		return nil, r.resolverError(err, "Failed to create user")
+	}
+
+	r.broker.PublishChange(ctx, model.EventUserCreated, &user)
+
+	return &user, nil
+}
+
+func (r *mutationResolver) UpdateUser(ctx context.Context, id int64, input UserInput, version *int) (*model.User, error) {
+	user := userFromInput(input)
+
+	if err := r.validator.Validate(user); err != nil {
+		return nil, r.resolverError(err, "Failed to update user")
+	}
+
+	if version != nil {
+		user.Version = int64(*version)
+	}
+
+	previous, err := r.repository.Update(ctx, id, &user)
+	if err != nil {
+		return nil, r.resolverError(err, "Failed to update user")
+	}
+
+	r.broker.PublishUpdate(ctx, previous, &user)
+
+	return &user, nil
+}
+
+func (r *mutationResolver) DeleteUser(ctx context.Context, id int64, version *int) (*model.User, error) {
+	var expected int64
+	if version != nil {
+		expected = int64(*version)
+	}
+
+	// read first so that the user that went to the trash can be returned
+	user, err := r.repository.Get(ctx, id)
+	if err == nil {
+		err = r.repository.Delete(ctx, id, expected)
+	}
+
+	if err != nil {
+		return nil, r.resolverError(err, "Failed to delete user")
+	}
+
+	deletedAt := time.Now().UTC()
+	user.DeletedAt = &deletedAt
+	r.broker.PublishChange(ctx, model.EventUserDeleted, user)
+
+	return user, nil
+}
+
+func (r *queryResolver) User(ctx context.Context, id *int64, userName *string) (*model.User, error) {
+	var (
+		user *model.User
+		err  error
+	)
+
+	switch {
+	case id != nil && userName != nil:
+		return nil, badUserInput(`'id' can't be combined with 'userName'`)
+	case id != nil:
+		user, err = r.repository.Get(ctx, *id)
+	case userName != nil:
+		user, err = r.repository.GetByUserName(ctx, *userName)
+	default:
+		return nil, badUserInput(`'id' or 'userName' is required`)
+	}
+
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, r.resolverError(err, "Failed to get user")
+	}
+
+	return user, nil
+}
+
+func (r *queryResolver) Users(ctx context.Context, filter *UserFilter, orderBy *UserOrder, first *int, after *string, last *int, before *string) (*UserConnection, error) {
+	conn, err := r.listUsers(ctx, filter, orderBy, pageArgs{first: first, after: after, last: last, before: before})
+	if err != nil {
+		return nil, r.resolverError(err, "Failed to list users")
+	}
+
+	return conn, nil
+}
+
+func (r *subscriptionResolver) UserChanged(ctx context.Context, department *string, lastEventID *int64) (<-chan *model.Event, error) {
+	var after int64
+	if lastEventID != nil {
+		after = *lastEventID
+	}
+
+	if after < 0 {
+		return nil, badUserInput(`'lastEventId' is not an event id`)
+	}
+
+	var only string
+	if department != nil {
+		only = *department
+	}
+
+	return r.subscribe(ctx, after, only), nil
+}
+
+func (r *userConnectionResolver) TotalCount(ctx context.Context, obj *UserConnection) (int, error) {
+	total, err := r.totalCount(ctx, obj)
+	if err != nil {
+		return 0, r.resolverError(err, "Failed to count users")
+	}
+
+	return int(total), nil
+}
+
+func (r *userEventResolver) User(ctx context.Context, obj *model.Event) (*model.User, error) {
+	if obj.Type == resetEvent {
+		return nil, nil
+	}
+
+	return &obj.User, nil
+}
+
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
+
+func (r *Resolver) UserConnection() UserConnectionResolver { return &userConnectionResolver{r} }
+
+func (r *Resolver) UserEvent() UserEventResolver { return &userEventResolver{r} }
+
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
+type userConnectionResolver struct{ *Resolver }
+type userEventResolver struct{ *Resolver }
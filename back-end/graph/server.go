@@ -0,0 +1,131 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	gqlhandler "github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gorilla/websocket"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Limits bounds how costly a single operation can be. Depth counts the
+// nested fields, complexity counts every field resolved, the fields of a
+// users page as many times as users are asked for.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// DefaultLimits allow listing a full page of users with every field
+var DefaultLimits = Limits{
+	MaxDepth:      10,
+	MaxComplexity: 15000,
+}
+
+// NewHandler returns the handler serving the schema resolved by resolver,
+// over GET and POST, and the subscriptions over WebSocket or Server-Sent
+// Events. Operations going over limits are rejected before being resolved.
+func NewHandler(resolver *Resolver, limits Limits) http.Handler {
+	config := Config{Resolvers: resolver}
+	config.Complexity.Query.Users = usersComplexity
+
+	server := gqlhandler.New(NewExecutableSchema(config))
+
+	server.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		// any origin is allowed, like by the CORS middleware of the REST API
+		Upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+	})
+	// before POST, it's the same request asking for text/event-stream
+	server.AddTransport(transport.SSE{})
+	server.AddTransport(transport.Options{})
+	server.AddTransport(transport.GET{})
+	server.AddTransport(transport.POST{})
+
+	server.Use(extension.Introspection{})
+	server.Use(depthLimit{max: limits.MaxDepth})
+	server.Use(extension.FixedComplexityLimit(limits.MaxComplexity))
+
+	return server
+}
+
+// usersComplexity counts the fields of a page as many times as users are
+// asked for
+func usersComplexity(childComplexity int, _ *UserFilter, _ *UserOrder, first *int, _ *string, last *int, _ *string) int {
+	size := defaultPageSize
+
+	switch {
+	case first != nil:
+		size = *first
+	case last != nil:
+		size = *last
+	}
+
+	return 1 + childComplexity*max(size, 1)
+}
+
+// depthLimit rejects the operations nesting fields deeper than max.
+// Introspection fields aren't counted, the queries of GraphQL tools are
+// deep but cheap.
+type depthLimit struct {
+	max int
+}
+
+var _ interface {
+	graphql.OperationContextMutator
+	graphql.HandlerExtension
+} = depthLimit{}
+
+func (d depthLimit) ExtensionName() string {
+	return "DepthLimit"
+}
+
+func (d depthLimit) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (d depthLimit) MutateOperationContext(_ context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	if rc.Operation == nil {
+		return nil
+	}
+
+	if depth := selectionDepth(rc.Operation.SelectionSet); depth > d.max {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", depth, d.max)
+		errcode.Set(err, CodeDepthLimit)
+
+		return err
+	}
+
+	return nil
+}
+
+// selectionDepth returns how deep the fields of set are nested, fragments
+// count as the fields they hold
+func selectionDepth(set ast.SelectionSet) int {
+	depth := 0
+
+	for _, selection := range set {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if !strings.HasPrefix(s.Name, "__") {
+				depth = max(depth, 1+selectionDepth(s.SelectionSet))
+			}
+		case *ast.InlineFragment:
+			depth = max(depth, selectionDepth(s.SelectionSet))
+		case *ast.FragmentSpread:
+			if s.Definition != nil {
+				depth = max(depth, selectionDepth(s.Definition.SelectionSet))
+			}
+		}
+	}
+
+	return depth
+}
@@ -0,0 +1,61 @@
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// resetEvent tells a resuming subscriber it missed events and has to read
+// the users again
+const resetEvent = "reset"
+
+// subscribe streams the changes published after lastEventID until ctx is
+// done. The stream ends when the subscriber is too slow to keep up, it can
+// resume from the last event it got.
+func (r *Resolver) subscribe(ctx context.Context, lastEventID int64, department string) <-chan *model.Event {
+	missed, events, cancel, resumed := r.broker.Subscribe(lastEventID)
+	changes := make(chan *model.Event, 1)
+
+	send := func(event model.Event) bool {
+		if event.Type != resetEvent && department != "" && !event.InDepartment(department) {
+			return true
+		}
+
+		select {
+		case changes <- &event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(changes)
+		defer cancel()
+
+		if !resumed && !send(model.Event{Type: resetEvent, OccurredAt: time.Now().UTC()}) {
+			return
+		}
+
+		for _, event := range missed {
+			if !send(event) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok || !send(event) {
+					return
+				}
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditHandler serves the audit log of user changes
+type AuditHandler struct {
+	repository storage.UserRepository
+}
+
+func NewAuditHandler(repository storage.UserRepository) *AuditHandler {
+	return &AuditHandler{repository: repository}
+}
+
+// History godoc
+//
+//	@Summary		User history
+//	@Description	get the audit log of a user, oldest change first
+//	@Tags			audit
+//	@Produce		json
+//	@Param			id		path		int	true	"User ID"	Format(int64)
+//	@Param			limit	query		int	false	"Page size"	default(100)	maximum(1000)
+//	@Param			after	query		int	false	"Continue after the entry with this id"
+//	@Success		200		{object}	model.AuditPage
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/users/{id}/history [get]
+func (a AuditHandler) History(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	filter, err := auditFilter(c)
+	if err != nil {
+		return err
+	}
+
+	filter.UserID = id
+
+	return a.list(c, filter)
+}
+
+// List godoc
+//
+//	@Summary		Audit log
+//	@Description	get the audit log of every user change, oldest first
+//	@Tags			audit
+//	@Produce		json
+//	@Param			actor			query		string	false	"Only changes made by this authenticated actor"
+//	@Param			claimed_actor	query		string	false	"Only changes claimed to be made on behalf of this actor with X-Actor"
+//	@Param			since			query		string	false	"Only changes made from this time on"	Format(date-time)
+//	@Param			limit			query		int		false	"Page size"	default(100)	maximum(1000)
+//	@Param			after			query		int		false	"Continue after the entry with this id"
+//	@Success		200				{object}	model.AuditPage
+//	@Failure		400				{object}	echo.HTTPError
+//	@Failure		500				{object}	echo.HTTPError
+//	@Router			/audit [get]
+func (a AuditHandler) List(c echo.Context) error {
+	filter, err := auditFilter(c)
+	if err != nil {
+		return err
+	}
+
+	filter.Actor = c.QueryParam("actor")
+	filter.ClaimedActor = c.QueryParam("claimed_actor")
+
+	if since := c.QueryParam("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, `'since' is not an RFC 3339 time`)
+		}
+	}
+
+	return a.list(c, filter)
+}
+
+func (a AuditHandler) list(c echo.Context, filter storage.AuditFilter) error {
+	entries, err := a.repository.ListAudit(c.Request().Context(), filter)
+	if err != nil {
+		c.Logger().Errorf("failed to get audit log: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get audit log")
+	}
+
+	page := model.AuditPage{
+		Items: entries,
+		Limit: filter.Limit,
+		Links: model.PageLinks{Self: c.Request().URL.RequestURI()},
+	}
+
+	if len(entries) == filter.Limit {
+		query := c.Request().URL.Query()
+		query.Set("limit", strconv.Itoa(filter.Limit))
+		query.Set("after", strconv.FormatInt(entries[len(entries)-1].AuditID, 10))
+
+		link := url.URL{Path: c.Request().URL.Path, RawQuery: query.Encode()}
+		page.Links.Next = link.String()
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+func auditFilter(c echo.Context) (storage.AuditFilter, error) {
+	filter := storage.AuditFilter{Limit: defaultAuditLimit}
+
+	var err error
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, `'limit' is not a number`)
+		}
+
+		if filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			return filter, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`'limit' must be between 1 and %d`, maxAuditLimit))
+		}
+	}
+
+	if after := c.QueryParam("after"); after != "" {
+		if filter.After, err = strconv.ParseInt(after, 10, 64); err != nil || filter.After < 0 {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, `'after' is not an audit entry id`)
+		}
+	}
+
+	return filter, nil
+}
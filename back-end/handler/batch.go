@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// maxBatchSize is the number of operations a batch may hold
+const maxBatchSize = 1000
+
+// Batch godoc
+//
+//	@Summary		Apply a batch of operations
+//	@Description	create, update and delete users in a single request. An atomic batch applies every operation or none of them and fails with the status of the operation that failed, otherwise each operation gets its own status.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			batch	body		model.BatchRequest	true	"Operations"
+//	@Param			atomic	query		bool			false	"Apply every operation or none of them, overrides the body"
+//	@Success		200		{object}	model.BatchResponse
+//	@Failure		400		{object}	model.BatchResponse
+//	@Failure		404		{object}	model.BatchResponse
+//	@Failure		409		{object}	model.BatchResponse
+//	@Failure		412		{object}	model.BatchResponse
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/users:batch [post]
+func (u UserHandler) Batch(c echo.Context) error {
+	logger := c.Logger()
+
+	var req model.BatchRequest
+	if err := c.Bind(&req); err != nil {
+		logger.Errorf("failed to bind to batch type: %v", err)
+
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind request body")
+	}
+
+	atomic, err := parseBool(c, "atomic", req.Atomic)
+	if err != nil {
+		return err
+	}
+
+	req.Atomic = atomic
+
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchSize {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("a batch holds 1 to %d operations", maxBatchSize))
+	}
+
+	results := make([]model.BatchItemResult, len(req.Operations))
+	ops := make([]storage.BatchOp, 0, len(req.Operations))
+	// the results the operations passed to the repository go to
+	indexes := make([]int, 0, len(req.Operations))
+
+	var invalid bool
+
+	for i, op := range req.Operations {
+		results[i] = model.BatchItemResult{Index: i, Op: op.Op}
+
+		if err := u.validateOperation(c, op); err != nil {
+			results[i].Status, results[i].Error = httpError(err)
+			invalid = true
+
+			continue
+		}
+
+		ops = append(ops, storage.BatchOp{Op: op.Op, ID: op.ID, Version: op.Version, User: op.User})
+		indexes = append(indexes, i)
+	}
+
+	// an atomic batch doesn't get to the database when it would fail anyway
+	if req.Atomic && invalid {
+		for _, i := range indexes {
+			results[i].Status, results[i].Error = http.StatusFailedDependency, storage.ErrBatchAborted.Error()
+		}
+
+		return c.JSON(http.StatusBadRequest, model.BatchResponse{Results: results})
+	}
+
+	outcomes, err := u.repository.Batch(c.Request().Context(), ops, storage.BatchOptions{Atomic: req.Atomic})
+	if err != nil {
+		logger.Errorf("failed to apply batch: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to apply batch")
+	}
+
+	status := http.StatusOK
+
+	for j, outcome := range outcomes {
+		result := &results[indexes[j]]
+
+		if outcome.Err != nil {
+			result.Status, result.Error = batchError(outcome.Err)
+
+			if req.Atomic && !errors.Is(outcome.Err, storage.ErrBatchAborted) {
+				status = result.Status
+			}
+
+			continue
+		}
+
+		result.User = outcome.User
+
+		switch result.Op {
+		case storage.OpCreate:
+			result.Status = http.StatusCreated
+			u.broker.PublishChange(c.Request().Context(), model.EventUserCreated, outcome.User)
+		case storage.OpUpdate:
+			result.Status = http.StatusOK
+			u.broker.PublishUpdate(c.Request().Context(), outcome.Previous, outcome.User)
+		case storage.OpDelete:
+			result.Status = http.StatusNoContent
+			u.broker.PublishChange(c.Request().Context(), model.EventUserDeleted, outcome.User)
+		}
+	}
+
+	return c.JSON(status, model.BatchResponse{Results: results})
+}
+
+// validateOperation checks an operation the way the request of its own
+// would be checked
+func (u UserHandler) validateOperation(c echo.Context, op model.BatchOperation) error {
+	switch op.Op {
+	case storage.OpCreate, storage.OpUpdate:
+		if op.Op == storage.OpUpdate && op.ID <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "'id' is required to update a user")
+		}
+
+		if op.User == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("'user' is required to %s a user", op.Op))
+		}
+
+		return c.Validate(*op.User)
+	case storage.OpDelete:
+		if op.ID <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "'id' is required to delete a user")
+		}
+
+		return nil
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("'op' must be one of create, update, delete, got '%s'", op.Op))
+	}
+}
+
+// batchError maps the error of a batch operation to its status
+func batchError(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrAlreadyExist), errors.Is(err, storage.ErrEmailInUse):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, storage.ErrUserNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, storage.ErrVersionConflict):
+		return http.StatusPreconditionFailed, err.Error()
+	case errors.Is(err, storage.ErrBatchAborted):
+		return http.StatusFailedDependency, err.Error()
+	default:
+		return http.StatusInternalServerError, "Failed to apply operation"
+	}
+}
+
+// httpError splits an echo.HTTPError into its status and message
+func httpError(err error) (int, string) {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code, fmt.Sprint(he.Message)
+	}
+
+	return http.StatusInternalServerError, err.Error()
+}
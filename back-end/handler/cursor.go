@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// CursorCodec turns storage cursors into opaque tokens signed with HMAC-SHA256,
+// so clients can't forge a cursor pointing at arbitrary sort values
+type CursorCodec struct {
+	secret []byte
+}
+
+// NewCursorCodec returns a codec signing tokens with secret
+func NewCursorCodec(secret []byte) *CursorCodec {
+	return &CursorCodec{secret: secret}
+}
+
+// Encode returns the signed token for cursor
+func (cc *CursorCodec) Encode(cursor storage.Cursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cursor: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(cc.sign(encoded)), nil
+}
+
+// Decode verifies the token signature and returns the cursor it holds
+func (cc *CursorCodec) Decode(token string) (*storage.Cursor, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, storage.ErrInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, cc.sign(encoded)) {
+		return nil, storage.ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, storage.ErrInvalidCursor
+	}
+
+	var cursor storage.Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, storage.ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func (cc *CursorCodec) sign(encoded string) []byte {
+	h := hmac.New(sha256.New, cc.secret)
+	h.Write([]byte(encoded))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/labstack/echo/v4"
+)
+
+// setETag tags the response with the version of user
+func setETag(c echo.Context, user *model.User) {
+	c.Response().Header().Set("ETag", strconv.Quote(strconv.FormatInt(user.Version, 10)))
+}
+
+// ifMatchVersion returns the user version the If-Match header expects, 0
+// when the header is missing or is "*".
+func ifMatchVersion(c echo.Context) (int64, error) {
+	tag := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if tag == "" || tag == "*" {
+		return 0, nil
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		unquoted = tag
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, `'If-Match' is not a user version`)
+	}
+
+	return version, nil
+}
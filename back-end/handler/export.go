@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MIMETextCSV = "text/csv"
+	MIMENDJSON  = "application/x-ndjson"
+	MIMEXLSX    = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportFormats maps the formats users are exported in to their media type
+var exportFormats = map[string]string{
+	"csv":    MIMETextCSV,
+	"ndjson": MIMENDJSON,
+	"xlsx":   MIMEXLSX,
+}
+
+// exportColumns head the CSV and XLSX exports, named after the JSON fields
+var exportColumns = []any{"id", "user_name", "first_name", "last_name", "email", "user_status", "department", "version"}
+
+// Export godoc
+//
+//	@Summary		Export users
+//	@Description	download every user matching the filters of the listing as CSV, NDJSON or XLSX. The format is picked by 'format', or else by the Accept header, CSV being the default. Users are written as they're read, the whole listing is never held in memory. CSV cells starting with =, +, -, @, a tab or a carriage return are prefixed with a quote so spreadsheets don't evaluate them.
+//	@Tags			users
+//	@Produce		text/csv,application/x-ndjson,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+//	@Param			format			query		string	false	"Export format"	Enums(csv, ndjson, xlsx)
+//	@Param			limit			query		int		false	"Maximum number of users"	maximum(1000)
+//	@Param			offset			query		int		false	"Number of users to skip"
+//	@Param			user_status		query		string	false	"Filter by status"	Enums(A, I, T)
+//	@Param			department		query		string	false	"Filter by department"
+//	@Param			email_domain	query		string	false	"Filter by email domain"
+//	@Param			sort			query		string	false	"Sort columns, '-' prefix for descending"	example(last_name,-user_id)
+//	@Param			as_of			query		string	false	"Export the users as they were at this RFC 3339 time"	Format(date-time)
+//	@Success		200				{file}		file
+//	@Header			200				{string}	Content-Disposition	"attachment; filename=\"users-2006-01-02.csv\""
+//	@Failure		400				{object}	echo.HTTPError
+//	@Failure		406				{object}	echo.HTTPError
+//	@Failure		500				{object}	echo.HTTPError
+//	@Router			/users/export [get]
+func (u UserHandler) Export(c echo.Context) error {
+	logger := c.Logger()
+
+	format, err := exportFormat(c)
+	if err != nil {
+		return err
+	}
+
+	opts, err := u.listOptions(c)
+	if err != nil {
+		return err
+	}
+
+	// an export holds every user unless told otherwise
+	if c.QueryParam("limit") == "" {
+		opts.Limit = 0
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, exportFormats[format])
+	res.Header().Set(echo.HeaderContentDisposition,
+		mime.FormatMediaType("attachment", map[string]string{"filename": fmt.Sprintf("users-%s.%s", time.Now().UTC().Format(time.DateOnly), format)}))
+
+	buf := bufio.NewWriter(res)
+
+	writer, err := newUserWriter(format, buf)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.Each(c.Request().Context(), opts, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if err == nil {
+		err = buf.Flush()
+	}
+
+	if err != nil {
+		logger.Errorf("failed to export users: %v", err)
+
+		// once it's committed, the response is only cut short
+		if !res.Committed {
+			res.Header().Del(echo.HeaderContentDisposition)
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export users")
+	}
+
+	if !res.Committed {
+		res.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
+// exportFormat picks the format from the query, or else from the first
+// media type of the Accept header an export can be written in
+func exportFormat(c echo.Context) (string, error) {
+	if format := c.QueryParam("format"); format != "" {
+		if _, ok := exportFormats[format]; !ok {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "'format' must be one of csv, ndjson, xlsx")
+		}
+
+		return format, nil
+	}
+
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if accept == "" {
+		return "csv", nil
+	}
+
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+
+		if mediaType == "*/*" || mediaType == "text/*" {
+			return "csv", nil
+		}
+
+		for format, formatType := range exportFormats {
+			if mediaType == formatType {
+				return format, nil
+			}
+		}
+	}
+
+	return "", echo.NewHTTPError(http.StatusNotAcceptable,
+		fmt.Sprintf("users are exported as %s, %s or %s", MIMETextCSV, MIMENDJSON, MIMEXLSX))
+}
+
+// userWriter writes users one after the other in an export format
+type userWriter interface {
+	Write(user model.User) error
+	// Close ends the export, it doesn't close the underlying writer
+	Close() error
+}
+
+func newUserWriter(format string, w io.Writer) (userWriter, error) {
+	switch format {
+	case "ndjson":
+		return ndjsonUserWriter{encoder: json.NewEncoder(w)}, nil
+	case "xlsx":
+		sheet, err := newXLSXWriter(w)
+		if err != nil {
+			return nil, err
+		}
+
+		return xlsxUserWriter{sheet: sheet}, sheet.Write(exportColumns...)
+	default:
+		writer := csvUserWriter{csv: csv.NewWriter(w)}
+
+		return writer, writer.write(exportColumns)
+	}
+}
+
+type csvUserWriter struct {
+	csv *csv.Writer
+}
+
+func (w csvUserWriter) Write(user model.User) error {
+	return w.write(exportRow(user))
+}
+
+func (w csvUserWriter) write(values []any) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = fmt.Sprint(value)
+
+		if text, ok := value.(string); ok {
+			record[i] = escapeFormula(text)
+		}
+	}
+
+	return w.csv.Write(record)
+}
+
+func (w csvUserWriter) Close() error {
+	w.csv.Flush()
+
+	return w.csv.Error()
+}
+
+type ndjsonUserWriter struct {
+	encoder *json.Encoder
+}
+
+func (w ndjsonUserWriter) Write(user model.User) error {
+	return w.encoder.Encode(user)
+}
+
+func (w ndjsonUserWriter) Close() error {
+	return nil
+}
+
+type xlsxUserWriter struct {
+	sheet *xlsxWriter
+}
+
+func (w xlsxUserWriter) Write(user model.User) error {
+	return w.sheet.Write(exportRow(user)...)
+}
+
+func (w xlsxUserWriter) Close() error {
+	return w.sheet.Close()
+}
+
+// escapeFormula quotes a cell a spreadsheet would evaluate as a formula, so
+// that a user named "=HYPERLINK(...)" is shown as text once the CSV is opened
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// exportRow returns the values of user in the order of exportColumns
+func exportRow(user model.User) []any {
+	return []any{
+		user.UserID, user.UserName, user.FirstName, user.LastName, user.Email, user.Status, user.Department, user.Version,
+	}
+}
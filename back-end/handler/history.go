@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// Revert godoc
+//
+//	@Summary		Revert user
+//	@Description	bring back the fields a user had at a former version, the change goes through a regular update
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int		true	"User ID"	Format(int64)
+//	@Param			to_version	query		int		true	"Version to revert to"	minimum(1)
+//	@Param			If-Match	header		string	false	"ETag of the user version being reverted"
+//	@Success		200			{object}	model.User
+//	@Header			200			{string}	ETag	"User version"
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		404			{object}	echo.HTTPError
+//	@Failure		409			{object}	echo.HTTPError
+//	@Failure		412			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Router			/users/{id}/revert [post]
+func (u UserHandler) Revert(c echo.Context) error {
+	logger := c.Logger()
+
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.ParseInt(c.QueryParam("to_version"), 10, 64)
+	if err != nil || version < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, `'to_version' is not a user version`)
+	}
+
+	user, err := u.repository.GetVersion(c.Request().Context(), id, version)
+	if err != nil {
+		logger.Errorf("failed to get user version: %v", err)
+
+		if errors.Is(err, storage.ErrVersionNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revert user")
+	}
+
+	// a former version may not pass the rules in force today
+	if err := c.Validate(*user); err != nil {
+		return err
+	}
+
+	if user.Version, err = ifMatchVersion(c); err != nil {
+		return err
+	}
+
+	previous, err := u.repository.Update(c.Request().Context(), id, user)
+	if err != nil {
+		logger.Errorf("failed to revert user: %v", err)
+
+		if errors.Is(err, storage.ErrAlreadyExist) || errors.Is(err, storage.ErrEmailInUse) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+
+		if errors.Is(err, storage.ErrVersionConflict) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revert user")
+	}
+
+	u.broker.PublishUpdate(c.Request().Context(), previous, user)
+	setETag(c, user)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// parseAsOf returns the time the as_of query parameter asks to read the
+// users at, zero when it's missing
+func parseAsOf(c echo.Context) (time.Time, error) {
+	param := c.QueryParam("as_of")
+	if param == "" {
+		return time.Time{}, nil
+	}
+
+	asOf, err := time.Parse(time.RFC3339Nano, param)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, `'as_of' is not an RFC 3339 time`)
+	}
+
+	return asOf, nil
+}
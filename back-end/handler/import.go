@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// importChunk is the number of rows imported at once, a file is read a
+// chunk at a time rather than as a whole
+const importChunk = 500
+
+// maxDryRunRows is the number of rows a dry run checks, it remembers the
+// username and email of every row to check the later ones against them
+const maxDryRunRows = 10000
+
+// maxImportErrors is the number of failed rows listed by a report, the
+// others are only counted
+const maxImportErrors = 1000
+
+// importFields sets the user field a CSV column is mapped to
+var importFields = map[string]func(user *model.User, value string){
+	"user_name":   func(user *model.User, value string) { user.UserName = value },
+	"first_name":  func(user *model.User, value string) { user.FirstName = value },
+	"last_name":   func(user *model.User, value string) { user.LastName = value },
+	"email":       func(user *model.User, value string) { user.Email = value },
+	"user_status": func(user *model.User, value string) { user.Status = value },
+	"department":  func(user *model.User, value string) { user.Department = value },
+}
+
+// requiredImportFields are the fields a file must have a column for, users
+// are active unless a column tells otherwise
+var requiredImportFields = []string{"user_name", "first_name", "last_name", "email"}
+
+// Import godoc
+//
+//	@Summary		Import users from CSV
+//	@Description	create a user from every row of a CSV file, sent as the body or as the 'file' field of a form. Columns named after a user field are mapped to it, others are mapped with 'map' or ignored. Rows are validated and created on their own, the ones that fail are reported with their line. Rows are created 500 at a time as the file is read, when an import fails midway the error tells how many users were created and up to which line. A dry run checks up to 10000 rows, larger files are refused.
+//	@Tags			users
+//	@Accept			text/csv,multipart/form-data
+//	@Produce		json
+//	@Param			file	body		string		true	"CSV file with a header row"
+//	@Param			map		query		[]string	false	"Map a column to a user field"	collectionFormat(multi)	example(E-mail=email)
+//	@Param			dry_run	query		bool		false	"Report what the import would do without creating any user"
+//	@Success		200		{object}	model.ImportReport
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		413		{object}	echo.HTTPError
+//	@Failure		415		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/users/import [post]
+func (u UserHandler) Import(c echo.Context) error {
+	logger := c.Logger()
+
+	dryRun, err := parseBool(c, "dry_run", false)
+	if err != nil {
+		return err
+	}
+
+	body, err := importBody(c)
+	if err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read the CSV header")
+	}
+
+	setters, err := columnMapping(header, c.QueryParams()["map"])
+	if err != nil {
+		return err
+	}
+
+	report := model.ImportReport{DryRun: dryRun, Errors: []model.ImportError{}}
+	imp := importer{handler: u, context: c, report: &report}
+
+	if report.DryRun {
+		// a dry run rolls every chunk back, the rows are checked against the
+		// earlier ones here, up to maxDryRunRows of them
+		imp.userNames, imp.emails = map[string]int{}, map[string]int{}
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		report.Rows++
+
+		if report.DryRun && report.Rows > maxDryRunRows {
+			return imp.abort(http.StatusRequestEntityTooLarge, fmt.Sprintf("a dry run checks up to %d rows", maxDryRunRows))
+		}
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				logger.Errorf("failed to read CSV: %v", err)
+
+				return imp.abort(http.StatusBadRequest, "Failed to read the CSV file")
+			}
+
+			imp.fail(model.ImportError{Line: parseErr.StartLine, Status: http.StatusBadRequest, Error: parseErr.Err.Error()})
+
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		user := &model.User{Status: "A"}
+
+		for i, value := range record {
+			if i < len(setters) && setters[i] != nil {
+				setters[i](user, strings.TrimSpace(value))
+			}
+		}
+
+		if err := imp.add(line, user); err != nil {
+			return err
+		}
+	}
+
+	if err := imp.flush(); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// importBody returns the CSV file of an import request without reading it
+func importBody(c echo.Context) (io.Reader, error) {
+	req := c.Request()
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+
+	switch mediaType {
+	case "text/csv":
+		return req.Body, nil
+	case echo.MIMEMultipartForm:
+		parts, err := req.MultipartReader()
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to read the form")
+		}
+
+		for {
+			part, err := parts.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, "'file' is empty")
+			}
+
+			if err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to read the form")
+			}
+
+			if part.FormName() == "file" {
+				return part, nil
+			}
+		}
+	default:
+		return nil, echo.NewHTTPError(http.StatusUnsupportedMediaType, "a CSV file is sent as text/csv or multipart/form-data")
+	}
+}
+
+// columnMapping returns the setter of every column of header, nil for the
+// ignored ones. A column is mapped by a 'column=field' entry of mappings,
+// or to the field it's named after.
+func columnMapping(header []string, mappings []string) ([]func(*model.User, string), error) {
+	explicit := make(map[string]string, len(mappings))
+
+	for _, mapping := range mappings {
+		i := strings.LastIndex(mapping, "=")
+		if i < 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("'map' must be like 'column=field', got '%s'", mapping))
+		}
+
+		column, field := strings.TrimSpace(mapping[:i]), strings.TrimSpace(mapping[i+1:])
+		if _, ok := importFields[field]; !ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("'%s' is not a user field", field))
+		}
+
+		explicit[strings.ToLower(column)] = field
+	}
+
+	setters := make([]func(*model.User, string), len(header))
+	mapped := make(map[string]bool, len(importFields))
+
+	for i, column := range header {
+		// spreadsheets often save CSV with a byte order mark
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+
+		field, ok := explicit[column]
+		if !ok {
+			field = strings.ReplaceAll(column, " ", "_")
+		}
+
+		if set, ok := importFields[field]; ok && !mapped[field] {
+			setters[i] = set
+			mapped[field] = true
+		}
+	}
+
+	for _, field := range requiredImportFields {
+		if !mapped[field] {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("no column is mapped to '%s'", field))
+		}
+	}
+
+	return setters, nil
+}
+
+// importer creates the users of an import a chunk at a time
+type importer struct {
+	handler UserHandler
+	context echo.Context
+	report  *model.ImportReport
+
+	ops   []storage.BatchOp
+	lines []int
+	// flushedLine is the line of the last row of the chunks created
+	flushedLine int
+
+	// the line of the first row with every username and email, on dry runs
+	userNames map[string]int
+	emails    map[string]int
+}
+
+// add queues the user read from line, it's reported right away when invalid
+func (imp *importer) add(line int, user *model.User) error {
+	if err := imp.context.Validate(user); err != nil {
+		status, message := httpError(err)
+		imp.fail(model.ImportError{Line: line, UserName: user.UserName, Status: status, Error: message})
+
+		return nil
+	}
+
+	if imp.userNames != nil {
+		if first, ok := imp.userNames[strings.ToLower(user.UserName)]; ok {
+			imp.fail(duplicate(line, user, storage.ErrAlreadyExist, first))
+
+			return nil
+		}
+
+		if first, ok := imp.emails[strings.ToLower(user.Email)]; ok {
+			imp.fail(duplicate(line, user, storage.ErrEmailInUse, first))
+
+			return nil
+		}
+
+		imp.userNames[strings.ToLower(user.UserName)] = line
+		imp.emails[strings.ToLower(user.Email)] = line
+	}
+
+	imp.ops = append(imp.ops, storage.BatchOp{Op: storage.OpCreate, User: user})
+	imp.lines = append(imp.lines, line)
+
+	if len(imp.ops) == importChunk {
+		return imp.flush()
+	}
+
+	return nil
+}
+
+// flush creates the queued users
+func (imp *importer) flush() error {
+	if len(imp.ops) == 0 {
+		return nil
+	}
+
+	c := imp.context
+
+	results, err := imp.handler.repository.Batch(c.Request().Context(), imp.ops, storage.BatchOptions{DryRun: imp.report.DryRun})
+	if err != nil {
+		c.Logger().Errorf("failed to import users: %v", err)
+
+		return imp.abort(http.StatusInternalServerError, "Failed to import users")
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			status, message := batchError(result.Err)
+			imp.fail(model.ImportError{Line: imp.lines[i], UserName: imp.ops[i].User.UserName, Status: status, Error: message})
+
+			continue
+		}
+
+		imp.report.Imported++
+
+		if !imp.report.DryRun {
+			imp.handler.broker.PublishChange(c.Request().Context(), model.EventUserCreated, result.User)
+		}
+	}
+
+	imp.flushedLine = imp.lines[len(imp.lines)-1]
+	imp.ops, imp.lines = imp.ops[:0], imp.lines[:0]
+
+	return nil
+}
+
+// abort is the error an import stopping midway is answered with, the users
+// of the chunks created before are kept so it tells how many there are
+func (imp *importer) abort(status int, message string) *echo.HTTPError {
+	if imp.report.DryRun || imp.report.Imported == 0 {
+		return echo.NewHTTPError(status, message)
+	}
+
+	return echo.NewHTTPError(status, fmt.Sprintf("%s, %d users were created from the rows up to line %d",
+		message, imp.report.Imported, imp.flushedLine))
+}
+
+// fail reports a row that wasn't imported
+func (imp *importer) fail(err model.ImportError) {
+	imp.report.Failed++
+
+	if len(imp.report.Errors) == maxImportErrors {
+		imp.report.Truncated = true
+
+		return
+	}
+
+	imp.report.Errors = append(imp.report.Errors, err)
+}
+
+// duplicate reports a row clashing with the row on line first
+func duplicate(line int, user *model.User, err error, first int) model.ImportError {
+	return model.ImportError{
+		Line:     line,
+		UserName: user.UserName,
+		Status:   http.StatusConflict,
+		Error:    fmt.Sprintf("%v by the row on line %d", err, first),
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+var emailDomainPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+func (u UserHandler) listOptions(c echo.Context) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Filter: storage.UserFilter{
+			Status:      c.QueryParam("user_status"),
+			Department:  c.QueryParam("department"),
+			EmailDomain: c.QueryParam("email_domain"),
+		},
+		Limit: defaultPageLimit,
+	}
+
+	var err error
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, `'limit' is not a number`)
+		}
+
+		if opts.Limit < 1 || opts.Limit > maxPageLimit {
+			return opts, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf(`'limit' must be between 1 and %d`, maxPageLimit))
+		}
+	}
+
+	if offset := c.QueryParam("offset"); offset != "" {
+		if opts.Offset, err = strconv.Atoi(offset); err != nil {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, `'offset' is not a number`)
+		}
+
+		if opts.Offset < 0 {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, `'offset' is invalid`)
+		}
+	}
+
+	if status := opts.Filter.Status; status != "" && status != "A" && status != "I" && status != "T" {
+		return opts, echo.NewHTTPError(http.StatusBadRequest, `'user_status' is invalid`)
+	}
+
+	if domain := opts.Filter.EmailDomain; domain != "" && !emailDomainPattern.MatchString(domain) {
+		return opts, echo.NewHTTPError(http.StatusBadRequest, `'email_domain' is invalid`)
+	}
+
+	if opts.AsOf, err = parseAsOf(c); err != nil {
+		return opts, err
+	}
+
+	if opts.Sort, err = storage.ParseSort(c.QueryParam("sort")); err != nil {
+		if errors.Is(err, storage.ErrInvalidSort) {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		return opts, err
+	}
+
+	token := c.QueryParam("cursor")
+	if token == "" {
+		return opts, nil
+	}
+
+	if opts.Offset > 0 {
+		return opts, echo.NewHTTPError(http.StatusBadRequest, `'cursor' can't be combined with 'offset'`)
+	}
+
+	if opts.After, err = u.cursors.Decode(token); err != nil {
+		return opts, echo.NewHTTPError(http.StatusBadRequest, `'cursor' is invalid`)
+	}
+
+	if len(opts.Sort) > 0 {
+		if key, ok := storage.KeysetSort(opts.Sort); !ok || key != opts.After.Sort()[0] {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, `'cursor' doesn't match 'sort'`)
+		}
+	}
+
+	return opts, nil
+}
+
+// newUserPage builds the page for users fetched with opts. A listing
+// continued from a cursor is expected to hold one extra user telling
+// whether there is a next page.
+func (u UserHandler) newUserPage(c echo.Context, users []model.User, total int64, opts storage.ListOptions) (model.UserPage, error) {
+	page := model.UserPage{
+		Items:  users,
+		Limit:  opts.Limit,
+		Offset: opts.Offset,
+		Links:  model.PageLinks{Self: c.Request().URL.RequestURI()},
+	}
+
+	var hasNext bool
+
+	if opts.After != nil {
+		hasNext = len(users) > opts.Limit
+		if hasNext {
+			page.Items = users[:opts.Limit]
+		}
+	} else {
+		page.Total = &total
+		hasNext = int64(opts.Offset+opts.Limit) < total
+
+		if hasNext {
+			page.Links.Next = pageLink(c, opts.Limit, opts.Offset+opts.Limit)
+		}
+
+		if opts.Offset > 0 {
+			page.Links.Prev = pageLink(c, opts.Limit, max(opts.Offset-opts.Limit, 0))
+		}
+	}
+
+	key, ok := storage.KeysetSort(opts.Sort)
+	if opts.After != nil {
+		key, ok = opts.After.Sort()[0], true
+	}
+
+	if !hasNext || !ok || len(page.Items) == 0 {
+		return page, nil
+	}
+
+	next, err := u.cursors.Encode(storage.NewCursor(key, page.Items[len(page.Items)-1]))
+	if err != nil {
+		return page, err
+	}
+
+	page.NextCursor = next
+
+	if opts.After != nil {
+		page.Links.Next = cursorLink(c, opts.Limit, next)
+	}
+
+	return page, nil
+}
+
+func pageLink(c echo.Context, limit, offset int) string {
+	query := c.Request().URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+
+	link := url.URL{Path: c.Request().URL.Path, RawQuery: query.Encode()}
+
+	return link.String()
+}
+
+func cursorLink(c echo.Context, limit int, cursor string) string {
+	query := c.Request().URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("cursor", cursor)
+
+	link := url.URL{Path: c.Request().URL.Path, RawQuery: query.Encode()}
+
+	return link.String()
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/andrii-stp/users-crud/model"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	mimeMergePatch = "application/merge-patch+json"
+	mimeJSONPatch  = "application/json-patch+json"
+)
+
+// userPatcher applies a JSON Merge Patch (RFC 7396) or a JSON Patch
+// (RFC 6902) document to the JSON representation of a user
+type userPatcher func(doc []byte) ([]byte, error)
+
+func newUserPatcher(contentType string, body []byte) (userPatcher, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	switch mediaType {
+	case mimeMergePatch:
+		if !json.Valid(body) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Merge patch is not valid JSON")
+		}
+
+		return func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, body)
+		}, nil
+	case mimeJSONPatch:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid JSON patch: %v", err))
+		}
+
+		return func(doc []byte) ([]byte, error) {
+			return patch.Apply(doc)
+		}, nil
+	default:
+		return nil, echo.NewHTTPError(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Content-Type must be %s or %s", mimeMergePatch, mimeJSONPatch))
+	}
+}
+
+// apply patches user in place. The id, version and deletion time are read
+// only: a patch may test them but changes to them are dropped.
+func (p userPatcher) apply(user *model.User) error {
+	doc, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	patched, err := p(doc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Failed to apply patch: %v", err))
+	}
+
+	var result model.User
+	if err = json.Unmarshal(patched, &result); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Patched user is not a valid user")
+	}
+
+	result.UserID, result.Version, result.DeletedAt = user.UserID, user.Version, user.DeletedAt
+	*user = result
+
+	return nil
+}
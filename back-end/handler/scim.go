@@ -0,0 +1,735 @@
+package handler
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// MIMESCIM is the media type of SCIM requests and responses
+const MIMESCIM = "application/scim+json"
+
+// Schemas of the SCIM resources and messages
+const (
+	SCIMUserSchema       = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMEnterpriseSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SCIMListSchema       = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema      = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+const (
+	// scimEmailType is the type of the single email a user has
+	scimEmailType = "work"
+
+	defaultSCIMCount = 100
+	maxSCIMCount     = 1000
+	// maxSCIMFilterScan is how many users a filter the repository can't
+	// test in full is tested on
+	maxSCIMFilterScan = 10000
+)
+
+// scimDocuments are the discovery resources, served as they are
+//
+//go:embed scim/*.json
+var scimDocuments embed.FS
+
+// scimFieldNames renames the user fields of validation errors after the
+// SCIM attributes they're mapped from
+var scimFieldNames = strings.NewReplacer(
+	"'user_name'", "'userName'",
+	"'first_name'", "'name.givenName'",
+	"'last_name'", "'name.familyName'",
+	"'email'", "'emails'",
+	"'user_status'", "'active'",
+)
+
+// SCIMHandler provisions users over SCIM 2.0 (RFC 7643, RFC 7644) for
+// identity providers. Changes go through the same repository and event
+// stream as the REST API.
+type SCIMHandler struct {
+	users *UserHandler
+}
+
+func NewSCIMHandler(users *UserHandler) *SCIMHandler {
+	return &SCIMHandler{users: users}
+}
+
+// SCIMUser is a user as SCIM represents it. Users hold a single email,
+// which is primary, and active users are the ones with status 'A'.
+type SCIMUser struct {
+	Schemas    []string        `json:"schemas"`
+	ID         string          `json:"id,omitempty"`
+	UserName   string          `json:"userName"`
+	Name       *SCIMName       `json:"name,omitempty"`
+	Emails     []SCIMEmail     `json:"emails,omitempty"`
+	Active     *bool           `json:"active,omitempty"`
+	Enterprise *SCIMEnterprise `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta       *SCIMMeta       `json:"meta,omitempty"`
+}
+
+type SCIMName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMEnterprise holds the attributes of the enterprise user extension
+type SCIMEnterprise struct {
+	Department string `json:"department,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+	Version      string `json:"version,omitempty"`
+}
+
+// SCIMListResponse is a page of the users matching a filter, StartIndex is
+// 1-based
+type SCIMListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation adds, replaces or removes the attribute at Path, or
+// the attributes of Value when there's no path
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// SCIMError is the body of the SCIM error responses
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func newSCIMError(status int, scimType, detail string) *SCIMError {
+	return &SCIMError{Schemas: []string{SCIMErrorSchema}, Status: strconv.Itoa(status), SCIMType: scimType, Detail: detail}
+}
+
+func (e *SCIMError) Error() string {
+	return e.Detail
+}
+
+// SCIMErrors answers the errors of the SCIM endpoints with SCIM error
+// responses
+func SCIMErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil || c.Response().Committed {
+			return err
+		}
+
+		var scimErr *SCIMError
+		if !errors.As(err, &scimErr) {
+			status, message := httpError(err)
+			if status == http.StatusInternalServerError {
+				message = http.StatusText(status)
+			}
+
+			scimErr = newSCIMError(status, "", message)
+		}
+
+		status, _ := strconv.Atoi(scimErr.Status)
+		if writeErr := scimJSON(c, status, scimErr); writeErr != nil {
+			return writeErr
+		}
+
+		return err
+	}
+}
+
+// ServiceProviderConfig tells which SCIM features are supported
+func (h SCIMHandler) ServiceProviderConfig(c echo.Context) error {
+	body, err := scimDocuments.ReadFile("scim/service_provider_config.json")
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(http.StatusOK, MIMESCIM, body)
+}
+
+// ResourceTypes lists the resource types, users only
+func (h SCIMHandler) ResourceTypes(c echo.Context) error {
+	return scimDocumentList(c, "scim/resource_types.json")
+}
+
+// ResourceType gets a resource type by id
+func (h SCIMHandler) ResourceType(c echo.Context) error {
+	return scimDocument(c, "scim/resource_types.json", c.Param("id"))
+}
+
+// Schemas lists the schemas of the user attributes
+func (h SCIMHandler) Schemas(c echo.Context) error {
+	return scimDocumentList(c, "scim/schemas.json")
+}
+
+// Schema gets a schema by its URN
+func (h SCIMHandler) Schema(c echo.Context) error {
+	return scimDocument(c, "scim/schemas.json", c.Param("id"))
+}
+
+// List gets a page of the users matching the filter query parameter. The
+// comparisons the repository can test are pushed down to it, a filter it
+// can't test in full is tested on at most maxSCIMFilterScan users and
+// refused with tooMany past them.
+func (h SCIMHandler) List(c echo.Context) error {
+	startIndex, count, err := scimPage(c)
+	if err != nil {
+		return err
+	}
+
+	var expr scimExpr
+
+	if filter := c.QueryParam("filter"); filter != "" {
+		if expr, err = parseSCIMFilter(filter); err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidFilter", err.Error())
+		}
+	}
+
+	var filter storage.UserFilter
+
+	page := SCIMListResponse{Schemas: []string{SCIMListSchema}, StartIndex: startIndex, Resources: []any{}}
+	ctx := c.Request().Context()
+
+	if pushDownSCIMFilter(&filter, expr) {
+		// a limit of 0 would list every user
+		users, total, err := h.users.repository.List(ctx, storage.ListOptions{Filter: filter, Limit: max(count, 1), Offset: startIndex - 1})
+		if err != nil {
+			return scimStorageError(c, "failed to list users", err)
+		}
+
+		page.TotalResults = int(total)
+
+		for _, user := range users[:min(count, len(users))] {
+			page.Resources = append(page.Resources, newSCIMUser(user))
+		}
+	} else {
+		scanned := 0
+
+		err := h.users.repository.Each(ctx, storage.ListOptions{Filter: filter}, func(user model.User) error {
+			if scanned++; scanned > maxSCIMFilterScan {
+				return newSCIMError(http.StatusBadRequest, "tooMany",
+					fmt.Sprintf("the filter has to be tested on more than %d users, narrow it with an eq comparison", maxSCIMFilterScan))
+			}
+
+			if !expr.match(user) {
+				return nil
+			}
+
+			page.TotalResults++
+			if page.TotalResults >= startIndex && len(page.Resources) < count {
+				page.Resources = append(page.Resources, newSCIMUser(user))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return scimStorageError(c, "failed to list users", err)
+		}
+	}
+
+	page.ItemsPerPage = len(page.Resources)
+
+	return scimJSON(c, http.StatusOK, page)
+}
+
+// Get gets a user by id
+func (h SCIMHandler) Get(c echo.Context) error {
+	id, err := parseSCIMID(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.users.repository.Get(c.Request().Context(), id)
+	if err != nil {
+		return scimStorageError(c, "failed to get user", err)
+	}
+
+	return scimUser(c, http.StatusOK, user)
+}
+
+// Create provisions a user, active unless told otherwise
+func (h SCIMHandler) Create(c echo.Context) error {
+	var resource SCIMUser
+	if err := decodeSCIM(c, &resource); err != nil {
+		return err
+	}
+
+	user := model.User{Status: "A"}
+	resource.apply(&user)
+
+	if err := validateSCIM(c, &user); err != nil {
+		return err
+	}
+
+	if err := h.users.repository.Create(c.Request().Context(), &user); err != nil {
+		return scimStorageError(c, "failed to create user", err)
+	}
+
+	h.users.broker.PublishChange(c.Request().Context(), model.EventUserCreated, &user)
+	c.Response().Header().Set(echo.HeaderLocation, scimLocation(user.UserID))
+
+	return scimUser(c, http.StatusCreated, &user)
+}
+
+// Replace replaces every attribute of a user, a user left without active
+// keeps its status
+func (h SCIMHandler) Replace(c echo.Context) error {
+	id, err := parseSCIMID(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var resource SCIMUser
+	if err := decodeSCIM(c, &resource); err != nil {
+		return err
+	}
+
+	var previous model.User
+
+	user, err := h.users.repository.Patch(c.Request().Context(), id, version, func(user *model.User) error {
+		previous = *user
+		resource.apply(user)
+
+		return validateSCIM(c, user)
+	})
+	if err != nil {
+		return scimStorageError(c, "failed to replace user", err)
+	}
+
+	h.users.broker.PublishUpdate(c.Request().Context(), &previous, user)
+
+	return scimUser(c, http.StatusOK, user)
+}
+
+// Patch applies the operations of a PatchOp request to a user
+func (h SCIMHandler) Patch(c echo.Context) error {
+	id, err := parseSCIMID(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var req SCIMPatchRequest
+	if err := decodeSCIM(c, &req); err != nil {
+		return err
+	}
+
+	if len(req.Operations) == 0 {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "'Operations' is empty")
+	}
+
+	var previous model.User
+
+	user, err := h.users.repository.Patch(c.Request().Context(), id, version, func(user *model.User) error {
+		previous = *user
+		resource := newSCIMUser(*user)
+
+		for _, op := range req.Operations {
+			if err := resource.patch(op); err != nil {
+				return err
+			}
+		}
+
+		resource.apply(user)
+
+		return validateSCIM(c, user)
+	})
+	if err != nil {
+		return scimStorageError(c, "failed to patch user", err)
+	}
+
+	h.users.broker.PublishUpdate(c.Request().Context(), &previous, user)
+
+	return scimUser(c, http.StatusOK, user)
+}
+
+// Delete removes a user for good, unlike the REST API it doesn't keep it in
+// the trash: a SCIM client expects a deleted user to be gone (RFC 7644 §3.6)
+// and provisions it again under the same userName. The user is deleted and
+// purged at once, so a failed request can be retried.
+func (h SCIMHandler) Delete(c echo.Context) error {
+	id, err := parseSCIMID(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.users.repository.Erase(c.Request().Context(), id, version)
+	if err != nil {
+		return scimStorageError(c, "failed to delete user", err)
+	}
+
+	h.users.broker.PublishChange(c.Request().Context(), model.EventUserDeleted, user)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func newSCIMUser(user model.User) SCIMUser {
+	active := user.Status == "A"
+	resource := SCIMUser{
+		Schemas:  []string{SCIMUserSchema},
+		ID:       strconv.FormatInt(user.UserID, 10),
+		UserName: user.UserName,
+		Name:     &SCIMName{GivenName: user.FirstName, FamilyName: user.LastName},
+		Emails:   []SCIMEmail{{Value: user.Email, Type: scimEmailType, Primary: true}},
+		Active:   &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Location:     scimLocation(user.UserID),
+			Version:      scimVersion(user.Version),
+		},
+	}
+
+	if user.Department != "" {
+		resource.Schemas = append(resource.Schemas, SCIMEnterpriseSchema)
+		resource.Enterprise = &SCIMEnterprise{Department: user.Department}
+	}
+
+	return resource
+}
+
+// apply writes the attributes of r to user. Deactivating a user that isn't
+// active keeps its status.
+func (r SCIMUser) apply(user *model.User) {
+	user.UserName = r.UserName
+	user.FirstName, user.LastName, user.Email, user.Department = "", "", "", ""
+
+	if r.Name != nil {
+		user.FirstName, user.LastName = r.Name.GivenName, r.Name.FamilyName
+	}
+
+	for i, email := range r.Emails {
+		if email.Primary || i == 0 {
+			user.Email = email.Value
+		}
+	}
+
+	if r.Enterprise != nil {
+		user.Department = r.Enterprise.Department
+	}
+
+	switch {
+	case r.Active == nil:
+	case *r.Active:
+		user.Status = "A"
+	case user.Status == "A":
+		user.Status = "I"
+	}
+}
+
+// patch applies op to r
+func (r *SCIMUser) patch(op SCIMPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", fmt.Sprintf("'%s' is not an operation", op.Op))
+	}
+
+	if op.Path != "" {
+		if kind == "remove" {
+			return r.set(op.Path, nil)
+		}
+
+		return r.set(op.Path, op.Value)
+	}
+
+	if kind == "remove" {
+		return newSCIMError(http.StatusBadRequest, "noTarget", "'path' is required to remove an attribute")
+	}
+
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &attributes); err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "'value' must be an object when there is no 'path'")
+	}
+
+	for path, value := range attributes {
+		if err := r.set(path, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set sets the attribute at path to value, or removes it when value is
+// nil. A value filter on emails selects the single email.
+func (r *SCIMUser) set(path string, value json.RawMessage) error {
+	key := strings.TrimPrefix(strings.ToLower(path), strings.ToLower(scimCorePrefix))
+
+	if strings.HasPrefix(key, "emails[") {
+		if _, sub, ok := strings.Cut(key, "]"); ok {
+			key = "emails" + sub
+		}
+	}
+
+	if r.Name == nil {
+		r.Name = &SCIMName{}
+	}
+
+	if r.Enterprise == nil {
+		r.Enterprise = &SCIMEnterprise{}
+	}
+
+	switch key {
+	case "username":
+		return decodeSCIMValue(path, value, &r.UserName)
+	case "name":
+		return decodeSCIMValue(path, value, r.Name)
+	case "name.givenname":
+		return decodeSCIMValue(path, value, &r.Name.GivenName)
+	case "name.familyname":
+		return decodeSCIMValue(path, value, &r.Name.FamilyName)
+	case "emails":
+		return decodeSCIMValue(path, value, &r.Emails)
+	case "emails.value":
+		var email string
+		if err := decodeSCIMValue(path, value, &email); err != nil {
+			return err
+		}
+
+		r.Emails = []SCIMEmail{{Value: email, Type: scimEmailType, Primary: true}}
+
+		return nil
+	case "active":
+		return decodeSCIMActive(path, value, &r.Active)
+	case strings.ToLower(SCIMEnterpriseSchema):
+		return decodeSCIMValue(path, value, r.Enterprise)
+	case strings.ToLower(SCIMEnterpriseSchema + ":department"):
+		return decodeSCIMValue(path, value, &r.Enterprise.Department)
+	default:
+		return newSCIMError(http.StatusBadRequest, "invalidPath", fmt.Sprintf("'%s' is not an attribute that can be changed", path))
+	}
+}
+
+// decodeSCIMValue decodes value into target, which is reset when value is nil
+func decodeSCIMValue(path string, value json.RawMessage, target any) error {
+	reflect.ValueOf(target).Elem().SetZero()
+
+	if value == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(value, target); err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", fmt.Sprintf("'%s' is invalid", path))
+	}
+
+	return nil
+}
+
+// decodeSCIMActive decodes active, some identity providers send it as a
+// string
+func decodeSCIMActive(path string, value json.RawMessage, active **bool) error {
+	*active = nil
+
+	if value == nil {
+		return nil
+	}
+
+	var b bool
+	if err := json.Unmarshal(value, &b); err != nil {
+		var s string
+		if json.Unmarshal(value, &s) != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", fmt.Sprintf("'%s' is invalid", path))
+		}
+
+		if b, err = strconv.ParseBool(s); err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", fmt.Sprintf("'%s' is invalid", path))
+		}
+	}
+
+	*active = &b
+
+	return nil
+}
+
+func scimPage(c echo.Context) (int, int, error) {
+	startIndex, count := 1, defaultSCIMCount
+
+	if param := c.QueryParam("startIndex"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil {
+			return 0, 0, newSCIMError(http.StatusBadRequest, "invalidValue", "'startIndex' is not a number")
+		}
+
+		startIndex = max(value, 1)
+	}
+
+	if param := c.QueryParam("count"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil {
+			return 0, 0, newSCIMError(http.StatusBadRequest, "invalidValue", "'count' is not a number")
+		}
+
+		count = min(max(value, 0), maxSCIMCount)
+	}
+
+	return startIndex, count, nil
+}
+
+func parseSCIMID(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, newSCIMError(http.StatusNotFound, "", fmt.Sprintf("user '%s' doesn't exist", c.Param("id")))
+	}
+
+	return id, nil
+}
+
+func decodeSCIM(c echo.Context, v any) error {
+	if err := json.NewDecoder(c.Request().Body).Decode(v); err != nil {
+		c.Logger().Errorf("failed to decode SCIM request: %v", err)
+
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", "Failed to decode request body")
+	}
+
+	return nil
+}
+
+func validateSCIM(c echo.Context, user *model.User) error {
+	if err := c.Validate(*user); err != nil {
+		_, message := httpError(err)
+
+		return newSCIMError(http.StatusBadRequest, "invalidValue", scimFieldNames.Replace(message))
+	}
+
+	return nil
+}
+
+func scimStorageError(c echo.Context, action string, err error) error {
+	var scimErr *SCIMError
+	if errors.As(err, &scimErr) {
+		return scimErr
+	}
+
+	c.Logger().Errorf("%s: %v", action, err)
+
+	switch {
+	case errors.Is(err, storage.ErrAlreadyExist), errors.Is(err, storage.ErrEmailInUse):
+		return newSCIMError(http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, storage.ErrUserNotFound):
+		return newSCIMError(http.StatusNotFound, "", err.Error())
+	case errors.Is(err, storage.ErrVersionConflict):
+		return newSCIMError(http.StatusPreconditionFailed, "", err.Error())
+	default:
+		return newSCIMError(http.StatusInternalServerError, "", http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// scimUser answers with user, tagged with its version
+func scimUser(c echo.Context, status int, user *model.User) error {
+	c.Response().Header().Set("ETag", scimVersion(user.Version))
+
+	return scimJSON(c, status, newSCIMUser(*user))
+}
+
+func scimJSON(c echo.Context, status int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(status, MIMESCIM, body)
+}
+
+func scimLocation(id int64) string {
+	return "/scim/v2/Users/" + strconv.FormatInt(id, 10)
+}
+
+// scimVersion is the weak ETag of a user version, If-Match accepts it
+func scimVersion(version int64) string {
+	return "W/" + strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// scimDocumentList answers with the discovery resources of file as a list
+func scimDocumentList(c echo.Context, file string) error {
+	resources, err := loadSCIMDocuments(file)
+	if err != nil {
+		return err
+	}
+
+	page := SCIMListResponse{
+		Schemas:      []string{SCIMListSchema},
+		TotalResults: len(resources),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    make([]any, len(resources)),
+	}
+
+	for i, resource := range resources {
+		page.Resources[i] = resource
+	}
+
+	return scimJSON(c, http.StatusOK, page)
+}
+
+// scimDocument answers with the discovery resource of file with id
+func scimDocument(c echo.Context, file, id string) error {
+	resources, err := loadSCIMDocuments(file)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		var r struct {
+			ID string `json:"id"`
+		}
+
+		if err := json.Unmarshal(resource, &r); err == nil && r.ID == id {
+			return c.Blob(http.StatusOK, MIMESCIM, resource)
+		}
+	}
+
+	return newSCIMError(http.StatusNotFound, "", fmt.Sprintf("'%s' doesn't exist", id))
+}
+
+func loadSCIMDocuments(file string) ([]json.RawMessage, error) {
+	body, err := scimDocuments.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []json.RawMessage
+
+	return resources, json.Unmarshal(body, &resources)
+}
@@ -0,0 +1,410 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// scimCorePrefix may qualify the attributes of the core user schema
+const scimCorePrefix = SCIMUserSchema + ":"
+
+// scimAttribute is an attribute of a SCIM user a filter can test, it's
+// either a string or a bool
+type scimAttribute struct {
+	name   string
+	isBool bool
+	value  func(user model.User) any
+}
+
+// scimAttributes are the attributes filters can test, by lowercase path.
+// Users hold a single email, the work one, which is primary.
+var scimAttributes = map[string]scimAttribute{
+	"id":              {name: "id", value: func(user model.User) any { return strconv.FormatInt(user.UserID, 10) }},
+	"username":        {name: "userName", value: func(user model.User) any { return user.UserName }},
+	"name.givenname":  {name: "name.givenName", value: func(user model.User) any { return user.FirstName }},
+	"name.familyname": {name: "name.familyName", value: func(user model.User) any { return user.LastName }},
+	"emails":          {name: "emails", value: func(user model.User) any { return user.Email }},
+	"emails.value":    {name: "emails.value", value: func(user model.User) any { return user.Email }},
+	"emails.type":     {name: "emails.type", value: func(model.User) any { return scimEmailType }},
+	"emails.primary":  {name: "emails.primary", isBool: true, value: func(model.User) any { return true }},
+	"active":          {name: "active", isBool: true, value: func(user model.User) any { return user.Status == "A" }},
+	strings.ToLower(SCIMEnterpriseSchema + ":department"): {
+		name: SCIMEnterpriseSchema + ":department", value: func(user model.User) any { return user.Department },
+	},
+}
+
+// scimExpr is a parsed SCIM filter
+type scimExpr interface {
+	match(user model.User) bool
+}
+
+type scimLogical struct {
+	and         bool
+	left, right scimExpr
+}
+
+func (e scimLogical) match(user model.User) bool {
+	if e.and {
+		return e.left.match(user) && e.right.match(user)
+	}
+
+	return e.left.match(user) || e.right.match(user)
+}
+
+type scimNot struct {
+	expr scimExpr
+}
+
+func (e scimNot) match(user model.User) bool {
+	return !e.expr.match(user)
+}
+
+type scimCompare struct {
+	attribute scimAttribute
+	// op is the lowercase operator, "pr" tests the attribute is set
+	op    string
+	value any
+}
+
+func (e scimCompare) match(user model.User) bool {
+	got := e.attribute.value(user)
+
+	if b, ok := got.(bool); ok {
+		switch e.op {
+		case "pr":
+			return true
+		case "eq":
+			return b == e.value
+		default:
+			return b != e.value
+		}
+	}
+
+	// strings are compared regardless of case, as none of the attributes
+	// are case exact
+	s := strings.ToLower(got.(string))
+	if e.op == "pr" {
+		return s != ""
+	}
+
+	v := strings.ToLower(e.value.(string))
+
+	switch e.op {
+	case "eq":
+		return s == v
+	case "ne":
+		return s != v
+	case "co":
+		return strings.Contains(s, v)
+	case "sw":
+		return strings.HasPrefix(s, v)
+	case "ew":
+		return strings.HasSuffix(s, v)
+	case "gt":
+		return s > v
+	case "ge":
+		return s >= v
+	case "lt":
+		return s < v
+	default:
+		return s <= v
+	}
+}
+
+// parseSCIMFilter parses a filter of RFC 7644 section 3.4.2.2
+func parseSCIMFilter(filter string) (scimExpr, error) {
+	tokens, err := scanSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := scimFilterParser{tokens: tokens}
+
+	expr, err := p.or("")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos])
+	}
+
+	return expr, nil
+}
+
+// scanSCIMFilter splits a filter into words, quoted strings and brackets
+func scanSCIMFilter(filter string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(filter); {
+		switch ch := filter[i]; {
+		case ch == ' ':
+			i++
+		case strings.IndexByte("()[]", ch) >= 0:
+			tokens = append(tokens, filter[i:i+1])
+			i++
+		case ch == '"':
+			end := i + 1
+			for ; end < len(filter) && filter[end] != '"'; end++ {
+				if filter[end] == '\\' {
+					end++
+				}
+			}
+
+			if end >= len(filter) {
+				return nil, errors.New("unterminated string")
+			}
+
+			tokens = append(tokens, filter[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && strings.IndexByte(" ()[]\"", filter[end]) < 0 {
+				end++
+			}
+
+			tokens = append(tokens, filter[i:end])
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+type scimFilterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *scimFilterParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	p.pos++
+
+	return p.tokens[p.pos-1]
+}
+
+// accept consumes the next token when it's keyword
+func (p *scimFilterParser) accept(keyword string) bool {
+	if p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], keyword) {
+		p.pos++
+
+		return true
+	}
+
+	return false
+}
+
+func (p *scimFilterParser) expect(token string) error {
+	if !p.accept(token) {
+		return fmt.Errorf("expected '%s'", token)
+	}
+
+	return nil
+}
+
+// or parses a filter, the attributes of a value filter are prefixed with
+// the path of the multi-valued attribute it's applied to
+func (p *scimFilterParser) or(prefix string) (scimExpr, error) {
+	left, err := p.and(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("or") {
+		right, err := p.and(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		left = scimLogical{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *scimFilterParser) and(prefix string) (scimExpr, error) {
+	left, err := p.unary(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("and") {
+		right, err := p.unary(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		left = scimLogical{and: true, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *scimFilterParser) unary(prefix string) (scimExpr, error) {
+	negate := p.accept("not")
+
+	if negate || p.accept("(") {
+		if negate {
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+		}
+
+		expr, err := p.or(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+
+		if negate {
+			return scimNot{expr: expr}, nil
+		}
+
+		return expr, nil
+	}
+
+	return p.attributeExpr(prefix)
+}
+
+func (p *scimFilterParser) attributeExpr(prefix string) (scimExpr, error) {
+	path := p.next()
+	if path == "" {
+		return nil, errors.New("expected an attribute")
+	}
+
+	if p.accept("[") {
+		if prefix != "" {
+			return nil, errors.New("value filters can't be nested")
+		}
+
+		expr, err := p.or(path + ".")
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expect("]")
+	}
+
+	attribute, err := lookupSCIMAttribute(prefix + path)
+	if err != nil {
+		return nil, err
+	}
+
+	op := strings.ToLower(p.next())
+
+	switch op {
+	case "pr":
+		return scimCompare{attribute: attribute, op: op}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("'%s' is not an operator", op)
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(p.next()), &value); err != nil {
+		return nil, fmt.Errorf("expected a value to compare '%s' with", attribute.name)
+	}
+
+	switch value.(type) {
+	case bool:
+		if !attribute.isBool || (op != "eq" && op != "ne") {
+			return nil, fmt.Errorf("'%s' can't be compared with '%s %v'", attribute.name, op, value)
+		}
+	case string:
+		if attribute.isBool {
+			return nil, fmt.Errorf("'%s' is compared with true or false", attribute.name)
+		}
+	default:
+		return nil, fmt.Errorf("'%s' can't be compared with %v", attribute.name, value)
+	}
+
+	return scimCompare{attribute: attribute, op: op, value: value}, nil
+}
+
+func lookupSCIMAttribute(path string) (scimAttribute, error) {
+	key := strings.ToLower(path)
+	key = strings.TrimPrefix(key, strings.ToLower(scimCorePrefix))
+
+	attribute, ok := scimAttributes[key]
+	if !ok {
+		return attribute, fmt.Errorf("'%s' can't be filtered on", path)
+	}
+
+	return attribute, nil
+}
+
+// pushDownSCIMFilter narrows filter with the comparisons of expr the
+// repository can test: the eq comparisons of userName, the name parts and
+// emails, and of active, joined by and. It reports whether filter then
+// matches exactly the users expr does, when it doesn't expr has to be
+// tested on the users filter matches.
+func pushDownSCIMFilter(filter *storage.UserFilter, expr scimExpr) bool {
+	switch expr := expr.(type) {
+	case nil:
+		return true
+	case scimLogical:
+		if !expr.and {
+			return false
+		}
+
+		left := pushDownSCIMFilter(filter, expr.left)
+
+		return pushDownSCIMFilter(filter, expr.right) && left
+	case scimCompare:
+		return pushDownSCIMCompare(filter, expr)
+	default:
+		return false
+	}
+}
+
+func pushDownSCIMCompare(filter *storage.UserFilter, expr scimCompare) bool {
+	if expr.attribute.name == "active" && expr.op != "pr" {
+		active := expr.value == (expr.op == "eq")
+		if active {
+			return setSCIMFilterField(&filter.Status, "A")
+		}
+
+		return setSCIMFilterField(&filter.NotStatus, "A")
+	}
+
+	if expr.op != "eq" {
+		return false
+	}
+
+	switch expr.attribute.name {
+	case "userName":
+		return setSCIMFilterField(&filter.UserName, expr.value.(string))
+	case "name.givenName":
+		return setSCIMFilterField(&filter.FirstName, expr.value.(string))
+	case "name.familyName":
+		return setSCIMFilterField(&filter.LastName, expr.value.(string))
+	case "emails", "emails.value":
+		return setSCIMFilterField(&filter.Email, expr.value.(string))
+	default:
+		return false
+	}
+}
+
+// setSCIMFilterField sets field to value unless it's set to another value
+// already, the comparison then isn't pushed down
+func setSCIMFilterField(field *string, value string) bool {
+	if *field == "" || strings.EqualFold(*field, value) {
+		*field = value
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/labstack/echo/v4"
+)
+
+// heartbeatInterval is how often a comment is sent on an idle stream so
+// that proxies don't close it
+const heartbeatInterval = 15 * time.Second
+
+// resetEvent tells a resuming client it missed events and has to reload the users
+const resetEvent = "reset"
+
+// Events godoc
+//
+//	@Summary		Stream user changes
+//	@Description	stream the changes made to users as Server-Sent Events, each one is a UserCreated, UserUpdated or UserDeleted event holding the user. A client resuming with Last-Event-ID gets the changes it missed, or a reset event when they're no longer kept.
+//	@Tags			users
+//	@Produce		text/event-stream
+//	@Param			department		query		string	false	"Only stream the changes of users in this department or moving out of it"
+//	@Param			Last-Event-ID	header		int		false	"Resume after this event"
+//	@Param			last_event_id	query		int		false	"Resume after this event, for clients that can't set headers"
+//	@Success		200				{object}	model.Event
+//	@Failure		400				{object}	echo.HTTPError
+//	@Router			/users/events [get]
+func (u UserHandler) Events(c echo.Context) error {
+	lastEventID, err := parseLastEventID(c)
+	if err != nil {
+		return err
+	}
+
+	department := c.QueryParam("department")
+	missed, events, cancel, resumed := u.broker.Subscribe(lastEventID)
+
+	defer cancel()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	// keeps nginx from buffering the stream
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		if _, err = fmt.Fprintf(res, "event: %s\ndata: {}\n\n", resetEvent); err != nil {
+			return nil
+		}
+	}
+
+	for _, event := range missed {
+		if err = writeEvent(res, event, department); err != nil {
+			return nil
+		}
+	}
+
+	res.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+		case event, ok := <-events:
+			if !ok {
+				// too slow to keep up, the client resumes from Last-Event-ID
+				return nil
+			}
+
+			if err = writeEvent(res, event, department); err != nil {
+				return nil
+			}
+		}
+
+		res.Flush()
+	}
+}
+
+// writeEvent writes event to the stream unless it's about a user neither in
+// department nor moving out of it
+func writeEvent(res *echo.Response, event model.Event, department string) error {
+	if department != "" && !event.InDepartment(department) {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.EventID, event.Type, data)
+
+	return err
+}
+
+func parseLastEventID(c echo.Context) (int64, error) {
+	param := c.Request().Header.Get("Last-Event-ID")
+	if param == "" {
+		param = c.QueryParam("last_event_id")
+	}
+
+	if param == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || id < 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, `'Last-Event-ID' is not an event id`)
+	}
+
+	return id, nil
+}
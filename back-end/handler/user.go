@@ -2,9 +2,13 @@ package handler
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/andrii-stp/users-crud/events"
 	"github.com/andrii-stp/users-crud/model"
 	"github.com/andrii-stp/users-crud/storage"
 	"github.com/labstack/echo/v4"
@@ -13,34 +17,177 @@ import (
 // UserHandler example
 type UserHandler struct {
 	repository storage.UserRepository
+	cursors    *CursorCodec
+	broker     *events.Broker
 }
 
 // NewUserHandler example
-func NewUserHandler(repository storage.UserRepository) *UserHandler {
-	return &UserHandler{repository: repository}
+func NewUserHandler(repository storage.UserRepository, cursors *CursorCodec, broker *events.Broker) *UserHandler {
+	return &UserHandler{repository: repository, cursors: cursors, broker: broker}
 }
 
 // List godoc
 //
 //	@Summary		List users
-//	@Description	get users
+//	@Description	get a page of users, optionally filtered and sorted
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{array}		model.User
-//	@Failure		500	{object}	echo.HTTPError
+//	@Param			limit			query		int		false	"Page size"	default(50)	maximum(1000)
+//	@Param			offset			query		int		false	"Number of users to skip"
+//	@Param			user_status		query		string	false	"Filter by status"	Enums(A, I, T)
+//	@Param			department		query		string	false	"Filter by department"
+//	@Param			email_domain	query		string	false	"Filter by email domain"
+//	@Param			sort			query		string	false	"Sort columns, '-' prefix for descending"	example(last_name,-user_id)
+//	@Param			cursor			query		string	false	"Continue after the cursor returned as next_cursor"
+//	@Param			as_of			query		string	false	"List the users as they were at this RFC 3339 time"	Format(date-time)
+//	@Success		200				{object}	model.UserPage
+//	@Failure		400				{object}	echo.HTTPError
+//	@Failure		500				{object}	echo.HTTPError
 //	@Router			/users [get]
 func (u UserHandler) List(c echo.Context) error {
+	return u.list(c, false)
+}
+
+// Trash godoc
+//
+//	@Summary		List deleted users
+//	@Description	get a page of the users in the trash, they're purged once the retention period is over
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit			query		int		false	"Page size"	default(50)	maximum(1000)
+//	@Param			offset			query		int		false	"Number of users to skip"
+//	@Param			user_status		query		string	false	"Filter by status"	Enums(A, I, T)
+//	@Param			department		query		string	false	"Filter by department"
+//	@Param			email_domain	query		string	false	"Filter by email domain"
+//	@Param			sort			query		string	false	"Sort columns, '-' prefix for descending"	example(last_name,-user_id)
+//	@Param			cursor			query		string	false	"Continue after the cursor returned as next_cursor"
+//	@Param			as_of			query		string	false	"List the users as they were at this RFC 3339 time"	Format(date-time)
+//	@Success		200				{object}	model.UserPage
+//	@Failure		400				{object}	echo.HTTPError
+//	@Failure		500				{object}	echo.HTTPError
+//	@Router			/users/trash [get]
+func (u UserHandler) Trash(c echo.Context) error {
+	return u.list(c, true)
+}
+
+func (u UserHandler) list(c echo.Context, deleted bool) error {
 	logger := c.Logger()
 
-	users, err := u.repository.List(c.Request().Context())
+	opts, err := u.listOptions(c)
+	if err != nil {
+		return err
+	}
+
+	opts.Filter.Deleted = deleted
+
+	query := opts
+	if query.After != nil {
+		query.Limit++
+	}
+
+	users, total, err := u.repository.List(c.Request().Context(), query)
 	if err != nil {
 		logger.Errorf("failed to get users from database: %v", err)
 
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			return echo.NewHTTPError(http.StatusBadRequest, `'cursor' is invalid`)
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users")
+	}
+
+	page, err := u.newUserPage(c, users, total, opts)
+	if err != nil {
+		logger.Errorf("failed to build users page: %v", err)
+
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users")
 	}
 
-	return c.JSON(http.StatusOK, users)
+	return c.JSON(http.StatusOK, page)
+}
+
+// Get godoc
+//
+//	@Summary		Get user
+//	@Description	get user by ID
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int		true	"User ID"	Format(int64)
+//	@Param			as_of	query		string	false	"Read the user as it was at this RFC 3339 time"	Format(date-time)
+//	@Success		200		{object}	model.User
+//	@Header			200		{string}	ETag	"User version"
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		404		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/users/{id} [get]
+func (u UserHandler) Get(c echo.Context) error {
+	logger := c.Logger()
+
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	asOf, err := parseAsOf(c)
+	if err != nil {
+		return err
+	}
+
+	var user *model.User
+	if asOf.IsZero() {
+		user, err = u.repository.Get(c.Request().Context(), id)
+	} else {
+		user, err = u.repository.GetAsOf(c.Request().Context(), id, asOf)
+	}
+
+	if err != nil {
+		logger.Errorf("failed to get user: %v", err)
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+
+	setETag(c, user)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// GetByUserName godoc
+//
+//	@Summary		Get user by username
+//	@Description	get user by username
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path		string	true	"Username"
+//	@Success		200		{object}	model.User
+//	@Header			200		{string}	ETag	"User version"
+//	@Failure		404		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/users/by-username/{name} [get]
+func (u UserHandler) GetByUserName(c echo.Context) error {
+	logger := c.Logger()
+
+	user, err := u.repository.GetByUserName(c.Request().Context(), c.Param("name"))
+	if err != nil {
+		logger.Errorf("failed to get user by username: %v", err)
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+
+	setETag(c, user)
+
+	return c.JSON(http.StatusOK, user)
 }
 
 // Create godoc
@@ -51,6 +198,7 @@ func (u UserHandler) List(c echo.Context) error {
 //	@Produce		json
 //	@Param			user	body		model.User		true	"Create user"
 //	@Success		201		{object}	model.User
+//	@Header			201		{string}	ETag	"User version"
 //	@Failure		400		{object}	echo.HTTPError
 //	@Failure		409		{object}	echo.HTTPError
 //	@Failure		500		{object}	echo.HTTPError
@@ -72,13 +220,16 @@ func (u UserHandler) Create(c echo.Context) error {
 	if err := u.repository.Create(c.Request().Context(), &user); err != nil {
 		logger.Errorf("failed to create user: %v", err)
 
-		if errors.Is(err, storage.ErrAlreadyExist) {
+		if errors.Is(err, storage.ErrAlreadyExist) || errors.Is(err, storage.ErrEmailInUse) {
 			return echo.NewHTTPError(http.StatusConflict, err.Error())
 		}
 
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
 	}
 
+	u.broker.PublishChange(c.Request().Context(), model.EventUserCreated, &user)
+	setETag(c, &user)
+
 	return c.JSON(http.StatusCreated, user)
 }
 
@@ -88,22 +239,23 @@ func (u UserHandler) Create(c echo.Context) error {
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
-//	@Param			id		path		int				true	"Update user"
-//	@Param			user	body		model.User		true	"Update user"
-//	@Success		201		{object}	model.User
-//	@Failure		400		{object}	echo.HTTPError
-//	@Failure		409		{object}	echo.HTTPError
-//	@Failure		500		{object}	echo.HTTPError
-//	@Router			/users{id} [put]
+//	@Param			id			path		int			true	"User ID"	Format(int64)
+//	@Param			user		body		model.User	true	"Update user"
+//	@Param			If-Match	header		string		false	"ETag of the user version being updated"
+//	@Success		200			{object}	model.User
+//	@Header			200			{string}	ETag	"User version"
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		404			{object}	echo.HTTPError
+//	@Failure		409			{object}	echo.HTTPError
+//	@Failure		412			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Router			/users/{id} [put]
 func (u UserHandler) Update(c echo.Context) error {
 	logger := c.Logger()
-	idParam := c.Param("id")
 
-	id, err := strconv.ParseInt(idParam, 10, 64)
+	id, err := parseID(c)
 	if err != nil {
-		logger.Errorf("failed to convert id to int: %v", err)
-
-		return echo.NewHTTPError(http.StatusBadRequest, `'id' is not a number`)
+		return err
 	}
 
 	var user model.User
@@ -117,14 +269,23 @@ func (u UserHandler) Update(c echo.Context) error {
 		return err
 	}
 
-	err = u.repository.Update(c.Request().Context(), id, &user)
+	// the version comes from If-Match only, a version in the body is ignored
+	if user.Version, err = ifMatchVersion(c); err != nil {
+		return err
+	}
+
+	previous, err := u.repository.Update(c.Request().Context(), id, &user)
 	if err != nil {
 		logger.Errorf("failed to update user: %v", err)
 
-		if errors.Is(err, storage.ErrAlreadyExist) {
+		if errors.Is(err, storage.ErrAlreadyExist) || errors.Is(err, storage.ErrEmailInUse) {
 			return echo.NewHTTPError(http.StatusConflict, err.Error())
 		}
 
+		if errors.Is(err, storage.ErrVersionConflict) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+
 		if errors.Is(err, storage.ErrUserNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
 		}
@@ -132,42 +293,214 @@ func (u UserHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update user")
 	}
 
+	u.broker.PublishUpdate(c.Request().Context(), previous, &user)
+	setETag(c, &user)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// Patch godoc
+//
+//	@Summary		Patch user
+//	@Description	change some fields of a user with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+//	@Tags			users
+//	@Accept			application/merge-patch+json,application/json-patch+json
+//	@Produce		json
+//	@Param			id			path		int		true	"User ID"	Format(int64)
+//	@Param			patch		body		object	true	"Merge patch object or JSON patch operations"
+//	@Param			If-Match	header		string	false	"ETag of the user version being patched"
+//	@Success		200			{object}	model.User
+//	@Header			200			{string}	ETag	"User version"
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		404			{object}	echo.HTTPError
+//	@Failure		409			{object}	echo.HTTPError
+//	@Failure		412			{object}	echo.HTTPError
+//	@Failure		415			{object}	echo.HTTPError
+//	@Failure		422			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Router			/users/{id} [patch]
+func (u UserHandler) Patch(c echo.Context) error {
+	logger := c.Logger()
+
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		logger.Errorf("failed to read patch: %v", err)
+
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read request body")
+	}
+
+	patcher, err := newUserPatcher(c.Request().Header.Get(echo.HeaderContentType), body)
+	if err != nil {
+		return err
+	}
+
+	var previous model.User
+
+	user, err := u.repository.Patch(c.Request().Context(), id, version, func(user *model.User) error {
+		previous = *user
+
+		if err := patcher.apply(user); err != nil {
+			return err
+		}
+
+		return c.Validate(*user)
+	})
+	if err != nil {
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			return httpErr
+		}
+
+		logger.Errorf("failed to patch user: %v", err)
+
+		if errors.Is(err, storage.ErrAlreadyExist) || errors.Is(err, storage.ErrEmailInUse) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+
+		if errors.Is(err, storage.ErrVersionConflict) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to patch user")
+	}
+
+	u.broker.PublishUpdate(c.Request().Context(), &previous, user)
+	setETag(c, user)
+
 	return c.JSON(http.StatusOK, user)
 }
 
 // Delete godoc
 //
 //	@Summary		Delete user
-//	@Description	Delete by username
+//	@Description	move the user to the trash, its username and email stay reserved until it's purged
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path		int	true	"User ID"	Format(int64)
-//	@Success		204	{object}	model.User
-//	@Failure		400	{object}	echo.HTTPError
-//	@Failure		404	{object}	echo.HTTPError
-//	@Failure		500	{object}	echo.HTTPError
+//	@Param			id			path		int		true	"User ID"	Format(int64)
+//	@Param			If-Match	header		string	false	"ETag of the user version being deleted"
+//	@Success		204
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		404			{object}	echo.HTTPError
+//	@Failure		412			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
 //	@Router			/users/{id} [delete]
 func (u UserHandler) Delete(c echo.Context) error {
 	logger := c.Logger()
-	idParam := c.Param("id")
 
-	id, err := strconv.ParseInt(idParam, 10, 64)
+	id, err := parseID(c)
 	if err != nil {
-		logger.Errorf("failed to convert id to int: %v", err)
+		return err
+	}
 
-		return echo.NewHTTPError(http.StatusBadRequest, `'id' is not a number`)
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
 	}
 
-	if err := u.repository.Delete(c.Request().Context(), id); err != nil {
+	// read first so that the stream tells which user went to the trash
+	user, err := u.repository.Get(c.Request().Context(), id)
+	if err == nil {
+		err = u.repository.Delete(c.Request().Context(), id, version)
+	}
+
+	if err != nil {
 		logger.Errorf("failed to delete user: %v", err)
 
 		if errors.Is(err, storage.ErrUserNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
 		}
 
+		if errors.Is(err, storage.ErrVersionConflict) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete user")
 	}
 
+	deletedAt := time.Now().UTC()
+	user.DeletedAt = &deletedAt
+	u.broker.PublishChange(c.Request().Context(), model.EventUserDeleted, user)
+
 	return c.NoContent(http.StatusNoContent)
 }
+
+// Restore godoc
+//
+//	@Summary		Restore user
+//	@Description	bring a deleted user back from the trash
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"	Format(int64)
+//	@Success		200	{object}	model.User
+//	@Header			200	{string}	ETag	"User version"
+//	@Failure		400	{object}	echo.HTTPError
+//	@Failure		404	{object}	echo.HTTPError
+//	@Failure		500	{object}	echo.HTTPError
+//	@Router			/users/{id}/restore [post]
+func (u UserHandler) Restore(c echo.Context) error {
+	logger := c.Logger()
+
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := u.repository.Restore(c.Request().Context(), id)
+	if err != nil {
+		logger.Errorf("failed to restore user: %v", err)
+
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to restore user")
+	}
+
+	u.broker.PublishChange(c.Request().Context(), model.EventUserUpdated, user)
+	setETag(c, user)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+func parseID(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Logger().Errorf("failed to convert id to int: %v", err)
+
+		return 0, echo.NewHTTPError(http.StatusBadRequest, `'id' is not a number`)
+	}
+
+	return id, nil
+}
+
+// parseBool reads the boolean query parameter name, fallback is returned
+// when it's left out
+func parseBool(c echo.Context, name string, fallback bool) (bool, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("'%s' is not a boolean", name))
+	}
+
+	return value, nil
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// WebhookHandler manages the webhook subscriptions to user events and their
+// deliveries
+type WebhookHandler struct {
+	repository storage.WebhookRepository
+}
+
+func NewWebhookHandler(repository storage.WebhookRepository) *WebhookHandler {
+	return &WebhookHandler{repository: repository}
+}
+
+// List godoc
+//
+//	@Summary	List webhooks
+//	@Tags		webhooks
+//	@Produce	json
+//	@Success	200	{object}	model.WebhookList
+//	@Failure	500	{object}	echo.HTTPError
+//	@Router		/webhooks [get]
+func (w WebhookHandler) List(c echo.Context) error {
+	webhooks, err := w.repository.ListWebhooks(c.Request().Context())
+	if err != nil {
+		c.Logger().Errorf("failed to list webhooks: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list webhooks")
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return c.JSON(http.StatusOK, model.WebhookList{Items: webhooks})
+}
+
+// Get godoc
+//
+//	@Summary	Get webhook
+//	@Tags		webhooks
+//	@Produce	json
+//	@Param		id	path		int	true	"Webhook ID"	Format(int64)
+//	@Success	200	{object}	model.Webhook
+//	@Failure	400	{object}	echo.HTTPError
+//	@Failure	404	{object}	echo.HTTPError
+//	@Failure	500	{object}	echo.HTTPError
+//	@Router		/webhooks/{id} [get]
+func (w WebhookHandler) Get(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := w.repository.GetWebhook(c.Request().Context(), id)
+	if err != nil {
+		return webhookError(c, err, "Failed to get webhook")
+	}
+
+	webhook.Secret = ""
+
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// Create godoc
+//
+//	@Summary		Create webhook
+//	@Description	subscribe a URL to user events, filtered by event type, department and status. The deliveries are signed with the secret, a random one is generated when it's left out and it's only returned here. Deliveries to internal addresses are refused and redirects aren't followed.
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body		model.Webhook	true	"Create webhook"
+//	@Success		201		{object}	model.Webhook
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/webhooks [post]
+func (w WebhookHandler) Create(c echo.Context) error {
+	logger := c.Logger()
+
+	var webhook model.Webhook
+	if err := c.Bind(&webhook); err != nil {
+		logger.Errorf("failed to bind to webhook type: %v", err)
+
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind request body")
+	}
+
+	if err := c.Validate(webhook); err != nil {
+		return err
+	}
+
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create webhook")
+		}
+
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := w.repository.CreateWebhook(c.Request().Context(), &webhook); err != nil {
+		logger.Errorf("failed to create webhook: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create webhook")
+	}
+
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+// Delete godoc
+//
+//	@Summary	Delete webhook
+//	@Tags		webhooks
+//	@Param		id	path	int	true	"Webhook ID"	Format(int64)
+//	@Success	204
+//	@Failure	400	{object}	echo.HTTPError
+//	@Failure	404	{object}	echo.HTTPError
+//	@Failure	500	{object}	echo.HTTPError
+//	@Router		/webhooks/{id} [delete]
+func (w WebhookHandler) Delete(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	if err = w.repository.DeleteWebhook(c.Request().Context(), id); err != nil {
+		return webhookError(c, err, "Failed to delete webhook")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Deliveries godoc
+//
+//	@Summary		List webhook deliveries
+//	@Description	list the deliveries of a webhook, newest first. The failed ones ran out of attempts and make up the dead-letter list.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id		path		int		true	"Webhook ID"	Format(int64)
+//	@Param			status	query		string	false	"Filter by status"	Enums(pending, delivered, failed)
+//	@Success		200		{object}	model.DeliveryList
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		404		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Router			/webhooks/{id}/deliveries [get]
+func (w WebhookHandler) Deliveries(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	status := c.QueryParam("status")
+	if status != "" && status != model.DeliveryPending && status != model.DeliveryDelivered && status != model.DeliveryFailed {
+		return echo.NewHTTPError(http.StatusBadRequest, `'status' is invalid`)
+	}
+
+	deliveries, err := w.repository.ListDeliveries(c.Request().Context(), id, status)
+	if err != nil {
+		return webhookError(c, err, "Failed to list deliveries")
+	}
+
+	return c.JSON(http.StatusOK, model.DeliveryList{Items: deliveries})
+}
+
+// Replay godoc
+//
+//	@Summary		Replay webhook delivery
+//	@Description	queue a delivery again with a fresh set of attempts
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id			path		int	true	"Webhook ID"	Format(int64)
+//	@Param			delivery_id	path		int	true	"Delivery ID"	Format(int64)
+//	@Success		200			{object}	model.Delivery
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		404			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Router			/webhooks/{id}/deliveries/{delivery_id}/replay [post]
+func (w WebhookHandler) Replay(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, `'delivery_id' is not a number`)
+	}
+
+	delivery, err := w.repository.GetDelivery(c.Request().Context(), id, deliveryID)
+	if err != nil {
+		return webhookError(c, err, "Failed to replay delivery")
+	}
+
+	if err = w.replay(c, delivery); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// ReplayFailed godoc
+//
+//	@Summary		Replay failed webhook deliveries
+//	@Description	queue every delivery of the dead-letter list again with a fresh set of attempts
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"	Format(int64)
+//	@Success		200	{object}	model.DeliveryList
+//	@Failure		400	{object}	echo.HTTPError
+//	@Failure		404	{object}	echo.HTTPError
+//	@Failure		500	{object}	echo.HTTPError
+//	@Router			/webhooks/{id}/deliveries/replay [post]
+func (w WebhookHandler) ReplayFailed(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := w.repository.ListDeliveries(c.Request().Context(), id, model.DeliveryFailed)
+	if err != nil {
+		return webhookError(c, err, "Failed to replay deliveries")
+	}
+
+	for i := range deliveries {
+		if err = w.replay(c, &deliveries[i]); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, model.DeliveryList{Items: deliveries})
+}
+
+func (w WebhookHandler) replay(c echo.Context, delivery *model.Delivery) error {
+	delivery.Status = model.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	delivery.DeliveredAt = nil
+
+	if err := w.repository.SaveDelivery(c.Request().Context(), delivery); err != nil {
+		c.Logger().Errorf("failed to replay delivery: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to replay delivery")
+	}
+
+	return nil
+}
+
+// webhookError maps the errors of the webhook repository to HTTP errors
+func webhookError(c echo.Context, err error, message string) error {
+	c.Logger().Errorf("%s: %v", message, err)
+
+	if errors.Is(err, storage.ErrWebhookNotFound) || errors.Is(err, storage.ErrDeliveryNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	return echo.NewHTTPError(http.StatusInternalServerError, message)
+}
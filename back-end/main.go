@@ -8,17 +8,28 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
 
 	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/events"
 	"github.com/andrii-stp/users-crud/router"
+	"github.com/andrii-stp/users-crud/rpc"
 	"github.com/andrii-stp/users-crud/storage"
-
-	_ "github.com/swaggo/echo-swagger/example/docs"
 )
 
 func main() {
+	autoMigrate := flag.Bool("migrate", true, "apply pending database migrations at startup")
+	flag.Usage = usage
+	flag.Parse()
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	configFile := ".env"
 
@@ -28,23 +39,206 @@ func main() {
 		os.Exit(1)
 	}
 
-	db, err := storage.Connect(cfg.Database)
+	if flag.Arg(0) == "migrate" {
+		if err = migrate(context.Background(), logger, cfg.Database, flag.Args()[1:]); err != nil {
+			logger.Error("failed to migrate database", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	repo, err := newRepository(context.Background(), logger, cfg.Database, *autoMigrate)
 	if err != nil {
-		logger.Error("failed to connect to database", slog.String("err", err.Error()))
+		logger.Error("failed to set up storage", slog.String("err", err.Error()))
 		os.Exit(1)
 	}
 
-	if err = storage.InitDB(db); err != nil {
-		logger.Error("failed to initialize database schema", slog.String("err", err.Error()))
+	go purgeTrash(context.Background(), logger, repo, cfg.Trash.Retention)
+
+	sink, err := events.OpenSink(cfg.Events.Sink)
+	if err != nil {
+		logger.Error("failed to set up event sink", slog.String("err", err.Error()))
 		os.Exit(1)
 	}
 
-	repo := storage.NewPostgresRepository(logger, db)
+	var sinks []events.Sink
+
+	if sink != nil {
+		defer sink.Close()
+
+		sinks = append(sinks, sink)
+	}
+
+	if webhooks, ok := repo.(storage.WebhookRepository); ok {
+		sinks = append(sinks, events.NewWebhookSink(webhooks))
+
+		go events.NewWebhookDeliverer(logger, webhooks, nil, cfg.Events.PollInterval).Run(context.Background())
+	}
+
+	if outbox, ok := repo.(storage.Outbox); ok && len(sinks) > 0 {
+		go events.NewDispatcher(logger, outbox, cfg.Events.PollInterval, sinks...).Run(context.Background())
+	}
+
+	// shared so that the REST event stream and Watch see the changes made
+	// through either API
+	broker := events.NewBroker(router.DefaultEventBuffer)
+
+	if cfg.Server.GRPCPort != "" {
+		go serveGRPC(logger, repo, broker, cfg.Auth, cfg.Server.GRPCPort)
+	}
+
+	if cfg.Server.CursorSecret == "" && cfg.Database.Driver != "memory" {
+		logger.Warn("CURSOR_SECRET isn't set, list cursors are signed with a random key and won't survive a restart or work across replicas")
+	}
 
-	server := router.Router(logger, repo)
+	server := router.Router(logger, repo,
+		router.WithCursorSecret([]byte(cfg.Server.CursorSecret)),
+		router.WithEventBroker(broker),
+		router.WithIdentity(router.AuthorizationIdentity(cfg.Auth.Identity)),
+		router.WithOpenAPIValidation(router.OpenAPIValidation{
+			Requests:  cfg.Server.ValidateRequests,
+			Responses: cfg.Server.ValidateResponses,
+		}),
+	)
 	port := ":" + cfg.Server.Port
 
 	if err = server.Start(port); err != nil {
 		server.Logger.Fatal("error when server is initializing")
 	}
 }
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage:\n")
+	fmt.Fprintf(out, "  %s [flags]                    start the server\n", os.Args[0])
+	fmt.Fprintf(out, "  %s migrate up|down|status|goto N\n\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func newRepository(ctx context.Context, logger *slog.Logger, cfg *config.Database, autoMigrate bool) (storage.UserRepository, error) {
+	if cfg.Driver == "memory" {
+		return storage.NewMemoryRepository(), nil
+	}
+
+	db, err := storage.Connect(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if autoMigrate {
+		migrator, err := storage.NewMigrator(logger, db, cfg.Driver)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = migrator.Up(ctx); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+
+	return storage.NewRepository(logger, db, cfg.Driver)
+}
+
+// serveGRPC serves the gRPC UserService on port, calls are authenticated
+// with the tokens of auth. The process exits when it can't.
+func serveGRPC(logger *slog.Logger, repo storage.UserRepository, broker *events.Broker, auth *config.Auth, port string) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		logger.Error("failed to listen for gRPC", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	server := rpc.NewServer(logger, repo, broker, router.NewUserValidator(logger),
+		rpc.WithIdentity(rpc.AuthorizationIdentity(auth.Identity)))
+
+	if err = server.Serve(listener); err != nil {
+		logger.Error("failed to serve gRPC", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// purgeInterval is how often the users past the trash retention are purged
+const purgeInterval = time.Hour
+
+// purgeTrash permanently removes the users deleted more than retention ago,
+// once at startup and then every purgeInterval until ctx is done.
+func purgeTrash(ctx context.Context, logger *slog.Logger, repo storage.UserRepository, retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := repo.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logger.Error("failed to purge deleted users", slog.String("err", err.Error()))
+		} else if purged > 0 {
+			logger.Info("purged deleted users", slog.Int64("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func migrate(ctx context.Context, logger *slog.Logger, cfg *config.Database, args []string) error {
+	if len(args) == 0 {
+		usage()
+
+		return fmt.Errorf("missing migrate command")
+	}
+
+	db, err := storage.Connect(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(logger, db, cfg.Driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "goto":
+		if len(args) < 2 {
+			return fmt.Errorf("missing version for goto")
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[1], err)
+		}
+
+		return migrator.Goto(ctx, version)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
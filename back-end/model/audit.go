@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// AuditEntry records a single change made to a user
+type AuditEntry struct {
+	AuditID int64  `json:"id"`
+	UserID  int64  `json:"user_id"`
+	Actor   string `json:"actor"`
+	// ClaimedActor is who the caller said it acted on behalf of, it isn't
+	// authenticated
+	ClaimedActor string            `json:"claimed_actor,omitempty"`
+	Action       string            `json:"action"`
+	RequestID    string            `json:"request_id,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Changes      map[string]Change `json:"changes"`
+}
+
+// Change holds the values of a field before and after a change, null when
+// the user didn't exist yet or has been purged
+type Change struct {
+	Before any `json:"before" extensions:"x-nullable"`
+	After  any `json:"after" extensions:"x-nullable"`
+}
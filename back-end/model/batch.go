@@ -0,0 +1,33 @@
+package model
+
+// BatchRequest is a list of operations applied in order
+type BatchRequest struct {
+	// Atomic applies every operation or none of them
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation creates a user, or updates or deletes the user with ID.
+// Version, when it's set, is the version the user is expected to be at.
+type BatchOperation struct {
+	Op      string `json:"op" enums:"create,update,delete"`
+	ID      int64  `json:"id,omitempty"`
+	Version int64  `json:"version,omitempty"`
+	User    *User  `json:"user,omitempty"`
+}
+
+// BatchResponse holds the outcome of every operation, in the order they
+// were requested
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+}
+
+// BatchItemResult is the outcome of an operation, Status is the HTTP status
+// it would have got as a request of its own
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Status int    `json:"status"`
+	User   *User  `json:"user,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import "errors"
+
+// Errors the API reports with their message, the repositories return them
+// and clients of the API can tell them apart with errors.Is
+var (
+	ErrAlreadyExist = errors.New("username already in use")
+	ErrEmailInUse   = errors.New("email already in use")
+	ErrUserNotFound = errors.New("user don't exist")
+	// ErrVersionConflict means the user changed since the expected version was read
+	ErrVersionConflict = errors.New("user version conflict")
+	// ErrVersionNotFound means the user never had the requested version
+	ErrVersionNotFound = errors.New("user version don't exist")
+
+	ErrInvalidSort   = errors.New("invalid sort field")
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	ErrWebhookNotFound  = errors.New("webhook don't exist")
+	ErrDeliveryNotFound = errors.New("delivery don't exist")
+)
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Types of the events published when users change
+const (
+	EventUserCreated       = "UserCreated"
+	EventUserUpdated       = "UserUpdated"
+	EventUserStatusChanged = "UserStatusChanged"
+	EventUserDeleted       = "UserDeleted"
+)
+
+// Event tells downstream systems about a change made to a user. User holds
+// the user as it is after the change, PreviousStatus is only set on
+// UserStatusChanged events and PreviousDepartment when the change moved the
+// user out of a department.
+type Event struct {
+	EventID            int64     `json:"id"`
+	Type               string    `json:"type"`
+	UserID             int64     `json:"user_id"`
+	OccurredAt         time.Time `json:"occurred_at"`
+	RequestID          string    `json:"request_id,omitempty"`
+	User               User      `json:"user"`
+	PreviousStatus     string    `json:"previous_status,omitempty"`
+	PreviousDepartment string    `json:"previous_department,omitempty"`
+}
+
+// InDepartment tells whether the user of the event is in department after
+// the change or was in it before, a user moving out of a department is still
+// a change of that department
+func (e Event) InDepartment(department string) bool {
+	return e.User.Department == department || (e.PreviousDepartment != "" && e.PreviousDepartment == department)
+}
@@ -0,0 +1,23 @@
+package model
+
+// ImportReport sums up an import, only the rows that failed are listed
+type ImportReport struct {
+	DryRun bool `json:"dry_run"`
+	Rows   int  `json:"rows"`
+	// Imported is the number of users created, or that would be on a dry run
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+	// Errors lists the first 1000 failed rows, Truncated tells whether
+	// there were more
+	Errors    []ImportError `json:"errors"`
+	Truncated bool          `json:"truncated"`
+}
+
+// ImportError tells why a row wasn't imported, Status is the HTTP status
+// creating the user would have got
+type ImportError struct {
+	Line     int    `json:"line"`
+	UserName string `json:"user_name,omitempty"`
+	Status   int    `json:"status"`
+	Error    string `json:"error"`
+}
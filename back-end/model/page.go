@@ -0,0 +1,28 @@
+package model
+
+// UserPage is a single page of a user listing. Total is left out when the
+// page is fetched with a cursor, NextCursor is set whenever the listing can
+// be continued by keyset.
+type UserPage struct {
+	Items      []User    `json:"items"`
+	Total      *int64    `json:"total,omitempty"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Links      PageLinks `json:"links"`
+}
+
+// PageLinks holds relative links to the neighbouring pages
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// AuditPage is a page of audit log entries, oldest first. Links.Next is set
+// when the page is full and more entries may follow.
+type AuditPage struct {
+	Items []AuditEntry `json:"items"`
+	Limit int          `json:"limit"`
+	Links PageLinks    `json:"links"`
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // User example
 type User struct {
 	UserID     int64  `json:"id"`
@@ -9,4 +11,8 @@ type User struct {
 	Email      string `json:"email"       validate:"required,email"`
 	Status     string `json:"user_status" validate:"required,status"`
 	Department string `json:"department"`
+	// Version is bumped by every update, it's sent back as the ETag
+	Version int64 `json:"version" readonly:"true"`
+	// DeletedAt is set once the user is moved to the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" readonly:"true"`
 }
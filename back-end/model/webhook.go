@@ -0,0 +1,51 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook subscribes an HTTP endpoint to user events. Empty filters match
+// every event. Secret signs the deliveries, it's only shown on creation.
+type Webhook struct {
+	WebhookID  int64     `json:"id" readonly:"true"`
+	URL        string    `json:"url" validate:"required,http_url"`
+	Secret     string    `json:"secret,omitempty" validate:"omitempty,min=16"`
+	EventTypes []string  `json:"event_types,omitempty" validate:"dive,oneof=UserCreated UserUpdated UserStatusChanged UserDeleted"`
+	Department string    `json:"department,omitempty"`
+	Status     string    `json:"user_status,omitempty" validate:"omitempty,status"`
+	CreatedAt  time.Time `json:"created_at" readonly:"true"`
+}
+
+// States of a webhook delivery, failed deliveries make up the dead-letter
+// list once they ran out of attempts
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is an event sent, or to be sent, to a webhook
+type Delivery struct {
+	DeliveryID    int64           `json:"id"`
+	WebhookID     int64           `json:"webhook_id"`
+	EventID       int64           `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload" swaggertype:"object"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// WebhookList holds every webhook, their secrets are left out
+type WebhookList struct {
+	Items []Webhook `json:"items"`
+}
+
+// DeliveryList holds the deliveries of a webhook, newest first
+type DeliveryList struct {
+	Items []Delivery `json:"items"`
+}
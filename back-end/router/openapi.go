@@ -0,0 +1,258 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/docs"
+	"github.com/andrii-stp/users-crud/handler"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo/v4"
+)
+
+// OpenAPIValidation tells what is checked against the OpenAPI spec of the
+// API served at /swagger
+type OpenAPIValidation struct {
+	// Requests rejects the requests that don't match the spec with 400
+	Requests bool
+	// Responses replaces the JSON responses that don't match the spec with
+	// 500, logging why. Responses are buffered to be checked, streams and
+	// files aren't.
+	Responses bool
+}
+
+func init() {
+	// patches are JSON documents, like JSON Patch ones
+	openapi3filter.RegisterBodyDecoder("application/merge-patch+json", openapi3filter.JSONBodyDecoder)
+}
+
+// openAPIValidator validates the requests and responses of the operations of
+// the spec, the others are left alone
+func openAPIValidator(logger *slog.Logger, validation OpenAPIValidation) echo.MiddlewareFunc {
+	doc, err := docs.OpenAPI()
+	if err != nil {
+		panic(fmt.Sprintf("failed to load the OpenAPI spec: %v", err))
+	}
+
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		panic(fmt.Sprintf("failed to route the OpenAPI spec: %v", err))
+	}
+
+	options := &openapi3filter.Options{
+		// the handlers ignore read-only fields, clients send back what they got
+		ExcludeReadOnlyValidations: true,
+		// the handlers apply their own defaults
+		SkipSettingDefaults:   true,
+		IncludeResponseStatus: true,
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+	}
+	options.WithCustomSchemaErrorFunc(schemaErrorMessage)
+
+	streamedOptions := *options
+	streamedOptions.ExcludeRequestBody = true
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			route, pathParams, err := specRouter.FindRoute(req)
+			if err != nil {
+				return next(c)
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+
+			if streamedBody(req) {
+				input.Options = &streamedOptions
+			}
+
+			if validation.Requests {
+				if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+					return requestError(req, err)
+				}
+			}
+
+			if !validation.Responses || !producesJSON(route) {
+				return next(c)
+			}
+
+			return validateResponse(logger, c, next, input)
+		}
+	}
+}
+
+// streamedBodyTypes are the media types of the request bodies the handlers
+// stream, checking them would read them whole into memory first
+var streamedBodyTypes = []string{handler.MIMETextCSV, echo.MIMEMultipartForm}
+
+// streamedBody tells whether the body of req is left to the handler to
+// check as it's read
+func streamedBody(req *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+
+	return slices.Contains(streamedBodyTypes, mediaType)
+}
+
+// validateResponse buffers the response of next to check it against the
+// spec before sending it
+func validateResponse(logger *slog.Logger, c echo.Context, next echo.HandlerFunc, input *openapi3filter.RequestValidationInput) error {
+	res := c.Response()
+	buf := &bufferedWriter{ResponseWriter: res.Writer}
+	res.Writer = buf
+
+	err := next(c)
+	if err != nil {
+		// the error is written now to be checked, echo leaves committed
+		// responses alone afterwards
+		c.Error(err)
+	}
+
+	res.Writer = buf.ResponseWriter
+
+	status := buf.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	validationErr := openapi3filter.ValidateResponse(input.Request.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 status,
+		Header:                 res.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(buf.body.Bytes())),
+		Options:                input.Options,
+	})
+	if validationErr == nil {
+		res.Writer.WriteHeader(status)
+		_, _ = res.Writer.Write(buf.body.Bytes())
+
+		return err
+	}
+
+	logger.Error("response doesn't match the OpenAPI spec",
+		slog.String("method", input.Request.Method),
+		slog.String("uri", input.Request.RequestURI),
+		slog.Int("status", status),
+		slog.String("err", validationErr.Error()))
+
+	for key := range res.Header() {
+		res.Header().Del(key)
+	}
+
+	res.Status = http.StatusInternalServerError
+	res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	res.Writer.WriteHeader(http.StatusInternalServerError)
+	_, _ = res.Writer.Write([]byte(`{"message":"Response doesn't match the API spec"}` + "\n"))
+
+	return err
+}
+
+// producesJSON tells whether the operation of route answers with JSON, its
+// responses can be buffered then
+func producesJSON(route *routers.Route) bool {
+	for _, response := range route.Operation.Responses.Map() {
+		for mediaType := range response.Value.Content {
+			if mediaType != echo.MIMEApplicationJSON {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// bufferedWriter holds back a response until it's validated
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// requestError is the error a request that doesn't match the spec is
+// answered with, a body of a media type the operation doesn't accept is
+// answered with 415 like by the handlers
+func requestError(req *http.Request, err error) *echo.HTTPError {
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) && reqErr.RequestBody != nil && reqErr.Err == nil {
+		content := reqErr.RequestBody.Content
+		if content.Get(req.Header.Get(echo.HeaderContentType)) == nil {
+			mediaTypes := make([]string, 0, len(content))
+			for mediaType := range content {
+				mediaTypes = append(mediaTypes, mediaType)
+			}
+
+			sort.Strings(mediaTypes)
+
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType,
+				"Content-Type must be "+strings.Join(mediaTypes, " or "))
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, requestErrorMessage(err))
+}
+
+// requestErrorMessage tells what's wrong with a request the way the
+// validator of the handlers does
+func requestErrorMessage(err error) string {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return err.Error()
+	}
+
+	reason := reqErr.Reason
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(reqErr.Err, &schemaErr) {
+		reason = schemaErrorMessage(schemaErr)
+	} else if reason == "" && reqErr.Err != nil {
+		reason = reqErr.Err.Error()
+	}
+
+	switch {
+	case reqErr.Parameter != nil:
+		return fmt.Sprintf("'%s' is invalid: %s", reqErr.Parameter.Name, reason)
+	case schemaErr != nil && len(schemaErr.JSONPointer()) == 0:
+		return "request body is invalid: " + reason
+	default:
+		return reason
+	}
+}
+
+// schemaErrorMessage leaves the schema out of the message of err, naming the
+// field it's about instead
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	field := strings.Join(err.JSONPointer(), ".")
+
+	switch {
+	case field == "":
+		return err.Reason
+	case err.SchemaField == "required":
+		return fmt.Sprintf("'%s' is required", field)
+	default:
+		return fmt.Sprintf("'%s' is invalid: %s", field, err.Reason)
+	}
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"crypto/rand"
+
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/graph"
+)
+
+// DefaultEventBuffer is how many user changes the live stream keeps for
+// clients resuming with Last-Event-ID
+const DefaultEventBuffer = 1000
+
+// Option customizes the router built by Router
+type Option func(*options)
+
+type options struct {
+	cursorSecret      []byte
+	broker            *events.Broker
+	graphQLLimits     graph.Limits
+	openAPIValidation OpenAPIValidation
+	identity          IdentityFunc
+}
+
+// WithCursorSecret sets the key list cursors are signed with. Without it a
+// random key is generated, so cursors don't survive a restart and can't be
+// shared between replicas.
+func WithCursorSecret(secret []byte) Option {
+	return func(o *options) {
+		o.cursorSecret = secret
+	}
+}
+
+// WithEventBroker sets the broker the user changes are published to, so
+// that it can be shared or inspected. Without it a broker keeping the last
+// 1000 changes is created.
+func WithEventBroker(broker *events.Broker) Option {
+	return func(o *options) {
+		o.broker = broker
+	}
+}
+
+// WithGraphQLLimits sets how deep and how complex a GraphQL operation can
+// be, graph.DefaultLimits are used without it
+func WithGraphQLLimits(limits graph.Limits) Option {
+	return func(o *options) {
+		o.graphQLLimits = limits
+	}
+}
+
+// WithOpenAPIValidation checks the requests and responses of the REST API
+// against its OpenAPI spec, nothing is checked without it
+func WithOpenAPIValidation(validation OpenAPIValidation) Option {
+	return func(o *options) {
+		o.openAPIValidation = validation
+	}
+}
+
+// WithIdentity sets how requests are authenticated, the changes are recorded
+// in the audit log as made by their identity. Without it every request is
+// anonymous.
+func WithIdentity(identity IdentityFunc) Option {
+	return func(o *options) {
+		o.identity = identity
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{graphQLLimits: graph.DefaultLimits}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if len(o.cursorSecret) == 0 {
+		o.cursorSecret = make([]byte, 32)
+		_, _ = rand.Read(o.cursorSecret)
+	}
+
+	if o.broker == nil {
+		o.broker = events.NewBroker(DefaultEventBuffer)
+	}
+
+	return o
+}
@@ -1,24 +1,35 @@
 package router
 
 import (
+	"cmp"
 	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
 
+	"github.com/andrii-stp/users-crud/docs"
+	"github.com/andrii-stp/users-crud/graph"
 	"github.com/andrii-stp/users-crud/handler"
 	"github.com/andrii-stp/users-crud/storage"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	swagger "github.com/swaggo/echo-swagger"
 )
 
-func Router(logger *slog.Logger, repo storage.UserRepository) *echo.Echo {
+func Router(logger *slog.Logger, repo storage.UserRepository, opts ...Option) *echo.Echo {
+	o := newOptions(opts)
 	e := echo.New()
 	version := e.Group("/api/v1")
 	users := version.Group("/users")
 
-	e.Use(middleware.CORS())
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		// lets the front-end read the user version for If-Match
+		ExposeHeaders: []string{"ETag"},
+	}))
+
+	e.Use(middleware.RequestID())
+	e.Use(auditInfo(o.identity))
 
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus:     true,
@@ -28,20 +39,117 @@ func Router(logger *slog.Logger, repo storage.UserRepository) *echo.Echo {
 		LogValuesFunc: logValues(logger),
 	}))
 
-	e.Validator = &UserValidator{logger: logger, validator: validator.New(validator.WithRequiredStructEnabled())}
+	if o.openAPIValidation.Requests || o.openAPIValidation.Responses {
+		e.Use(openAPIValidator(logger, o.openAPIValidation))
+	}
 
-	e.GET("/swagger/*", swagger.WrapHandler)
+	e.Validator = NewUserValidator(logger)
 
-	userHandler := handler.NewUserHandler(repo)
+	// the Swagger UI would be served an empty document otherwise
+	if _, err := docs.OpenAPIJSON(); err != nil {
+		panic(fmt.Sprintf("failed to load the OpenAPI spec: %v", err))
+	}
+
+	e.GET("/swagger/*", swagger.EchoWrapHandler(swagger.InstanceName(docs.OpenAPIInstanceName)))
+
+	cursors := handler.NewCursorCodec(o.cursorSecret)
+	userHandler := handler.NewUserHandler(repo, cursors, o.broker)
 
 	users.GET("", userHandler.List)
+	users.GET("/events", userHandler.Events)
+	users.GET("/export", userHandler.Export)
+	users.GET("/trash", userHandler.Trash)
+	users.GET("/:id", userHandler.Get)
+	users.GET("/by-username/:name", userHandler.GetByUserName)
 	users.POST("", userHandler.Create)
+	users.POST("\\:batch", userHandler.Batch)
+	users.POST("/import", userHandler.Import)
 	users.PUT("/:id", userHandler.Update)
+	users.PATCH("/:id", userHandler.Patch)
 	users.DELETE("/:id", userHandler.Delete)
+	users.POST("/:id/restore", userHandler.Restore)
+	users.POST("/:id/revert", userHandler.Revert)
+
+	auditHandler := handler.NewAuditHandler(repo)
+
+	users.GET("/:id/history", auditHandler.History)
+	version.GET("/audit", auditHandler.List)
+
+	// every repository stores webhooks, the assertion keeps
+	// UserRepository about users only
+	if webhookRepo, ok := repo.(storage.WebhookRepository); ok {
+		webhooks := version.Group("/webhooks")
+		webhookHandler := handler.NewWebhookHandler(webhookRepo)
+
+		webhooks.GET("", webhookHandler.List)
+		webhooks.POST("", webhookHandler.Create)
+		webhooks.GET("/:id", webhookHandler.Get)
+		webhooks.DELETE("/:id", webhookHandler.Delete)
+		webhooks.GET("/:id/deliveries", webhookHandler.Deliveries)
+		webhooks.POST("/:id/deliveries/replay", webhookHandler.ReplayFailed)
+		webhooks.POST("/:id/deliveries/:delivery_id/replay", webhookHandler.Replay)
+	}
+
+	scim := e.Group("/scim/v2", handler.SCIMErrors)
+	scimHandler := handler.NewSCIMHandler(userHandler)
+
+	scim.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+	scim.GET("/ResourceTypes", scimHandler.ResourceTypes)
+	scim.GET("/ResourceTypes/:id", scimHandler.ResourceType)
+	scim.GET("/Schemas", scimHandler.Schemas)
+	scim.GET("/Schemas/:id", scimHandler.Schema)
+	scim.GET("/Users", scimHandler.List)
+	scim.POST("/Users", scimHandler.Create)
+	scim.GET("/Users/:id", scimHandler.Get)
+	scim.PUT("/Users/:id", scimHandler.Replace)
+	scim.PATCH("/Users/:id", scimHandler.Patch)
+	scim.DELETE("/Users/:id", scimHandler.Delete)
+
+	resolver := graph.NewResolver(logger, repo, cursors, o.broker, e.Validator)
+	e.Any("/graphql", echo.WrapHandler(graph.NewHandler(resolver, o.graphQLLimits)))
 
 	return e
 }
 
+// anonymousActor is recorded for requests that aren't authenticated
+const anonymousActor = "anonymous"
+
+// IdentityFunc returns who a request is authenticated as, "" when it isn't
+type IdentityFunc func(r *http.Request) string
+
+// AuthorizationIdentity authenticates requests by their Authorization header,
+// identify returns who a header value authenticates, "" for nobody
+func AuthorizationIdentity(identify func(authorization string) string) IdentityFunc {
+	return func(r *http.Request) string {
+		return identify(r.Header.Get(echo.HeaderAuthorization))
+	}
+}
+
+// auditInfo passes the actor and the request ID down to the repository, the
+// actor is the identity of the request
+func auditInfo(identity IdentityFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			actor := anonymousActor
+			if identity != nil {
+				actor = cmp.Or(identity(req), anonymousActor)
+			}
+
+			ctx := storage.WithAuditInfo(req.Context(), storage.AuditInfo{
+				Actor:        actor,
+				ClaimedActor: req.Header.Get(storage.ActorHeader),
+				RequestID:    c.Response().Header().Get(echo.HeaderXRequestID),
+			})
+
+			c.SetRequest(req.WithContext(ctx))
+
+			return next(c)
+		}
+	}
+}
+
 func logValues(logger *slog.Logger) func(c echo.Context, v middleware.RequestLoggerValues) error {
 	return func(c echo.Context, v middleware.RequestLoggerValues) error {
 		if v.Error == nil {
@@ -7,7 +7,6 @@ import (
 	"reflect"
 	"strings"
 
-	"github.com/andrii-stp/users-crud/model"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 )
@@ -18,6 +17,12 @@ type UserValidator struct {
 	validator *validator.Validate
 }
 
+// NewUserValidator returns the validator the users are checked with before
+// they're written, whatever API they come from
+func NewUserValidator(logger *slog.Logger) *UserValidator {
+	return &UserValidator{logger: logger, validator: validator.New(validator.WithRequiredStructEnabled())}
+}
+
 // Validation example
 func (uv *UserValidator) Validate(i interface{}) error {
 	err := uv.validator.RegisterValidation("status", userStatusValidation)
@@ -30,16 +35,19 @@ func (uv *UserValidator) Validate(i interface{}) error {
 
 		validationErrors := err.(validator.ValidationErrors)
 
-		elem := reflect.TypeOf(&model.User{}).Elem()
+		elem := reflect.Indirect(reflect.ValueOf(i)).Type()
 		for _, validationError := range validationErrors {
-			field, _ := elem.FieldByName(validationError.Field())
+			// elements checked with dive are named like 'EventTypes[0]'
+			name, _, _ := strings.Cut(validationError.StructField(), "[")
+			field, _ := elem.FieldByName(name)
 			state := "empty"
 
 			if validationError.Tag() != "required" {
 				state = "invalid"
 			}
 
-			messages = append(messages, fmt.Sprintf(`'%s' is %s`, field.Tag.Get("json"), state))
+			jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			messages = append(messages, fmt.Sprintf(`'%s' is %s`, jsonName, state))
 		}
 
 		return echo.NewHTTPError(http.StatusBadRequest, strings.Join(messages, "\n"))
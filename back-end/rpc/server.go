@@ -0,0 +1,223 @@
+package rpc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/events"
+	usersv1 "github.com/andrii-stp/users-crud/proto/users/v1"
+	"github.com/andrii-stp/users-crud/storage"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/random"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// actorKey, requestIDKey and authorizationKey are the metadata counterparts
+// of the X-Actor, X-Request-ID and Authorization headers of the REST API
+var (
+	actorKey         = strings.ToLower(storage.ActorHeader)
+	requestIDKey     = strings.ToLower(echo.HeaderXRequestID)
+	authorizationKey = strings.ToLower(echo.HeaderAuthorization)
+)
+
+// anonymousActor is recorded for calls that aren't authenticated, like by
+// the REST API
+const anonymousActor = "anonymous"
+
+// IdentityFunc returns who a call is authenticated as, "" when it isn't
+type IdentityFunc func(ctx context.Context) string
+
+// AuthorizationIdentity authenticates calls by their authorization metadata,
+// like the Authorization header of the REST API. identify returns who a
+// metadata value authenticates, "" for nobody.
+func AuthorizationIdentity(identify func(authorization string) string) IdentityFunc {
+	return func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		return identify(firstValue(md, authorizationKey))
+	}
+}
+
+// Option customizes the server built by NewServer
+type Option func(*options)
+
+type options struct {
+	identity IdentityFunc
+}
+
+// WithIdentity sets how calls are authenticated, the changes are recorded
+// in the audit log as made by their identity. Without it every call is
+// anonymous.
+func WithIdentity(identity IdentityFunc) Option {
+	return func(o *options) {
+		o.identity = identity
+	}
+}
+
+// NewServer returns a gRPC server serving UserService from repo. Changes are
+// published to broker so that both Watch and the REST event stream see them,
+// users are checked with validator like the REST API does.
+func NewServer(logger *slog.Logger, repo storage.UserRepository, broker *events.Broker, validator echo.Validator, opts ...Option) *grpc.Server {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(logUnary(logger), auditUnary(o.identity)),
+		grpc.ChainStreamInterceptor(logStream(logger), auditStream(o.identity)),
+	)
+
+	usersv1.RegisterUserServiceServer(server, &userService{
+		logger:     logger,
+		repository: repo,
+		broker:     broker,
+		validator:  validator,
+	})
+
+	return server
+}
+
+// auditUnary passes the actor and the request ID down to the repository
+func auditUnary(identity IdentityFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := withAuditInfo(ctx, identity)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// auditStream is auditUnary for streaming calls
+func auditStream(identity IdentityFunc) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := withAuditInfo(stream.Context(), identity)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &auditedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// withAuditInfo passes the identity of the call as the actor, the actor in
+// the call metadata is only claimed. A request ID is generated when the
+// caller didn't send one and is sent back in the response header.
+func withAuditInfo(ctx context.Context, identity IdentityFunc) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	info := storage.AuditInfo{
+		Actor:        anonymousActor,
+		ClaimedActor: firstValue(md, actorKey),
+		RequestID:    firstValue(md, requestIDKey),
+	}
+
+	if identity != nil {
+		info.Actor = cmp.Or(identity(ctx), anonymousActor)
+	}
+
+	if info.RequestID == "" {
+		info.RequestID = random.String(32)
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, info.RequestID)); err != nil {
+		return nil, err
+	}
+
+	return storage.WithAuditInfo(ctx, info), nil
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// auditedStream carries the context holding the audit info to the handler
+type auditedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *auditedStream) Context() context.Context {
+	return s.ctx
+}
+
+func logUnary(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		logCall(logger, info.FullMethod, err)
+
+		return resp, err
+	}
+}
+
+func logStream(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, stream)
+		logCall(logger, info.FullMethod, err)
+
+		return err
+	}
+}
+
+// logCall logs a call like the REST API logs requests
+func logCall(logger *slog.Logger, method string, err error) {
+	code := status.Code(err)
+
+	if err == nil {
+		logger.LogAttrs(context.Background(), slog.LevelInfo, "CALL",
+			slog.String("method", method),
+			slog.String("code", code.String()),
+		)
+
+		return
+	}
+
+	logger.LogAttrs(context.Background(), slog.LevelError, "CALL_ERROR",
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.String("err", err.Error()),
+	)
+}
+
+// statusError maps a repository error to the status returned to the caller,
+// unexpected errors are logged and hidden behind message
+func (s *userService) statusError(err error, message string) error {
+	var httpErr *echo.HTTPError
+
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrAlreadyExist), errors.Is(err, storage.ErrEmailInUse):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, storage.ErrVersionConflict):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, storage.ErrInvalidSort):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.As(err, &httpErr):
+		// the validator reports invalid users as HTTP errors
+		return status.Errorf(codes.InvalidArgument, "%v", httpErr.Message)
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	s.logger.Error(message, slog.String("err", err.Error()))
+
+	return status.Error(codes.Internal, message)
+}
@@ -0,0 +1,268 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/model"
+	usersv1 "github.com/andrii-stp/users-crud/proto/users/v1"
+	"github.com/andrii-stp/users-crud/storage"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// resetEvent tells a resuming watcher it missed events and has to read the
+// users again
+const resetEvent = "reset"
+
+var emailDomainPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+type userService struct {
+	usersv1.UnimplementedUserServiceServer
+
+	logger     *slog.Logger
+	repository storage.UserRepository
+	broker     *events.Broker
+	validator  echo.Validator
+}
+
+func (s *userService) Get(ctx context.Context, req *usersv1.GetRequest) (*usersv1.User, error) {
+	var (
+		user *model.User
+		err  error
+	)
+
+	switch key := req.GetKey().(type) {
+	case *usersv1.GetRequest_Id:
+		user, err = s.repository.Get(ctx, key.Id)
+	case *usersv1.GetRequest_UserName:
+		user, err = s.repository.GetByUserName(ctx, key.UserName)
+	default:
+		return nil, status.Error(codes.InvalidArgument, `'id' or 'user_name' is required`)
+	}
+
+	if err != nil {
+		return nil, s.statusError(err, "Failed to get user")
+	}
+
+	return toProto(user), nil
+}
+
+func (s *userService) List(req *usersv1.ListRequest, stream usersv1.UserService_ListServer) error {
+	opts, err := listOptions(req)
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.Each(stream.Context(), opts, func(user model.User) error {
+		return stream.Send(toProto(&user))
+	})
+	if err != nil {
+		return s.statusError(err, "Failed to list users")
+	}
+
+	return nil
+}
+
+// listOptions checks the filters the same way the REST API checks its query
+func listOptions(req *usersv1.ListRequest) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Filter: storage.UserFilter{
+			Status:      req.GetUserStatus(),
+			Department:  req.GetDepartment(),
+			EmailDomain: req.GetEmailDomain(),
+			Deleted:     req.GetDeleted(),
+		},
+		Limit:  int(req.GetLimit()),
+		Offset: int(req.GetOffset()),
+	}
+
+	if opts.Limit < 0 {
+		return opts, status.Error(codes.InvalidArgument, `'limit' is invalid`)
+	}
+
+	if opts.Offset < 0 {
+		return opts, status.Error(codes.InvalidArgument, `'offset' is invalid`)
+	}
+
+	if s := opts.Filter.Status; s != "" && s != "A" && s != "I" && s != "T" {
+		return opts, status.Error(codes.InvalidArgument, `'user_status' is invalid`)
+	}
+
+	if domain := opts.Filter.EmailDomain; domain != "" && !emailDomainPattern.MatchString(domain) {
+		return opts, status.Error(codes.InvalidArgument, `'email_domain' is invalid`)
+	}
+
+	if req.GetAsOf() != nil {
+		if err := req.GetAsOf().CheckValid(); err != nil {
+			return opts, status.Error(codes.InvalidArgument, `'as_of' is invalid`)
+		}
+
+		opts.AsOf = req.GetAsOf().AsTime()
+	}
+
+	var err error
+	if opts.Sort, err = storage.ParseSort(req.GetSort()); err != nil {
+		return opts, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return opts, nil
+}
+
+func (s *userService) Create(ctx context.Context, req *usersv1.CreateRequest) (*usersv1.User, error) {
+	if req.GetUser() == nil {
+		return nil, status.Error(codes.InvalidArgument, `'user' is required`)
+	}
+
+	user := fromProto(req.GetUser())
+
+	if err := s.validator.Validate(user); err != nil {
+		return nil, s.statusError(err, "Failed to create user")
+	}
+
+	if err := s.repository.Create(ctx, &user); err != nil {
+		return nil, s.statusError(err, "Failed to create user")
+	}
+
+	s.broker.PublishChange(ctx, model.EventUserCreated, &user)
+
+	return toProto(&user), nil
+}
+
+func (s *userService) Update(ctx context.Context, req *usersv1.UpdateRequest) (*usersv1.User, error) {
+	if req.GetUser() == nil {
+		return nil, status.Error(codes.InvalidArgument, `'user' is required`)
+	}
+
+	user := fromProto(req.GetUser())
+
+	if err := s.validator.Validate(user); err != nil {
+		return nil, s.statusError(err, "Failed to update user")
+	}
+
+	// the version comes from the request only, the one of the user is ignored
+	user.Version = req.GetVersion()
+
+	previous, err := s.repository.Update(ctx, req.GetId(), &user)
+	if err != nil {
+		return nil, s.statusError(err, "Failed to update user")
+	}
+
+	s.broker.PublishUpdate(ctx, previous, &user)
+
+	return toProto(&user), nil
+}
+
+func (s *userService) Delete(ctx context.Context, req *usersv1.DeleteRequest) (*usersv1.DeleteResponse, error) {
+	// read first so that the watchers are told which user went to the trash
+	user, err := s.repository.Get(ctx, req.GetId())
+	if err == nil {
+		err = s.repository.Delete(ctx, req.GetId(), req.GetVersion())
+	}
+
+	if err != nil {
+		return nil, s.statusError(err, "Failed to delete user")
+	}
+
+	deletedAt := time.Now().UTC()
+	user.DeletedAt = &deletedAt
+	s.broker.PublishChange(ctx, model.EventUserDeleted, user)
+
+	return &usersv1.DeleteResponse{}, nil
+}
+
+func (s *userService) Watch(req *usersv1.WatchRequest, stream usersv1.UserService_WatchServer) error {
+	if req.GetLastEventId() < 0 {
+		return status.Error(codes.InvalidArgument, `'last_event_id' is not an event id`)
+	}
+
+	missed, events, cancel, resumed := s.broker.Subscribe(req.GetLastEventId())
+
+	defer cancel()
+
+	// the header tells the watcher it won't miss the changes made from now on
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	if !resumed {
+		if err := stream.Send(&usersv1.UserEvent{Type: resetEvent}); err != nil {
+			return err
+		}
+	}
+
+	for _, event := range missed {
+		if err := sendEvent(stream, event, req.GetDepartment()); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "too slow to keep up, resume from the last event id")
+			}
+
+			if err := sendEvent(stream, event, req.GetDepartment()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendEvent sends event to the stream unless it's about a user neither in
+// department nor moving out of it
+func sendEvent(stream usersv1.UserService_WatchServer, event model.Event, department string) error {
+	if department != "" && !event.InDepartment(department) {
+		return nil
+	}
+
+	return stream.Send(&usersv1.UserEvent{
+		Id:         event.EventID,
+		Type:       event.Type,
+		UserId:     event.UserID,
+		OccurredAt: timestamppb.New(event.OccurredAt),
+		RequestId:  event.RequestID,
+		User:       toProto(&event.User),
+	})
+}
+
+func toProto(user *model.User) *usersv1.User {
+	u := &usersv1.User{
+		Id:         user.UserID,
+		UserName:   user.UserName,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Email:      user.Email,
+		UserStatus: user.Status,
+		Department: user.Department,
+		Version:    user.Version,
+	}
+
+	if user.DeletedAt != nil {
+		u.DeletedAt = timestamppb.New(*user.DeletedAt)
+	}
+
+	return u
+}
+
+// fromProto returns the writable fields of user
+func fromProto(user *usersv1.User) model.User {
+	return model.User{
+		UserName:   user.GetUserName(),
+		FirstName:  user.GetFirstName(),
+		LastName:   user.GetLastName(),
+		Email:      user.GetEmail(),
+		Status:     user.GetUserStatus(),
+		Department: user.GetDepartment(),
+	}
+}
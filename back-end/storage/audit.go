@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+// SystemActor is recorded when a change isn't made on behalf of anyone, like
+// purging the trash
+const SystemActor = "system"
+
+// ActorHeader names who a request is made on behalf of, in the REST API and
+// the gRPC metadata. Anyone can set it, so it's recorded as the claimed actor
+// only.
+const ActorHeader = "X-Actor"
+
+// AuditInfo tells who is behind the changes made with a context
+type AuditInfo struct {
+	// Actor is who authenticated the changes
+	Actor string
+	// ClaimedActor is who the caller says it acts on behalf of, it isn't
+	// authenticated so it's recorded apart from Actor
+	ClaimedActor string
+	RequestID    string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns a context whose changes are recorded as made by info
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFrom returns the audit info ctx was made with, changes made
+// without any are recorded as made by SystemActor
+func AuditInfoFrom(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	if info.Actor == "" {
+		info.Actor = SystemActor
+	}
+
+	return info
+}
+
+// AuditFilter narrows the entries returned by UserRepository.ListAudit,
+// zero fields are ignored. Entries come oldest first, After continues the
+// listing past the entry with that id.
+type AuditFilter struct {
+	UserID int64
+	// Actor matches who authenticated the changes, ClaimedActor who they were
+	// claimed to be made on behalf of
+	Actor        string
+	ClaimedActor string
+	Since        time.Time
+	After        int64
+	Limit        int
+}
+
+// auditColumns are the user_audit columns in the order scanAuditEntry reads them
+var auditColumns = []string{"audit_id", "user_id", "actor", "claimed_actor", "action", "request_id", "created_at", "changes"}
+
+// newAuditEntry records action turning before into after, either may be nil
+// when the user didn't exist before or doesn't after.
+func newAuditEntry(ctx context.Context, action string, before, after *model.User) (model.AuditEntry, error) {
+	info := AuditInfoFrom(ctx)
+
+	entry := model.AuditEntry{
+		Actor:        info.Actor,
+		ClaimedActor: info.ClaimedActor,
+		Action:       action,
+		RequestID:    info.RequestID,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if after != nil {
+		entry.UserID = after.UserID
+	} else if before != nil {
+		entry.UserID = before.UserID
+	}
+
+	changes, err := diffUsers(before, after)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Changes = changes
+
+	return entry, nil
+}
+
+// diffUsers compares the JSON representation of two users, the id and the
+// version are left out as every change touches them.
+func diffUsers(before, after *model.User) (map[string]model.Change, error) {
+	beforeFields, err := userJSONFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterFields, err := userJSONFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]model.Change{}
+
+	for _, fields := range []map[string]any{beforeFields, afterFields} {
+		for field := range fields {
+			if field == "id" || field == "version" {
+				continue
+			}
+
+			b, a := beforeFields[field], afterFields[field]
+			if b != a {
+				changes[field] = model.Change{Before: b, After: a}
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func userJSONFields(user *model.User) (map[string]any, error) {
+	fields := map[string]any{}
+	if user == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// userChange is a user before and after a change, either may be nil when
+// the user didn't exist before or doesn't after
+type userChange struct {
+	before, after *model.User
+}
+
+// audit records action in the same transaction as the changes themselves,
+// with a single INSERT
+func (r sqlUserRepository) audit(ctx context.Context, tx sq.BaseRunner, action string, changes []userChange) error {
+	insert := r.builder().Insert("user_audit").
+		Columns("user_id", "actor", "claimed_actor", "action", "request_id", "created_at", "changes")
+
+	for _, change := range changes {
+		entry, err := newAuditEntry(ctx, action, change.before, change.after)
+		if err != nil {
+			return err
+		}
+
+		fields, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return err
+		}
+
+		insert = insert.Values(entry.UserID, entry.Actor, entry.ClaimedActor, entry.Action, entry.RequestID, entry.CreatedAt, string(fields))
+	}
+
+	if _, err := insert.RunWith(tx).ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
+
+func (r sqlUserRepository) ListAudit(ctx context.Context, filter AuditFilter) ([]model.AuditEntry, error) {
+	query := r.builder().Select(auditColumns...).From("user_audit").Where(filter.where()).OrderBy("audit_id ASC")
+
+	if filter.Limit > 0 {
+		query = query.Limit(uint64(filter.Limit))
+	}
+
+	rows, err := query.RunWith(r.reader()).QueryContext(ctx)
+	if err != nil {
+		r.logger.Error("Failed to execute audit query",
+			slog.String("err", err.Error()))
+
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := []model.AuditEntry{}
+
+	for rows.Next() {
+		var (
+			entry   model.AuditEntry
+			changes []byte
+		)
+
+		if err := rows.Scan(&entry.AuditID, &entry.UserID, &entry.Actor, &entry.ClaimedActor,
+			&entry.Action, &entry.RequestID, &entry.CreatedAt, &changes); err != nil {
+			return nil, fmt.Errorf("failed to scan audit data: %w", err)
+		}
+
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode audit changes: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (f AuditFilter) where() sq.And {
+	conditions := sq.And{}
+
+	if f.UserID != 0 {
+		conditions = append(conditions, sq.Eq{"user_id": f.UserID})
+	}
+
+	if f.Actor != "" {
+		conditions = append(conditions, sq.Eq{"actor": f.Actor})
+	}
+
+	if f.ClaimedActor != "" {
+		conditions = append(conditions, sq.Eq{"claimed_actor": f.ClaimedActor})
+	}
+
+	if !f.Since.IsZero() {
+		conditions = append(conditions, sq.GtOrEq{"created_at": f.Since.UTC()})
+	}
+
+	if f.After != 0 {
+		conditions = append(conditions, sq.Gt{"audit_id": f.After})
+	}
+
+	return conditions
+}
+
+func (f AuditFilter) match(entry model.AuditEntry) bool {
+	return (f.UserID == 0 || entry.UserID == f.UserID) &&
+		(f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.ClaimedActor == "" || entry.ClaimedActor == f.ClaimedActor) &&
+		(f.Since.IsZero() || !entry.CreatedAt.Before(f.Since)) &&
+		entry.AuditID > f.After
+}
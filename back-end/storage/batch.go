@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// Operations a batch is made of
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// BatchOp is a single operation of a batch. Creates and updates write User,
+// updates and deletes target ID and, unless it's zero, expect Version.
+type BatchOp struct {
+	Op      string
+	ID      int64
+	Version int64
+	User    *model.User
+}
+
+// BatchOptions tell how a batch is applied. An atomic batch applies every
+// operation or none of them, a dry run reports the outcome of every
+// operation without keeping any of them.
+type BatchOptions struct {
+	Atomic bool
+	DryRun bool
+}
+
+// BatchResult is the outcome of a batch operation. User is the user as
+// written, or as moved to the trash, Previous the user an update replaced,
+// Err tells why the operation failed.
+type BatchResult struct {
+	User     *model.User
+	Previous *model.User
+	Err      error
+}
+
+var (
+	// ErrBatchAborted is the outcome of the operations of an atomic batch
+	// rolled back, or never run, because another one failed
+	ErrBatchAborted = errors.New("batch aborted")
+	ErrUnknownOp    = errors.New("unknown batch operation")
+)
+
+// batchSavepoint is the savepoint every batch operation runs in
+const batchSavepoint = "batch_op"
+
+func (r sqlUserRepository) Batch(ctx context.Context, ops []BatchOp, opts BatchOptions) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("batch transaction failed: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	var failed bool
+
+	for i := 0; i < len(ops) && !(opts.Atomic && failed); {
+		// a run of creates is inserted at once
+		end := i
+		for end < len(ops) && ops[end].Op == OpCreate {
+			end++
+		}
+
+		if end > i {
+			ok, err := r.batchCreate(ctx, tx, ops[i:end], results[i:end], opts.Atomic)
+			if err != nil {
+				return nil, err
+			}
+
+			failed = failed || !ok
+			i = end
+
+			continue
+		}
+
+		op := ops[i]
+
+		opErr, err := r.savepoint(ctx, tx, func() error {
+			var err error
+
+			switch op.Op {
+			case OpUpdate:
+				op.User.Version = op.Version
+				if results[i].Previous, err = r.update(ctx, tx, op.ID, op.User); err == nil {
+					results[i].User = op.User
+				}
+			case OpDelete:
+				results[i].User, err = r.delete(ctx, tx, op.ID, op.Version)
+			default:
+				err = fmt.Errorf("%w: '%s'", ErrUnknownOp, op.Op)
+			}
+
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		results[i].Err = opErr
+		failed = failed || opErr != nil
+		i++
+	}
+
+	if opts.Atomic && failed {
+		abort(results)
+
+		return results, nil
+	}
+
+	// a dry run is rolled back once its outcome is known
+	if opts.DryRun {
+		return results, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// batchCreate inserts the users of a run of creates with a single INSERT.
+// When it fails the users are inserted one at a time to tell which ones
+// can't be, an atomic batch stops at the first one. It reports whether
+// every user was created.
+func (r sqlUserRepository) batchCreate(ctx context.Context, tx *sql.Tx, ops []BatchOp, results []BatchResult, atomic bool) (bool, error) {
+	users := make([]*model.User, len(ops))
+	for i, op := range ops {
+		users[i] = op.User
+	}
+
+	opErr, err := r.savepoint(ctx, tx, func() error {
+		return r.createUsers(ctx, tx, users)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if opErr == nil {
+		for i, user := range users {
+			results[i].User = user
+		}
+
+		return true, nil
+	}
+
+	ok := true
+
+	for i, user := range users {
+		results[i].Err, err = r.savepoint(ctx, tx, func() error {
+			return r.createUsers(ctx, tx, []*model.User{user})
+		})
+		if err != nil {
+			return false, err
+		}
+
+		if results[i].Err != nil {
+			ok = false
+
+			if atomic {
+				break
+			}
+
+			continue
+		}
+
+		results[i].User = user
+	}
+
+	return ok, nil
+}
+
+// createUsers inserts users with a multi-row INSERT and reads them back by
+// username, which is unique
+func (r sqlUserRepository) createUsers(ctx context.Context, tx *sql.Tx, users []*model.User) error {
+	now := time.Now().UTC()
+
+	insert := r.builder().Insert("users").
+		Columns("user_name", "first_name", "last_name", "email", "user_status", "department", "valid_from")
+
+	byName := make(map[string]*model.User, len(users))
+	names := make([]string, 0, len(users))
+
+	for _, user := range users {
+		insert = insert.Values(user.UserName, user.FirstName, user.LastName, user.Email, user.Status, user.Department, now)
+		byName[strings.ToLower(user.UserName)] = user
+		names = append(names, strings.ToLower(user.UserName))
+	}
+
+	if _, err := insert.RunWith(tx).ExecContext(ctx); err != nil {
+		return r.dialect.mapError(err)
+	}
+
+	rows, err := r.builder().Select(userColumns...).From("users").Where(sq.Eq{"LOWER(user_name)": names}).
+		RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var created model.User
+		if err := rows.Scan(userFields(&created)...); err != nil {
+			return fmt.Errorf("failed to scan user data: %w", err)
+		}
+
+		if user, ok := byName[strings.ToLower(created.UserName)]; ok {
+			*user = created
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	changes := make([]userChange, len(users))
+	for i, user := range users {
+		changes[i] = userChange{after: user}
+	}
+
+	return r.recordAll(ctx, tx, ActionCreate, changes)
+}
+
+// savepoint runs fn within a savepoint of tx and rolls back to it when fn
+// fails, so the transaction can go on. The error of fn is returned first,
+// the second one means tx can't be used anymore.
+func (r sqlUserRepository) savepoint(ctx context.Context, tx *sql.Tx, fn func() error) (error, error) {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+batchSavepoint); err != nil {
+		return nil, err
+	}
+
+	if opErr := fn(); opErr != nil {
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+batchSavepoint); err != nil {
+			return nil, err
+		}
+
+		return opErr, nil
+	}
+
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+batchSavepoint)
+
+	return nil, err
+}
+
+// abort marks the operations of a failed atomic batch that didn't fail
+// themselves as aborted
+func abort(results []BatchResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+}
+
+func (ms *MemoryUserRepository) Batch(ctx context.Context, ops []BatchOp, opts BatchOptions) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// what an atomic batch or a dry run rolls back to
+	var (
+		lastID    = ms.lastID
+		lastEvent = ms.lastEvent
+		users     = maps.Clone(ms.users)
+		validFrom = maps.Clone(ms.validFrom)
+		history   = maps.Clone(ms.history)
+		audit     = len(ms.audit)
+		events    = len(ms.events)
+	)
+
+	results := make([]BatchResult, len(ops))
+
+	for i, op := range ops {
+		var err error
+
+		switch op.Op {
+		case OpCreate:
+			if err = ms.create(ctx, op.User); err == nil {
+				results[i].User = op.User
+			}
+		case OpUpdate:
+			op.User.Version = op.Version
+			if results[i].Previous, err = ms.update(ctx, op.ID, op.User); err == nil {
+				results[i].User = op.User
+			}
+		case OpDelete:
+			results[i].User, err = ms.delete(ctx, op.ID, op.Version)
+		default:
+			err = fmt.Errorf("%w: '%s'", ErrUnknownOp, op.Op)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		results[i] = BatchResult{Err: err}
+
+		if opts.Atomic {
+			abort(results)
+
+			break
+		}
+	}
+
+	if opts.DryRun || (opts.Atomic && failed(results)) {
+		ms.lastID, ms.lastEvent = lastID, lastEvent
+		ms.users, ms.validFrom, ms.history = users, validFrom, history
+		ms.audit, ms.events = ms.audit[:audit], ms.events[:events]
+	}
+
+	return results, nil
+}
+
+func failed(results []BatchResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// ErrVersionNotFound means the user never had the requested version
+var ErrVersionNotFound = model.ErrVersionNotFound
+
+// Every change to a user moves the row it replaces to users_history, along
+// with the time range it was current for. users.valid_from tells when the
+// current row took over, it's NULL for rows older than the history itself.
+
+// userVersionColumns are the users_history columns holding a former user
+// row, in the order of userColumns
+var userVersionColumns = []string{"user_id", "user_name", "first_name", "last_name", "email", "user_status", "department", "version", "deleted_at"}
+
+// validAt holds the rows that were current at asOf
+func validAt(asOf time.Time) sq.Or {
+	return sq.Or{sq.Eq{"valid_from": nil}, sq.LtOrEq{"valid_from": asOf.UTC()}}
+}
+
+// selectUsers selects columns from users, or from the rows users held at
+// asOf unless it's zero
+func (r sqlUserRepository) selectUsers(asOf time.Time, columns ...string) sq.SelectBuilder {
+	query := r.builder().Select(columns...)
+	if asOf.IsZero() {
+		return query.From("users")
+	}
+
+	past, args, _ := sq.Select(userVersionColumns...).From("users_history").
+		Where(sq.And{validAt(asOf), sq.Gt{"valid_to": asOf.UTC()}}).ToSql()
+
+	current := sq.Select(userColumns...).From("users").Where(validAt(asOf)).
+		SuffixExpr(sq.Expr("UNION ALL "+past, args...))
+
+	return query.FromSelect(current, "users")
+}
+
+// archive moves the current row of the user with id to users_history before
+// it's changed at now. The update that follows must set valid_from to now.
+func (r sqlUserRepository) archive(ctx context.Context, tx *sql.Tx, id int64, now time.Time) error {
+	var (
+		user      model.User
+		validFrom sql.NullTime
+	)
+
+	builder := r.builder()
+
+	err := builder.Select(append(userColumns, "valid_from")...).From("users").Where(sq.Eq{"user_id": id}).
+		RunWith(tx).QueryRowContext(ctx).Scan(append(userFields(&user), &validFrom)...)
+	if err != nil {
+		return err
+	}
+
+	var from any
+	if validFrom.Valid {
+		from = validFrom.Time.UTC()
+	}
+
+	_, err = builder.Insert("users_history").
+		Columns(append(userVersionColumns, "valid_from", "valid_to")...).
+		Values(user.UserID, user.UserName, user.FirstName, user.LastName, user.Email, user.Status,
+			user.Department, user.Version, user.DeletedAt, from, now).
+		RunWith(tx).ExecContext(ctx)
+
+	return err
+}
+
+func (r sqlUserRepository) GetAsOf(ctx context.Context, id int64, asOf time.Time) (*model.User, error) {
+	var user model.User
+
+	err := r.selectUsers(asOf, userColumns...).Where(sq.Eq{"user_id": id, "deleted_at": nil}).
+		RunWith(r.reader()).QueryRowContext(ctx).Scan(userFields(&user)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r sqlUserRepository) GetVersion(ctx context.Context, id int64, version int64) (*model.User, error) {
+	var user model.User
+
+	where := sq.Eq{"user_id": id, "version": version}
+
+	err := r.builder().Select(userColumns...).From("users").Where(where).
+		RunWith(r.reader()).QueryRowContext(ctx).Scan(userFields(&user)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		// a version moved to the trash and back is archived twice, both
+		// rows hold the same fields
+		err = r.builder().Select(userVersionColumns...).From("users_history").Where(where).Limit(1).
+			RunWith(r.reader()).QueryRowContext(ctx).Scan(userFields(&user)...)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVersionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	user.DeletedAt = nil
+
+	return &user, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// LeaseDuration is how long a lease is held once taken or renewed. A holder
+// renews it each time it claims its queue again, when it stops another
+// replica takes the lease over once it runs out.
+const LeaseDuration = time.Minute
+
+// ClaimDuration is how long a claimed webhook delivery is left to its
+// claimer, it outlasts the attempts of a whole batch. A delivery claimed by
+// a replica that stopped is attempted again once it runs out.
+const ClaimDuration = 30 * time.Minute
+
+// newClaim returns a random token telling the rows claimed at once apart
+func newClaim() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// eventsLease is the lease on the outbox
+const eventsLease = "user_events"
+
+// acquireLease takes the lease name for holder at now, or renews it when
+// holder has it already. It reports false while another holder has it.
+func (r sqlUserRepository) acquireLease(ctx context.Context, name, holder string, now time.Time) (bool, error) {
+	_, err := r.builder().Update("leases").
+		SetMap(sq.Eq{"holder": holder, "expires_at": now.Add(LeaseDuration).UTC()}).
+		Where(sq.Eq{"name": name}).
+		Where(sq.Or{sq.Eq{"holder": holder}, sq.Lt{"expires_at": now.UTC()}}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to take lease %s: %w", name, err)
+	}
+
+	// the rows affected aren't told apart from the rows matched by every
+	// driver, the lease is read back instead
+	var current string
+
+	err = r.builder().Select("holder").From("leases").Where(sq.Eq{"name": name}).
+		RunWith(r.db).QueryRowContext(ctx).Scan(&current)
+	if err != nil {
+		return false, fmt.Errorf("failed to read lease %s: %w", name, err)
+	}
+
+	return current == holder, nil
+}
+
+// memoryLease is a lease of the memory repository, which has no replicas
+// but honours leases like the databases do
+type memoryLease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+// acquireLease is sqlUserRepository.acquireLease, ms must be locked
+func (ms *MemoryUserRepository) acquireLease(name, holder string, now time.Time) bool {
+	if ms.leases == nil {
+		ms.leases = map[string]memoryLease{}
+	}
+
+	lease := ms.leases[name]
+	if lease.holder != holder && !lease.expiresAt.Before(now) {
+		return false
+	}
+
+	ms.leases[name] = memoryLease{holder: holder, expiresAt: now.Add(LeaseDuration)}
+
+	return true
+}
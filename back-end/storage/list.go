@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// ListOptions narrows, orders and pages the users returned by UserRepository.List.
+// A zero Limit means no limit. When After is set the listing continues right
+// after the cursor, Offset and Sort are ignored and the total is not counted.
+// A non-zero AsOf lists the users as they were at that time.
+type ListOptions struct {
+	Filter UserFilter
+	Sort   []SortField
+	Limit  int
+	Offset int
+	After  *Cursor
+	AsOf   time.Time
+}
+
+// UserFilter holds optional equality filters, empty fields are ignored.
+// Deleted lists the users in the trash instead of the live ones.
+type UserFilter struct {
+	Status string
+	// NotStatus leaves out the users with this status
+	NotStatus   string
+	Department  string
+	EmailDomain string
+	// UserName, FirstName, LastName and Email match regardless of case
+	UserName  string
+	FirstName string
+	LastName  string
+	Email     string
+	Deleted   bool
+}
+
+// SortField orders a listing by a single column.
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// Cursor points at the last user of a page for keyset pagination, it holds
+// the value of the sort column along with the user_id tie-breaker.
+type Cursor struct {
+	SortKey string `json:"k"`
+	Desc    bool   `json:"d,omitempty"`
+	Value   string `json:"v,omitempty"`
+	UserID  int64  `json:"id"`
+}
+
+var (
+	ErrInvalidSort   = model.ErrInvalidSort
+	ErrInvalidCursor = model.ErrInvalidCursor
+)
+
+// sortColumns is the allowlist of columns a listing can be ordered by.
+var sortColumns = map[string]struct{}{
+	"user_id":     {},
+	"user_name":   {},
+	"first_name":  {},
+	"last_name":   {},
+	"email":       {},
+	"user_status": {},
+	"department":  {},
+}
+
+// ParseSort parses a comma separated list of columns, each optionally
+// prefixed with '-' for descending order, e.g. "last_name,-user_id".
+func ParseSort(s string) ([]SortField, error) {
+	var fields []SortField
+
+	if s == "" {
+		return fields, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		field := SortField{Column: strings.TrimSpace(part)}
+
+		if strings.HasPrefix(field.Column, "-") {
+			field.Column = field.Column[1:]
+			field.Desc = true
+		}
+
+		if _, ok := sortColumns[field.Column]; !ok {
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidSort, field.Column)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// KeysetSort returns the single column fields can be walked by with a cursor.
+// It reports false when fields order by more than one column besides user_id.
+func KeysetSort(fields []SortField) (SortField, bool) {
+	switch {
+	case len(fields) == 0:
+		return SortField{Column: "user_id"}, true
+	case len(fields) == 1:
+		return fields[0], true
+	case len(fields) == 2 && fields[1].Column == "user_id" && fields[1].Desc == fields[0].Desc:
+		return fields[0], true
+	default:
+		return SortField{}, false
+	}
+}
+
+// NewCursor returns the cursor pointing right after user in a listing sorted by key.
+func NewCursor(key SortField, user model.User) Cursor {
+	cursor := Cursor{SortKey: key.Column, Desc: key.Desc, UserID: user.UserID}
+
+	if key.Column != "user_id" {
+		cursor.Value = columnValue(user, key.Column)
+	}
+
+	return cursor
+}
+
+// columnValue returns the value of a sortable text column of user.
+func columnValue(user model.User, column string) string {
+	switch column {
+	case "user_name":
+		return user.UserName
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "email":
+		return user.Email
+	case "user_status":
+		return user.Status
+	case "department":
+		return user.Department
+	default:
+		return ""
+	}
+}
+
+// Sort returns the order a listing continued from the cursor must follow.
+func (c Cursor) Sort() []SortField {
+	return []SortField{{Column: c.SortKey, Desc: c.Desc}}
+}
+
+func (c Cursor) where() (sq.Sqlizer, error) {
+	if _, ok := sortColumns[c.SortKey]; !ok {
+		return nil, fmt.Errorf("%w: unknown sort key '%s'", ErrInvalidCursor, c.SortKey)
+	}
+
+	op := ">"
+	if c.Desc {
+		op = "<"
+	}
+
+	if c.SortKey == "user_id" {
+		return sq.Expr("user_id "+op+" ?", c.UserID), nil
+	}
+
+	return sq.Expr(fmt.Sprintf("(%s, user_id) %s (?, ?)", c.SortKey, op), c.Value, c.UserID), nil
+}
+
+// orderBy returns the ORDER BY clauses for fields, always ending with
+// user_id so that pages are stable. The tie-breaker follows the direction
+// of the last field so a single column listing can be walked by keyset.
+func orderBy(fields []SortField) []string {
+	var (
+		clauses []string
+		hasID   bool
+		desc    bool
+	)
+
+	for _, field := range fields {
+		direction := "ASC"
+		if field.Desc {
+			direction = "DESC"
+		}
+
+		hasID = hasID || field.Column == "user_id"
+		desc = field.Desc
+		clauses = append(clauses, field.Column+" "+direction)
+	}
+
+	if !hasID && desc {
+		clauses = append(clauses, "user_id DESC")
+	} else if !hasID {
+		clauses = append(clauses, "user_id ASC")
+	}
+
+	return clauses
+}
+
+func (f UserFilter) where() sq.And {
+	conditions := sq.And{sq.Eq{"deleted_at": nil}}
+	if f.Deleted {
+		conditions = sq.And{sq.NotEq{"deleted_at": nil}}
+	}
+
+	if f.Status != "" {
+		conditions = append(conditions, sq.Eq{"user_status": f.Status})
+	}
+
+	if f.NotStatus != "" {
+		conditions = append(conditions, sq.NotEq{"user_status": f.NotStatus})
+	}
+
+	if f.Department != "" {
+		conditions = append(conditions, sq.Eq{"department": f.Department})
+	}
+
+	for _, field := range [][2]string{
+		{"user_name", f.UserName},
+		{"first_name", f.FirstName},
+		{"last_name", f.LastName},
+		{"email", f.Email},
+	} {
+		if field[1] != "" {
+			conditions = append(conditions, sq.Eq{"LOWER(" + field[0] + ")": strings.ToLower(field[1])})
+		}
+	}
+
+	if f.EmailDomain != "" {
+		conditions = append(conditions, sq.Like{"LOWER(email)": "%@" + strings.ToLower(f.EmailDomain)})
+	}
+
+	return conditions
+}
@@ -0,0 +1,780 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// MemoryUserRepository keeps users in memory, it follows the same rules as
+// the database backed repositories and is meant for tests and demos.
+type MemoryUserRepository struct {
+	mu        sync.RWMutex
+	lastID    int64
+	users     map[int64]model.User
+	validFrom map[int64]time.Time
+	history   map[int64][]userVersion
+	audit     []model.AuditEntry
+	events    []model.Event
+	lastEvent int64
+
+	webhooks     []model.Webhook
+	lastWebhook  int64
+	deliveries   []model.Delivery
+	lastDelivery int64
+
+	leases map[string]memoryLease
+}
+
+// userVersion is a former row of a user along with the time range it was
+// current for
+type userVersion struct {
+	user      model.User
+	validFrom time.Time
+	validTo   time.Time
+}
+
+var _ UserRepository = (*MemoryUserRepository)(nil)
+
+func NewMemoryRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:     map[int64]model.User{},
+		validFrom: map[int64]time.Time{},
+		history:   map[int64][]userVersion{},
+	}
+}
+
+func (ms *MemoryUserRepository) List(ctx context.Context, opts ListOptions) ([]model.User, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	users := []model.User{}
+
+	for _, user := range ms.usersAt(opts.AsOf) {
+		if opts.Filter.match(user) {
+			users = append(users, user)
+		}
+	}
+
+	total := int64(len(users))
+	sortFields := opts.Sort
+
+	if opts.After != nil {
+		if _, ok := sortColumns[opts.After.SortKey]; !ok {
+			return nil, 0, ErrInvalidCursor
+		}
+
+		sortFields, total = opts.After.Sort(), 0
+		users = slices.DeleteFunc(users, func(user model.User) bool {
+			return !opts.After.before(user)
+		})
+	}
+
+	slices.SortFunc(users, func(a, b model.User) int {
+		return compareUsers(a, b, sortFields)
+	})
+
+	if opts.After == nil {
+		users = users[min(opts.Offset, len(users)):]
+	}
+
+	if opts.Limit > 0 && len(users) > opts.Limit {
+		users = users[:opts.Limit]
+	}
+
+	return users, total, nil
+}
+
+func (ms *MemoryUserRepository) Each(ctx context.Context, opts ListOptions, fn func(model.User) error) error {
+	users, _, err := ms.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryUserRepository) Get(ctx context.Context, id int64) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	user, ok := ms.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (ms *MemoryUserRepository) GetByUserName(ctx context.Context, username string) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, user := range ms.users {
+		if user.DeletedAt == nil && strings.EqualFold(user.UserName, username) {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrUserNotFound
+}
+
+func (ms *MemoryUserRepository) Create(ctx context.Context, user *model.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.create(ctx, user)
+}
+
+// create stores a new user, ms.mu must be held
+func (ms *MemoryUserRepository) create(ctx context.Context, user *model.User) error {
+	if err := ms.checkUnique(0, user); err != nil {
+		return err
+	}
+
+	ms.lastID++
+	user.UserID, user.Version, user.DeletedAt = ms.lastID, 1, nil
+	ms.users[user.UserID] = *user
+	ms.validFrom[user.UserID] = time.Now()
+
+	return ms.record(ctx, ActionCreate, nil, user)
+}
+
+func (ms *MemoryUserRepository) Update(ctx context.Context, id int64, user *model.User) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.update(ctx, id, user)
+}
+
+// update replaces the user with id and returns it as it was before, ms.mu
+// must be held
+func (ms *MemoryUserRepository) update(ctx context.Context, id int64, user *model.User) (*model.User, error) {
+	existing, ok := ms.users[id]
+	if !ok || existing.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if user.Version != 0 && user.Version != existing.Version {
+		return nil, ErrVersionConflict
+	}
+
+	if err := ms.checkUnique(id, user); err != nil {
+		return nil, err
+	}
+
+	user.UserID, user.Version, user.DeletedAt = id, existing.Version+1, nil
+	ms.archive(id, time.Now())
+	ms.users[id] = *user
+
+	return &existing, ms.record(ctx, ActionUpdate, &existing, user)
+}
+
+func (ms *MemoryUserRepository) Patch(ctx context.Context, id int64, version int64, patch func(user *model.User) error) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	existing, ok := ms.users[id]
+	if !ok || existing.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if version != 0 && version != existing.Version {
+		return nil, ErrVersionConflict
+	}
+
+	user := existing
+	if err := patch(&user); err != nil {
+		return nil, err
+	}
+
+	if len(changedColumns(existing, user)) == 0 {
+		return &existing, nil
+	}
+
+	if err := ms.checkUnique(id, &user); err != nil {
+		return nil, err
+	}
+
+	// fields the database doesn't let a patch write
+	user.UserID, user.Version, user.DeletedAt = id, existing.Version+1, nil
+	ms.archive(id, time.Now())
+	ms.users[id] = user
+
+	return &user, ms.record(ctx, ActionUpdate, &existing, &user)
+}
+
+func (ms *MemoryUserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, err := ms.delete(ctx, id, version)
+
+	return err
+}
+
+// delete moves the user with id to the trash and returns it as deleted,
+// ms.mu must be held
+func (ms *MemoryUserRepository) delete(ctx context.Context, id int64, version int64) (*model.User, error) {
+	user, ok := ms.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if version != 0 && version != user.Version {
+		return nil, ErrVersionConflict
+	}
+
+	deleted := user
+	deletedAt := time.Now().UTC()
+	deleted.DeletedAt = &deletedAt
+	ms.archive(id, deletedAt)
+	ms.users[id] = deleted
+
+	return &deleted, ms.record(ctx, ActionDelete, &user, &deleted)
+}
+
+func (ms *MemoryUserRepository) Restore(ctx context.Context, id int64) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	user, ok := ms.users[id]
+	if !ok || user.DeletedAt == nil {
+		return nil, ErrUserNotFound
+	}
+
+	restored := user
+	restored.DeletedAt = nil
+	ms.archive(id, time.Now())
+	ms.users[id] = restored
+
+	return &restored, ms.record(ctx, ActionRestore, &user, &restored)
+}
+
+func (ms *MemoryUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return ms.purge(ctx, func(user model.User) bool {
+		return user.DeletedAt.Before(deletedBefore)
+	})
+}
+
+func (ms *MemoryUserRepository) Erase(ctx context.Context, id int64, version int64) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	deleted, err := ms.delete(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ms.purgeLocked(ctx, func(user model.User) bool {
+		return user.UserID == id
+	})
+
+	return deleted, err
+}
+
+// purge permanently removes the trashed users matching match, along with
+// their history
+func (ms *MemoryUserRepository) purge(ctx context.Context, match func(user model.User) bool) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.purgeLocked(ctx, match)
+}
+
+// purgeLocked is purge with ms.mu held
+func (ms *MemoryUserRepository) purgeLocked(ctx context.Context, match func(user model.User) bool) (int64, error) {
+	var purged int64
+
+	for id, user := range ms.users {
+		if user.DeletedAt != nil && match(user) {
+			if err := ms.record(ctx, ActionPurge, &user, nil); err != nil {
+				return purged, err
+			}
+
+			delete(ms.users, id)
+			delete(ms.validFrom, id)
+			delete(ms.history, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (ms *MemoryUserRepository) GetAsOf(ctx context.Context, id int64, asOf time.Time) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, user := range ms.usersAt(asOf) {
+		if user.UserID == id && user.DeletedAt == nil {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrUserNotFound
+}
+
+func (ms *MemoryUserRepository) GetVersion(ctx context.Context, id int64, version int64) (*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	candidates := []model.User{ms.users[id]}
+	for _, former := range ms.history[id] {
+		candidates = append(candidates, former.user)
+	}
+
+	for _, user := range candidates {
+		if user.UserID == id && user.Version == version {
+			user.DeletedAt = nil
+
+			return &user, nil
+		}
+	}
+
+	return nil, ErrVersionNotFound
+}
+
+// archive moves the current row of the user with id to its history before
+// it's changed at now, ms.mu must be held
+func (ms *MemoryUserRepository) archive(id int64, now time.Time) {
+	ms.history[id] = append(ms.history[id], userVersion{
+		user:      ms.users[id],
+		validFrom: ms.validFrom[id],
+		validTo:   now,
+	})
+	ms.validFrom[id] = now
+}
+
+// usersAt returns every user as it was at asOf, or as it is when asOf is
+// zero, ms.mu must be held
+func (ms *MemoryUserRepository) usersAt(asOf time.Time) []model.User {
+	users := make([]model.User, 0, len(ms.users))
+
+	for id, user := range ms.users {
+		if asOf.IsZero() || !ms.validFrom[id].After(asOf) {
+			users = append(users, user)
+
+			continue
+		}
+
+		for _, former := range ms.history[id] {
+			if !former.validFrom.After(asOf) && former.validTo.After(asOf) {
+				users = append(users, former.user)
+			}
+		}
+	}
+
+	return users
+}
+
+func (ms *MemoryUserRepository) ListAudit(ctx context.Context, filter AuditFilter) ([]model.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	entries := []model.AuditEntry{}
+
+	for _, entry := range ms.audit {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+
+		if filter.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// record appends the audit entry and the events of a change, ms.mu must be held
+func (ms *MemoryUserRepository) record(ctx context.Context, action string, before, after *model.User) error {
+	entry, err := newAuditEntry(ctx, action, before, after)
+	if err != nil {
+		return err
+	}
+
+	entry.AuditID = int64(len(ms.audit) + 1)
+	ms.audit = append(ms.audit, entry)
+
+	for _, event := range newEvents(ctx, action, before, after) {
+		ms.lastEvent++
+		event.EventID = ms.lastEvent
+		ms.events = append(ms.events, event)
+	}
+
+	return nil
+}
+
+func (ms *MemoryUserRepository) ClaimEvents(ctx context.Context, holder string, now time.Time, limit int) ([]model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !ms.acquireLease(eventsLease, holder, now) {
+		return []model.Event{}, nil
+	}
+
+	events := slices.Clone(ms.events[:min(limit, len(ms.events))])
+	if events == nil {
+		events = []model.Event{}
+	}
+
+	return events, nil
+}
+
+func (ms *MemoryUserRepository) AckEvents(ctx context.Context, ids ...int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.events = slices.DeleteFunc(ms.events, func(event model.Event) bool {
+		return slices.Contains(ids, event.EventID)
+	})
+
+	return nil
+}
+
+// checkUnique mirrors the case-insensitive unique indexes of the database,
+// the user with id is left out so it can keep its own username and email.
+// Users in the trash are checked too, they're only released once purged.
+func (ms *MemoryUserRepository) checkUnique(id int64, user *model.User) error {
+	for _, existing := range ms.users {
+		if existing.UserID == id {
+			continue
+		}
+
+		if strings.EqualFold(existing.UserName, user.UserName) {
+			return ErrAlreadyExist
+		}
+
+		if strings.EqualFold(existing.Email, user.Email) {
+			return ErrEmailInUse
+		}
+	}
+
+	return nil
+}
+
+func (f UserFilter) match(user model.User) bool {
+	if f.Deleted != (user.DeletedAt != nil) {
+		return false
+	}
+
+	if f.Status != "" && user.Status != f.Status {
+		return false
+	}
+
+	if f.NotStatus != "" && user.Status == f.NotStatus {
+		return false
+	}
+
+	if f.Department != "" && user.Department != f.Department {
+		return false
+	}
+
+	for _, field := range [][2]string{
+		{user.UserName, f.UserName},
+		{user.FirstName, f.FirstName},
+		{user.LastName, f.LastName},
+		{user.Email, f.Email},
+	} {
+		if field[1] != "" && !strings.EqualFold(field[0], field[1]) {
+			return false
+		}
+	}
+
+	if f.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(user.Email), "@"+strings.ToLower(f.EmailDomain)) {
+		return false
+	}
+
+	return true
+}
+
+// before reports whether user comes after the cursor in the listing order.
+func (c Cursor) before(user model.User) bool {
+	order := cmp.Compare(user.UserID, c.UserID)
+
+	if c.SortKey != "user_id" {
+		if byKey := cmp.Compare(columnValue(user, c.SortKey), c.Value); byKey != 0 {
+			order = byKey
+		}
+	}
+
+	if c.Desc {
+		return order < 0
+	}
+
+	return order > 0
+}
+
+func compareUsers(a, b model.User, fields []SortField) int {
+	for _, field := range fields {
+		order := cmp.Compare(columnValue(a, field.Column), columnValue(b, field.Column))
+		if field.Column == "user_id" {
+			order = cmp.Compare(a.UserID, b.UserID)
+		}
+
+		if field.Desc {
+			order = -order
+		}
+
+		if order != 0 {
+			return order
+		}
+	}
+
+	// same tie-breaker as orderBy: user_id following the last field
+	order := cmp.Compare(a.UserID, b.UserID)
+	if len(fields) > 0 && fields[len(fields)-1].Desc {
+		return -order
+	}
+
+	return order
+}
+
+func (ms *MemoryUserRepository) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append([]model.Webhook{}, ms.webhooks...), nil
+}
+
+func (ms *MemoryUserRepository) GetWebhook(ctx context.Context, id int64) (*model.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	i := slices.IndexFunc(ms.webhooks, func(webhook model.Webhook) bool { return webhook.WebhookID == id })
+	if i < 0 {
+		return nil, ErrWebhookNotFound
+	}
+
+	webhook := ms.webhooks[i]
+
+	return &webhook, nil
+}
+
+func (ms *MemoryUserRepository) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.lastWebhook++
+	webhook.WebhookID = ms.lastWebhook
+	webhook.CreatedAt = time.Now().UTC()
+	ms.webhooks = append(ms.webhooks, *webhook)
+
+	return nil
+}
+
+func (ms *MemoryUserRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := slices.IndexFunc(ms.webhooks, func(webhook model.Webhook) bool { return webhook.WebhookID == id })
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+
+	ms.webhooks = slices.Delete(ms.webhooks, i, i+1)
+	ms.deliveries = slices.DeleteFunc(ms.deliveries, func(delivery model.Delivery) bool {
+		return delivery.WebhookID == id
+	})
+
+	return nil
+}
+
+func (ms *MemoryUserRepository) EnqueueDeliveries(ctx context.Context, deliveries ...model.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		queued := slices.ContainsFunc(ms.deliveries, func(existing model.Delivery) bool {
+			return existing.WebhookID == delivery.WebhookID && existing.EventID == delivery.EventID
+		})
+
+		if queued {
+			continue
+		}
+
+		ms.lastDelivery++
+		delivery.DeliveryID = ms.lastDelivery
+		delivery.Status = model.DeliveryPending
+		delivery.Attempts = 0
+		delivery.LastError = ""
+		delivery.CreatedAt = time.Now().UTC()
+		ms.deliveries = append(ms.deliveries, delivery)
+	}
+
+	return nil
+}
+
+func (ms *MemoryUserRepository) ClaimDeliveries(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	due := []model.Delivery{}
+
+	for i, delivery := range ms.deliveries {
+		if len(due) == limit {
+			break
+		}
+
+		if delivery.Status == model.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			ms.deliveries[i].NextAttemptAt = now.Add(ClaimDuration)
+			due = append(due, ms.deliveries[i])
+		}
+	}
+
+	return due, nil
+}
+
+func (ms *MemoryUserRepository) SaveDelivery(ctx context.Context, delivery *model.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i, existing := range ms.deliveries {
+		if existing.DeliveryID == delivery.DeliveryID {
+			ms.deliveries[i].Status = delivery.Status
+			ms.deliveries[i].Attempts = delivery.Attempts
+			ms.deliveries[i].NextAttemptAt = delivery.NextAttemptAt
+			ms.deliveries[i].LastError = delivery.LastError
+			ms.deliveries[i].DeliveredAt = delivery.DeliveredAt
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryUserRepository) ListDeliveries(ctx context.Context, webhookID int64, status string) ([]model.Delivery, error) {
+	if _, err := ms.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	deliveries := []model.Delivery{}
+
+	for i := len(ms.deliveries) - 1; i >= 0; i-- {
+		delivery := ms.deliveries[i]
+		if delivery.WebhookID == webhookID && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (ms *MemoryUserRepository) GetDelivery(ctx context.Context, webhookID, deliveryID int64) (*model.Delivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, delivery := range ms.deliveries {
+		if delivery.WebhookID == webhookID && delivery.DeliveryID == deliveryID {
+			return &delivery, nil
+		}
+	}
+
+	return nil, ErrDeliveryNotFound
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	ErrUnknownVersion  = errors.New("unknown migration version")
+	ErrNoMigrations    = errors.New("no migrations for driver")
+	ErrMissingDownFile = errors.New("migration has no down file")
+)
+
+// migrationLockKey identifies the advisory lock held while migrating, so
+// only one replica changes the schema at a time.
+const migrationLockKey = 4_137_265_580
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts the embedded migrations of a database driver
+type Migrator struct {
+	logger     *slog.Logger
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+func NewMigrator(logger *slog.Logger, db *sql.DB, driver string) (*Migrator, error) {
+	dialect, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoMigrations, driver)
+	}
+
+	migrations, err := loadMigrations(path.Join("migrations", driver))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		logger:     logger,
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(int64) (int64, error) {
+		if len(m.migrations) == 0 {
+			return 0, nil
+		}
+
+		return m.migrations[len(m.migrations)-1].Version, nil
+	})
+}
+
+// Down reverts the latest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, func(current int64) (int64, error) {
+		for i := len(m.migrations) - 1; i > 0; i-- {
+			if m.migrations[i].Version <= current {
+				return m.migrations[i-1].Version, nil
+			}
+		}
+
+		return 0, nil
+	})
+}
+
+// Goto applies or reverts migrations until version is the latest applied
+// one, version 0 reverts all of them.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && !slices.ContainsFunc(m.migrations, func(mg Migration) bool { return mg.Version == version }) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.run(ctx, func(int64) (int64, error) {
+		return version, nil
+	})
+}
+
+// Status lists every known migration along with the time it was applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	if err = m.createVersionTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) run(ctx context.Context, target func(current int64) (int64, error)) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	unlock, err := m.dialect.lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	defer unlock()
+
+	if err = m.createVersionTable(ctx, conn); err != nil {
+		return err
+	}
+
+	applied, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	var current int64
+
+	for version := range applied {
+		current = max(current, version)
+	}
+
+	version, err := target(current)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > version {
+			continue
+		}
+
+		if err = m.apply(ctx, conn, migration, true); err != nil {
+			return err
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= version {
+			continue
+		}
+
+		if err = m.apply(ctx, conn, migration, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	builder := sq.StatementBuilder.PlaceholderFormat(m.dialect.placeholder).RunWith(tx)
+	script, record := migration.Up, builder.Insert("schema_migrations").
+		Columns("version", "name").Values(migration.Version, migration.Name)
+
+	if !up {
+		if migration.Down == "" {
+			return fmt.Errorf("%w: %d_%s", ErrMissingDownFile, migration.Version, migration.Name)
+		}
+
+		script = migration.Down
+	}
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = record.ExecContext(ctx)
+	} else {
+		_, err = builder.Delete("schema_migrations").Where(sq.Eq{"version": migration.Version}).ExecContext(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+
+	m.logger.Info("Migration applied",
+		slog.Int64("version", migration.Version),
+		slog.String("name", migration.Name),
+		slog.String("direction", direction))
+
+	return nil
+}
+
+func (m *Migrator) createVersionTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+
+	return err
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema migrations: %w", err)
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		script, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return migrations, nil
+}
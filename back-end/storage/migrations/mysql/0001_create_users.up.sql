@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id BIGINT PRIMARY KEY AUTO_INCREMENT,
+	user_name VARCHAR(50) NOT NULL,
+	first_name VARCHAR(255) NOT NULL,
+	last_name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	user_status VARCHAR(1) NOT NULL,
+	department VARCHAR(255),
+
+	-- keyset pagination compares (sort_key, user_id) row values
+	INDEX users_user_name_user_id_idx (user_name, user_id),
+	INDEX users_first_name_user_id_idx (first_name, user_id),
+	INDEX users_last_name_user_id_idx (last_name, user_id),
+	INDEX users_email_user_id_idx (email, user_id),
+	INDEX users_user_status_user_id_idx (user_status, user_id),
+	INDEX users_department_user_id_idx (department, user_id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,2 @@
+DROP INDEX users_email_key ON users;
+DROP INDEX users_user_name_key ON users;
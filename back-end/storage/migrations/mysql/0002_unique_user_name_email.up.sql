@@ -0,0 +1,10 @@
+-- The indexes can't be built while users differ only by the case of their
+-- username or email, such users are listed by the queries below and have to
+-- be renamed or deleted, all but one of each, before migrating again:
+--   SELECT LOWER(user_name), GROUP_CONCAT(user_id) FROM users GROUP BY LOWER(user_name) HAVING COUNT(*) > 1;
+--   SELECT LOWER(email), GROUP_CONCAT(user_id) FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1;
+
+-- MariaDB has no functional indexes, the case-insensitive collation of the
+-- users table already makes these unique regardless of case
+CREATE UNIQUE INDEX users_user_name_key ON users (user_name);
+CREATE UNIQUE INDEX users_email_key ON users (email);
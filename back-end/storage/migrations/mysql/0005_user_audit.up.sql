@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+	audit_id BIGINT PRIMARY KEY AUTO_INCREMENT,
+	-- no foreign key, the log outlives purged users
+	user_id BIGINT NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	request_id VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME(6) NOT NULL,
+	changes JSON NOT NULL,
+
+	INDEX user_audit_user_id_idx (user_id, audit_id),
+	INDEX user_audit_actor_idx (actor, created_at),
+	INDEX user_audit_created_at_idx (created_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
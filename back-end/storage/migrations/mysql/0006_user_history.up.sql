@@ -0,0 +1,20 @@
+-- when the current row took over, NULL for rows older than the history
+ALTER TABLE users ADD COLUMN valid_from DATETIME(6) NULL;
+
+CREATE TABLE IF NOT EXISTS users_history (
+	history_id BIGINT PRIMARY KEY AUTO_INCREMENT,
+	user_id BIGINT NOT NULL,
+	user_name VARCHAR(50) NOT NULL,
+	first_name VARCHAR(255) NOT NULL,
+	last_name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	user_status VARCHAR(1) NOT NULL,
+	department VARCHAR(255),
+	version BIGINT NOT NULL,
+	deleted_at DATETIME(6) NULL,
+	valid_from DATETIME(6) NULL,
+	valid_to DATETIME(6) NOT NULL,
+
+	INDEX users_history_user_id_idx (user_id, version),
+	INDEX users_history_valid_to_idx (valid_to)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_events (
+	event_id BIGINT PRIMARY KEY AUTO_INCREMENT,
+	event_type VARCHAR(32) NOT NULL,
+	user_id BIGINT NOT NULL,
+	occurred_at DATETIME(6) NOT NULL,
+	payload JSON NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	webhook_id BIGINT PRIMARY KEY AUTO_INCREMENT,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(255) NOT NULL,
+	-- comma separated, empty for every event type
+	event_types VARCHAR(255) NOT NULL DEFAULT '',
+	department VARCHAR(255) NOT NULL DEFAULT '',
+	user_status VARCHAR(1) NOT NULL DEFAULT '',
+	created_at DATETIME(6) NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	delivery_id BIGINT PRIMARY KEY AUTO_INCREMENT,
+	webhook_id BIGINT NOT NULL,
+	event_id BIGINT NOT NULL,
+	event_type VARCHAR(32) NOT NULL,
+	payload JSON NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME(6) NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	delivered_at DATETIME(6),
+
+	UNIQUE KEY webhook_deliveries_event_key (webhook_id, event_id),
+	INDEX webhook_deliveries_due_idx (status, next_attempt_at),
+	FOREIGN KEY (webhook_id) REFERENCES webhooks (webhook_id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- who the caller said it acted on behalf of, unlike actor it isn't
+-- authenticated
+ALTER TABLE user_audit ADD COLUMN claimed_actor VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- a lease lets a single replica work on a queue at a time, the others wait
+-- for it to run out
+CREATE TABLE IF NOT EXISTS leases (
+	name VARCHAR(64) PRIMARY KEY,
+	holder VARCHAR(64) NOT NULL,
+	expires_at DATETIME(6) NOT NULL
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+INSERT IGNORE INTO leases (name, holder, expires_at) VALUES ('user_events', '', '1970-01-01 00:00:00');
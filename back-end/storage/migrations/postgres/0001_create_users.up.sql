@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	user_name VARCHAR(50) NOT NULL,
+	first_name VARCHAR(255) NOT NULL,
+	last_name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	user_status VARCHAR(1) NOT NULL,
+	department VARCHAR(255)
+);
+
+-- keyset pagination compares (sort_key, user_id) row values
+CREATE INDEX IF NOT EXISTS users_user_name_user_id_idx ON users (user_name, user_id);
+CREATE INDEX IF NOT EXISTS users_first_name_user_id_idx ON users (first_name, user_id);
+CREATE INDEX IF NOT EXISTS users_last_name_user_id_idx ON users (last_name, user_id);
+CREATE INDEX IF NOT EXISTS users_email_user_id_idx ON users (email, user_id);
+CREATE INDEX IF NOT EXISTS users_user_status_user_id_idx ON users (user_status, user_id);
+CREATE INDEX IF NOT EXISTS users_department_user_id_idx ON users (department, user_id);
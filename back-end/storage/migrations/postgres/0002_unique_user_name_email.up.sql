@@ -0,0 +1,23 @@
+-- The indexes can't be built while users differ only by the case of their
+-- username or email, such users are listed so they can be renamed or deleted
+-- before migrating again.
+DO $$
+DECLARE
+    conflicts TEXT;
+BEGIN
+    SELECT string_agg(format('%s %L (user_id %s)', field, value, ids), ', ') INTO conflicts
+    FROM (
+        SELECT 'user_name' AS field, LOWER(user_name) AS value, string_agg(user_id::TEXT, ', ' ORDER BY user_id) AS ids
+        FROM users GROUP BY LOWER(user_name) HAVING COUNT(*) > 1
+        UNION ALL
+        SELECT 'email', LOWER(email), string_agg(user_id::TEXT, ', ' ORDER BY user_id)
+        FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1
+    ) AS duplicates;
+
+    IF conflicts IS NOT NULL THEN
+        RAISE EXCEPTION 'users differ only by case, rename or delete all but one user of each before migrating again: %', conflicts;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_user_name_key ON users (LOWER(user_name));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (LOWER(email));
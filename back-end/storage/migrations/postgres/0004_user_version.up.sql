@@ -0,0 +1,2 @@
+-- bumped by every update, compared on writes for optimistic concurrency
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+	audit_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	-- no foreign key, the log outlives purged users
+	user_id BIGINT NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	request_id VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	changes JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (user_id, audit_id);
+CREATE INDEX IF NOT EXISTS user_audit_actor_idx ON user_audit (actor, created_at);
+CREATE INDEX IF NOT EXISTS user_audit_created_at_idx ON user_audit (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS users_history;
+ALTER TABLE users DROP COLUMN IF EXISTS valid_from;
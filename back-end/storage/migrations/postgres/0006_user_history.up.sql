@@ -0,0 +1,20 @@
+-- when the current row took over, NULL for rows older than the history
+ALTER TABLE users ADD COLUMN IF NOT EXISTS valid_from TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS users_history (
+	history_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	user_id BIGINT NOT NULL,
+	user_name VARCHAR(50) NOT NULL,
+	first_name VARCHAR(255) NOT NULL,
+	last_name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	user_status VARCHAR(1) NOT NULL,
+	department VARCHAR(255),
+	version BIGINT NOT NULL,
+	deleted_at TIMESTAMPTZ,
+	valid_from TIMESTAMPTZ,
+	valid_to TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS users_history_user_id_idx ON users_history (user_id, version);
+CREATE INDEX IF NOT EXISTS users_history_valid_to_idx ON users_history (valid_to);
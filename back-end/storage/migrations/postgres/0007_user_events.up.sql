@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_events (
+	event_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	event_type VARCHAR(32) NOT NULL,
+	user_id BIGINT NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	payload JSONB NOT NULL
+);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	webhook_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(255) NOT NULL,
+	-- comma separated, empty for every event type
+	event_types VARCHAR(255) NOT NULL DEFAULT '',
+	department VARCHAR(255) NOT NULL DEFAULT '',
+	user_status VARCHAR(1) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	delivery_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	webhook_id BIGINT NOT NULL REFERENCES webhooks (webhook_id),
+	event_id BIGINT NOT NULL,
+	event_type VARCHAR(32) NOT NULL,
+	payload JSONB NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	delivered_at TIMESTAMPTZ,
+
+	UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at);
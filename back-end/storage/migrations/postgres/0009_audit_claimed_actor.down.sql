@@ -0,0 +1 @@
+ALTER TABLE user_audit DROP COLUMN IF EXISTS claimed_actor;
@@ -0,0 +1,10 @@
+-- a lease lets a single replica work on a queue at a time, the others wait
+-- for it to run out
+CREATE TABLE IF NOT EXISTS leases (
+	name VARCHAR(64) PRIMARY KEY,
+	holder VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+INSERT INTO leases (name, holder, expires_at) VALUES ('user_events', '', '1970-01-01 00:00:00+00')
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,3 @@
+-- the random token of the deliverer attempting a delivery, next_attempt_at is
+-- pushed back meanwhile so that other replicas leave it alone
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS claim VARCHAR(64) NOT NULL DEFAULT '';
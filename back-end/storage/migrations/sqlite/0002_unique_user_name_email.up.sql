@@ -0,0 +1,8 @@
+-- The indexes can't be built while users differ only by the case of their
+-- username or email, such users are listed by the queries below and have to
+-- be renamed or deleted, all but one of each, before migrating again:
+--   SELECT LOWER(user_name), GROUP_CONCAT(user_id) FROM users GROUP BY LOWER(user_name) HAVING COUNT(*) > 1;
+--   SELECT LOWER(email), GROUP_CONCAT(user_id) FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_user_name_key ON users (LOWER(user_name));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (LOWER(email));
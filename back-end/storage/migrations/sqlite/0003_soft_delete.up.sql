@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+-- purging looks up the users deleted before the retention period
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_events (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type VARCHAR(32) NOT NULL,
+	user_id INTEGER NOT NULL,
+	occurred_at TIMESTAMP NOT NULL,
+	payload TEXT NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE user_audit DROP COLUMN claimed_actor;
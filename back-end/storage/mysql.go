@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlDuplicateEntry is the error number of ER_DUP_ENTRY, returned by MySQL
+// and MariaDB when a unique index is violated
+const mysqlDuplicateEntry = 1062
+
+// mysqlLockName names the lock held while migrating, GET_LOCK takes a string
+// rather than the numeric key of the Postgres advisory lock
+const mysqlLockName = "users_crud_migrations"
+
+type MySQLUserRepository struct {
+	sqlUserRepository
+}
+
+var _ UserRepository = (*MySQLUserRepository)(nil)
+
+// mysqlDialect has no RETURNING, created users are read back by the id
+// LAST_INSERT_ID() reports for the transaction's connection
+var mysqlDialect = dialect{
+	placeholder: sq.Question,
+	open:        openMySQL,
+	mapError:    mysqlError,
+	lock:        mysqlLock,
+}
+
+func NewMySQLRepository(logger *slog.Logger, db *sql.DB) *MySQLUserRepository {
+	return &MySQLUserRepository{
+		sqlUserRepository{
+			logger:  logger,
+			db:      db,
+			dialect: mysqlDialect,
+		},
+	}
+}
+
+// openMySQL connects to cfg.Host, which may carry a port and defaults to
+// 3306. Migrations hold several statements, and applied_at is scanned into a
+// time.Time, hence MultiStatements and ParseTime.
+func openMySQL(cfg *config.Database) (*sql.DB, error) {
+	mysqlCfg := mysql.NewConfig()
+	mysqlCfg.Net = "tcp"
+	mysqlCfg.Addr = cfg.Host
+	mysqlCfg.User = cfg.User
+	mysqlCfg.Passwd = cfg.Password
+	mysqlCfg.DBName = cfg.Name
+	mysqlCfg.ParseTime = true
+	mysqlCfg.MultiStatements = true
+
+	switch cfg.SSLMode {
+	case "disable":
+		mysqlCfg.TLSConfig = "false"
+	case "require":
+		mysqlCfg.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		mysqlCfg.TLSConfig = "true"
+	default:
+		mysqlCfg.TLSConfig = "preferred"
+	}
+
+	connector, err := mysql.NewConnector(mysqlCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+// mysqlError maps unique violations of the users indexes to ErrAlreadyExist
+// and ErrEmailInUse, other errors are returned untouched. MySQL names the key
+// "users.users_email_key" while MariaDB leaves the table out.
+func mysqlError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlDuplicateEntry {
+		return err
+	}
+
+	if strings.Contains(mysqlErr.Message, "users_email_key") {
+		return ErrEmailInUse
+	}
+
+	return ErrAlreadyExist
+}
+
+func mysqlLock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", mysqlLockName).Scan(&acquired); err != nil {
+		return nil, err
+	}
+
+	if acquired.Int64 != 1 {
+		return nil, errors.New("GET_LOCK didn't acquire the migration lock")
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", mysqlLockName)
+	}, nil
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// Outbox hands out the events recorded along with user changes. Events stay
+// pending, oldest first, until they're acknowledged, so a crash between
+// delivering and acknowledging them delivers them again.
+//
+// ClaimEvents returns the pending events to holder only while it holds the
+// lease on the outbox, it's taken or renewed at now for LeaseDuration. The
+// other holders get no events meanwhile, so that replicas don't deliver the
+// same events.
+type Outbox interface {
+	ClaimEvents(ctx context.Context, holder string, now time.Time, limit int) ([]model.Event, error)
+	AckEvents(ctx context.Context, ids ...int64) error
+}
+
+var (
+	_ Outbox = sqlUserRepository{}
+	_ Outbox = (*MemoryUserRepository)(nil)
+)
+
+// newEvents returns the events published for action turning before into
+// after. Purging a user publishes nothing as it was deleted already.
+func newEvents(ctx context.Context, action string, before, after *model.User) []model.Event {
+	if after == nil {
+		return nil
+	}
+
+	event := model.Event{
+		UserID:     after.UserID,
+		OccurredAt: time.Now().UTC(),
+		RequestID:  AuditInfoFrom(ctx).RequestID,
+		User:       *after,
+	}
+
+	switch action {
+	case ActionCreate:
+		event.Type = model.EventUserCreated
+	case ActionDelete:
+		event.Type = model.EventUserDeleted
+	case ActionUpdate, ActionRestore:
+		event.Type = model.EventUserUpdated
+	default:
+		return nil
+	}
+
+	if before != nil && event.Type == model.EventUserUpdated && before.Department != after.Department {
+		event.PreviousDepartment = before.Department
+	}
+
+	events := []model.Event{event}
+
+	if before != nil && event.Type == model.EventUserUpdated && before.Status != after.Status {
+		event.Type = model.EventUserStatusChanged
+		event.PreviousStatus = before.Status
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// record audits a change and publishes its events, both in the transaction
+// making the change
+func (r sqlUserRepository) record(ctx context.Context, tx sq.BaseRunner, action string, before, after *model.User) error {
+	return r.recordAll(ctx, tx, action, []userChange{{before: before, after: after}})
+}
+
+// recordAll records changes made by the same action at once
+func (r sqlUserRepository) recordAll(ctx context.Context, tx sq.BaseRunner, action string, changes []userChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := r.audit(ctx, tx, action, changes); err != nil {
+		return err
+	}
+
+	return r.publish(ctx, tx, action, changes)
+}
+
+// publish adds the events of changes to the outbox in the same transaction
+// as the changes themselves, with a single INSERT
+func (r sqlUserRepository) publish(ctx context.Context, tx sq.BaseRunner, action string, changes []userChange) error {
+	insert := r.builder().Insert("user_events").Columns("event_type", "user_id", "occurred_at", "payload")
+
+	var published int
+
+	for _, change := range changes {
+		for _, event := range newEvents(ctx, action, change.before, change.after) {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			insert = insert.Values(event.Type, event.UserID, event.OccurredAt, string(payload))
+			published++
+		}
+	}
+
+	if published == 0 {
+		return nil
+	}
+
+	if _, err := insert.RunWith(tx).ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+
+	return nil
+}
+
+func (r sqlUserRepository) ClaimEvents(ctx context.Context, holder string, now time.Time, limit int) ([]model.Event, error) {
+	if held, err := r.acquireLease(ctx, eventsLease, holder, now); err != nil || !held {
+		return []model.Event{}, err
+	}
+
+	rows, err := r.builder().Select("event_id", "payload").From("user_events").
+		OrderBy("event_id ASC").Limit(uint64(limit)).
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		r.logger.Error("Failed to execute outbox query",
+			slog.String("err", err.Error()))
+
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var (
+		events      = []model.Event{}
+		undecodable []int64
+	)
+
+	for rows.Next() {
+		var (
+			id      int64
+			payload []byte
+			event   model.Event
+		)
+
+		if err := rows.Scan(&id, &payload); err != nil {
+			return nil, fmt.Errorf("failed to scan event data: %w", err)
+		}
+
+		// such an event would hold every following one back for good
+		if err := json.Unmarshal(payload, &event); err != nil {
+			r.logger.Error("Dropping an event that can't be decoded",
+				slog.Int64("event_id", id),
+				slog.String("payload", string(payload)),
+				slog.String("err", err.Error()))
+
+			undecodable = append(undecodable, id)
+
+			continue
+		}
+
+		event.EventID = id
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single connection, held by rows until they're closed
+	rows.Close()
+
+	// the audit log keeps the record of the change
+	if err := r.AckEvents(ctx, undecodable...); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// AckEvents removes delivered events from the outbox, the audit log keeps
+// the record of the changes
+func (r sqlUserRepository) AckEvents(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := r.builder().Delete("user_events").Where(sq.Eq{"event_id": ids}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge events: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/lib/pq"
+)
+
+type PostgresUserRepository struct {
+	sqlUserRepository
+}
+
+var _ UserRepository = (*PostgresUserRepository)(nil)
+
+var postgresDialect = dialect{
+	returning:   true,
+	placeholder: sq.Dollar,
+	open:        openPostgres,
+	mapError:    postgresError,
+	lock:        postgresAdvisoryLock,
+}
+
+func NewPostgresRepository(logger *slog.Logger, db *sql.DB) *PostgresUserRepository {
+	return &PostgresUserRepository{
+		sqlUserRepository{
+			logger:  logger,
+			db:      db,
+			dialect: postgresDialect,
+		},
+	}
+}
+
+func openPostgres(cfg *config.Database) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
+
+	return sql.Open("postgres", connStr)
+}
+
+// postgresError maps unique violations of the users indexes to ErrAlreadyExist
+// and ErrEmailInUse, other errors are returned untouched.
+func postgresError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+
+	if pqErr.Constraint == "users_email_key" {
+		return ErrEmailInUse
+	}
+
+	return ErrAlreadyExist
+}
+
+func postgresAdvisoryLock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}, nil
+}
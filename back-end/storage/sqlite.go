@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/config"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type SQLiteUserRepository struct {
+	sqlUserRepository
+}
+
+var _ UserRepository = (*SQLiteUserRepository)(nil)
+
+var sqliteDialect = dialect{
+	returning:   true,
+	placeholder: sq.Question,
+	open:        openSQLite,
+	mapError:    sqliteError,
+	lock:        sqliteLock,
+}
+
+// NewSQLiteRepository returns a repository writing through db, which holds
+// a single connection, and reading through a pool of sqliteReaders
+// connections of its own so that a slow reader, such as an export streamed
+// to a slow client, doesn't hold the writes up. Reads go through db too when
+// the pool can't be opened, e.g. for an in-memory database.
+func NewSQLiteRepository(logger *slog.Logger, db *sql.DB) *SQLiteUserRepository {
+	reads, err := openSQLiteReads(db)
+	if err != nil {
+		logger.Warn("Failed to open the SQLite read pool, reads share the write connection",
+			slog.String("err", err.Error()))
+	}
+
+	return &SQLiteUserRepository{
+		sqlUserRepository{
+			logger:  logger,
+			db:      db,
+			dialect: sqliteDialect,
+			reads:   reads,
+		},
+	}
+}
+
+// sqliteReaders is how many connections the read pool holds
+const sqliteReaders = 4
+
+// openSQLite opens the database file named by cfg.Name in WAL mode, where
+// readers don't block the writer. SQLite allows a single writer, so the pool
+// is limited to one connection rather than having concurrent transactions
+// fail with SQLITE_BUSY, the repository reads through a pool of its own.
+func openSQLite(cfg *config.Database) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+cfg.Name+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// openSQLiteReads opens a read only pool on the database file db is
+// connected to, it returns nil for a database without a file.
+func openSQLiteReads(db *sql.DB) (*sql.DB, error) {
+	var (
+		seq        int
+		name, file string
+	)
+
+	if err := db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		return nil, err
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	reads, err := sql.Open("sqlite", "file:"+file+"?_pragma=busy_timeout(5000)&_pragma=query_only(1)")
+	if err != nil {
+		return nil, err
+	}
+
+	reads.SetMaxOpenConns(sqliteReaders)
+
+	return reads, nil
+}
+
+// sqliteError maps unique violations of the users indexes to ErrAlreadyExist
+// and ErrEmailInUse, other errors are returned untouched.
+func sqliteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code() != sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return err
+	}
+
+	if strings.Contains(sqliteErr.Error(), "users_email_key") {
+		return ErrEmailInUse
+	}
+
+	return ErrAlreadyExist
+}
+
+// sqliteLock doesn't lock anything: the database is a local file and the
+// single connection pool already serializes migrations.
+func sqliteLock(context.Context, *sql.Conn) (func(), error) {
+	return func() {}, nil
+}
@@ -1,18 +1,44 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 
+	sq "github.com/Masterminds/squirrel"
 	"github.com/andrii-stp/users-crud/config"
-	_ "github.com/lib/pq"
 )
 
+var ErrUnknownDriver = errors.New("unknown database driver")
+
+// dialect holds what differs between the SQL databases users can be stored in
+type dialect struct {
+	placeholder sq.PlaceholderFormat
+	// returning tells whether INSERT and UPDATE support RETURNING, without it
+	// the written row is read back within the same transaction
+	returning bool
+	open      func(cfg *config.Database) (*sql.DB, error)
+	// mapError translates unique violations to ErrAlreadyExist and ErrEmailInUse
+	mapError func(err error) error
+	// lock keeps other replicas from migrating the schema at the same time
+	lock func(ctx context.Context, conn *sql.Conn) (unlock func(), err error)
+}
+
+var dialects = map[string]dialect{
+	"mysql":    mysqlDialect,
+	"postgres": postgresDialect,
+	"sqlite":   sqliteDialect,
+}
+
 func Connect(cfg *config.Database) (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
+	dialect, ok := dialects[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDriver, cfg.Driver)
+	}
 
-	db, err := sql.Open(cfg.Driver, connStr)
+	db, err := dialect.open(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -24,22 +50,16 @@ func Connect(cfg *config.Database) (*sql.DB, error) {
 	return db, nil
 }
 
-func InitDB(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS users (
-		user_id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-		user_name VARCHAR(50) NOT NULL,
-		first_name VARCHAR(255) NOT NULL,
-		last_name VARCHAR(255) NOT NULL,
-		email VARCHAR(255) NOT NULL,
-		user_status VARCHAR(1) NOT NULL,
-		department VARCHAR(255)
-	  );
-	`
-
-	if _, err := db.Exec(schema); err != nil {
-		return err
+// NewRepository returns the UserRepository for a database opened with Connect
+func NewRepository(logger *slog.Logger, db *sql.DB, driver string) (UserRepository, error) {
+	switch driver {
+	case "mysql":
+		return NewMySQLRepository(logger, db), nil
+	case "postgres":
+		return NewPostgresRepository(logger, db), nil
+	case "sqlite":
+		return NewSQLiteRepository(logger, db), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDriver, driver)
 	}
-
-	return nil
 }
@@ -0,0 +1,1562 @@
+// Package storagetest holds the conformance suite every storage.UserRepository
+// implementation runs, so that backends can't drift apart in behavior.
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// Factory returns a repository holding no users
+type Factory func(t *testing.T) storage.UserRepository
+
+// Run runs the conformance suite against the repositories built by newRepository
+func Run(t *testing.T, newRepository Factory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo storage.UserRepository)
+	}{
+		{"Create", testCreate},
+		{"CreateDuplicate", testCreateDuplicate},
+		{"Get", testGet},
+		{"GetByUserName", testGetByUserName},
+		{"Update", testUpdate},
+		{"UpdateDuplicate", testUpdateDuplicate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"UpdateOnlyTarget", testUpdateOnlyTarget},
+		{"Patch", testPatch},
+		{"Version", testVersion},
+		{"ConcurrentUpdate", testConcurrentUpdate},
+		{"Delete", testDelete},
+		{"Trash", testTrash},
+		{"Purge", testPurge},
+		{"Erase", testErase},
+		{"Audit", testAudit},
+		{"History", testHistory},
+		{"Events", testEvents},
+		{"EventsLease", testEventsLease},
+		{"Webhooks", testWebhooks},
+		{"DeliveryClaims", testDeliveryClaims},
+		{"Batch", testBatch},
+		{"List", testList},
+		{"ListCursor", testListCursor},
+		{"Each", testEach},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+// NewUser returns a valid user whose username and email are derived from name
+func NewUser(name string) *model.User {
+	return &model.User{
+		UserName:   name,
+		FirstName:  "First " + name,
+		LastName:   "Last " + name,
+		Email:      name + "@example.com",
+		Status:     "A",
+		Department: "Engineering",
+	}
+}
+
+func create(t *testing.T, repo storage.UserRepository, user *model.User) *model.User {
+	t.Helper()
+
+	if err := repo.Create(context.Background(), user); err != nil {
+		t.Fatalf("failed to create user %s: %v", user.UserName, err)
+	}
+
+	return user
+}
+
+func expectError(t *testing.T, err, expected error) {
+	t.Helper()
+
+	if !errors.Is(err, expected) {
+		t.Fatalf("expected error %v but got %v", expected, err)
+	}
+}
+
+func expectUser(t *testing.T, got, expected *model.User) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("expected user %+v but got nil", *expected)
+	}
+
+	if *got != *expected {
+		t.Fatalf("expected user %+v but got %+v", *expected, *got)
+	}
+}
+
+func testCreate(t *testing.T, repo storage.UserRepository) {
+	first := create(t, repo, NewUser("alice"))
+	second := create(t, repo, NewUser("bob"))
+
+	if first.UserID == 0 || second.UserID == 0 {
+		t.Fatalf("expected generated ids but got %d and %d", first.UserID, second.UserID)
+	}
+
+	if first.UserID == second.UserID {
+		t.Fatalf("expected distinct ids but both are %d", first.UserID)
+	}
+
+	got, err := repo.Get(context.Background(), first.UserID)
+	if err != nil {
+		t.Fatalf("failed to get created user: %v", err)
+	}
+
+	expectUser(t, got, first)
+}
+
+func testCreateDuplicate(t *testing.T, repo storage.UserRepository) {
+	create(t, repo, NewUser("alice"))
+
+	sameName := NewUser("ALICE")
+	sameName.Email = "other@example.com"
+	expectError(t, repo.Create(context.Background(), sameName), storage.ErrAlreadyExist)
+
+	sameEmail := NewUser("bob")
+	sameEmail.Email = "Alice@Example.com"
+	expectError(t, repo.Create(context.Background(), sameEmail), storage.ErrEmailInUse)
+
+	users, total, err := repo.List(context.Background(), storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+
+	if total != 1 || len(users) != 1 {
+		t.Fatalf("expected a single user but got %d (total %d)", len(users), total)
+	}
+}
+
+func testGet(t *testing.T, repo storage.UserRepository) {
+	_, err := repo.Get(context.Background(), -1)
+	expectError(t, err, storage.ErrUserNotFound)
+}
+
+func testGetByUserName(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+
+	got, err := repo.GetByUserName(context.Background(), "Alice")
+	if err != nil {
+		t.Fatalf("failed to get user by username: %v", err)
+	}
+
+	expectUser(t, got, alice)
+
+	_, err = repo.GetByUserName(context.Background(), "nobody")
+	expectError(t, err, storage.ErrUserNotFound)
+}
+
+func testUpdate(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	update := NewUser("Alice")
+	update.Department = "Sales"
+	update.Status = "I"
+
+	previous, err := repo.Update(context.Background(), alice.UserID, update)
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	if update.UserID != alice.UserID {
+		t.Fatalf("expected updated user to keep id %d but got %d", alice.UserID, update.UserID)
+	}
+
+	expectUser(t, previous, alice)
+
+	got, err := repo.Get(context.Background(), alice.UserID)
+	if err != nil {
+		t.Fatalf("failed to get updated user: %v", err)
+	}
+
+	expectUser(t, got, update)
+
+	got, err = repo.Get(context.Background(), bob.UserID)
+	if err != nil {
+		t.Fatalf("failed to get other user: %v", err)
+	}
+
+	expectUser(t, got, bob)
+}
+
+func testUpdateDuplicate(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	create(t, repo, NewUser("bob"))
+
+	sameName := NewUser("Bob")
+	sameName.Email = alice.Email
+	_, err := repo.Update(context.Background(), alice.UserID, sameName)
+	expectError(t, err, storage.ErrAlreadyExist)
+
+	sameEmail := NewUser("alice")
+	sameEmail.Email = "BOB@example.com"
+	_, err = repo.Update(context.Background(), alice.UserID, sameEmail)
+	expectError(t, err, storage.ErrEmailInUse)
+
+	got, err := repo.Get(context.Background(), alice.UserID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+
+	expectUser(t, got, alice)
+}
+
+func testUpdateNotFound(t *testing.T, repo storage.UserRepository) {
+	_, err := repo.Update(context.Background(), -1, NewUser("alice"))
+	expectError(t, err, storage.ErrUserNotFound)
+}
+
+// testUpdateOnlyTarget guards against an update missing its WHERE clause,
+// which overwrote every user with the updated one
+func testUpdateOnlyTarget(t *testing.T, repo storage.UserRepository) {
+	users := []*model.User{
+		create(t, repo, NewUser("alice")),
+		create(t, repo, NewUser("bob")),
+		create(t, repo, NewUser("carol")),
+	}
+
+	update := NewUser("bobby")
+	if _, err := repo.Update(context.Background(), users[1].UserID, update); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	got, total, err := repo.List(context.Background(), storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+
+	if total != int64(len(users)) || len(got) != len(users) {
+		t.Fatalf("expected %d users but got %d of %d", len(users), len(got), total)
+	}
+
+	for i, expected := range []*model.User{users[0], update, users[2]} {
+		expectUser(t, &got[i], expected)
+	}
+}
+
+func testPatch(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	patched, err := repo.Patch(context.Background(), alice.UserID, 0, func(user *model.User) error {
+		user.Department = "Sales"
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to patch user: %v", err)
+	}
+
+	expected := *alice
+	expected.Department, expected.Version = "Sales", alice.Version+1
+	expectUser(t, patched, &expected)
+
+	got, err := repo.Get(context.Background(), alice.UserID)
+	if err != nil {
+		t.Fatalf("failed to get patched user: %v", err)
+	}
+
+	expectUser(t, got, &expected)
+
+	unchanged, err := repo.Patch(context.Background(), alice.UserID, 0, func(*model.User) error { return nil })
+	if err != nil {
+		t.Fatalf("failed to apply an empty patch: %v", err)
+	}
+
+	expectUser(t, unchanged, &expected)
+
+	errPatch := errors.New("patch failed")
+	_, err = repo.Patch(context.Background(), alice.UserID, 0, func(user *model.User) error {
+		user.Department = "Marketing"
+
+		return errPatch
+	})
+	expectError(t, err, errPatch)
+
+	_, err = repo.Patch(context.Background(), alice.UserID, 0, func(user *model.User) error {
+		user.UserName = "BOB"
+
+		return nil
+	})
+	expectError(t, err, storage.ErrAlreadyExist)
+
+	_, err = repo.Patch(context.Background(), alice.UserID, alice.Version, func(user *model.User) error {
+		user.Department = "Marketing"
+
+		return nil
+	})
+	expectError(t, err, storage.ErrVersionConflict)
+
+	_, err = repo.Patch(context.Background(), -1, 0, func(*model.User) error { return nil })
+	expectError(t, err, storage.ErrUserNotFound)
+
+	if got, err = repo.Get(context.Background(), alice.UserID); err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+
+	expectUser(t, got, &expected)
+
+	if got, err = repo.Get(context.Background(), bob.UserID); err != nil {
+		t.Fatalf("failed to get other user: %v", err)
+	}
+
+	expectUser(t, got, bob)
+}
+
+func testVersion(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+
+	if alice.Version != 1 {
+		t.Fatalf("expected a created user to be at version 1 but got %d", alice.Version)
+	}
+
+	update := NewUser("alice")
+	if _, err := repo.Update(context.Background(), alice.UserID, update); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	if update.Version != 2 {
+		t.Fatalf("expected an update to bump the version to 2 but got %d", update.Version)
+	}
+
+	stale := NewUser("alice")
+	stale.Version = 1
+	_, err := repo.Update(context.Background(), alice.UserID, stale)
+	expectError(t, err, storage.ErrVersionConflict)
+
+	current := NewUser("alice")
+	current.Department = "Sales"
+	current.Version = 2
+
+	if _, err := repo.Update(context.Background(), alice.UserID, current); err != nil {
+		t.Fatalf("failed to update user at its current version: %v", err)
+	}
+
+	if current.Version != 3 {
+		t.Fatalf("expected version 3 but got %d", current.Version)
+	}
+
+	expectError(t, repo.Delete(context.Background(), alice.UserID, 2), storage.ErrVersionConflict)
+	expectError(t, repo.Delete(context.Background(), -1, 2), storage.ErrUserNotFound)
+
+	if err := repo.Delete(context.Background(), alice.UserID, 3); err != nil {
+		t.Fatalf("failed to delete user at its current version: %v", err)
+	}
+}
+
+func testConcurrentUpdate(t *testing.T, repo storage.UserRepository) {
+	const workers = 8
+
+	alice := create(t, repo, NewUser("alice"))
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		updated   int
+		conflicts int
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			update := NewUser("alice")
+			update.Department = fmt.Sprintf("Department %d", i)
+			update.Version = alice.Version
+
+			_, err := repo.Update(context.Background(), alice.UserID, update)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case err == nil:
+				updated++
+			case errors.Is(err, storage.ErrVersionConflict):
+				conflicts++
+			default:
+				t.Errorf("failed to update user: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if updated != 1 || conflicts != workers-1 {
+		t.Fatalf("expected a single update to win but got %d updates and %d conflicts", updated, conflicts)
+	}
+}
+
+func testDelete(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	if err := repo.Delete(context.Background(), alice.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	_, err := repo.Get(context.Background(), alice.UserID)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	expectError(t, repo.Delete(context.Background(), alice.UserID, 0), storage.ErrUserNotFound)
+
+	if _, err := repo.Get(context.Background(), bob.UserID); err != nil {
+		t.Fatalf("expected other user to be kept but got %v", err)
+	}
+}
+
+func testTrash(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	if err := repo.Delete(context.Background(), alice.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	users, total, err := repo.List(context.Background(), storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+
+	if total != 1 {
+		t.Errorf("expected deleted users to be left out of the total but got %d", total)
+	}
+
+	expectUsers(t, users, []*model.User{bob})
+
+	trash, total, err := repo.List(context.Background(), storage.ListOptions{Filter: storage.UserFilter{Deleted: true}})
+	if err != nil {
+		t.Fatalf("failed to list deleted users: %v", err)
+	}
+
+	if total != 1 || len(trash) != 1 || trash[0].UserID != alice.UserID {
+		t.Fatalf("expected only %s in the trash but got %+v (total %d)", alice.UserName, trash, total)
+	}
+
+	if trash[0].DeletedAt == nil {
+		t.Errorf("expected deleted user to have a deletion time")
+	}
+
+	_, err = repo.GetByUserName(context.Background(), alice.UserName)
+	expectError(t, err, storage.ErrUserNotFound)
+	_, err = repo.Update(context.Background(), alice.UserID, NewUser("alice"))
+	expectError(t, err, storage.ErrUserNotFound)
+
+	sameName := NewUser("alice")
+	sameName.Email = "other@example.com"
+	expectError(t, repo.Create(context.Background(), sameName), storage.ErrAlreadyExist)
+
+	restored, err := repo.Restore(context.Background(), alice.UserID)
+	if err != nil {
+		t.Fatalf("failed to restore user: %v", err)
+	}
+
+	expectUser(t, restored, alice)
+
+	_, err = repo.Restore(context.Background(), alice.UserID)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	_, err = repo.Restore(context.Background(), -1)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	got, err := repo.Get(context.Background(), alice.UserID)
+	if err != nil {
+		t.Fatalf("failed to get restored user: %v", err)
+	}
+
+	expectUser(t, got, alice)
+}
+
+func testPurge(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	if err := repo.Delete(context.Background(), alice.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	purged, err := repo.Purge(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+
+	if purged != 0 {
+		t.Fatalf("expected users deleted within the retention to be kept but %d were purged", purged)
+	}
+
+	if purged, err = repo.Purge(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+
+	if purged != 1 {
+		t.Fatalf("expected a single user to be purged but got %d", purged)
+	}
+
+	_, err = repo.Restore(context.Background(), alice.UserID)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	if _, err := repo.Get(context.Background(), bob.UserID); err != nil {
+		t.Fatalf("expected live users to be kept but got %v", err)
+	}
+
+	create(t, repo, NewUser("alice"))
+}
+
+func testErase(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	_, err := repo.Erase(context.Background(), alice.UserID, alice.Version+1)
+	expectError(t, err, storage.ErrVersionConflict)
+
+	if err = repo.Delete(context.Background(), bob.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	_, err = repo.Erase(context.Background(), bob.UserID, 0)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	erased, err := repo.Erase(context.Background(), alice.UserID, alice.Version)
+	if err != nil {
+		t.Fatalf("failed to erase user: %v", err)
+	}
+
+	if erased.UserID != alice.UserID || erased.UserName != alice.UserName || erased.DeletedAt == nil {
+		t.Errorf("expected the erased user to be returned as deleted but got %+v", erased)
+	}
+
+	_, err = repo.Erase(context.Background(), alice.UserID, 0)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	_, err = repo.Restore(context.Background(), alice.UserID)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	if _, err := repo.Restore(context.Background(), bob.UserID); err != nil {
+		t.Fatalf("expected other trashed users to be kept but got %v", err)
+	}
+
+	create(t, repo, NewUser("alice"))
+}
+
+func testAudit(t *testing.T, repo storage.UserRepository) {
+	ctx := storage.WithAuditInfo(context.Background(), storage.AuditInfo{Actor: "admin", ClaimedActor: "hr-sync", RequestID: "request-1"})
+
+	alice := NewUser("alice")
+	if err := repo.Create(ctx, alice); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	update := NewUser("alice")
+	update.Department = "Sales"
+
+	if _, err := repo.Update(ctx, alice.UserID, update); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	stale := NewUser("alice")
+	stale.Version = 1
+	_, err := repo.Update(ctx, alice.UserID, stale)
+	expectError(t, err, storage.ErrVersionConflict)
+
+	if err := repo.Delete(ctx, alice.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	if _, err := repo.Purge(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+
+	bob := create(t, repo, NewUser("bob"))
+
+	history, err := repo.ListAudit(context.Background(), storage.AuditFilter{UserID: alice.UserID})
+	if err != nil {
+		t.Fatalf("failed to list audit log: %v", err)
+	}
+
+	actions := []string{storage.ActionCreate, storage.ActionUpdate, storage.ActionDelete, storage.ActionPurge}
+	if len(history) != len(actions) {
+		t.Fatalf("expected %d audit entries but got %+v", len(actions), history)
+	}
+
+	for i, entry := range history {
+		if entry.Action != actions[i] || entry.UserID != alice.UserID {
+			t.Fatalf("expected %s of user %d at %d but got %+v", actions[i], alice.UserID, i, entry)
+		}
+
+		if entry.CreatedAt.IsZero() {
+			t.Errorf("expected %s entry to have a time", entry.Action)
+		}
+	}
+
+	if history[0].Actor != "admin" || history[0].ClaimedActor != "hr-sync" || history[0].RequestID != "request-1" {
+		t.Errorf("expected actor and request id to be recorded but got %+v", history[0])
+	}
+
+	if history[3].Actor != storage.SystemActor {
+		t.Errorf("expected purge to be recorded as %s but got %s", storage.SystemActor, history[3].Actor)
+	}
+
+	if change := history[0].Changes["user_name"]; change.Before != nil || change.After != "alice" {
+		t.Errorf("expected creation to record the username but got %+v", history[0].Changes)
+	}
+
+	if change, ok := history[1].Changes["department"]; !ok || change.Before != "Engineering" || change.After != "Sales" || len(history[1].Changes) != 1 {
+		t.Errorf("expected update to record only the department change but got %+v", history[1].Changes)
+	}
+
+	if change := history[2].Changes["deleted_at"]; change.Before != nil || change.After == nil {
+		t.Errorf("expected deletion to record the deletion time but got %+v", history[2].Changes)
+	}
+
+	tests := []struct {
+		name     string
+		filter   storage.AuditFilter
+		expected []int64
+	}{
+		{"actor", storage.AuditFilter{Actor: "admin"}, []int64{history[0].AuditID, history[1].AuditID, history[2].AuditID}},
+		{"claimed actor", storage.AuditFilter{ClaimedActor: "hr-sync"}, []int64{history[0].AuditID, history[1].AuditID, history[2].AuditID}},
+		{"unknown claimed actor", storage.AuditFilter{ClaimedActor: "admin"}, nil},
+		{"since", storage.AuditFilter{Since: time.Now().Add(time.Hour)}, nil},
+		{"after", storage.AuditFilter{UserID: alice.UserID, After: history[2].AuditID}, []int64{history[3].AuditID}},
+		{"limit", storage.AuditFilter{Limit: 2}, []int64{history[0].AuditID, history[1].AuditID}},
+		{"other user", storage.AuditFilter{UserID: bob.UserID, Actor: "admin"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := repo.ListAudit(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("failed to list audit log: %v", err)
+			}
+
+			if entries == nil {
+				t.Errorf("expected an empty list rather than nil")
+			}
+
+			var ids []int64
+			for _, entry := range entries {
+				ids = append(ids, entry.AuditID)
+			}
+
+			if fmt.Sprint(ids) != fmt.Sprint(tt.expected) {
+				t.Fatalf("expected entries %v but got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func testHistory(t *testing.T, repo storage.UserRepository) {
+	// marks the time between two changes
+	mark := func() time.Time {
+		time.Sleep(5 * time.Millisecond)
+		defer time.Sleep(5 * time.Millisecond)
+
+		return time.Now()
+	}
+
+	beforeCreate := mark()
+	alice := create(t, repo, NewUser("alice"))
+	afterCreate := mark()
+
+	update := NewUser("alice")
+	update.Department = "Sales"
+
+	if _, err := repo.Update(context.Background(), alice.UserID, update); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	afterUpdate := mark()
+
+	if err := repo.Delete(context.Background(), alice.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	afterDelete := mark()
+
+	_, err := repo.GetAsOf(context.Background(), alice.UserID, beforeCreate)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	got, err := repo.GetAsOf(context.Background(), alice.UserID, afterCreate)
+	if err != nil {
+		t.Fatalf("failed to get user as of its creation: %v", err)
+	}
+
+	expectUser(t, got, alice)
+
+	got, err = repo.GetAsOf(context.Background(), alice.UserID, afterUpdate)
+	if err != nil {
+		t.Fatalf("failed to get user as of its update: %v", err)
+	}
+
+	expectUser(t, got, update)
+
+	_, err = repo.GetAsOf(context.Background(), alice.UserID, afterDelete)
+	expectError(t, err, storage.ErrUserNotFound)
+
+	tests := []struct {
+		name     string
+		opts     storage.ListOptions
+		expected []*model.User
+	}{
+		{"before create", storage.ListOptions{AsOf: beforeCreate}, nil},
+		{"after create", storage.ListOptions{AsOf: afterCreate}, []*model.User{alice}},
+		{"filtered", storage.ListOptions{AsOf: afterCreate, Filter: storage.UserFilter{Department: "Sales"}}, nil},
+		{"after update", storage.ListOptions{AsOf: afterUpdate, Filter: storage.UserFilter{Department: "Sales"}}, []*model.User{update}},
+		{"after delete", storage.ListOptions{AsOf: afterDelete}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, total, err := repo.List(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatalf("failed to list users: %v", err)
+			}
+
+			if total != int64(len(tt.expected)) {
+				t.Errorf("expected total %d but got %d", len(tt.expected), total)
+			}
+
+			expectUsers(t, users, tt.expected)
+		})
+	}
+
+	trash, _, err := repo.List(context.Background(), storage.ListOptions{AsOf: afterDelete, Filter: storage.UserFilter{Deleted: true}})
+	if err != nil {
+		t.Fatalf("failed to list deleted users: %v", err)
+	}
+
+	if len(trash) != 1 || trash[0].UserID != alice.UserID {
+		t.Fatalf("expected the user to be in the trash after its deletion but got %+v", trash)
+	}
+
+	for _, expected := range []*model.User{alice, update} {
+		got, err := repo.GetVersion(context.Background(), alice.UserID, expected.Version)
+		if err != nil {
+			t.Fatalf("failed to get version %d: %v", expected.Version, err)
+		}
+
+		expectUser(t, got, expected)
+	}
+
+	_, err = repo.GetVersion(context.Background(), alice.UserID, update.Version+1)
+	expectError(t, err, storage.ErrVersionNotFound)
+
+	_, err = repo.GetVersion(context.Background(), -1, 1)
+	expectError(t, err, storage.ErrVersionNotFound)
+}
+
+func testEvents(t *testing.T, repo storage.UserRepository) {
+	outbox, ok := repo.(storage.Outbox)
+	if !ok {
+		t.Skip("the repository has no outbox")
+	}
+
+	ctx := storage.WithAuditInfo(context.Background(), storage.AuditInfo{RequestID: "req-1"})
+
+	alice := NewUser("alice")
+	if err := repo.Create(ctx, alice); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	update := NewUser("alice")
+	update.Status, update.Department = "I", "Sales"
+
+	if _, err := repo.Update(ctx, alice.UserID, update); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	if err := repo.Delete(ctx, alice.UserID, 0); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	events, err := outbox.ClaimEvents(context.Background(), "dispatcher", time.Now(), 10)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+
+	expected := []string{model.EventUserCreated, model.EventUserUpdated, model.EventUserStatusChanged, model.EventUserDeleted}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events but got %+v", len(expected), events)
+	}
+
+	for i, event := range events {
+		if event.Type != expected[i] {
+			t.Errorf("expected event %d to be %s but got %s", i, expected[i], event.Type)
+		}
+
+		if event.UserID != alice.UserID || event.User.UserID != alice.UserID || event.RequestID != "req-1" {
+			t.Errorf("expected event %d to be about user %d from req-1 but got %+v", i, alice.UserID, event)
+		}
+
+		if i > 0 && event.EventID <= events[i-1].EventID {
+			t.Errorf("expected events to be ordered by id but got %d after %d", event.EventID, events[i-1].EventID)
+		}
+	}
+
+	if events[2].PreviousStatus != "A" || events[2].User.Status != "I" {
+		t.Errorf("expected the status to change from A to I but got %+v", events[2])
+	}
+
+	if events[1].PreviousDepartment != "Engineering" || events[2].PreviousDepartment != "Engineering" || events[3].PreviousDepartment != "" {
+		t.Errorf("expected the update to move the user out of Engineering but got %+v", events[1:])
+	}
+
+	if events[3].User.DeletedAt == nil {
+		t.Errorf("expected the deleted event to hold the deletion time but got %+v", events[3])
+	}
+
+	first, err := outbox.ClaimEvents(context.Background(), "dispatcher", time.Now(), 2)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+
+	if len(first) != 2 || first[0].EventID != events[0].EventID {
+		t.Fatalf("expected the 2 oldest events but got %+v", first)
+	}
+
+	if err = outbox.AckEvents(context.Background(), first[0].EventID, first[1].EventID); err != nil {
+		t.Fatalf("failed to acknowledge events: %v", err)
+	}
+
+	rest, err := outbox.ClaimEvents(context.Background(), "dispatcher", time.Now(), 10)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+
+	if len(rest) != 2 || rest[0].EventID != events[2].EventID {
+		t.Fatalf("expected the events left to be pending but got %+v", rest)
+	}
+
+	if err = outbox.AckEvents(context.Background(), rest[0].EventID, rest[1].EventID); err != nil {
+		t.Fatalf("failed to acknowledge events: %v", err)
+	}
+
+	rest, err = outbox.ClaimEvents(context.Background(), "dispatcher", time.Now(), 10)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+
+	if len(rest) != 0 {
+		t.Fatalf("expected no pending event but got %+v", rest)
+	}
+}
+
+func testEventsLease(t *testing.T, repo storage.UserRepository) {
+	outbox, ok := repo.(storage.Outbox)
+	if !ok {
+		t.Skip("the repository has no outbox")
+	}
+
+	create(t, repo, NewUser("alice"))
+
+	now := time.Now()
+	claim := func(holder string, at time.Time) []model.Event {
+		t.Helper()
+
+		events, err := outbox.ClaimEvents(context.Background(), holder, at, 10)
+		if err != nil {
+			t.Fatalf("failed to claim events: %v", err)
+		}
+
+		return events
+	}
+
+	if events := claim("replica-1", now); len(events) != 1 {
+		t.Fatalf("expected the event to be claimed but got %+v", events)
+	}
+
+	if events := claim("replica-2", now.Add(time.Second)); len(events) != 0 {
+		t.Fatalf("expected no event while the lease is held by another replica but got %+v", events)
+	}
+
+	// renewed by its holder
+	if events := claim("replica-1", now.Add(storage.LeaseDuration/2)); len(events) != 1 {
+		t.Fatalf("expected the holder to claim the event again but got %+v", events)
+	}
+
+	if events := claim("replica-2", now.Add(storage.LeaseDuration)); len(events) != 0 {
+		t.Fatalf("expected no event while the renewed lease is held but got %+v", events)
+	}
+
+	if events := claim("replica-2", now.Add(2*storage.LeaseDuration)); len(events) != 1 {
+		t.Fatalf("expected the lease to be taken over once it ran out but got %+v", events)
+	}
+
+	if events := claim("replica-1", now.Add(2*storage.LeaseDuration)); len(events) != 0 {
+		t.Fatalf("expected the former holder to get no event but got %+v", events)
+	}
+}
+
+func testWebhooks(t *testing.T, repo storage.UserRepository) {
+	webhooks, ok := repo.(storage.WebhookRepository)
+	if !ok {
+		t.Skip("the repository doesn't store webhooks")
+	}
+
+	ctx := context.Background()
+
+	webhook := &model.Webhook{
+		URL:        "https://payroll.example.com/hooks",
+		Secret:     "0123456789abcdef",
+		EventTypes: []string{model.EventUserCreated, model.EventUserDeleted},
+		Department: "Engineering",
+	}
+
+	if err := webhooks.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	if webhook.WebhookID == 0 || webhook.CreatedAt.IsZero() {
+		t.Fatalf("expected the webhook to get an id and a creation time but got %+v", webhook)
+	}
+
+	got, err := webhooks.GetWebhook(ctx, webhook.WebhookID)
+	if err != nil {
+		t.Fatalf("failed to get webhook: %v", err)
+	}
+
+	if got.URL != webhook.URL || got.Secret != webhook.Secret || got.Department != webhook.Department ||
+		fmt.Sprint(got.EventTypes) != fmt.Sprint(webhook.EventTypes) {
+		t.Errorf("expected %+v but got %+v", webhook, got)
+	}
+
+	list, err := webhooks.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("failed to list webhooks: %v", err)
+	}
+
+	if len(list) != 1 || list[0].WebhookID != webhook.WebhookID {
+		t.Fatalf("expected the webhook to be listed but got %+v", list)
+	}
+
+	now := time.Now()
+	later := now.Add(time.Minute)
+
+	err = webhooks.EnqueueDeliveries(ctx,
+		model.Delivery{WebhookID: webhook.WebhookID, EventID: 1, EventType: model.EventUserCreated,
+			Payload: []byte(`{"id":1}`), NextAttemptAt: now},
+		model.Delivery{WebhookID: webhook.WebhookID, EventID: 2, EventType: model.EventUserDeleted,
+			Payload: []byte(`{"id":2}`), NextAttemptAt: later},
+	)
+	if err != nil {
+		t.Fatalf("failed to enqueue deliveries: %v", err)
+	}
+
+	// a redelivered event is queued once
+	err = webhooks.EnqueueDeliveries(ctx, model.Delivery{WebhookID: webhook.WebhookID, EventID: 1,
+		EventType: model.EventUserCreated, Payload: []byte(`{"id":1}`), NextAttemptAt: now})
+	if err != nil {
+		t.Fatalf("failed to enqueue deliveries: %v", err)
+	}
+
+	due, err := webhooks.ClaimDeliveries(ctx, now.Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("failed to get due deliveries: %v", err)
+	}
+
+	if len(due) != 1 || due[0].EventID != 1 || due[0].Status != model.DeliveryPending {
+		t.Fatalf("expected the first delivery to be due but got %+v", due)
+	}
+
+	// JSON columns don't keep the payload byte for byte
+	var payload map[string]int
+	if err = json.Unmarshal(due[0].Payload, &payload); err != nil || payload["id"] != 1 {
+		t.Errorf("expected the payload to be kept but got %s", due[0].Payload)
+	}
+
+	due[0].Status = model.DeliveryFailed
+	due[0].Attempts = 8
+	due[0].LastError = "webhook responded with status 500"
+
+	if err = webhooks.SaveDelivery(ctx, &due[0]); err != nil {
+		t.Fatalf("failed to save delivery: %v", err)
+	}
+
+	due, err = webhooks.ClaimDeliveries(ctx, later, 10)
+	if err != nil {
+		t.Fatalf("failed to get due deliveries: %v", err)
+	}
+
+	if len(due) != 1 || due[0].EventID != 2 {
+		t.Fatalf("expected the failed delivery not to be due anymore but got %+v", due)
+	}
+
+	failed, err := webhooks.ListDeliveries(ctx, webhook.WebhookID, model.DeliveryFailed)
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+
+	if len(failed) != 1 || failed[0].Attempts != 8 || failed[0].LastError == "" {
+		t.Fatalf("expected the failed delivery to be listed but got %+v", failed)
+	}
+
+	all, err := webhooks.ListDeliveries(ctx, webhook.WebhookID, "")
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+
+	if len(all) != 2 || all[0].EventID != 2 {
+		t.Fatalf("expected every delivery, newest first, but got %+v", all)
+	}
+
+	delivery, err := webhooks.GetDelivery(ctx, webhook.WebhookID, failed[0].DeliveryID)
+	if err != nil {
+		t.Fatalf("failed to get delivery: %v", err)
+	}
+
+	if delivery.Status != model.DeliveryFailed {
+		t.Errorf("expected the delivery to have failed but got %+v", delivery)
+	}
+
+	_, err = webhooks.GetDelivery(ctx, webhook.WebhookID+1, failed[0].DeliveryID)
+	expectError(t, err, storage.ErrDeliveryNotFound)
+
+	if err = webhooks.DeleteWebhook(ctx, webhook.WebhookID); err != nil {
+		t.Fatalf("failed to delete webhook: %v", err)
+	}
+
+	_, err = webhooks.GetWebhook(ctx, webhook.WebhookID)
+	expectError(t, err, storage.ErrWebhookNotFound)
+
+	_, err = webhooks.ListDeliveries(ctx, webhook.WebhookID, "")
+	expectError(t, err, storage.ErrWebhookNotFound)
+
+	err = webhooks.DeleteWebhook(ctx, webhook.WebhookID)
+	expectError(t, err, storage.ErrWebhookNotFound)
+
+	if due, _ = webhooks.ClaimDeliveries(ctx, later, 10); len(due) != 0 {
+		t.Errorf("expected the deliveries to be deleted along with the webhook but got %+v", due)
+	}
+}
+
+func testDeliveryClaims(t *testing.T, repo storage.UserRepository) {
+	webhooks, ok := repo.(storage.WebhookRepository)
+	if !ok {
+		t.Skip("the repository doesn't store webhooks")
+	}
+
+	ctx := context.Background()
+
+	webhook := &model.Webhook{URL: "https://payroll.example.com/hooks", Secret: "0123456789abcdef"}
+	if err := webhooks.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	now := time.Now()
+
+	for id := int64(1); id <= 3; id++ {
+		err := webhooks.EnqueueDeliveries(ctx, model.Delivery{WebhookID: webhook.WebhookID, EventID: id,
+			EventType: model.EventUserCreated, Payload: []byte(`{}`), NextAttemptAt: now})
+		if err != nil {
+			t.Fatalf("failed to enqueue deliveries: %v", err)
+		}
+	}
+
+	first, err := webhooks.ClaimDeliveries(ctx, now, 2)
+	if err != nil {
+		t.Fatalf("failed to claim deliveries: %v", err)
+	}
+
+	second, err := webhooks.ClaimDeliveries(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("failed to claim deliveries: %v", err)
+	}
+
+	if len(first) != 2 || len(second) != 1 || second[0].EventID != 3 {
+		t.Fatalf("expected the claims to split the deliveries but got %+v and %+v", first, second)
+	}
+
+	if again, _ := webhooks.ClaimDeliveries(ctx, now.Add(storage.ClaimDuration/2), 10); len(again) != 0 {
+		t.Fatalf("expected the claimed deliveries to be left alone but got %+v", again)
+	}
+
+	// a failed attempt is due again after its backoff rather than the claim
+	first[0].Attempts = 1
+	first[0].NextAttemptAt = now.Add(time.Minute)
+
+	if err = webhooks.SaveDelivery(ctx, &first[0]); err != nil {
+		t.Fatalf("failed to save delivery: %v", err)
+	}
+
+	if again, _ := webhooks.ClaimDeliveries(ctx, now.Add(2*time.Minute), 10); len(again) != 1 || again[0].EventID != first[0].EventID {
+		t.Fatalf("expected the failed delivery to be due again but got %+v", again)
+	}
+
+	// the claim of a replica that stopped runs out
+	if again, _ := webhooks.ClaimDeliveries(ctx, now.Add(storage.ClaimDuration+time.Minute), 10); len(again) != 2 {
+		t.Fatalf("expected the abandoned deliveries to be due again but got %+v", again)
+	}
+}
+
+func testBatch(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+	bob := create(t, repo, NewUser("bob"))
+
+	update := NewUser("alice")
+	update.Department = "Sales"
+
+	duplicate := NewUser("bob")
+	duplicate.Email = "bobby@example.com"
+
+	t.Run("best effort", func(t *testing.T) {
+		results, err := repo.Batch(context.Background(), []storage.BatchOp{
+			{Op: storage.OpCreate, User: NewUser("carol")},
+			{Op: storage.OpCreate, User: duplicate},
+			{Op: storage.OpCreate, User: NewUser("dave")},
+			{Op: storage.OpUpdate, ID: alice.UserID, Version: alice.Version, User: update},
+			{Op: storage.OpDelete, ID: bob.UserID, Version: bob.Version + 1},
+		}, storage.BatchOptions{})
+		if err != nil {
+			t.Fatalf("failed to apply batch: %v", err)
+		}
+
+		expected := []error{nil, storage.ErrAlreadyExist, nil, nil, storage.ErrVersionConflict}
+		for i, result := range results {
+			if !errors.Is(result.Err, expected[i]) {
+				t.Errorf("expected operation %d to fail with %v but got %v", i, expected[i], result.Err)
+			}
+		}
+
+		for _, i := range []int{0, 2} {
+			got, err := repo.Get(context.Background(), results[i].User.UserID)
+			if err != nil {
+				t.Fatalf("failed to get created user: %v", err)
+			}
+
+			expectUser(t, got, results[i].User)
+		}
+
+		if results[3].User.Department != "Sales" || results[3].User.Version != alice.Version+1 {
+			t.Errorf("expected alice to be moved to Sales at the next version but got %+v", results[3].User)
+		}
+
+		if _, err := repo.Get(context.Background(), bob.UserID); err != nil {
+			t.Errorf("expected bob not to be deleted but got %v", err)
+		}
+	})
+
+	t.Run("atomic", func(t *testing.T) {
+		_, before, err := repo.List(context.Background(), storage.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list users: %v", err)
+		}
+
+		audit, err := repo.ListAudit(context.Background(), storage.AuditFilter{})
+		if err != nil {
+			t.Fatalf("failed to list audit log: %v", err)
+		}
+
+		results, err := repo.Batch(context.Background(), []storage.BatchOp{
+			{Op: storage.OpCreate, User: NewUser("erin")},
+			{Op: storage.OpDelete, ID: bob.UserID},
+			{Op: storage.OpUpdate, ID: bob.UserID + 100, User: NewUser("frank")},
+			{Op: storage.OpCreate, User: NewUser("grace")},
+		}, storage.BatchOptions{Atomic: true})
+		if err != nil {
+			t.Fatalf("failed to apply batch: %v", err)
+		}
+
+		expected := []error{storage.ErrBatchAborted, storage.ErrBatchAborted, storage.ErrUserNotFound, storage.ErrBatchAborted}
+		for i, result := range results {
+			if !errors.Is(result.Err, expected[i]) || result.User != nil {
+				t.Errorf("expected operation %d to fail with %v but got %+v", i, expected[i], result)
+			}
+		}
+
+		_, after, err := repo.List(context.Background(), storage.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list users: %v", err)
+		}
+
+		if after != before {
+			t.Errorf("expected %d users after the rollback but got %d", before, after)
+		}
+
+		if _, err := repo.Get(context.Background(), bob.UserID); err != nil {
+			t.Errorf("expected bob not to be deleted but got %v", err)
+		}
+
+		_, err = repo.GetByUserName(context.Background(), "erin")
+		expectError(t, err, storage.ErrUserNotFound)
+
+		rolledBack, err := repo.ListAudit(context.Background(), storage.AuditFilter{})
+		if err != nil {
+			t.Fatalf("failed to list audit log: %v", err)
+		}
+
+		if len(rolledBack) != len(audit) {
+			t.Errorf("expected %d audit entries after the rollback but got %d", len(audit), len(rolledBack))
+		}
+
+		created := create(t, repo, NewUser("erin"))
+		if created.UserID <= bob.UserID {
+			t.Errorf("expected a new user id after %d but got %d", bob.UserID, created.UserID)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		audit, err := repo.ListAudit(context.Background(), storage.AuditFilter{})
+		if err != nil {
+			t.Fatalf("failed to list audit log: %v", err)
+		}
+
+		results, err := repo.Batch(context.Background(), []storage.BatchOp{
+			{Op: storage.OpCreate, User: NewUser("judy")},
+			{Op: storage.OpCreate, User: duplicate},
+			{Op: storage.OpDelete, ID: bob.UserID},
+		}, storage.BatchOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("failed to apply batch: %v", err)
+		}
+
+		expected := []error{nil, storage.ErrAlreadyExist, nil}
+		for i, result := range results {
+			if !errors.Is(result.Err, expected[i]) {
+				t.Errorf("expected operation %d to fail with %v but got %v", i, expected[i], result.Err)
+			}
+		}
+
+		_, err = repo.GetByUserName(context.Background(), "judy")
+		expectError(t, err, storage.ErrUserNotFound)
+
+		if _, err := repo.Get(context.Background(), bob.UserID); err != nil {
+			t.Errorf("expected bob not to be deleted but got %v", err)
+		}
+
+		kept, err := repo.ListAudit(context.Background(), storage.AuditFilter{})
+		if err != nil {
+			t.Fatalf("failed to list audit log: %v", err)
+		}
+
+		if len(kept) != len(audit) {
+			t.Errorf("expected %d audit entries after the dry run but got %d", len(audit), len(kept))
+		}
+	})
+
+	t.Run("atomic commit", func(t *testing.T) {
+		results, err := repo.Batch(context.Background(), []storage.BatchOp{
+			{Op: storage.OpCreate, User: NewUser("heidi")},
+			{Op: storage.OpCreate, User: NewUser("ivan")},
+			{Op: storage.OpDelete, ID: bob.UserID},
+		}, storage.BatchOptions{Atomic: true})
+		if err != nil {
+			t.Fatalf("failed to apply batch: %v", err)
+		}
+
+		for i, result := range results {
+			if result.Err != nil || result.User == nil {
+				t.Errorf("expected operation %d to succeed but got %+v", i, result)
+			}
+		}
+
+		if results[2].User.DeletedAt == nil {
+			t.Errorf("expected the deleted user to hold the deletion time but got %+v", results[2].User)
+		}
+
+		_, err = repo.Get(context.Background(), bob.UserID)
+		expectError(t, err, storage.ErrUserNotFound)
+
+		for _, name := range []string{"heidi", "ivan"} {
+			if _, err := repo.GetByUserName(context.Background(), name); err != nil {
+				t.Errorf("expected %s to be created but got %v", name, err)
+			}
+		}
+	})
+}
+
+func testList(t *testing.T, repo storage.UserRepository) {
+	alice := NewUser("alice")
+	alice.LastName, alice.Email = "Clark", "alice@corp.example.com"
+	bob := NewUser("bob")
+	bob.LastName, bob.Status = "Anderson", "I"
+	carol := NewUser("carol")
+	carol.LastName, carol.Department = "Brown", "Sales"
+
+	for _, user := range []*model.User{alice, bob, carol} {
+		create(t, repo, user)
+	}
+
+	tests := []struct {
+		name     string
+		opts     storage.ListOptions
+		expected []*model.User
+		total    int64
+	}{
+		{"all", storage.ListOptions{}, []*model.User{alice, bob, carol}, 3},
+		{"status", storage.ListOptions{Filter: storage.UserFilter{Status: "I"}}, []*model.User{bob}, 1},
+		{"department", storage.ListOptions{Filter: storage.UserFilter{Department: "Sales"}}, []*model.User{carol}, 1},
+		{"email domain", storage.ListOptions{Filter: storage.UserFilter{EmailDomain: "EXAMPLE.com"}}, []*model.User{bob, carol}, 2},
+		{"not status", storage.ListOptions{Filter: storage.UserFilter{NotStatus: "I"}}, []*model.User{alice, carol}, 2},
+		{"user name", storage.ListOptions{Filter: storage.UserFilter{UserName: "BOB"}}, []*model.User{bob}, 1},
+		{"last name", storage.ListOptions{Filter: storage.UserFilter{LastName: "brown"}}, []*model.User{carol}, 1},
+		{"email", storage.ListOptions{Filter: storage.UserFilter{Email: "Alice@Corp.Example.com", FirstName: alice.FirstName}}, []*model.User{alice}, 1},
+		{"no match", storage.ListOptions{Filter: storage.UserFilter{UserName: "alice", LastName: "Brown"}}, nil, 0},
+		{"sort", storage.ListOptions{Sort: []storage.SortField{{Column: "last_name"}}}, []*model.User{bob, carol, alice}, 3},
+		{"sort desc", storage.ListOptions{Sort: []storage.SortField{{Column: "user_id", Desc: true}}}, []*model.User{carol, bob, alice}, 3},
+		{"limit", storage.ListOptions{Limit: 2}, []*model.User{alice, bob}, 3},
+		{"offset", storage.ListOptions{Limit: 2, Offset: 2}, []*model.User{carol}, 3},
+		{"past the end", storage.ListOptions{Offset: 5}, nil, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, total, err := repo.List(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatalf("failed to list users: %v", err)
+			}
+
+			if total != tt.total {
+				t.Errorf("expected total %d but got %d", tt.total, total)
+			}
+
+			if users == nil {
+				t.Errorf("expected an empty list rather than nil")
+			}
+
+			expectUsers(t, users, tt.expected)
+		})
+	}
+}
+
+func testListCursor(t *testing.T, repo storage.UserRepository) {
+	var users []*model.User
+
+	for i, lastName := range []string{"Brown", "Anderson", "Brown", "Clark", "Anderson"} {
+		user := NewUser(fmt.Sprintf("user%d", i))
+		user.LastName = lastName
+		users = append(users, create(t, repo, user))
+	}
+
+	tests := []struct {
+		name     string
+		key      storage.SortField
+		expected []*model.User
+	}{
+		{"user_id", storage.SortField{Column: "user_id"}, users},
+		{"last_name", storage.SortField{Column: "last_name"}, []*model.User{users[1], users[4], users[0], users[2], users[3]}},
+		{"last_name desc", storage.SortField{Column: "last_name", Desc: true}, []*model.User{users[3], users[2], users[0], users[4], users[1]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				walked []model.User
+				after  *storage.Cursor
+			)
+
+			for page := 0; page < len(users); page++ {
+				opts := storage.ListOptions{Sort: []storage.SortField{tt.key}, Limit: 2, After: after}
+
+				got, _, err := repo.List(context.Background(), opts)
+				if err != nil {
+					t.Fatalf("failed to list users: %v", err)
+				}
+
+				if len(got) == 0 {
+					break
+				}
+
+				walked = append(walked, got...)
+				cursor := storage.NewCursor(tt.key, got[len(got)-1])
+				after = &cursor
+			}
+
+			expectUsers(t, walked, tt.expected)
+		})
+	}
+
+	t.Run("unknown sort key", func(t *testing.T) {
+		_, _, err := repo.List(context.Background(), storage.ListOptions{
+			After: &storage.Cursor{SortKey: "password", UserID: users[0].UserID},
+		})
+		expectError(t, err, storage.ErrInvalidCursor)
+	})
+}
+
+func testEach(t *testing.T, repo storage.UserRepository) {
+	alice := NewUser("alice")
+	alice.LastName = "Clark"
+	bob := NewUser("bob")
+	bob.LastName = "Anderson"
+	carol := NewUser("carol")
+	carol.Department = "Sales"
+
+	for _, user := range []*model.User{alice, bob, carol} {
+		create(t, repo, user)
+	}
+
+	opts := storage.ListOptions{
+		Filter: storage.UserFilter{Department: "Engineering"},
+		Sort:   []storage.SortField{{Column: "last_name"}},
+	}
+
+	var got []model.User
+
+	err := repo.Each(context.Background(), opts, func(user model.User) error {
+		got = append(got, user)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk users: %v", err)
+	}
+
+	expectUsers(t, got, []*model.User{bob, alice})
+
+	stop := errors.New("stop")
+	calls := 0
+
+	err = repo.Each(context.Background(), storage.ListOptions{}, func(model.User) error {
+		calls++
+
+		return stop
+	})
+	expectError(t, err, stop)
+
+	if calls != 1 {
+		t.Errorf("expected the walk to stop at the first error but got %d calls", calls)
+	}
+}
+
+func testConcurrentCreate(t *testing.T, repo storage.UserRepository) {
+	const workers = 8
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+		errs    []error
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			user := NewUser("alice")
+			user.Email = fmt.Sprintf("alice%d@example.com", i)
+
+			err := repo.Create(context.Background(), user)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err == nil {
+				created++
+			} else {
+				errs = append(errs, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("expected exactly one user to be created but got %d", created)
+	}
+
+	for _, err := range errs {
+		expectError(t, err, storage.ErrAlreadyExist)
+	}
+
+	ids := map[int64]bool{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			user := NewUser(fmt.Sprintf("worker%d", i))
+			if err := repo.Create(context.Background(), user); err != nil {
+				t.Errorf("failed to create user: %v", err)
+
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			ids[user.UserID] = true
+		}()
+	}
+
+	wg.Wait()
+
+	if len(ids) != workers {
+		t.Fatalf("expected %d distinct ids but got %d", workers, len(ids))
+	}
+}
+
+func testCanceledContext(t *testing.T, repo storage.UserRepository) {
+	alice := create(t, repo, NewUser("alice"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := repo.List(ctx, storage.ListOptions{})
+	expectError(t, err, context.Canceled)
+
+	_, err = repo.Get(ctx, alice.UserID)
+	expectError(t, err, context.Canceled)
+
+	_, err = repo.GetByUserName(ctx, alice.UserName)
+	expectError(t, err, context.Canceled)
+
+	expectError(t, repo.Create(ctx, NewUser("bob")), context.Canceled)
+	_, err = repo.Update(ctx, alice.UserID, NewUser("carol"))
+	expectError(t, err, context.Canceled)
+	expectError(t, repo.Delete(ctx, alice.UserID, 0), context.Canceled)
+
+	_, err = repo.Patch(ctx, alice.UserID, 0, func(*model.User) error { return nil })
+	expectError(t, err, context.Canceled)
+
+	_, err = repo.Restore(ctx, alice.UserID)
+	expectError(t, err, context.Canceled)
+
+	_, err = repo.Purge(ctx, time.Now())
+	expectError(t, err, context.Canceled)
+
+	got, err := repo.Get(context.Background(), alice.UserID)
+	if err != nil {
+		t.Fatalf("expected user to be untouched but got %v", err)
+	}
+
+	expectUser(t, got, alice)
+}
+
+func expectUsers(t *testing.T, got []model.User, expected []*model.User) {
+	t.Helper()
+
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d users but got %d: %+v", len(expected), len(got), got)
+	}
+
+	for i := range got {
+		if got[i] != *expected[i] {
+			t.Fatalf("expected user %+v at %d but got %+v", *expected[i], i, got[i])
+		}
+	}
+}
@@ -6,98 +6,255 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/andrii-stp/users-crud/model"
 )
 
 type UserRepository interface {
-	List(ctx context.Context) ([]model.User, error)
+	List(ctx context.Context, opts ListOptions) ([]model.User, int64, error)
+	// Each calls fn with every user listed with opts without holding them all
+	Each(ctx context.Context, opts ListOptions, fn func(model.User) error) error
+	Get(ctx context.Context, id int64) (*model.User, error)
+	GetByUserName(ctx context.Context, username string) (*model.User, error)
 	Create(ctx context.Context, user *model.User) error
-	Update(ctx context.Context, id int64, user *model.User) error
-	Delete(ctx context.Context, id int64) error
+	// Update replaces the user with id and bumps its version, it returns the
+	// user as it was before. A non-zero user.Version must match the stored
+	// one or ErrVersionConflict is returned.
+	Update(ctx context.Context, id int64, user *model.User) (*model.User, error)
+	// Patch hands the user with id to patch and writes back the fields it
+	// changed, within a single transaction. A non-zero version must match the
+	// stored one, an error returned by patch is passed through untouched.
+	Patch(ctx context.Context, id int64, version int64, patch func(user *model.User) error) (*model.User, error)
+	// Delete moves the user to the trash, its username and email stay
+	// reserved until it's purged. A non-zero version must match the stored one.
+	Delete(ctx context.Context, id int64, version int64) error
+	// Restore brings a user back from the trash
+	Restore(ctx context.Context, id int64) (*model.User, error)
+	// Purge permanently removes the users moved to the trash before deletedBefore
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// Erase deletes the user with id and purges it at once, within a single
+	// transaction, and returns it as deleted. Its username and email are free
+	// again. A non-zero version must match the stored one.
+	Erase(ctx context.Context, id int64, version int64) (*model.User, error)
+	// GetAsOf returns the user with id as it was at asOf
+	GetAsOf(ctx context.Context, id int64, asOf time.Time) (*model.User, error)
+	// GetVersion returns the fields the user with id had at version, or
+	// ErrVersionNotFound
+	GetVersion(ctx context.Context, id int64, version int64) (*model.User, error)
+	// ListAudit returns the audit log entries recorded along with every change
+	ListAudit(ctx context.Context, filter AuditFilter) ([]model.AuditEntry, error)
+	// Batch applies ops in order and returns the outcome of each one. An
+	// atomic batch runs in a single transaction and stops at the first
+	// operation that fails, otherwise every operation is attempted on its own.
+	Batch(ctx context.Context, ops []BatchOp, opts BatchOptions) ([]BatchResult, error)
 }
 
-type PostgresUserRepository struct {
-	logger *slog.Logger
-	db     *sql.DB
+// sqlUserRepository implements UserRepository on top of a SQL database, the
+// dialect holds what differs between the supported databases.
+type sqlUserRepository struct {
+	logger  *slog.Logger
+	db      *sql.DB
+	dialect dialect
+	// reads is the pool the reads outside a transaction go through, when
+	// the dialect opens one apart from db
+	reads *sql.DB
 }
 
+// The errors are declared by model, so that clients of the API can check for
+// them without depending on storage
 var (
-	_ UserRepository = (*PostgresUserRepository)(nil)
-
-	ErrAlreadyExist = errors.New("username already in use")
-	ErrUserNotFound = errors.New("user don't exist")
+	ErrAlreadyExist = model.ErrAlreadyExist
+	ErrEmailInUse   = model.ErrEmailInUse
+	ErrUserNotFound = model.ErrUserNotFound
+	// ErrVersionConflict means the user changed since the expected version was read
+	ErrVersionConflict = model.ErrVersionConflict
 )
 
-func NewPostgresRepository(logger *slog.Logger, db *sql.DB) *PostgresUserRepository {
-	return &PostgresUserRepository{
-		logger: logger,
-		db:     db,
+// userColumns are the users columns in the order scanUser reads them
+var userColumns = []string{"user_id", "user_name", "first_name", "last_name", "email", "user_status", "department", "version", "deleted_at"}
+
+// reader returns the pool to read through outside a transaction
+func (r sqlUserRepository) reader() *sql.DB {
+	if r.reads != nil {
+		return r.reads
 	}
+
+	return r.db
+}
+
+func (r sqlUserRepository) builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(r.dialect.placeholder)
 }
 
-func (ps PostgresUserRepository) List(ctx context.Context) ([]model.User, error) {
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+func (r sqlUserRepository) List(ctx context.Context, opts ListOptions) ([]model.User, int64, error) {
+	where := opts.Filter.where()
+
+	var total int64
+
+	if opts.After == nil {
+		err := r.selectUsers(opts.AsOf, "COUNT(*)").Where(where).RunWith(r.reader()).QueryRowContext(ctx).Scan(&total)
+		if err != nil {
+			r.logger.Error("Failed to execute count query",
+				slog.String("err", err.Error()))
+
+			return nil, 0, err
+		}
+	}
 
-	rows, err := psql.Select("*").From("users").RunWith(ps.db).QueryContext(ctx)
+	query, err := r.listQuery(opts)
 	if err != nil {
-		ps.logger.Error("Failed to execute select query",
+		return nil, 0, err
+	}
+
+	rows, err := query.RunWith(r.reader()).QueryContext(ctx)
+	if err != nil {
+		r.logger.Error("Failed to execute select query",
 			slog.String("err", err.Error()))
 
-		return nil, err
+		return nil, 0, err
 	}
 
 	defer rows.Close()
 
-	var users []model.User
+	users := []model.User{}
 
 	for rows.Next() {
 		var user model.User
-		if err := rows.Scan(&user.UserID, &user.UserName, &user.FirstName, &user.LastName,
-			&user.Email, &user.Status, &user.Department); err != nil {
-			return nil, fmt.Errorf("failed to scan select data: %w", err)
+		if err := rows.Scan(userFields(&user)...); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan select data: %w", err)
 		}
 
 		users = append(users, user)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// listQuery selects the users listed with opts
+func (r sqlUserRepository) listQuery(opts ListOptions) (sq.SelectBuilder, error) {
+	query := r.selectUsers(opts.AsOf, userColumns...).Where(opts.Filter.where())
+
+	if opts.After != nil {
+		after, err := opts.After.where()
+		if err != nil {
+			return query, err
+		}
+
+		query = query.Where(after).OrderBy(orderBy(opts.After.Sort())...)
+	} else {
+		query = query.OrderBy(orderBy(opts.Sort)...)
+	}
+
+	if opts.Limit > 0 {
+		query = query.Limit(uint64(opts.Limit))
+	}
+
+	if opts.Offset > 0 && opts.After == nil {
+		// some databases don't accept an OFFSET without a LIMIT
+		if opts.Limit <= 0 {
+			query = query.Limit(math.MaxInt64)
+		}
+
+		query = query.Offset(uint64(opts.Offset))
 	}
 
-	return users, nil
+	return query, nil
 }
 
-func (ps PostgresUserRepository) Create(ctx context.Context, user *model.User) error {
-	tx, err := ps.db.BeginTx(ctx, nil)
+// Each calls fn with every user listed with opts, one at a time as they're
+// read from the database. It stops at the first error fn returns.
+func (r sqlUserRepository) Each(ctx context.Context, opts ListOptions, fn func(model.User) error) error {
+	query, err := r.listQuery(opts)
 	if err != nil {
 		return err
 	}
 
-	defer tx.Rollback()
+	rows, err := query.RunWith(r.reader()).QueryContext(ctx)
+	if err != nil {
+		r.logger.Error("Failed to execute select query",
+			slog.String("err", err.Error()))
 
-	exist, err := getByID(ctx, tx, user.UserID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return err
 	}
 
-	if exist != nil {
-		return ErrAlreadyExist
+	defer rows.Close()
+
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(userFields(&user)...); err != nil {
+			return fmt.Errorf("failed to scan select data: %w", err)
+		}
+
+		if err := fn(user); err != nil {
+			return err
+		}
 	}
 
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	return rows.Err()
+}
 
-	err = psql.Insert("users").
-		Columns("user_name", "first_name", "last_name", "email", "user_status", "department").
-		Values(user.UserName, user.FirstName, user.LastName, user.Email, user.Status, user.Department).
-		Suffix("RETURNING *").RunWith(tx).QueryRowContext(ctx).
-		Scan(&user.UserID, &user.UserName, &user.FirstName, &user.LastName, &user.Email, &user.Status, &user.Department)
+func (r sqlUserRepository) Get(ctx context.Context, id int64) (*model.User, error) {
+	user, err := r.getByID(ctx, r.reader(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r sqlUserRepository) GetByUserName(ctx context.Context, username string) (*model.User, error) {
+	user, err := r.getByUserName(ctx, r.reader(), username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r sqlUserRepository) Create(ctx context.Context, user *model.User) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	defer tx.Rollback()
+
+	builder := r.builder()
+	insert := builder.Insert("users").
+		Columns("user_name", "first_name", "last_name", "email", "user_status", "department", "valid_from").
+		Values(user.UserName, user.FirstName, user.LastName, user.Email, user.Status, user.Department, time.Now().UTC())
+
+	if r.dialect.returning {
+		err = insert.Suffix("RETURNING " + strings.Join(userColumns, ", ")).RunWith(tx).QueryRowContext(ctx).
+			Scan(userFields(user)...)
+	} else {
+		err = r.insertAndRead(ctx, tx, insert, user)
+	}
+
+	if err != nil {
+		return r.dialect.mapError(err)
+	}
+
+	if err = r.record(ctx, tx, ActionCreate, nil, user); err != nil {
+		return err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return err
 	}
@@ -105,39 +262,56 @@ func (ps PostgresUserRepository) Create(ctx context.Context, user *model.User) e
 	return nil
 }
 
-func (ps PostgresUserRepository) Update(ctx context.Context, id int64, user *model.User) error {
-	tx, err := ps.db.BeginTx(ctx, nil)
+func (r sqlUserRepository) Update(ctx context.Context, id int64, user *model.User) (*model.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("update user transaction failed: %w", err)
+		return nil, fmt.Errorf("update user transaction failed: %w", err)
 	}
 
 	defer tx.Rollback()
 
-	// get existing user by id
-	var targeted *model.User
+	previous, err := r.update(ctx, tx, id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
 
-	targeted, err = getByID(ctx, tx, id)
+	return previous, nil
+}
+
+// update replaces the user with id within tx and returns it as it was before
+func (r sqlUserRepository) update(ctx context.Context, tx *sql.Tx, id int64, user *model.User) (*model.User, error) {
+	// get existing user by id
+	targeted, err := r.getByID(ctx, tx, id)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
+		return nil, err
 	}
 
 	if targeted == nil {
-		return ErrUserNotFound
+		return nil, ErrUserNotFound
 	}
 
-	// check requested username if it's alredy in use
-	exist, err := getByUserName(ctx, tx, user.UserName)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
+	if user.Version != 0 && user.Version != targeted.Version {
+		return nil, ErrVersionConflict
 	}
 
-	if exist != nil && exist.UserID != targeted.UserID {
-		return ErrAlreadyExist
+	// compare-and-swap, a concurrent update since targeted was read leaves
+	// no row to update
+	where := sq.Eq{"user_id": id, "deleted_at": nil}
+	if user.Version != 0 {
+		where["version"] = user.Version
 	}
 
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	now := time.Now().UTC()
+	if err = r.archive(ctx, tx, id, now); err != nil {
+		return nil, err
+	}
 
-	err = psql.Update("users").SetMap(
+	builder := r.builder()
+	update := builder.Update("users").SetMap(
 		sq.Eq{
 			"user_name":   user.UserName,
 			"first_name":  user.FirstName,
@@ -145,62 +319,377 @@ func (ps PostgresUserRepository) Update(ctx context.Context, id int64, user *mod
 			"email":       user.Email,
 			"user_status": user.Status,
 			"department":  user.Department,
-		}).Suffix("RETURNING *").RunWith(tx).QueryRowContext(ctx).
-		Scan(&user.UserID, &user.UserName, &user.FirstName, &user.LastName, &user.Email, &user.Status, &user.Department)
+			"version":     sq.Expr("version + 1"),
+			"valid_from":  now,
+		}).Where(where)
+
+	if r.dialect.returning {
+		err = update.Suffix("RETURNING " + strings.Join(userColumns, ", ")).RunWith(tx).QueryRowContext(ctx).
+			Scan(userFields(user)...)
+	} else {
+		err = r.updateAndRead(ctx, tx, update, id, user)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVersionConflict
+	}
 
 	if err != nil {
-		return err
+		return nil, r.dialect.mapError(err)
+	}
+
+	if err = r.record(ctx, tx, ActionUpdate, targeted, user); err != nil {
+		return nil, err
+	}
+
+	return targeted, nil
+}
+
+func (r sqlUserRepository) Patch(ctx context.Context, id int64, version int64, patch func(user *model.User) error) (*model.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("patch user transaction failed: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	targeted, err := r.getByID(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 0 && version != targeted.Version {
+		return nil, ErrVersionConflict
+	}
+
+	user := *targeted
+	if err = patch(&user); err != nil {
+		return nil, err
+	}
+
+	changes := changedColumns(*targeted, user)
+	if len(changes) == 0 {
+		return targeted, nil
+	}
+
+	now := time.Now().UTC()
+	if err = r.archive(ctx, tx, id, now); err != nil {
+		return nil, err
+	}
+
+	// compare-and-swap against the version the patch was applied to
+	changes["version"], changes["valid_from"] = sq.Expr("version + 1"), now
+	update := r.builder().Update("users").SetMap(changes).
+		Where(sq.Eq{"user_id": id, "deleted_at": nil, "version": targeted.Version})
+
+	if r.dialect.returning {
+		err = update.Suffix("RETURNING " + strings.Join(userColumns, ", ")).RunWith(tx).QueryRowContext(ctx).
+			Scan(userFields(&user)...)
+	} else {
+		err = r.updateAndRead(ctx, tx, update, id, &user)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVersionConflict
+	}
+
+	if err != nil {
+		return nil, r.dialect.mapError(err)
+	}
+
+	if err = r.record(ctx, tx, ActionUpdate, targeted, &user); err != nil {
+		return nil, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &user, nil
 }
 
-func (ps PostgresUserRepository) Delete(ctx context.Context, id int64) error {
-	tx, err := ps.db.BeginTx(ctx, nil)
+func (r sqlUserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	defer tx.Rollback()
 
-	// get by username targeted user
-	var exist *model.User
+	if _, err = r.delete(ctx, tx, id, version); err != nil {
+		return err
+	}
 
-	exist, err = getByID(ctx, tx, id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+	return tx.Commit()
+}
+
+// delete moves the user with id to the trash within tx and returns it as deleted
+func (r sqlUserRepository) delete(ctx context.Context, tx *sql.Tx, id int64, version int64) (*model.User, error) {
+	targeted, err := r.getByID(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 0 && version != targeted.Version {
+		return nil, ErrVersionConflict
+	}
+
+	deleted := *targeted
+	deletedAt := time.Now().UTC()
+	deleted.DeletedAt = &deletedAt
+
+	if err = r.archive(ctx, tx, id, deletedAt); err != nil {
+		return nil, err
+	}
+
+	builder := r.builder()
+
+	res, err := builder.Update("users").Set("deleted_at", deletedAt).Set("valid_from", deletedAt).
+		Where(sq.Eq{"user_id": id, "deleted_at": nil, "version": targeted.Version}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user changed since it was read
+	if err = expectAffected(res, ErrVersionConflict); err != nil {
+		return nil, err
+	}
+
+	if err = r.record(ctx, tx, ActionDelete, targeted, &deleted); err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+func (r sqlUserRepository) Restore(ctx context.Context, id int64) (*model.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	deleted, err := r.getUser(ctx, tx, sq.And{sq.Eq{"user_id": id}, sq.NotEq{"deleted_at": nil}})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err = r.archive(ctx, tx, id, now); err != nil {
+		return nil, err
+	}
+
+	builder := r.builder()
+
+	res, err := builder.Update("users").Set("deleted_at", nil).Set("valid_from", now).
+		Where(sq.And{sq.Eq{"user_id": id}, sq.NotEq{"deleted_at": nil}}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = expectAffected(res, ErrUserNotFound); err != nil {
+		return nil, err
+	}
+
+	user, err := r.getByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.record(ctx, tx, ActionRestore, deleted, user); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r sqlUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return r.purge(ctx, sq.Lt{"deleted_at": deletedBefore.UTC()})
+}
+
+func (r sqlUserRepository) Erase(ctx context.Context, id int64, version int64) (*model.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	deleted, err := r.delete(ctx, tx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = r.purgeTx(ctx, tx, sq.Eq{"user_id": id}); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// purge permanently removes the trashed users matching where, along with
+// their history
+func (r sqlUserRepository) purge(ctx context.Context, where sq.Sqlizer) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	purged, err := r.purgeTx(ctx, tx, where)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// purgeTx is purge within tx
+func (r sqlUserRepository) purgeTx(ctx context.Context, tx *sql.Tx, where sq.Sqlizer) (int64, error) {
+	builder := r.builder()
+
+	rows, err := builder.Select(userColumns...).From("users").Where(where).RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var users []model.User
+
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(userFields(&user)...); err != nil {
+			rows.Close()
+
+			return 0, fmt.Errorf("failed to scan purged users: %w", err)
+		}
+
+		users = append(users, user)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	changes := make([]userChange, 0, len(users))
+	for i := range users {
+		changes = append(changes, userChange{before: &users[i]})
+	}
+
+	if err = r.recordAll(ctx, tx, ActionPurge, changes); err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.UserID)
+	}
+
+	if _, err = builder.Delete("users_history").Where(sq.Eq{"user_id": ids}).RunWith(tx).ExecContext(ctx); err != nil {
+		return 0, err
+	}
+
+	res, err := builder.Delete("users").Where(sq.Eq{"user_id": ids}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// insertAndRead runs insert and reads the new row back by the id the
+// database generated for it, for dialects without RETURNING.
+func (r sqlUserRepository) insertAndRead(ctx context.Context, tx *sql.Tx, insert sq.InsertBuilder, user *model.User) error {
+	res, err := insert.RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
 		return err
 	}
 
-	if exist == nil {
-		return ErrUserNotFound
+	created, err := r.getByID(ctx, tx, id)
+	if err != nil {
+		return err
 	}
 
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	*user = *created
+
+	return nil
+}
 
-	_, err = psql.Delete("users").Where(sq.Eq{"user_id": id}).RunWith(tx).ExecContext(ctx)
+// updateAndRead runs update and reads the row with id back, for dialects
+// without RETURNING. Like a RETURNING query, it fails with sql.ErrNoRows when
+// update didn't match any row.
+func (r sqlUserRepository) updateAndRead(ctx context.Context, tx *sql.Tx, update sq.UpdateBuilder, id int64, user *model.User) error {
+	res, err := update.RunWith(tx).ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
+	affected, err := res.RowsAffected()
+	if err != nil {
 		return err
 	}
 
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	updated, err := r.getByID(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	*user = *updated
+
 	return nil
 }
 
-func getByID(ctx context.Context, tx *sql.Tx, id int64) (*model.User, error) {
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+func (r sqlUserRepository) getByID(ctx context.Context, runner sq.BaseRunner, id int64) (*model.User, error) {
+	return r.getUser(ctx, runner, sq.Eq{"user_id": id, "deleted_at": nil})
+}
+
+// getUser returns the single user matching where, deleted or not
+func (r sqlUserRepository) getUser(ctx context.Context, runner sq.BaseRunner, where sq.Sqlizer) (*model.User, error) {
+	builder := r.builder()
 
 	var user model.User
-	err := psql.Select("*").From("users").Where(sq.Eq{"user_id": id}).
-		RunWith(tx).QueryRowContext(ctx).
-		Scan(&user.UserID, &user.UserName, &user.FirstName, &user.LastName,
-			&user.Email, &user.Status, &user.Department)
+	err := builder.Select(userColumns...).From("users").Where(where).
+		RunWith(runner).QueryRowContext(ctx).Scan(userFields(&user)...)
 
 	if err != nil {
 		return nil, err
@@ -209,14 +698,12 @@ func getByID(ctx context.Context, tx *sql.Tx, id int64) (*model.User, error) {
 	return &user, nil
 }
 
-func getByUserName(ctx context.Context, tx *sql.Tx, username string) (*model.User, error) {
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+func (r sqlUserRepository) getByUserName(ctx context.Context, runner sq.BaseRunner, username string) (*model.User, error) {
+	builder := r.builder()
 
 	var user model.User
-	err := psql.Select("*").From("users").Where(sq.Eq{"user_name": username}).
-		RunWith(tx).QueryRowContext(ctx).
-		Scan(&user.UserID, &user.UserName, &user.FirstName, &user.LastName,
-			&user.Email, &user.Status, &user.Department)
+	err := builder.Select(userColumns...).From("users").Where("LOWER(user_name) = LOWER(?)", username).Where(sq.Eq{"deleted_at": nil}).
+		RunWith(runner).QueryRowContext(ctx).Scan(userFields(&user)...)
 
 	if err != nil {
 		return nil, err
@@ -224,3 +711,44 @@ func getByUserName(ctx context.Context, tx *sql.Tx, username string) (*model.Use
 
 	return &user, nil
 }
+
+// changedColumns returns the writable columns whose value differs between
+// before and after
+func changedColumns(before, after model.User) sq.Eq {
+	changes := sq.Eq{}
+
+	for column, values := range map[string][2]string{
+		"user_name":   {before.UserName, after.UserName},
+		"first_name":  {before.FirstName, after.FirstName},
+		"last_name":   {before.LastName, after.LastName},
+		"email":       {before.Email, after.Email},
+		"user_status": {before.Status, after.Status},
+		"department":  {before.Department, after.Department},
+	} {
+		if values[0] != values[1] {
+			changes[column] = values[1]
+		}
+	}
+
+	return changes
+}
+
+// userFields returns the destinations scanning userColumns into user
+func userFields(user *model.User) []any {
+	return []any{&user.UserID, &user.UserName, &user.FirstName, &user.LastName,
+		&user.Email, &user.Status, &user.Department, &user.Version, &user.DeletedAt}
+}
+
+// expectAffected returns notFound when res didn't touch any row
+func expectAffected(res sql.Result, notFound error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
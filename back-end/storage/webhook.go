@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+// WebhookRepository stores the webhook subscriptions and the deliveries
+// made to them
+type WebhookRepository interface {
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (*model.Webhook, error)
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+	// DeleteWebhook removes the webhook with id along with its deliveries
+	DeleteWebhook(ctx context.Context, id int64) error
+	// EnqueueDeliveries stores new deliveries, those already queued for the
+	// same webhook and event are skipped so a redelivered event is sent once
+	EnqueueDeliveries(ctx context.Context, deliveries ...model.Delivery) error
+	// ClaimDeliveries claims the pending deliveries to attempt at now, oldest
+	// first. They aren't due again for ClaimDuration, so that other replicas
+	// don't attempt them meanwhile.
+	ClaimDeliveries(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error)
+	// SaveDelivery writes back the outcome of a delivery attempt
+	SaveDelivery(ctx context.Context, delivery *model.Delivery) error
+	// ListDeliveries returns the deliveries of a webhook, newest first,
+	// narrowed to status unless it's empty
+	ListDeliveries(ctx context.Context, webhookID int64, status string) ([]model.Delivery, error)
+	GetDelivery(ctx context.Context, webhookID, deliveryID int64) (*model.Delivery, error)
+}
+
+var (
+	ErrWebhookNotFound  = model.ErrWebhookNotFound
+	ErrDeliveryNotFound = model.ErrDeliveryNotFound
+)
+
+var (
+	_ WebhookRepository = sqlUserRepository{}
+	_ WebhookRepository = (*MemoryUserRepository)(nil)
+)
+
+// webhookColumns are the webhooks columns in the order scanWebhook reads them
+var webhookColumns = []string{"webhook_id", "url", "secret", "event_types", "department", "user_status", "created_at"}
+
+// deliveryColumns are the webhook_deliveries columns in the order
+// scanDelivery reads them
+var deliveryColumns = []string{"delivery_id", "webhook_id", "event_id", "event_type", "payload", "status",
+	"attempts", "next_attempt_at", "last_error", "created_at", "delivered_at"}
+
+func (r sqlUserRepository) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	rows, err := r.builder().Select(webhookColumns...).From("webhooks").OrderBy("webhook_id ASC").
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		r.logger.Error("Failed to execute webhooks query",
+			slog.String("err", err.Error()))
+
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	webhooks := []model.Webhook{}
+
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (r sqlUserRepository) GetWebhook(ctx context.Context, id int64) (*model.Webhook, error) {
+	row := r.builder().Select(webhookColumns...).From("webhooks").Where(sq.Eq{"webhook_id": id}).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	webhook, err := scanWebhook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+
+	return webhook, err
+}
+
+func (r sqlUserRepository) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	webhook.CreatedAt = time.Now().UTC()
+
+	insert := r.builder().Insert("webhooks").
+		Columns("url", "secret", "event_types", "department", "user_status", "created_at").
+		Values(webhook.URL, webhook.Secret, strings.Join(webhook.EventTypes, ","), webhook.Department,
+			webhook.Status, webhook.CreatedAt)
+
+	id, err := r.insertID(ctx, r.db, insert, "webhook_id")
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	webhook.WebhookID = id
+
+	return nil
+}
+
+func (r sqlUserRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	builder := r.builder()
+
+	if _, err = builder.Delete("webhook_deliveries").Where(sq.Eq{"webhook_id": id}).
+		RunWith(tx).ExecContext(ctx); err != nil {
+		return err
+	}
+
+	res, err := builder.Delete("webhooks").Where(sq.Eq{"webhook_id": id}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = expectAffected(res, ErrWebhookNotFound); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r sqlUserRepository) EnqueueDeliveries(ctx context.Context, deliveries ...model.Delivery) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	builder := r.builder()
+
+	for _, delivery := range deliveries {
+		var queued int
+
+		err = builder.Select("COUNT(*)").From("webhook_deliveries").
+			Where(sq.Eq{"webhook_id": delivery.WebhookID, "event_id": delivery.EventID}).
+			RunWith(tx).QueryRowContext(ctx).Scan(&queued)
+		if err != nil {
+			return err
+		}
+
+		if queued > 0 {
+			continue
+		}
+
+		_, err = builder.Insert("webhook_deliveries").
+			Columns("webhook_id", "event_id", "event_type", "payload", "status", "attempts",
+				"next_attempt_at", "last_error", "created_at").
+			Values(delivery.WebhookID, delivery.EventID, delivery.EventType, string(delivery.Payload),
+				model.DeliveryPending, 0, delivery.NextAttemptAt.UTC(), "", time.Now().UTC()).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue delivery: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r sqlUserRepository) ClaimDeliveries(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error) {
+	due := sq.And{sq.Eq{"status": model.DeliveryPending}, sq.LtOrEq{"next_attempt_at": now.UTC()}}
+
+	rows, err := r.builder().Select("delivery_id").From("webhook_deliveries").Where(due).
+		OrderBy("delivery_id ASC").Limit(uint64(limit)).
+		RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due deliveries: %w", err)
+	}
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+
+			return nil, fmt.Errorf("failed to scan delivery id: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return []model.Delivery{}, err
+	}
+
+	// the deliveries another replica claimed in between are left out by
+	// the due condition, the claim tells those this one got
+	claim := newClaim()
+
+	_, err = r.builder().Update("webhook_deliveries").
+		SetMap(sq.Eq{"claim": claim, "next_attempt_at": now.Add(ClaimDuration).UTC()}).
+		Where(sq.Eq{"delivery_id": ids}).Where(due).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+
+	query := r.builder().Select(deliveryColumns...).From("webhook_deliveries").
+		Where(sq.Eq{"delivery_id": ids, "claim": claim}).OrderBy("delivery_id ASC")
+
+	return r.queryDeliveries(ctx, query)
+}
+
+func (r sqlUserRepository) SaveDelivery(ctx context.Context, delivery *model.Delivery) error {
+	_, err := r.builder().Update("webhook_deliveries").
+		SetMap(sq.Eq{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt.UTC(),
+			"last_error":      delivery.LastError,
+			"delivered_at":    utcOrNil(delivery.DeliveredAt),
+		}).
+		Where(sq.Eq{"delivery_id": delivery.DeliveryID}).
+		RunWith(r.db).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to save delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (r sqlUserRepository) ListDeliveries(ctx context.Context, webhookID int64, status string) ([]model.Delivery, error) {
+	if _, err := r.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	query := r.builder().Select(deliveryColumns...).From("webhook_deliveries").
+		Where(sq.Eq{"webhook_id": webhookID}).OrderBy("delivery_id DESC")
+
+	if status != "" {
+		query = query.Where(sq.Eq{"status": status})
+	}
+
+	return r.queryDeliveries(ctx, query)
+}
+
+func (r sqlUserRepository) GetDelivery(ctx context.Context, webhookID, deliveryID int64) (*model.Delivery, error) {
+	row := r.builder().Select(deliveryColumns...).From("webhook_deliveries").
+		Where(sq.Eq{"webhook_id": webhookID, "delivery_id": deliveryID}).
+		RunWith(r.db).QueryRowContext(ctx)
+
+	delivery, err := scanDelivery(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+
+	return delivery, err
+}
+
+func (r sqlUserRepository) queryDeliveries(ctx context.Context, query sq.SelectBuilder) ([]model.Delivery, error) {
+	rows, err := query.RunWith(r.db).QueryContext(ctx)
+	if err != nil {
+		r.logger.Error("Failed to execute deliveries query",
+			slog.String("err", err.Error()))
+
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []model.Delivery{}
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// insertID runs insert and returns the id generated for the new row in
+// idColumn
+func (r sqlUserRepository) insertID(ctx context.Context, runner sq.BaseRunner, insert sq.InsertBuilder, idColumn string) (int64, error) {
+	var id int64
+
+	if r.dialect.returning {
+		err := insert.Suffix("RETURNING " + idColumn).RunWith(runner).QueryRowContext(ctx).Scan(&id)
+
+		return id, err
+	}
+
+	res, err := insert.RunWith(runner).ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+func scanWebhook(row sq.RowScanner) (*model.Webhook, error) {
+	var (
+		webhook    model.Webhook
+		eventTypes string
+	)
+
+	err := row.Scan(&webhook.WebhookID, &webhook.URL, &webhook.Secret, &eventTypes,
+		&webhook.Department, &webhook.Status, &webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if eventTypes != "" {
+		webhook.EventTypes = strings.Split(eventTypes, ",")
+	}
+
+	return &webhook, nil
+}
+
+func scanDelivery(row sq.RowScanner) (*model.Delivery, error) {
+	var (
+		delivery model.Delivery
+		payload  []byte
+	)
+
+	err := row.Scan(&delivery.DeliveryID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &payload,
+		&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastError,
+		&delivery.CreatedAt, &delivery.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = payload
+
+	return &delivery, nil
+}
+
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+
+	return t.UTC()
+}
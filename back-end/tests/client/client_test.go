@@ -0,0 +1,514 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrii-stp/users-crud/client"
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/handler"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/router"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+// noWait retries right away so that tests don't sleep
+var noWait = client.WithRetryPolicy(client.RetryPolicy{MaxRetries: 3})
+
+// newServer serves the API from a fresh memory repository, wrapped by
+// middleware when it's set
+func newServer(t *testing.T, middleware func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	var h http.Handler = router.Router(logger, storage.NewMemoryRepository(),
+		router.WithCursorSecret([]byte("client-test-secret")),
+		router.WithEventBroker(events.NewBroker(router.DefaultEventBuffer)))
+
+	if middleware != nil {
+		h = middleware(h)
+	}
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newClient(t *testing.T, server *httptest.Server, opts ...client.Option) *client.Client {
+	t.Helper()
+
+	c, err := client.New(server.URL, append([]client.Option{noWait}, opts...)...)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	return c
+}
+
+func createUsers(t *testing.T, c *client.Client, users ...model.User) []model.User {
+	t.Helper()
+
+	created := make([]model.User, 0, len(users))
+
+	for _, user := range users {
+		u, err := c.CreateUser(context.Background(), user)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		created = append(created, *u)
+	}
+
+	return created
+}
+
+var testUsers = []model.User{
+	{UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", Status: "A", Department: "Sales"},
+	{UserName: "asmith", FirstName: "Anna", LastName: "Smith", Email: "asmith@example.org", Status: "I", Department: "IT"},
+	{UserName: "bbrown", FirstName: "Bob", LastName: "Brown", Email: "bbrown@example.com", Status: "A", Department: "IT"},
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"localhost:8080", "ftp://localhost", "http://%zz"} {
+		if _, err := client.New(baseURL); err == nil {
+			t.Errorf("Expected an error for %q", baseURL)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil), client.WithActor("alice"))
+
+	created := createUsers(t, c, testUsers[0])[0]
+	if created.UserID == 0 || created.Version != 1 {
+		t.Fatalf("Expected a created user at version 1 but got %+v", created)
+	}
+
+	user, err := c.GetUser(ctx, created.UserID)
+	if err != nil || user.UserName != "jdoe" {
+		t.Fatalf("Expected jdoe but got %+v, %v", user, err)
+	}
+
+	if user, err = c.GetUserByUserName(ctx, "JDOE"); err != nil || user.UserID != created.UserID {
+		t.Fatalf("Expected jdoe by username but got %+v, %v", user, err)
+	}
+
+	update := created
+	update.Department = "Marketing"
+
+	if user, err = c.UpdateUser(ctx, created.UserID, update, created.Version); err != nil || user.Version != 2 {
+		t.Fatalf("Expected an update to version 2 but got %+v, %v", user, err)
+	}
+
+	if user, err = c.MergePatchUser(ctx, created.UserID, map[string]any{"user_status": "I"}, 2); err != nil || user.Status != "I" {
+		t.Fatalf("Expected a merge patched status but got %+v, %v", user, err)
+	}
+
+	user, err = c.JSONPatchUser(ctx, created.UserID, []client.PatchOperation{
+		{Op: "replace", Path: "/first_name", Value: "Johnny"},
+	}, 0)
+	if err != nil || user.FirstName != "Johnny" || user.Version != 4 {
+		t.Fatalf("Expected a JSON patched first name but got %+v, %v", user, err)
+	}
+
+	if user, err = c.RevertUser(ctx, created.UserID, 1, 4); err != nil || user.Department != "Sales" || user.Version != 5 {
+		t.Fatalf("Expected a revert to version 1 but got %+v, %v", user, err)
+	}
+
+	history, err := c.UserHistory(ctx, created.UserID, client.AuditFilter{})
+	if err != nil || len(history.Items) != 5 {
+		t.Fatalf("Expected 5 history entries but got %+v, %v", history, err)
+	}
+
+	audit, err := c.Audit(ctx, client.AuditFilter{Actor: "anonymous", ClaimedActor: "alice", Limit: 2})
+	if err != nil || len(audit.Items) != 2 || audit.Items[0].ClaimedActor != "alice" {
+		t.Fatalf("Expected 2 audit entries claimed by alice but got %+v, %v", audit, err)
+	}
+
+	if err = c.DeleteUser(ctx, created.UserID, 5); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	trash, err := c.ListTrash(ctx, client.ListOptions{})
+	if err != nil || len(trash.Items) != 1 {
+		t.Fatalf("Expected a user in the trash but got %+v, %v", trash, err)
+	}
+
+	if user, err = c.RestoreUser(ctx, created.UserID); err != nil || user.DeletedAt != nil {
+		t.Fatalf("Expected a restored user but got %+v, %v", user, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil))
+	user := createUsers(t, c, testUsers[0])[0]
+
+	_, err := c.GetUser(ctx, 100)
+	if !errors.Is(err, model.ErrUserNotFound) {
+		t.Errorf("Expected %v but got %v", model.ErrUserNotFound, err)
+	}
+
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 *client.Error but got %v", err)
+	}
+
+	duplicate := testUsers[1]
+	duplicate.UserName = "JDoe"
+
+	if _, err = c.CreateUser(ctx, duplicate); !errors.Is(err, model.ErrAlreadyExist) {
+		t.Errorf("Expected %v but got %v", model.ErrAlreadyExist, err)
+	}
+
+	duplicate = testUsers[1]
+	duplicate.Email = "jdoe@example.com"
+
+	if _, err = c.CreateUser(ctx, duplicate); !errors.Is(err, model.ErrEmailInUse) {
+		t.Errorf("Expected %v but got %v", model.ErrEmailInUse, err)
+	}
+
+	if _, err = c.UpdateUser(ctx, user.UserID, user, 7); !errors.Is(err, model.ErrVersionConflict) {
+		t.Errorf("Expected %v but got %v", model.ErrVersionConflict, err)
+	}
+
+	if err = c.DeleteUser(ctx, user.UserID, 7); !errors.Is(err, model.ErrVersionConflict) {
+		t.Errorf("Expected %v but got %v", model.ErrVersionConflict, err)
+	}
+
+	invalid := testUsers[1]
+	invalid.Email = "not an email"
+
+	_, err = c.CreateUser(ctx, invalid)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "'email' is invalid" {
+		t.Errorf("Expected a validation error but got %v", err)
+	}
+
+	if errors.Unwrap(err) != nil {
+		t.Errorf("Expected a validation error not to unwrap but got %v", errors.Unwrap(err))
+	}
+
+	if _, err = c.ListUsers(ctx, client.ListOptions{Sort: "password"}); !errors.Is(err, storage.ErrInvalidSort) {
+		t.Errorf("Expected %v but got %v", storage.ErrInvalidSort, err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil))
+	createUsers(t, c, testUsers...)
+
+	page, err := c.ListUsers(ctx, client.ListOptions{Department: "IT", Sort: "user_name"})
+	if err != nil || len(page.Items) != 2 || page.Items[0].UserName != "asmith" {
+		t.Fatalf("Expected the IT users by username but got %+v, %v", page, err)
+	}
+
+	var names []string
+
+	err = c.EachUser(ctx, client.ListOptions{Limit: 1, Sort: "-user_name"}, func(user model.User) error {
+		names = append(names, user.UserName)
+
+		return nil
+	})
+	if err != nil || strings.Join(names, ",") != "jdoe,bbrown,asmith" {
+		t.Fatalf("Expected every user a page at a time but got %v, %v", names, err)
+	}
+
+	stop := errors.New("stop")
+
+	if err = c.EachUser(ctx, client.ListOptions{Limit: 1}, func(model.User) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("Expected EachUser to stop but got %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil))
+
+	resp, err := c.Batch(ctx, []model.BatchOperation{
+		{Op: "create", User: &testUsers[0]},
+		{Op: "delete", ID: 100},
+	}, false)
+	if err != nil || len(resp.Results) != 2 {
+		t.Fatalf("Expected 2 results but got %+v, %v", resp, err)
+	}
+
+	if resp.Results[0].Status != http.StatusCreated || resp.Results[1].Status != http.StatusNotFound {
+		t.Errorf("Expected 201 and 404 but got %+v", resp.Results)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil))
+
+	csv := "login,first_name,last_name,email\njdoe,John,Doe,jdoe@example.com\nasmith,Anna,Smith,invalid\n"
+	opts := client.ImportOptions{Mapping: map[string]string{"login": "user_name"}, DryRun: true}
+
+	report, err := c.ImportUsers(ctx, strings.NewReader(csv), opts)
+	if err != nil || !report.DryRun || report.Imported != 1 || report.Failed != 1 {
+		t.Fatalf("Expected a dry run importing 1 user but got %+v, %v", report, err)
+	}
+
+	opts.DryRun = false
+
+	if report, err = c.ImportUsers(ctx, strings.NewReader(csv), opts); err != nil || report.Imported != 1 {
+		t.Fatalf("Expected 1 imported user but got %+v, %v", report, err)
+	}
+
+	file, err := c.ExportUsers(ctx, handler.MIMENDJSON, client.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to export users: %v", err)
+	}
+
+	defer file.Close()
+
+	body, err := io.ReadAll(file)
+	if err != nil || !strings.Contains(string(body), `"user_name":"jdoe"`) {
+		t.Errorf("Expected jdoe in the export but got %s, %v", body, err)
+	}
+
+	var apiErr *client.Error
+	if _, err = c.ExportUsers(ctx, "application/pdf", client.ListOptions{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("Expected a 406 but got %v", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil))
+
+	webhook, err := c.CreateWebhook(ctx, model.Webhook{URL: "http://localhost:9999/hook", EventTypes: []string{model.EventUserCreated}})
+	if err != nil || webhook.Secret == "" {
+		t.Fatalf("Expected a webhook with a secret but got %+v, %v", webhook, err)
+	}
+
+	if webhook, err = c.GetWebhook(ctx, webhook.WebhookID); err != nil || webhook.Secret != "" {
+		t.Fatalf("Expected a webhook without its secret but got %+v, %v", webhook, err)
+	}
+
+	webhooks, err := c.ListWebhooks(ctx)
+	if err != nil || len(webhooks) != 1 {
+		t.Fatalf("Expected a webhook but got %+v, %v", webhooks, err)
+	}
+
+	deliveries, err := c.WebhookDeliveries(ctx, webhook.WebhookID, model.DeliveryFailed)
+	if err != nil || len(deliveries) != 0 {
+		t.Fatalf("Expected no failed delivery but got %+v, %v", deliveries, err)
+	}
+
+	if _, err = c.ReplayDelivery(ctx, webhook.WebhookID, 100); !errors.Is(err, storage.ErrDeliveryNotFound) {
+		t.Errorf("Expected %v but got %v", storage.ErrDeliveryNotFound, err)
+	}
+
+	if err = c.DeleteWebhook(ctx, webhook.WebhookID); err != nil {
+		t.Fatalf("Failed to delete webhook: %v", err)
+	}
+
+	if _, err = c.GetWebhook(ctx, webhook.WebhookID); !errors.Is(err, storage.ErrWebhookNotFound) {
+		t.Errorf("Expected %v but got %v", storage.ErrWebhookNotFound, err)
+	}
+}
+
+// failing answers the first n requests with status before handing them to
+// the API, calls counts every request
+func failing(status int, n int32, calls *atomic.Int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= n {
+				http.Error(w, `{"message":"try again"}`, status)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("get is retried on 5xx", func(t *testing.T) {
+		var calls atomic.Int32
+
+		c := newClient(t, newServer(t, failing(http.StatusBadGateway, 2, &calls)))
+
+		if _, err := c.ListUsers(ctx, client.ListOptions{}); err != nil {
+			t.Fatalf("Expected the listing to succeed but got %v", err)
+		}
+
+		if calls.Load() != 3 {
+			t.Errorf("Expected 3 calls but got %d", calls.Load())
+		}
+	})
+
+	t.Run("retries give up", func(t *testing.T) {
+		var calls atomic.Int32
+
+		c := newClient(t, newServer(t, failing(http.StatusInternalServerError, 10, &calls)))
+
+		var apiErr *client.Error
+		if _, err := c.GetUser(ctx, 1); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "try again" {
+			t.Fatalf("Expected a 500 but got %v", err)
+		}
+
+		if calls.Load() != 4 {
+			t.Errorf("Expected 4 calls but got %d", calls.Load())
+		}
+	})
+
+	t.Run("post is only retried on 503", func(t *testing.T) {
+		var calls atomic.Int32
+
+		c := newClient(t, newServer(t, failing(http.StatusInternalServerError, 1, &calls)))
+
+		if _, err := c.CreateUser(ctx, testUsers[0]); err == nil || calls.Load() != 1 {
+			t.Fatalf("Expected a single failed call but got %d calls, %v", calls.Load(), err)
+		}
+
+		calls.Store(0)
+		c = newClient(t, newServer(t, failing(http.StatusServiceUnavailable, 1, &calls)))
+
+		if _, err := c.CreateUser(ctx, testUsers[0]); err != nil || calls.Load() != 2 {
+			t.Fatalf("Expected a retried creation but got %d calls, %v", calls.Load(), err)
+		}
+	})
+
+	t.Run("4xx isn't retried", func(t *testing.T) {
+		var calls atomic.Int32
+
+		c := newClient(t, newServer(t, failing(http.StatusTooManyRequests, 1, &calls)))
+
+		if _, err := c.ListUsers(ctx, client.ListOptions{}); err == nil || calls.Load() != 1 {
+			t.Fatalf("Expected a single failed call but got %d calls, %v", calls.Load(), err)
+		}
+	})
+}
+
+func TestContext(t *testing.T) {
+	c, err := client.New(newServer(t, failing(http.StatusServiceUnavailable, 100, new(atomic.Int32))).URL,
+		client.WithRetryPolicy(client.RetryPolicy{MaxRetries: 100, MinBackoff: time.Second, MaxBackoff: time.Second}))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err = c.ListUsers(ctx, client.ListOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	requireToken := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	server := newServer(t, requireToken)
+
+	var apiErr *client.Error
+	if _, err := newClient(t, server).ListUsers(context.Background(), client.ListOptions{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 but got %v", err)
+	}
+
+	var tokens atomic.Int32
+
+	// the token is asked for on every attempt
+	refreshing := client.AuthenticatorFunc(func(req *http.Request) error {
+		tokens.Add(1)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		return nil
+	})
+
+	if _, err := newClient(t, server, client.WithAuth(refreshing)).ListUsers(context.Background(), client.ListOptions{}); err != nil || tokens.Load() != 1 {
+		t.Errorf("Expected an authenticated listing but got %d tokens, %v", tokens.Load(), err)
+	}
+
+	if _, err := newClient(t, server, client.WithAuth(client.BearerToken("secret"))).ListUsers(context.Background(), client.ListOptions{}); err != nil {
+		t.Errorf("Expected an authenticated listing but got %v", err)
+	}
+
+	failed := errors.New("no token")
+	broken := client.AuthenticatorFunc(func(*http.Request) error { return failed })
+
+	if _, err := newClient(t, server, client.WithAuth(broken)).ListUsers(context.Background(), client.ListOptions{}); !errors.Is(err, failed) {
+		t.Errorf("Expected %v but got %v", failed, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := newClient(t, newServer(t, nil))
+	createUsers(t, c, testUsers...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	got := make(chan model.Event, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Watch(ctx, client.WatchOptions{Department: "IT", LastEventID: 1}, func(event model.Event) error {
+			got <- event
+
+			return nil
+		})
+	}()
+
+	// the changes missed after the first one come first, then the new ones
+	for i, name := range []string{"asmith", "bbrown", "cwhite"} {
+		select {
+		case event := <-got:
+			if event.Type != model.EventUserCreated || event.User.UserName != name || event.EventID != int64(i+2) {
+				t.Errorf("Expected %s to be created but got %+v", name, event)
+			}
+		case err := <-done:
+			t.Fatalf("Expected %s to be created but got %v", name, err)
+		}
+
+		if name == "bbrown" {
+			createUsers(t, c, model.User{UserName: "cwhite", FirstName: "Carl", LastName: "White", Email: "cwhite@example.com", Status: "A", Department: "IT"})
+		}
+	}
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v but got %v", context.Canceled, err)
+	}
+
+	// the changes after an event that was never handed out can't be resumed
+	stop := errors.New("stop")
+
+	err := c.Watch(context.Background(), client.WatchOptions{LastEventID: 100}, func(event model.Event) error {
+		if event.Type != client.EventReset {
+			t.Errorf("Expected a reset event but got %+v", event)
+		}
+
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected %v but got %v", stop, err)
+	}
+}
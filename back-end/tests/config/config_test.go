@@ -2,6 +2,7 @@ package config_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/andrii-stp/users-crud/config"
 )
@@ -38,3 +39,190 @@ func TestLoad(t *testing.T) {
 		t.Errorf("SSLMode expected as postgres but got %v", cfg.Database.SSLMode)
 	}
 }
+
+func TestLoadMemoryDriver(t *testing.T) {
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("DB_DRIVER", "memory")
+
+	for _, env := range []string{"DB_HOST", "DB_USERNAME", "DB_PASSWORD", "DB_NAME", "DB_SSLMODE"} {
+		t.Setenv(env, "")
+	}
+
+	cfg, err := config.Load("missing.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Database.Driver != "memory" {
+		t.Errorf("Driver expected as memory but got %v", cfg.Database.Driver)
+	}
+}
+
+func TestLoadTrashRetention(t *testing.T) {
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("DB_DRIVER", "memory")
+
+	cfg, err := config.Load("missing.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Trash.Retention != 30*24*time.Hour {
+		t.Errorf("Retention expected as 720h but got %v", cfg.Trash.Retention)
+	}
+
+	t.Setenv("TRASH_RETENTION", "36h")
+
+	if cfg, err = config.Load("missing.env"); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Trash.Retention != 36*time.Hour {
+		t.Errorf("Retention expected as 36h but got %v", cfg.Trash.Retention)
+	}
+
+	t.Setenv("TRASH_RETENTION", "a week")
+
+	if _, err = config.Load("missing.env"); err == nil {
+		t.Errorf("Expected an invalid retention to be rejected")
+	}
+}
+
+func TestLoadEvents(t *testing.T) {
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("DB_DRIVER", "memory")
+
+	cfg, err := config.Load("missing.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Events.Sink != "stdout" {
+		t.Errorf("Sink expected as stdout but got %v", cfg.Events.Sink)
+	}
+
+	if cfg.Events.PollInterval != time.Second {
+		t.Errorf("Poll interval expected as 1s but got %v", cfg.Events.PollInterval)
+	}
+
+	t.Setenv("EVENTS_SINK", "file:/var/log/users/events.ndjson")
+	t.Setenv("EVENTS_POLL_INTERVAL", "250ms")
+
+	if cfg, err = config.Load("missing.env"); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Events.Sink != "file:/var/log/users/events.ndjson" {
+		t.Errorf("Sink expected as file:/var/log/users/events.ndjson but got %v", cfg.Events.Sink)
+	}
+
+	if cfg.Events.PollInterval != 250*time.Millisecond {
+		t.Errorf("Poll interval expected as 250ms but got %v", cfg.Events.PollInterval)
+	}
+
+	t.Setenv("EVENTS_POLL_INTERVAL", "-1s")
+
+	if _, err = config.Load("missing.env"); err == nil {
+		t.Errorf("Expected an invalid poll interval to be rejected")
+	}
+}
+
+func TestLoadGRPCPort(t *testing.T) {
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("DB_DRIVER", "memory")
+
+	cfg, err := config.Load("missing.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Server.GRPCPort != "" {
+		t.Errorf("gRPC port expected to be empty but got %v", cfg.Server.GRPCPort)
+	}
+
+	t.Setenv("GRPC_PORT", "9090")
+
+	if cfg, err = config.Load("missing.env"); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Server.GRPCPort != "9090" {
+		t.Errorf("gRPC port expected as 9090 but got %v", cfg.Server.GRPCPort)
+	}
+
+	t.Setenv("GRPC_PORT", "8080")
+
+	if _, err = config.Load("missing.env"); err == nil {
+		t.Errorf("Expected the gRPC port to be rejected when it's the server port")
+	}
+}
+
+func TestLoadOpenAPIValidation(t *testing.T) {
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("DB_DRIVER", "memory")
+
+	cfg, err := config.Load("missing.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Server.ValidateRequests || cfg.Server.ValidateResponses {
+		t.Errorf("OpenAPI validation expected to be off but got %+v", cfg.Server)
+	}
+
+	t.Setenv("OPENAPI_VALIDATION", "requests, responses")
+
+	if cfg, err = config.Load("missing.env"); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.Server.ValidateRequests || !cfg.Server.ValidateResponses {
+		t.Errorf("OpenAPI validation expected to be on but got %+v", cfg.Server)
+	}
+
+	t.Setenv("OPENAPI_VALIDATION", "everything")
+
+	if _, err = config.Load("missing.env"); err == nil {
+		t.Errorf("Expected OPENAPI_VALIDATION to be rejected")
+	}
+}
+
+func TestLoadAuthTokens(t *testing.T) {
+	configFile := "../test.env"
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	tests := []struct {
+		authorization string
+		expected      string
+	}{
+		{"Bearer test-token", "admin"},
+		{"Bearer other-token", ""},
+		{"test-token", ""},
+		{"Bearer ", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if identity := cfg.Auth.Identity(tt.authorization); identity != tt.expected {
+			t.Errorf("Identity of %q expected as %q but got %q", tt.authorization, tt.expected, identity)
+		}
+	}
+
+	t.Setenv("AUTH_TOKENS", "")
+
+	if _, err = config.Load(configFile); err == nil {
+		t.Errorf("Expected AUTH_TOKENS to be required for a database driver")
+	}
+
+	for _, env := range []string{"alice", "alice:", "alice:a,alice:b", "alice:a,bob:a"} {
+		t.Setenv("AUTH_TOKENS", env)
+
+		if _, err = config.Load(configFile); err == nil {
+			t.Errorf("Expected AUTH_TOKENS %q to be rejected", env)
+		}
+	}
+}
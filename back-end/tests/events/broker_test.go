@@ -0,0 +1,97 @@
+package events_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/model"
+)
+
+func publish(broker *events.Broker, count int) {
+	for i := 0; i < count; i++ {
+		broker.Publish(model.Event{Type: model.EventUserUpdated, UserID: int64(i + 1)})
+	}
+}
+
+func eventIDs(events []model.Event) []int64 {
+	ids := []int64{}
+	for _, event := range events {
+		ids = append(ids, event.EventID)
+	}
+
+	return ids
+}
+
+func TestBrokerSubscribe(t *testing.T) {
+	broker := events.NewBroker(10)
+	publish(broker, 2)
+
+	missed, ch, cancel, resumed := broker.Subscribe(0)
+	defer cancel()
+
+	if len(missed) != 0 || !resumed {
+		t.Fatalf("Expected a new subscriber to only get new events but got %v, %v", missed, resumed)
+	}
+
+	if event := broker.Publish(model.Event{Type: model.EventUserCreated}); event.EventID != 3 {
+		t.Fatalf("Expected the event to be numbered 3 but got %d", event.EventID)
+	}
+
+	if event := <-ch; event.EventID != 3 || event.Type != model.EventUserCreated {
+		t.Errorf("Expected to get the published event but got %+v", event)
+	}
+
+	cancel()
+
+	if _, ok := <-ch; ok {
+		t.Errorf("Expected the channel to be closed once canceled")
+	}
+}
+
+func TestBrokerResume(t *testing.T) {
+	broker := events.NewBroker(3)
+	publish(broker, 5)
+
+	tests := []struct {
+		name        string
+		lastEventID int64
+		missed      []int64
+		resumed     bool
+	}{
+		{"up to date", 5, []int64{}, true},
+		{"kept", 3, []int64{4, 5}, true},
+		{"right before the buffer", 2, []int64{3, 4, 5}, true},
+		{"dropped", 1, []int64{3, 4, 5}, false},
+		{"unknown", 9, []int64{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missed, _, cancel, resumed := broker.Subscribe(tt.lastEventID)
+			defer cancel()
+
+			if ids := eventIDs(missed); fmt.Sprint(ids) != fmt.Sprint(tt.missed) || resumed != tt.resumed {
+				t.Errorf("Expected %v, %v but got %v, %v", tt.missed, tt.resumed, ids, resumed)
+			}
+		})
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := events.NewBroker(10)
+
+	_, ch, cancel, _ := broker.Subscribe(0)
+	defer cancel()
+
+	publish(broker, 100)
+
+	var received int
+	for range ch {
+		received++
+	}
+
+	if received == 0 || received == 100 {
+		t.Errorf("Expected the subscriber to get some events before being dropped but got %d", received)
+	}
+}
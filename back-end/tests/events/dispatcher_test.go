@@ -0,0 +1,174 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/andrii-stp/users-crud/storage/storagetest"
+)
+
+// flakySink records the events it gets and fails once it got failAfter of them
+type flakySink struct {
+	events    []model.Event
+	failAfter int
+}
+
+func (s *flakySink) Send(_ context.Context, event model.Event) error {
+	if s.failAfter >= 0 && len(s.events) >= s.failAfter {
+		return errors.New("sink is down")
+	}
+
+	s.events = append(s.events, event)
+
+	return nil
+}
+
+func newLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(io.Discard, nil))
+}
+
+func newDispatcher(repo *storage.MemoryUserRepository, sinks ...events.Sink) *events.Dispatcher {
+	return events.NewDispatcher(newLogger(), repo, time.Hour, sinks...)
+}
+
+func createUsers(t *testing.T, repo storage.UserRepository, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		if err := repo.Create(context.Background(), storagetest.NewUser(name)); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	createUsers(t, repo, "alice", "bob", "carol")
+
+	sink := &flakySink{failAfter: -1}
+
+	dispatched, err := newDispatcher(repo, sink).Dispatch(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to dispatch events: %v", err)
+	}
+
+	if dispatched != 3 || len(sink.events) != 3 {
+		t.Fatalf("Expected 3 events to be dispatched but got %d, %+v", dispatched, sink.events)
+	}
+
+	for i, name := range []string{"alice", "bob", "carol"} {
+		if sink.events[i].Type != model.EventUserCreated || sink.events[i].User.UserName != name {
+			t.Errorf("Expected %s to be created but got %+v", name, sink.events[i])
+		}
+	}
+
+	pending, _ := repo.ClaimEvents(context.Background(), "test", time.Now(), 10)
+	if len(pending) != 0 {
+		t.Errorf("Expected dispatched events to be acknowledged but got %+v", pending)
+	}
+}
+
+func TestDispatchRetriesFailedEvents(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	createUsers(t, repo, "alice", "bob", "carol")
+
+	healthy := &flakySink{failAfter: -1}
+	flaky := &flakySink{failAfter: 1}
+	dispatcher := newDispatcher(repo, healthy, flaky)
+
+	dispatched, err := dispatcher.Dispatch(context.Background())
+	if err == nil {
+		t.Fatalf("Expected the failing sink to stop the dispatch")
+	}
+
+	if dispatched != 1 {
+		t.Fatalf("Expected 1 event to be dispatched but got %d", dispatched)
+	}
+
+	flaky.failAfter = -1
+
+	if dispatched, err = dispatcher.Dispatch(context.Background()); err != nil {
+		t.Fatalf("Failed to dispatch events: %v", err)
+	}
+
+	if dispatched != 2 {
+		t.Fatalf("Expected 2 events to be dispatched but got %d", dispatched)
+	}
+
+	if len(flaky.events) != 3 {
+		t.Errorf("Expected every event to reach the sink once it's back but got %+v", flaky.events)
+	}
+
+	// the event the flaky sink failed on is delivered again to the healthy one
+	if len(healthy.events) != 4 || healthy.events[1].EventID != healthy.events[2].EventID {
+		t.Errorf("Expected the failed event to be delivered again but got %+v", healthy.events)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	createUsers(t, repo, "alice", "bob")
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+
+	sink, err := events.OpenSink("file:" + path)
+	if err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+
+	defer sink.Close()
+
+	if _, err = newDispatcher(repo, sink).Dispatch(context.Background()); err != nil {
+		t.Fatalf("Failed to dispatch events: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open event file: %v", err)
+	}
+
+	defer file.Close()
+
+	var lines []model.Event
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event model.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode event line: %v", err)
+		}
+
+		lines = append(lines, event)
+	}
+
+	if len(lines) != 2 || lines[1].User.UserName != "bob" {
+		t.Errorf("Expected a line per event but got %+v", lines)
+	}
+}
+
+func TestOpenSink(t *testing.T) {
+	if sink, err := events.OpenSink("none"); err != nil || sink != nil {
+		t.Errorf("Expected no sink for none but got %v, %v", sink, err)
+	}
+
+	if sink, err := events.OpenSink("stdout"); err != nil || sink == nil {
+		t.Errorf("Expected a stdout sink but got %v, %v", sink, err)
+	}
+
+	for _, spec := range []string{"kafka", "file:", ""} {
+		if _, err := events.OpenSink(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/andrii-stp/users-crud/storage/storagetest"
+)
+
+const webhookSecret = "0123456789abcdef"
+
+// receiver records the deliveries it gets and answers with status
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(r.status)
+}
+
+func setupWebhook(t *testing.T, webhook *model.Webhook) (*storage.MemoryUserRepository, *receiver, *events.WebhookDeliverer) {
+	t.Helper()
+
+	recv := &receiver{status: http.StatusNoContent}
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+
+	repo := storage.NewMemoryRepository()
+	webhook.URL = server.URL
+	webhook.Secret = webhookSecret
+
+	if err := repo.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+
+	deliverer := events.NewWebhookDeliverer(newLogger(), repo, server.Client(), time.Hour)
+
+	return repo, recv, deliverer
+}
+
+func dispatchToWebhooks(t *testing.T, repo *storage.MemoryUserRepository) {
+	t.Helper()
+
+	if _, err := newDispatcher(repo, events.NewWebhookSink(repo)).Dispatch(context.Background()); err != nil {
+		t.Fatalf("Failed to dispatch events: %v", err)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	repo, recv, deliverer := setupWebhook(t, &model.Webhook{
+		EventTypes: []string{model.EventUserCreated},
+		Department: "Engineering",
+	})
+
+	createUsers(t, repo, "alice")
+
+	sales := storagetest.NewUser("bob")
+	sales.Department = "Sales"
+
+	if err := repo.Create(context.Background(), sales); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if err := repo.Delete(context.Background(), 1, 0); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	dispatchToWebhooks(t, repo)
+
+	delivered, err := deliverer.Deliver(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("Failed to deliver webhooks: %v", err)
+	}
+
+	if delivered != 1 || len(recv.requests) != 1 {
+		t.Fatalf("Expected only the creation of alice to be delivered but got %d deliveries", len(recv.requests))
+	}
+
+	req, body := recv.requests[0], recv.bodies[0]
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(events.TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("Expected a timestamp header but got %q", req.Header.Get(events.TimestampHeader))
+	}
+
+	if signature := req.Header.Get(events.SignatureHeader); signature != events.Sign(webhookSecret, timestamp, body) {
+		t.Errorf("Expected the body to be signed but got %q", signature)
+	}
+
+	if req.Header.Get(events.EventHeader) != model.EventUserCreated {
+		t.Errorf("Expected a %s event but got %q", model.EventUserCreated, req.Header.Get(events.EventHeader))
+	}
+
+	deliveries, _ := repo.ListDeliveries(context.Background(), 1, model.DeliveryDelivered)
+	if len(deliveries) != 1 || deliveries[0].DeliveredAt == nil || deliveries[0].Attempts != 1 {
+		t.Errorf("Expected the delivery to be marked as delivered but got %+v", deliveries)
+	}
+
+	// the outbox may hand the same event out again
+	if err = repo.EnqueueDeliveries(context.Background(), deliveries[0]); err != nil {
+		t.Fatalf("Failed to enqueue delivery: %v", err)
+	}
+
+	if delivered, _ = deliverer.Deliver(context.Background(), time.Now()); delivered != 0 {
+		t.Errorf("Expected an event to be delivered once but it was delivered again")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	repo, recv, deliverer := setupWebhook(t, &model.Webhook{})
+	recv.status = http.StatusServiceUnavailable
+
+	createUsers(t, repo, "alice")
+	dispatchToWebhooks(t, repo)
+
+	now := time.Now()
+
+	var previous time.Duration
+
+	for attempt := 1; attempt <= 8; attempt++ {
+		if _, err := deliverer.Deliver(context.Background(), now); err != nil {
+			t.Fatalf("Failed to deliver webhooks: %v", err)
+		}
+
+		deliveries, _ := repo.ListDeliveries(context.Background(), 1, "")
+		delivery := deliveries[0]
+
+		if delivery.Attempts != attempt || delivery.LastError == "" {
+			t.Fatalf("Expected attempt %d to be recorded but got %+v", attempt, delivery)
+		}
+
+		if attempt == 8 {
+			if delivery.Status != model.DeliveryFailed {
+				t.Fatalf("Expected the delivery to fail after 8 attempts but got %+v", delivery)
+			}
+
+			break
+		}
+
+		wait := delivery.NextAttemptAt.Sub(now)
+		if wait < previous || wait > time.Hour {
+			t.Fatalf("Expected the wait to back off up to an hour but got %v after %v", wait, previous)
+		}
+
+		// not due before the backoff is over
+		if delivered, _ := deliverer.Deliver(context.Background(), now.Add(wait-time.Second)); delivered != 0 ||
+			len(recv.requests) != attempt {
+			t.Fatalf("Expected no attempt before the backoff is over")
+		}
+
+		previous = wait
+		now = delivery.NextAttemptAt
+	}
+
+	recv.status = http.StatusOK
+
+	if delivered, _ := deliverer.Deliver(context.Background(), now.Add(24*time.Hour)); delivered != 0 {
+		t.Errorf("Expected a failed delivery to wait for a replay")
+	}
+}
+
+func TestWebhookInternalAddress(t *testing.T) {
+	repo, recv, _ := setupWebhook(t, &model.Webhook{})
+	deliverer := events.NewWebhookDeliverer(newLogger(), repo, nil, time.Hour)
+
+	createUsers(t, repo, "alice")
+	dispatchToWebhooks(t, repo)
+
+	if delivered, err := deliverer.Deliver(context.Background(), time.Now()); err != nil || delivered != 0 {
+		t.Fatalf("Expected no delivery to a loopback address but got %d, %v", delivered, err)
+	}
+
+	deliveries, _ := repo.ListDeliveries(context.Background(), 1, "")
+	if len(recv.requests) != 0 || !strings.Contains(deliveries[0].LastError, events.ErrForbiddenAddress.Error()) {
+		t.Errorf("Expected the delivery to be refused but got %+v", deliveries[0])
+	}
+}
+
+func TestWebhookSpecialAddresses(t *testing.T) {
+	client := events.NewWebhookClient()
+
+	for _, host := range []string{
+		"0.0.0.0",
+		"0.1.2.3",
+		"100.64.0.1",
+		"169.254.169.254",
+		"198.18.0.1",
+		"255.255.255.255",
+		"[::ffff:10.0.0.1]",
+		"[::ffff:127.0.0.1]",
+		"[::127.0.0.1]",
+		"[64:ff9b::7f00:1]",
+		"[64:ff9b::a9fe:a9fe]",
+		"[64:ff9b:1::1]",
+		"[2002:a00:1::1]",
+		"[fd00::1]",
+	} {
+		_, err := client.Get("http://" + host + "/hooks")
+		if !errors.Is(err, events.ErrForbiddenAddress) {
+			t.Errorf("Expected %s to be refused but got %v", host, err)
+		}
+	}
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	target := &receiver{status: http.StatusNoContent}
+	targetServer := httptest.NewServer(target)
+	t.Cleanup(targetServer.Close)
+
+	redirect := httptest.NewServer(http.RedirectHandler(targetServer.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+
+	repo := storage.NewMemoryRepository()
+	if err := repo.CreateWebhook(context.Background(), &model.Webhook{URL: redirect.URL, Secret: webhookSecret}); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+
+	// the test servers are on loopback, only the redirect policy is kept
+	client := events.NewWebhookClient()
+	client.Transport = redirect.Client().Transport
+
+	deliverer := events.NewWebhookDeliverer(newLogger(), repo, client, time.Hour)
+
+	createUsers(t, repo, "alice")
+	dispatchToWebhooks(t, repo)
+
+	if delivered, err := deliverer.Deliver(context.Background(), time.Now()); err != nil || delivered != 0 {
+		t.Fatalf("Expected the redirect not to count as a delivery but got %d, %v", delivered, err)
+	}
+
+	deliveries, _ := repo.ListDeliveries(context.Background(), 1, "")
+	if len(target.requests) != 0 || !strings.Contains(deliveries[0].LastError, "307") {
+		t.Errorf("Expected the redirect not to be followed but got %+v", deliveries[0])
+	}
+}
@@ -0,0 +1,349 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/graph"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/router"
+	"github.com/andrii-stp/users-crud/storage"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GraphQL", func() {
+	Describe("with memory storage", Ordered, func() {
+		graphQLSpecs(&MemoryStorage{})
+	})
+
+	Describe("with sqlite storage", Ordered, func() {
+		graphQLSpecs(&DatabaseStorage{
+			Database: &config.Database{Driver: "sqlite", Name: filepath.Join(os.TempDir(), "graphql_handler_test.db")},
+		})
+	})
+})
+
+// GraphQLResponse is the body of a GraphQL response
+type GraphQLResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// Code returns the code of the first error, if any
+func (r GraphQLResponse) Code() string {
+	if len(r.Errors) == 0 {
+		return ""
+	}
+
+	code, _ := r.Errors[0].Extensions["code"].(string)
+
+	return code
+}
+
+func graphQLSpecs(testStorage TestStorage) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	var (
+		repo  storage.UserRepository
+		users []*model.User
+	)
+
+	BeforeAll(func() {
+		testStorage.Setup(logger)
+	})
+
+	AfterAll(func() {
+		testStorage.Teardown()
+	})
+
+	BeforeEach(func() {
+		repo = testStorage.Fresh()
+
+		users = []*model.User{
+			{UserName: "alice", FirstName: "Alice", LastName: "Smith", Email: "alice@example.com", Status: "A", Department: "Sales"},
+			{UserName: "bob", FirstName: "Bob", LastName: "Jones", Email: "bob@corp.example.com", Status: "T", Department: "IT"},
+			{UserName: "carol", FirstName: "Carol", LastName: "Brown", Email: "carol@example.com", Status: "A", Department: "IT"},
+			{UserName: "dave", FirstName: "Dave", LastName: "Adams", Email: "dave@example.com", Status: "I", Department: "Sales"},
+		}
+
+		for _, user := range users {
+			if err := repo.Create(context.Background(), user); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	execute := func(query string, variables map[string]any, opts ...router.Option) GraphQLResponse {
+		body, _ := json.Marshal(map[string]any{"query": query, "variables": variables})
+		req, _ := http.NewRequest(http.MethodPost, "/graphql", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp := ExecuteRequest(logger, req, repo, opts...)
+		Expect(resp.Code).To(Equal(http.StatusOK), resp.Body.String())
+
+		var result GraphQLResponse
+		Expect(json.Unmarshal(resp.Body.Bytes(), &result)).To(Succeed())
+
+		return result
+	}
+
+	// userNames returns the names of the users of a connection, in order
+	userNames := func(conn any) []string {
+		names := []string{}
+
+		for _, edge := range conn.(map[string]any)["edges"].([]any) {
+			names = append(names, edge.(map[string]any)["node"].(map[string]any)["userName"].(string))
+		}
+
+		return names
+	}
+
+	Describe("user", func() {
+
+		It("should get a user by id or username", func() {
+			result := execute(`query($id: ID) { user(id: $id) { id userName userStatus version } }`,
+				map[string]any{"id": users[1].UserID})
+
+			Expect(result.Errors).To(BeEmpty())
+			Expect(result.Data["user"]).To(Equal(map[string]any{
+				"id": fmt.Sprint(users[1].UserID), "userName": "bob", "userStatus": "T", "version": float64(1),
+			}))
+
+			result = execute(`{ user(userName: "CAROL") { email } }`, nil)
+			Expect(result.Data["user"]).To(Equal(map[string]any{"email": "carol@example.com"}))
+		})
+
+		It("should be null when there's no such user", func() {
+			result := execute(`{ user(id: -1) { id } }`, nil)
+
+			Expect(result.Errors).To(BeEmpty())
+			Expect(result.Data).To(HaveKeyWithValue("user", BeNil()))
+		})
+
+		It("should ask for an id or a username", func() {
+			Expect(execute(`{ user { id } }`, nil).Code()).To(Equal(graph.CodeBadUserInput))
+		})
+
+	})
+
+	Describe("users", func() {
+		query := `query($filter: UserFilter, $orderBy: UserOrder, $first: Int, $after: String, $last: Int, $before: String) {
+			users(filter: $filter, orderBy: $orderBy, first: $first, after: $after, last: $last, before: $before) {
+				edges { cursor node { userName } }
+				pageInfo { hasNextPage hasPreviousPage startCursor endCursor }
+				totalCount
+			}
+		}`
+
+		It("should filter and sort users", func() {
+			result := execute(query, map[string]any{
+				"filter":  map[string]any{"userStatus": "A", "emailDomain": "example.com"},
+				"orderBy": map[string]any{"field": "LAST_NAME", "direction": "DESC"},
+			})
+
+			Expect(result.Errors).To(BeEmpty())
+			Expect(userNames(result.Data["users"])).To(Equal([]string{"alice", "carol"}))
+			Expect(result.Data["users"]).To(HaveKeyWithValue("totalCount", float64(2)))
+		})
+
+		It("should page forward then backward", func() {
+			orderBy := map[string]any{"field": "USER_NAME"}
+
+			first := execute(query, map[string]any{"orderBy": orderBy, "first": 2})
+			Expect(userNames(first.Data["users"])).To(Equal([]string{"alice", "bob"}))
+
+			info := first.Data["users"].(map[string]any)["pageInfo"].(map[string]any)
+			Expect(info["hasNextPage"]).To(BeTrue())
+			Expect(info["hasPreviousPage"]).To(BeFalse())
+
+			second := execute(query, map[string]any{"orderBy": orderBy, "first": 2, "after": info["endCursor"]})
+			Expect(userNames(second.Data["users"])).To(Equal([]string{"carol", "dave"}))
+			Expect(second.Data["users"]).To(HaveKeyWithValue("totalCount", float64(4)))
+
+			info = second.Data["users"].(map[string]any)["pageInfo"].(map[string]any)
+			Expect(info["hasNextPage"]).To(BeFalse())
+			Expect(info["hasPreviousPage"]).To(BeTrue())
+
+			back := execute(query, map[string]any{"orderBy": orderBy, "last": 1, "before": info["startCursor"]})
+			Expect(userNames(back.Data["users"])).To(Equal([]string{"bob"}))
+
+			info = back.Data["users"].(map[string]any)["pageInfo"].(map[string]any)
+			Expect(info["hasNextPage"]).To(BeTrue())
+			Expect(info["hasPreviousPage"]).To(BeTrue())
+
+			last := execute(query, map[string]any{"orderBy": orderBy, "last": 3})
+			Expect(userNames(last.Data["users"])).To(Equal([]string{"bob", "carol", "dave"}))
+		})
+
+		It("should reject a cursor of another order", func() {
+			first := execute(query, map[string]any{"first": 1})
+			cursor := first.Data["users"].(map[string]any)["pageInfo"].(map[string]any)["endCursor"]
+
+			result := execute(query, map[string]any{"orderBy": map[string]any{"field": "EMAIL"}, "after": cursor})
+			Expect(result.Code()).To(Equal(graph.CodeBadUserInput))
+		})
+
+		It("should reject invalid arguments", func() {
+			for _, variables := range []map[string]any{
+				{"filter": map[string]any{"userStatus": "X"}},
+				{"after": "forged"},
+				{"first": 1001},
+				{"first": 1, "last": 1},
+			} {
+				Expect(execute(query, variables).Code()).To(Equal(graph.CodeBadUserInput), fmt.Sprint(variables))
+			}
+		})
+
+	})
+
+	Describe("mutations", func() {
+		create := `mutation($input: UserInput!) { createUser(input: $input) { id userName version } }`
+
+		input := func(userName, email string) map[string]any {
+			return map[string]any{"input": map[string]any{
+				"userName": userName, "firstName": "Erin", "lastName": "Hill", "email": email, "userStatus": "A",
+			}}
+		}
+
+		It("should create a user", func() {
+			result := execute(create, input("erin", "erin@example.com"))
+
+			Expect(result.Errors).To(BeEmpty())
+			Expect(result.Data["createUser"]).To(HaveKeyWithValue("userName", "erin"))
+
+			_, err := repo.GetByUserName(context.Background(), "erin")
+			Expect(err).To(BeNil())
+		})
+
+		It("should check the user with the validator", func() {
+			result := execute(create, input("erin", "erin"))
+
+			Expect(result.Code()).To(Equal(graph.CodeBadUserInput))
+			Expect(result.Errors[0].Message).To(Equal(`'email' is invalid`))
+		})
+
+		It("should report a username in use", func() {
+			Expect(execute(create, input("ALICE", "erin@example.com")).Code()).To(Equal(graph.CodeAlreadyExists))
+		})
+
+		It("should update a user at the expected version", func() {
+			update := `mutation($id: ID!, $input: UserInput!, $version: Int) {
+				updateUser(id: $id, input: $input, version: $version) { userName version }
+			}`
+
+			variables := input("alice2", "alice@example.com")
+			variables["id"] = users[0].UserID
+			variables["version"] = 1
+
+			result := execute(update, variables)
+			Expect(result.Errors).To(BeEmpty())
+			Expect(result.Data["updateUser"]).To(Equal(map[string]any{"userName": "alice2", "version": float64(2)}))
+
+			Expect(execute(update, variables).Code()).To(Equal(graph.CodeVersionConflict))
+
+			variables["id"] = -1
+			Expect(execute(update, variables).Code()).To(Equal(graph.CodeNotFound))
+		})
+
+		It("should delete a user", func() {
+			del := `mutation($id: ID!) { deleteUser(id: $id) { userName deletedAt } }`
+
+			result := execute(del, map[string]any{"id": users[0].UserID})
+			Expect(result.Errors).To(BeEmpty())
+			Expect(result.Data["deleteUser"]).To(HaveKeyWithValue("deletedAt", Not(BeNil())))
+
+			Expect(execute(del, map[string]any{"id": users[0].UserID}).Code()).To(Equal(graph.CodeNotFound))
+		})
+
+	})
+
+	Describe("limits", func() {
+
+		It("should reject an operation nested too deep", func() {
+			result := execute(`{ users { edges { node { id } } } }`, nil,
+				router.WithGraphQLLimits(graph.Limits{MaxDepth: 3, MaxComplexity: 1000}))
+
+			Expect(result.Code()).To(Equal(graph.CodeDepthLimit))
+			Expect(result.Data).To(BeNil())
+		})
+
+		It("should reject an operation that's too complex", func() {
+			limits := router.WithGraphQLLimits(graph.Limits{MaxDepth: 10, MaxComplexity: 100})
+
+			Expect(execute(`{ users(first: 10) { edges { node { id } } } }`, nil, limits).Errors).To(BeEmpty())
+
+			result := execute(`{ users(first: 1000) { edges { node { id } } } }`, nil, limits)
+			Expect(result.Code()).To(Equal("COMPLEXITY_LIMIT_EXCEEDED"))
+		})
+
+		It("should not count introspection", func() {
+			result := execute(`{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, nil,
+				router.WithGraphQLLimits(graph.Limits{MaxDepth: 2, MaxComplexity: 100000}))
+
+			Expect(result.Errors).To(BeEmpty())
+		})
+
+	})
+
+	Describe("userChanged", func() {
+
+		It("should stream the changes of a department over SSE", func() {
+			broker := events.NewBroker(10)
+
+			go func() {
+				defer GinkgoRecover()
+
+				time.Sleep(20 * time.Millisecond)
+
+				for _, user := range users[:2] {
+					body, _ := json.Marshal(map[string]any{
+						"query":     `mutation($id: ID!) { deleteUser(id: $id) { id } }`,
+						"variables": map[string]any{"id": user.UserID},
+					})
+					req, _ := http.NewRequest(http.MethodPost, "/graphql", bytes.NewBuffer(body))
+					req.Header.Set("Content-Type", "application/json")
+					Expect(ExecuteRequest(logger, req, repo, router.WithEventBroker(broker)).Code).To(Equal(http.StatusOK))
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			body := `{"query": "subscription { userChanged(department: \"IT\") { type userId user { userName } } }"}`
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/graphql", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "text/event-stream")
+
+			resp := ExecuteRequest(logger, req, repo, router.WithEventBroker(broker))
+			Expect(resp.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+
+			var changes []string
+
+			for _, line := range strings.Split(resp.Body.String(), "\n") {
+				if data, ok := strings.CutPrefix(line, "data: "); ok {
+					changes = append(changes, data)
+				}
+			}
+
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0]).To(MatchJSON(fmt.Sprintf(
+				`{"data": {"userChanged": {"type": "UserDeleted", "userId": "%d", "user": {"userName": "bob"}}}}`, users[1].UserID)))
+		})
+
+	})
+}
@@ -0,0 +1,180 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/andrii-stp/users-crud/docs"
+	"github.com/andrii-stp/users-crud/handler"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/router"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/labstack/echo/v4"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAPI", func() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	validate := router.WithOpenAPIValidation(router.OpenAPIValidation{Requests: true, Responses: true})
+
+	var (
+		repo storage.UserRepository
+		user *model.User
+	)
+
+	BeforeEach(func() {
+		repo = storage.NewMemoryRepository()
+		user = &model.User{UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", Status: "A"}
+
+		if err := repo.Create(context.Background(), user); err != nil {
+			panic(err)
+		}
+	})
+
+	It("should be a valid OpenAPI 3 document", func() {
+		doc, err := docs.OpenAPI()
+		Expect(err).To(BeNil())
+		Expect(doc.Validate(context.Background())).To(Succeed())
+	})
+
+	It("should be served to the Swagger UI", func() {
+		req, _ := http.NewRequest(http.MethodGet, "/swagger/doc.json", nil)
+		// the Swagger UI handler routes by the raw request URI
+		req.RequestURI = "/swagger/doc.json"
+		resp := ExecuteRequest(logger, req, repo)
+
+		Expect(resp.Code).To(Equal(http.StatusOK))
+
+		spec, err := Deserialize(resp.Body.String())
+		Expect(err).To(BeNil())
+		Expect(spec["openapi"]).To(HavePrefix("3."))
+		Expect(spec["servers"]).To(Equal([]any{map[string]any{"url": "/api/v1"}}))
+		Expect(spec["paths"]).To(HaveKey("/users/{id}"))
+		Expect(spec["paths"]).ToNot(HaveKey("/users{id}"))
+	})
+
+	It("should document the update of a user as answered with 200", func() {
+		doc, err := docs.OpenAPI()
+		Expect(err).To(BeNil())
+
+		update := doc.Paths.Find("/users/{id}").Put
+		Expect(update).ToNot(BeNil())
+		Expect(update.Responses.Status(http.StatusOK)).ToNot(BeNil())
+		Expect(update.Responses.Status(http.StatusCreated)).To(BeNil())
+	})
+
+	Describe("request validation", func() {
+
+		It("should reject an invalid query parameter", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/users?limit=many", nil)
+			resp := ExecuteRequest(logger, req, repo, validate)
+
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(resp.Body.String()).To(ContainSubstring(`'limit' is invalid`))
+		})
+
+		It("should reject a body missing a required field", func() {
+			req, _ := http.NewRequest(http.MethodPost, "/api/v1/users", bytes.NewBufferString(`{"user_name": "asmith"}`))
+			req.Header.Add("Content-Type", "application/json")
+			resp := ExecuteRequest(logger, req, repo, validate)
+
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(resp.Body.String()).To(ContainSubstring(`'email' is required`))
+		})
+
+		It("should reject a body of a media type the operation doesn't accept with 415", func() {
+			req, _ := http.NewRequest(http.MethodPatch, "/api/v1/users/1", bytes.NewBufferString(`{"department": "Sales"}`))
+			req.Header.Add("Content-Type", "application/json")
+			resp := ExecuteRequest(logger, req, repo, validate)
+
+			Expect(resp.Code).To(Equal(http.StatusUnsupportedMediaType))
+			Expect(resp.Body.String()).To(ContainSubstring("application/merge-patch+json"))
+			Expect(resp.Body.String()).To(ContainSubstring("application/json-patch+json"))
+		})
+
+		It("should let valid requests through, read-only fields included", func() {
+			body := `{"id": 1, "user_name": "jdoe", "first_name": "Johnny", "last_name": "Doe", "email": "jdoe@example.com", "user_status": "A", "version": 1}`
+			req, _ := http.NewRequest(http.MethodPut, "/api/v1/users/1", bytes.NewBufferString(body))
+			req.Header.Add("Content-Type", "application/json")
+			resp := ExecuteRequest(logger, req, repo, validate)
+
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Header().Get("ETag")).To(Equal(`"2"`))
+
+			req, _ = http.NewRequest(http.MethodPatch, "/api/v1/users/1", bytes.NewBufferString(`[{"op": "replace", "path": "/department", "value": "Sales"}]`))
+			req.Header.Add("Content-Type", "application/json-patch+json")
+			resp = ExecuteRequest(logger, req, repo, validate)
+
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("should leave the CSV and multipart bodies to the handlers", func() {
+			csvBody := "user_name,first_name,last_name,email\nasmith,Alice,Smith,asmith@example.com\n"
+
+			var buf bytes.Buffer
+			form := multipart.NewWriter(&buf)
+			part, _ := form.CreateFormFile("file", "hires.csv")
+			_, _ = part.Write([]byte("user_name,first_name,last_name,email\nbjones,Bob,Jones,bjones@example.com\n"))
+			_ = form.Close()
+
+			for body, contentType := range map[string]string{csvBody: handler.MIMETextCSV, buf.String(): form.FormDataContentType()} {
+				req, _ := http.NewRequest(http.MethodPost, "/api/v1/users/import", strings.NewReader(body))
+				req.Header.Add("Content-Type", contentType)
+				resp := ExecuteRequest(logger, req, repo, validate)
+
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(resp.Body.String()).To(ContainSubstring(`"imported":1`))
+			}
+		})
+
+		It("should leave the endpoints outside the spec alone", func() {
+			req, _ := http.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query": "{ user(id: 1) { userName } }"}`))
+			req.Header.Add("Content-Type", "application/json")
+			resp := ExecuteRequest(logger, req, repo, validate)
+
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Body.String()).To(ContainSubstring(`"userName":"jdoe"`))
+		})
+
+	})
+
+	Describe("response validation", func() {
+
+		It("should replace a response that doesn't match the spec with 500", func() {
+			e := router.Router(logger, repo, validate)
+			// shadows /users/by-username/{name} with a handler drifting from it
+			e.GET("/api/v1/users/by-username/drift", func(c echo.Context) error {
+				return c.JSON(http.StatusOK, map[string]any{"id": "one", "user_name": 1})
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/by-username/drift", nil)
+			resp := httptest.NewRecorder()
+			e.ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusInternalServerError))
+			Expect(resp.Body.String()).To(ContainSubstring("Response doesn't match the API spec"))
+		})
+
+		It("should replace a response of an undocumented status with 500", func() {
+			e := router.Router(logger, repo, validate)
+			e.GET("/api/v1/users/by-username/teapot", func(c echo.Context) error {
+				return echo.NewHTTPError(http.StatusTeapot, "I'm a teapot")
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/by-username/teapot", nil)
+			resp := httptest.NewRecorder()
+			e.ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusInternalServerError))
+		})
+
+	})
+})
@@ -0,0 +1,476 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/handler"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SCIMHandler", func() {
+	Describe("with memory storage", Ordered, func() {
+		scimHandlerSpecs(&MemoryStorage{})
+	})
+
+	Describe("with sqlite storage", Ordered, func() {
+		scimHandlerSpecs(&DatabaseStorage{
+			Database: &config.Database{Driver: "sqlite", Name: filepath.Join(os.TempDir(), "scim_handler_test.db")},
+		})
+	})
+})
+
+func scimHandlerSpecs(testStorage TestStorage) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	var (
+		repo  storage.UserRepository
+		alice *model.User
+		bob   *model.User
+	)
+
+	BeforeAll(func() {
+		testStorage.Setup(logger)
+	})
+
+	AfterAll(func() {
+		testStorage.Teardown()
+	})
+
+	BeforeEach(func() {
+		repo = testStorage.Fresh()
+
+		alice = &model.User{UserName: "alice", FirstName: "Alice", LastName: "Smith", Email: "alice@example.com", Status: "A", Department: "Sales"}
+		bob = &model.User{UserName: "bob", FirstName: "Bob", LastName: "Jones", Email: "bob@corp.example.com", Status: "T"}
+
+		for _, user := range []*model.User{alice, bob} {
+			if err := repo.Create(context.Background(), user); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	base := "/scim/v2"
+
+	request := func(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, base+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", handler.MIMESCIM)
+
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+
+		return ExecuteRequest(logger, req, repo)
+	}
+
+	decode := func(resp *httptest.ResponseRecorder, v any) {
+		Expect(resp.Header().Get("Content-Type")).To(Equal(handler.MIMESCIM))
+		Expect(json.Unmarshal(resp.Body.Bytes(), v)).To(Succeed())
+	}
+
+	Describe("List", func() {
+		list := func(filter string, query ...string) handler.SCIMListResponse {
+			values := url.Values{}
+			if filter != "" {
+				values.Set("filter", filter)
+			}
+
+			for i := 0; i+1 < len(query); i += 2 {
+				values.Set(query[i], query[i+1])
+			}
+
+			resp := request(http.MethodGet, "/Users?"+values.Encode(), "")
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			var page handler.SCIMListResponse
+			decode(resp, &page)
+
+			return page
+		}
+
+		userNames := func(page handler.SCIMListResponse) []string {
+			names := []string{}
+			for _, resource := range page.Resources {
+				names = append(names, resource.(map[string]any)["userName"].(string))
+			}
+
+			return names
+		}
+
+		It("should map users onto the SCIM user schema", func() {
+			page := list(`userName eq "ALICE"`)
+			Expect(page.TotalResults).To(Equal(1))
+
+			body, _ := json.Marshal(page.Resources[0])
+
+			var user handler.SCIMUser
+			Expect(json.Unmarshal(body, &user)).To(Succeed())
+
+			active := true
+			Expect(user).To(Equal(handler.SCIMUser{
+				Schemas:    []string{handler.SCIMUserSchema, handler.SCIMEnterpriseSchema},
+				ID:         fmt.Sprint(alice.UserID),
+				UserName:   "alice",
+				Name:       &handler.SCIMName{GivenName: "Alice", FamilyName: "Smith"},
+				Emails:     []handler.SCIMEmail{{Value: "alice@example.com", Type: "work", Primary: true}},
+				Active:     &active,
+				Enterprise: &handler.SCIMEnterprise{Department: "Sales"},
+				Meta:       &handler.SCIMMeta{ResourceType: "User", Location: fmt.Sprintf("/scim/v2/Users/%d", alice.UserID), Version: `W/"1"`},
+			}))
+		})
+
+		DescribeTable("should filter users",
+			func(filter string, expected ...string) {
+				Expect(userNames(list(filter))).To(Equal(append([]string{}, expected...)))
+			},
+			Entry("all", "", "alice", "bob"),
+			Entry("unknown userName", `userName eq "carol"`),
+			Entry("active", `active eq true`, "alice"),
+			Entry("inactive", `active eq false`, "bob"),
+			Entry("starts with", `name.familyName sw "jo"`, "bob"),
+			Entry("email value filter", `emails[type eq "work" and value ew "@corp.example.com"]`, "bob"),
+			Entry("department", `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department eq "Sales"`, "alice"),
+			Entry("present", `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department pr`, "alice"),
+			Entry("qualified core attribute", `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bob"`, "bob"),
+			Entry("or", `userName eq "alice" or userName eq "bob"`, "alice", "bob"),
+			Entry("not", `not (userName co "li")`, "bob"),
+			Entry("grouping", `(userName eq "alice" or active eq false) and name.givenName sw "B"`, "bob"),
+			Entry("email", `emails.value eq "BOB@corp.example.com"`, "bob"),
+			Entry("family name and active", `name.familyName eq "smith" and active eq true`, "alice"),
+			Entry("not inactive", `active ne false`, "alice"),
+			Entry("pushed down and tested", `active eq false and userName co "o"`, "bob"),
+			Entry("contradiction", `userName eq "alice" and userName eq "bob"`),
+		)
+
+		It("should page the users", func() {
+			page := list("", "startIndex", "2", "count", "1")
+			Expect(page.TotalResults).To(Equal(2))
+			Expect(page.StartIndex).To(Equal(2))
+			Expect(page.ItemsPerPage).To(Equal(1))
+			Expect(userNames(page)).To(Equal([]string{"bob"}))
+		})
+
+		It("should count the filtered users without listing them", func() {
+			page := list(`active eq false`, "count", "0")
+			Expect(page.TotalResults).To(Equal(1))
+			Expect(page.ItemsPerPage).To(Equal(0))
+		})
+
+		It("should get a 400 response when a filter has to be tested on too many users", func() {
+			ops := []storage.BatchOp{}
+			for i := range 10000 {
+				user := &model.User{UserName: fmt.Sprintf("user%d", i), FirstName: "User", LastName: "Test", Email: fmt.Sprintf("user%d@example.com", i), Status: "A"}
+				ops = append(ops, storage.BatchOp{Op: storage.OpCreate, User: user})
+			}
+
+			_, err := repo.Batch(context.Background(), ops, storage.BatchOptions{Atomic: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := request(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName co "9999"`), "")
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(resp.Body.String()).To(ContainSubstring(`"scimType":"tooMany"`))
+
+			page := list(`userName eq "user9999"`)
+			Expect(userNames(page)).To(Equal([]string{"user9999"}))
+		})
+
+		DescribeTable("should get a 400 response for an invalid filter",
+			func(filter string) {
+				resp := request(http.MethodGet, "/Users?filter="+url.QueryEscape(filter), "")
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+
+				var scimErr handler.SCIMError
+				decode(resp, &scimErr)
+				Expect(scimErr.Schemas).To(Equal([]string{handler.SCIMErrorSchema}))
+				Expect(scimErr.Status).To(Equal("400"))
+				Expect(scimErr.SCIMType).To(Equal("invalidFilter"))
+			},
+			Entry("unknown attribute", `nickName eq "al"`),
+			Entry("unknown operator", `userName like "al"`),
+			Entry("bool compared with a string", `active eq "true"`),
+			Entry("unbalanced parenthesis", `(userName eq "alice"`),
+			Entry("unterminated string", `userName eq "alice`),
+		)
+	})
+
+	Describe("Get", func() {
+		It("should get a user tagged with its version", func() {
+			resp := request(http.MethodGet, fmt.Sprintf("/Users/%d", bob.UserID), "")
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Header().Get("ETag")).To(Equal(`W/"1"`))
+
+			var user handler.SCIMUser
+			decode(resp, &user)
+			Expect(*user.Active).To(BeFalse())
+			Expect(user.Enterprise).To(BeNil())
+		})
+
+		It("should get a 404 response for an unknown user", func() {
+			for _, id := range []string{"0", "999", "alice"} {
+				resp := request(http.MethodGet, "/Users/"+id, "")
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+
+				var scimErr handler.SCIMError
+				decode(resp, &scimErr)
+				Expect(scimErr.Status).To(Equal("404"))
+			}
+		})
+	})
+
+	Describe("Create", func() {
+		It("should provision an active user", func() {
+			resp := request(http.MethodPost, "/Users", `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"],
+				"userName": "carol",
+				"name": {"givenName": "Carol", "familyName": "White"},
+				"emails": [{"value": "carol@home.example.com", "type": "home"}, {"value": "carol@example.com", "type": "work", "primary": true}],
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "Finance"}
+			}`)
+			Expect(resp.Code).To(Equal(http.StatusCreated))
+
+			var created handler.SCIMUser
+			decode(resp, &created)
+			Expect(resp.Header().Get("Location")).To(Equal("/scim/v2/Users/" + created.ID))
+
+			user, err := repo.GetByUserName(context.Background(), "carol")
+			Expect(err).To(BeNil())
+			Expect(*user).To(Equal(model.User{
+				UserID: user.UserID, UserName: "carol", FirstName: "Carol", LastName: "White",
+				Email: "carol@example.com", Status: "A", Department: "Finance", Version: 1,
+			}))
+		})
+
+		It("should provision an inactive user", func() {
+			resp := request(http.MethodPost, "/Users", `{"userName": "carol", "name": {"givenName": "Carol", "familyName": "White"}, "emails": [{"value": "carol@example.com"}], "active": false}`)
+			Expect(resp.Code).To(Equal(http.StatusCreated))
+
+			user, err := repo.GetByUserName(context.Background(), "carol")
+			Expect(err).To(BeNil())
+			Expect(user.Status).To(Equal("I"))
+		})
+
+		It("should get a 409 response for a taken userName", func() {
+			resp := request(http.MethodPost, "/Users", `{"userName": "Alice", "name": {"givenName": "Alice", "familyName": "Smith"}, "emails": [{"value": "other@example.com"}]}`)
+			Expect(resp.Code).To(Equal(http.StatusConflict))
+
+			var scimErr handler.SCIMError
+			decode(resp, &scimErr)
+			Expect(scimErr.SCIMType).To(Equal("uniqueness"))
+		})
+
+		It("should get a 400 response naming the SCIM attributes", func() {
+			resp := request(http.MethodPost, "/Users", `{"userName": "carol", "emails": [{"value": "not-an-email"}]}`)
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+
+			var scimErr handler.SCIMError
+			decode(resp, &scimErr)
+			Expect(scimErr.SCIMType).To(Equal("invalidValue"))
+			Expect(scimErr.Detail).To(ContainSubstring("'name.givenName' is empty"))
+			Expect(scimErr.Detail).To(ContainSubstring("'emails' is invalid"))
+		})
+
+		It("should get a 400 response for a body that isn't JSON", func() {
+			resp := request(http.MethodPost, "/Users", `userName=carol`)
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("Replace", func() {
+		It("should replace every attribute", func() {
+			resp := request(http.MethodPut, fmt.Sprintf("/Users/%d", alice.UserID), `{
+				"userName": "alice.smith",
+				"name": {"givenName": "Alice", "familyName": "Brown"},
+				"emails": [{"value": "alice.brown@example.com", "primary": true}]
+			}`, "If-Match", `W/"1"`)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Header().Get("ETag")).To(Equal(`W/"2"`))
+
+			user, err := repo.Get(context.Background(), alice.UserID)
+			Expect(err).To(BeNil())
+			Expect(user.UserName).To(Equal("alice.smith"))
+			Expect(user.LastName).To(Equal("Brown"))
+			Expect(user.Department).To(BeEmpty())
+			Expect(user.Status).To(Equal("A"))
+		})
+
+		It("should get a 412 response when If-Match is stale", func() {
+			resp := request(http.MethodPut, fmt.Sprintf("/Users/%d", alice.UserID), `{
+				"userName": "alice", "name": {"givenName": "Alice", "familyName": "Smith"}, "emails": [{"value": "alice@example.com"}]
+			}`, "If-Match", `W/"7"`)
+			Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+		})
+	})
+
+	Describe("Patch", func() {
+		patch := func(id int64, operations string) *httptest.ResponseRecorder {
+			return request(http.MethodPatch, fmt.Sprintf("/Users/%d", id),
+				fmt.Sprintf(`{"schemas": [%q], "Operations": %s}`, handler.SCIMPatchSchema, operations))
+		}
+
+		It("should apply operations with paths", func() {
+			resp := patch(alice.UserID, `[
+				{"op": "replace", "path": "name.familyName", "value": "Brown"},
+				{"op": "Replace", "path": "emails[type eq \"work\"].value", "value": "alice.brown@example.com"},
+				{"op": "remove", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department"}
+			]`)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			user, err := repo.Get(context.Background(), alice.UserID)
+			Expect(err).To(BeNil())
+			Expect(user.LastName).To(Equal("Brown"))
+			Expect(user.Email).To(Equal("alice.brown@example.com"))
+			Expect(user.Department).To(BeEmpty())
+			Expect(user.Version).To(BeEquivalentTo(2))
+		})
+
+		It("should apply an operation without a path", func() {
+			resp := patch(alice.UserID, `[{"op": "replace", "value": {
+				"active": "False",
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "Finance"}
+			}}]`)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			var patched handler.SCIMUser
+			decode(resp, &patched)
+			Expect(*patched.Active).To(BeFalse())
+			Expect(patched.Enterprise.Department).To(Equal("Finance"))
+
+			user, err := repo.Get(context.Background(), alice.UserID)
+			Expect(err).To(BeNil())
+			Expect(user.Status).To(Equal("I"))
+		})
+
+		It("should keep the status of a user that isn't active", func() {
+			Expect(patch(bob.UserID, `[{"op": "replace", "path": "active", "value": false}]`).Code).To(Equal(http.StatusOK))
+
+			user, err := repo.Get(context.Background(), bob.UserID)
+			Expect(err).To(BeNil())
+			Expect(user.Status).To(Equal("T"))
+
+			Expect(patch(bob.UserID, `[{"op": "replace", "path": "active", "value": true}]`).Code).To(Equal(http.StatusOK))
+
+			user, err = repo.Get(context.Background(), bob.UserID)
+			Expect(err).To(BeNil())
+			Expect(user.Status).To(Equal("A"))
+		})
+
+		DescribeTable("should get a 400 response",
+			func(operations, scimType string) {
+				resp := patch(alice.UserID, operations)
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+
+				var scimErr handler.SCIMError
+				decode(resp, &scimErr)
+				Expect(scimErr.SCIMType).To(Equal(scimType))
+
+				user, err := repo.Get(context.Background(), alice.UserID)
+				Expect(err).To(BeNil())
+				Expect(user.Version).To(BeEquivalentTo(1))
+			},
+			Entry("no operations", `[]`, "invalidValue"),
+			Entry("unknown operation", `[{"op": "move", "path": "userName", "value": "al"}]`, "invalidSyntax"),
+			Entry("unknown path", `[{"op": "replace", "path": "nickName", "value": "al"}]`, "invalidPath"),
+			Entry("remove without a path", `[{"op": "remove"}]`, "noTarget"),
+			Entry("wrong type", `[{"op": "replace", "path": "userName", "value": 5}]`, "invalidValue"),
+			Entry("required attribute removed", `[{"op": "remove", "path": "userName"}]`, "invalidValue"),
+		)
+
+		It("should get a 409 response when patching in a taken email", func() {
+			resp := patch(alice.UserID, `[{"op": "replace", "path": "emails", "value": [{"value": "BOB@corp.example.com"}]}]`)
+			Expect(resp.Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should get a 404 response for an unknown user", func() {
+			Expect(patch(999, `[{"op": "replace", "path": "active", "value": true}]`).Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("should remove the user for good", func() {
+			resp := request(http.MethodDelete, fmt.Sprintf("/Users/%d", alice.UserID), "")
+			Expect(resp.Code).To(Equal(http.StatusNoContent))
+
+			_, err := repo.Get(context.Background(), alice.UserID)
+			Expect(err).To(MatchError(storage.ErrUserNotFound))
+
+			_, err = repo.Restore(context.Background(), alice.UserID)
+			Expect(err).To(MatchError(storage.ErrUserNotFound))
+
+			Expect(request(http.MethodDelete, fmt.Sprintf("/Users/%d", alice.UserID), "").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should keep the user when If-Match is stale", func() {
+			resp := request(http.MethodDelete, fmt.Sprintf("/Users/%d", alice.UserID), "", "If-Match", `W/"7"`)
+			Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+
+			_, err := repo.Get(context.Background(), alice.UserID)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp = request(http.MethodDelete, fmt.Sprintf("/Users/%d", alice.UserID), "", "If-Match", `W/"1"`)
+			Expect(resp.Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("should let the user be provisioned again", func() {
+			Expect(request(http.MethodDelete, fmt.Sprintf("/Users/%d", alice.UserID), "").Code).To(Equal(http.StatusNoContent))
+
+			resp := request(http.MethodPost, "/Users", `{"userName": "alice", "name": {"givenName": "Alice", "familyName": "Smith"}, "emails": [{"value": "alice@example.com"}]}`)
+			Expect(resp.Code).To(Equal(http.StatusCreated))
+
+			resp = request(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq "alice"`), "")
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			var page handler.SCIMListResponse
+			decode(resp, &page)
+			Expect(page.TotalResults).To(Equal(1))
+		})
+	})
+
+	Describe("Discovery", func() {
+		It("should tell the supported features", func() {
+			resp := request(http.MethodGet, "/ServiceProviderConfig", "")
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			var config map[string]any
+			decode(resp, &config)
+			Expect(config["patch"]).To(Equal(map[string]any{"supported": true}))
+			Expect(config["filter"]).To(HaveKeyWithValue("supported", true))
+		})
+
+		It("should list the resource types and schemas", func() {
+			for path, id := range map[string]string{"/ResourceTypes": "User", "/Schemas": handler.SCIMUserSchema} {
+				resp := request(http.MethodGet, path, "")
+				Expect(resp.Code).To(Equal(http.StatusOK))
+
+				var page handler.SCIMListResponse
+				decode(resp, &page)
+				Expect(page.TotalResults).To(Equal(len(page.Resources)))
+				Expect(page.Resources[0]).To(HaveKeyWithValue("id", id))
+
+				resp = request(http.MethodGet, path+"/"+id, "")
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			}
+		})
+
+		It("should get a 404 response for an unknown schema", func() {
+			resp := request(http.MethodGet, "/Schemas/urn:example:unknown", "")
+			Expect(resp.Code).To(Equal(http.StatusNotFound))
+
+			var scimErr handler.SCIMError
+			decode(resp, &scimErr)
+			Expect(scimErr.Status).To(Equal("404"))
+		})
+	})
+}
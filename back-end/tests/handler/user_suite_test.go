@@ -1,6 +1,8 @@
 package handler_test
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -8,6 +10,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/andrii-stp/users-crud/config"
 	"github.com/andrii-stp/users-crud/router"
 	"github.com/andrii-stp/users-crud/storage"
 	. "github.com/onsi/ginkgo/v2"
@@ -19,8 +22,13 @@ func TestHandler(t *testing.T) {
 	RunSpecs(t, "User Handler Suite")
 }
 
-func ExecuteRequest(logger *slog.Logger, req *http.Request, repo storage.UserRepository) *httptest.ResponseRecorder {
-	r := router.Router(logger, repo)
+// ExecuteRequest serves req, checking the response against the OpenAPI spec
+// so that the spec can't drift from the handlers
+func ExecuteRequest(logger *slog.Logger, req *http.Request, repo storage.UserRepository, opts ...router.Option) *httptest.ResponseRecorder {
+	r := router.Router(logger, repo, append([]router.Option{
+		router.WithCursorSecret([]byte("test-secret")),
+		router.WithOpenAPIValidation(router.OpenAPIValidation{Responses: true}),
+	}, opts...)...)
 	nr := httptest.NewRecorder()
 
 	r.ServeHTTP(nr, req)
@@ -38,10 +46,91 @@ func Deserialize(d string) (map[string]interface{}, error) {
 }
 
 func DeserializeList(d string) ([]map[string]interface{}, error) {
-	var l []map[string]interface{}
-	if err := json.Unmarshal([]byte(d), &l); err != nil {
+	var p struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(d), &p); err != nil {
 		return nil, fmt.Errorf("failed to deserialize a list. %w", err)
 	}
 
-	return l, nil
+	return p.Items, nil
+}
+
+// TestStorage is a storage backend the handler specs run against
+type TestStorage interface {
+	// Setup prepares the backend once, skipping the specs when it's unavailable
+	Setup(logger *slog.Logger)
+	// Fresh returns a repository holding no users
+	Fresh() storage.UserRepository
+	Teardown()
+}
+
+type MemoryStorage struct{}
+
+func (ms *MemoryStorage) Setup(*slog.Logger) {}
+
+func (ms *MemoryStorage) Fresh() storage.UserRepository {
+	return storage.NewMemoryRepository()
+}
+
+func (ms *MemoryStorage) Teardown() {}
+
+// DatabaseStorage runs the specs against Database, or the database configured
+// in EnvFile when it's nil
+type DatabaseStorage struct {
+	EnvFile  string
+	Database *config.Database
+
+	db       *sql.DB
+	migrator *storage.Migrator
+	repo     storage.UserRepository
+}
+
+func (ds *DatabaseStorage) Setup(logger *slog.Logger) {
+	if ds.Database == nil {
+		cfg, err := config.Load(ds.EnvFile)
+		if err != nil {
+			panic(fmt.Errorf("failed to load config. %w", err))
+		}
+
+		ds.Database = cfg.Database
+	}
+
+	var err error
+
+	if ds.db, err = storage.Connect(ds.Database); err != nil {
+		Skip(fmt.Sprintf("database is unavailable: %v", err))
+	}
+
+	if ds.migrator, err = storage.NewMigrator(logger, ds.db, ds.Database.Driver); err != nil {
+		panic(fmt.Errorf("failed to load migrations. %w", err))
+	}
+
+	if err = ds.migrator.Up(context.Background()); err != nil {
+		panic(fmt.Errorf("failed to migrate database. %w", err))
+	}
+
+	if ds.repo, err = storage.NewRepository(logger, ds.db, ds.Database.Driver); err != nil {
+		panic(fmt.Errorf("failed to create repository. %w", err))
+	}
+}
+
+func (ds *DatabaseStorage) Fresh() storage.UserRepository {
+	if _, err := ds.db.Exec("DELETE FROM user_audit; DELETE FROM user_events; DELETE FROM webhook_deliveries; DELETE FROM webhooks; DELETE FROM users_history; DELETE FROM users;"); err != nil {
+		panic(fmt.Errorf("failed to delete users. %w", err))
+	}
+
+	return ds.repo
+}
+
+func (ds *DatabaseStorage) Teardown() {
+	if ds.migrator == nil {
+		return
+	}
+
+	if err := ds.migrator.Goto(context.Background(), 0); err != nil {
+		panic(fmt.Errorf("failed to revert migrations. %w", err))
+	}
+
+	ds.db.Close()
 }
@@ -1,58 +1,86 @@
 package handler_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/handler"
 	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/router"
 	"github.com/andrii-stp/users-crud/storage"
 
-	sq "github.com/Masterminds/squirrel"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
 
-var _ = Describe("UserHandler", Ordered, func() {
-	cfg, err := config.Load("../test.env")
-	if err != nil {
-		panic(fmt.Errorf("failed to load config. %w", err))
-	}
+var _ = Describe("UserHandler", func() {
+	Describe("with memory storage", Ordered, func() {
+		userHandlerSpecs(&MemoryStorage{})
+	})
 
-	db, err := storage.Connect(cfg.Database)
-	if err != nil {
-		panic(fmt.Errorf("failed to connect to database. %w", err))
-	}
+	Describe("with sqlite storage", Ordered, func() {
+		userHandlerSpecs(&DatabaseStorage{
+			Database: &config.Database{Driver: "sqlite", Name: filepath.Join(os.TempDir(), "users_handler_test.db")},
+		})
+	})
+
+	Describe("with mysql storage", Ordered, func() {
+		userHandlerSpecs(&DatabaseStorage{
+			Database: &config.Database{
+				Driver:   "mysql",
+				Host:     "localhost:3306",
+				User:     "root",
+				Password: "mysql",
+				Name:     "users_test",
+				SSLMode:  "disable",
+			},
+		})
+	})
 
+	Describe("with database storage", Ordered, func() {
+		userHandlerSpecs(&DatabaseStorage{EnvFile: "../test.env"})
+	})
+})
+
+func userHandlerSpecs(testStorage TestStorage) {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
-	repo := storage.NewPostgresRepository(logger, db)
+	var repo storage.UserRepository
 
 	BeforeAll(func() {
-		if err := storage.InitDB(db); err != nil {
-			panic(fmt.Errorf("failed to create table users. %w", err))
-		}
+		testStorage.Setup(logger)
 	})
 
 	AfterAll(func() {
-		if _, err := db.Exec(`
-		DROP TABLE IF EXISTS users;
-		`); err != nil {
-			panic(fmt.Errorf("failed to drop table users. %w", err))
-		}
+		testStorage.Teardown()
 	})
 
+	insertUser := func(u *model.User) {
+		if err := repo.Create(context.Background(), u); err != nil {
+			panic(err)
+		}
+	}
+
 	var user *model.User
 
 	BeforeEach(func() {
-		if _, err := db.Exec("DELETE FROM users;"); err != nil {
-			panic(fmt.Errorf("failed to delete users. %w", err))
-		}
+		repo = testStorage.Fresh()
 
 		user = &model.User{
 			UserName:   "JohnDoe",
@@ -63,30 +91,23 @@ var _ = Describe("UserHandler", Ordered, func() {
 			Department: "Accounts",
 		}
 
-		err = sq.StatementBuilder.Insert("users").
-			Columns("user_name", "first_name", "last_name", "email", "user_status", "department").
-			Values(user.UserName, user.FirstName, user.LastName, user.Email, user.Status, user.Department).
-			Suffix("RETURNING *").PlaceholderFormat(sq.Dollar).RunWith(db).QueryRow().
-			Scan(&user.UserID, &user.UserName, &user.FirstName, &user.LastName, &user.Email, &user.Status, &user.Department)
-
-		if err != nil {
-			panic(err)
-		}
-	})
-
-	AfterEach(func() {
-		if _, err := db.Exec("DELETE FROM users;"); err != nil {
-			panic(fmt.Errorf("failed to delete users. %w", err))
-		}
+		insertUser(user)
 	})
 
 	url := "/api/v1/users"
 
 	Describe("List", func() {
-		var resp *httptest.ResponseRecorder
+		var (
+			resp  *httptest.ResponseRecorder
+			query string
+		)
+
+		BeforeEach(func() {
+			query = ""
+		})
 
 		JustBeforeEach(func() {
-			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req, _ := http.NewRequest(http.MethodGet, url+query, nil)
 			resp = ExecuteRequest(logger, req, repo)
 		})
 
@@ -117,6 +138,309 @@ var _ = Describe("UserHandler", Ordered, func() {
 
 		})
 
+		Context("with several users", func() {
+
+			BeforeEach(func() {
+				insertUser(&model.User{
+					UserName:   "Kirby",
+					FirstName:  "Kir",
+					LastName:   "By",
+					Email:      "kirby@nintendo.com",
+					Status:     "T",
+					Department: "Explorer",
+				})
+				insertUser(&model.User{
+					UserName:   "Pikachu",
+					FirstName:  "Pika",
+					LastName:   "Chu",
+					Email:      "pikachu@nintendo.com",
+					Status:     "I",
+					Department: "Pokemon",
+				})
+			})
+
+			Context("should paginate with limit and offset", func() {
+
+				BeforeEach(func() {
+					query = "?limit=1&offset=1"
+				})
+
+				It("body should have a single item, the total and page links", func() {
+					page, err := Deserialize(resp.Body.String())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(page["items"]).To(HaveLen(1))
+					Expect(page["total"]).To(Equal(float64(3)))
+					Expect(page["limit"]).To(Equal(float64(1)))
+					Expect(page["offset"]).To(Equal(float64(1)))
+
+					links := page["links"].(map[string]interface{})
+					Expect(links["next"]).To(Equal(url + "?limit=1&offset=2"))
+					Expect(links["prev"]).To(Equal(url + "?limit=1&offset=0"))
+				})
+
+			})
+
+			Context("should filter by status, department and email domain", func() {
+
+				BeforeEach(func() {
+					query = "?email_domain=Nintendo.com&department=Pokemon&user_status=I"
+				})
+
+				It("body should only have matching users", func() {
+					l, err := DeserializeList(resp.Body.String())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(l).To(HaveLen(1))
+					Expect(l[0]["user_name"]).To(Equal("Pikachu"))
+				})
+
+			})
+
+			Context("should sort by the requested columns", func() {
+
+				BeforeEach(func() {
+					query = "?sort=-user_status,last_name"
+				})
+
+				It("body should have users in order", func() {
+					l, err := DeserializeList(resp.Body.String())
+					Expect(err).ToNot(HaveOccurred())
+
+					var names []interface{}
+					for _, e := range l {
+						names = append(names, e["user_name"])
+					}
+					Expect(names).To(Equal([]interface{}{"Kirby", "Pikachu", "JohnDoe"}))
+				})
+
+			})
+
+			Context("should walk every user with cursors", func() {
+
+				BeforeEach(func() {
+					query = "?limit=1&sort=-last_name"
+				})
+
+				It("body should link to the next page until the last one", func() {
+					var names []interface{}
+
+					body := resp.Body.String()
+					for i := 0; i < 3; i++ {
+						page, err := Deserialize(body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(page["items"]).To(HaveLen(1))
+						names = append(names, page["items"].([]interface{})[0].(map[string]interface{})["user_name"])
+
+						links := page["links"].(map[string]interface{})
+						if i == 2 {
+							Expect(page).ToNot(HaveKey("next_cursor"))
+							Expect(links).ToNot(HaveKey("next"))
+							break
+						}
+
+						Expect(page["next_cursor"]).ToNot(BeEmpty())
+
+						next := fmt.Sprintf("%s?cursor=%s&limit=1&sort=-last_name", url, page["next_cursor"])
+						if i > 0 {
+							Expect(page).ToNot(HaveKey("total"))
+							next = links["next"].(string)
+						}
+
+						req, _ := http.NewRequest(http.MethodGet, next, nil)
+						body = ExecuteRequest(logger, req, repo).Body.String()
+					}
+
+					Expect(names).To(Equal([]interface{}{"JohnDoe", "Pikachu", "Kirby"}))
+				})
+
+			})
+
+			Context("should get a 400 response when the cursor was tampered with", func() {
+
+				BeforeEach(func() {
+					query = "?cursor=eyJrIjoidXNlcl9pZCIsImlkIjoxfQ.c2lnbmF0dXJl"
+				})
+
+				It("status code should be 400", func() {
+					Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				})
+
+			})
+
+			Context("should return an empty page past the last user", func() {
+
+				BeforeEach(func() {
+					query = "?offset=10"
+				})
+
+				It("body should have no items", func() {
+					page, err := Deserialize(resp.Body.String())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(page["items"]).To(BeEmpty())
+					Expect(page["total"]).To(Equal(float64(3)))
+				})
+
+			})
+
+		})
+
+		Context("should get a 400 response when sorting by an unknown column", func() {
+
+			BeforeEach(func() {
+				query = "?sort=password"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should get a 400 response when limit is out of range", func() {
+
+			BeforeEach(func() {
+				query = "?limit=0"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should get a 400 response when filtering by an invalid status", func() {
+
+			BeforeEach(func() {
+				query = "?user_status=_"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+	})
+
+	Describe("Get", func() {
+		var (
+			resp *httptest.ResponseRecorder
+			path string
+		)
+
+		BeforeEach(func() {
+			path = fmt.Sprintf("%s/%d", url, user.UserID)
+		})
+
+		JustBeforeEach(func() {
+			req, _ := http.NewRequest(http.MethodGet, path, nil)
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		Context("should get a user correctly", func() {
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should have equivalent values", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["id"]).To(Equal(float64(user.UserID)))
+				Expect(e["user_name"]).To(Equal(user.UserName))
+				Expect(e["first_name"]).To(Equal(user.FirstName))
+				Expect(e["last_name"]).To(Equal(user.LastName))
+				Expect(e["email"]).To(Equal(user.Email))
+				Expect(e["user_status"]).To(Equal(user.Status))
+				Expect(e["department"]).To(Equal(user.Department))
+			})
+
+			It("ETag should hold the user version", func() {
+				Expect(resp.Header().Get("ETag")).To(Equal(`"1"`))
+			})
+
+		})
+
+		Context("should get a 404 response when user does not exist", func() {
+
+			BeforeEach(func() {
+				path = url + "/-1"
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+			It("body should contain the error message", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["message"]).To(Equal(storage.ErrUserNotFound.Error()))
+			})
+
+		})
+
+		Context("should get a 400 response when sending request with invalid id", func() {
+
+			BeforeEach(func() {
+				path = url + "/invalid"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+	})
+
+	Describe("GetByUserName", func() {
+		var (
+			resp *httptest.ResponseRecorder
+			name string
+		)
+
+		BeforeEach(func() {
+			name = user.UserName
+		})
+
+		JustBeforeEach(func() {
+			req, _ := http.NewRequest(http.MethodGet, url+"/by-username/"+name, nil)
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		Context("should get a user correctly", func() {
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should have equivalent values", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["id"]).To(Equal(float64(user.UserID)))
+				Expect(e["user_name"]).To(Equal(user.UserName))
+				Expect(e["email"]).To(Equal(user.Email))
+			})
+
+		})
+
+		Context("should get a 404 response when user does not exist", func() {
+
+			BeforeEach(func() {
+				name = "Nobody"
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+			It("body should contain the error message", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["message"]).To(Equal(storage.ErrUserNotFound.Error()))
+			})
+
+		})
+
 	})
 
 	Describe("Create", func() {
@@ -170,6 +494,56 @@ var _ = Describe("UserHandler", Ordered, func() {
 
 		})
 
+		Context("should get an error when create a user with existing user_name in another case", func() {
+
+			BeforeEach(func() {
+				payload = []byte(`{
+					"user_name": "johndoe",
+					"first_name": "Pika",
+					"last_name": "Chu",
+					"email": "pikachu@yahoo.com",
+					"user_status": "I",
+					"department": "Pokemon"
+				}`)
+			})
+
+			It("status code should be 409", func() {
+				Expect(resp.Code).To(Equal(http.StatusConflict))
+			})
+
+			It("body should contain the error message", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["message"]).To(Equal(storage.ErrAlreadyExist.Error()))
+			})
+
+		})
+
+		Context("should get an error when create a user with existing email", func() {
+
+			BeforeEach(func() {
+				payload = []byte(`{
+					"user_name": "Pikachu",
+					"first_name": "Pika",
+					"last_name": "Chu",
+					"email": "JohnDoe@yahoo.com",
+					"user_status": "I",
+					"department": "Pokemon"
+				}`)
+			})
+
+			It("status code should be 409", func() {
+				Expect(resp.Code).To(Equal(http.StatusConflict))
+			})
+
+			It("body should contain the error message", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["message"]).To(Equal(storage.ErrEmailInUse.Error()))
+			})
+
+		})
+
 		Context("should get an error when create a user without user_name", func() {
 
 			BeforeEach(func() {
@@ -246,6 +620,7 @@ var _ = Describe("UserHandler", Ordered, func() {
 			payload      []byte
 			expectedUser *model.User
 			id           int64
+			ifMatch      string
 		)
 
 		BeforeEach(func() {
@@ -263,6 +638,7 @@ var _ = Describe("UserHandler", Ordered, func() {
 			}
 
 			id = user.UserID
+			ifMatch = ""
 		})
 
 		JustBeforeEach(func() {
@@ -270,28 +646,84 @@ var _ = Describe("UserHandler", Ordered, func() {
 
 			req, _ := http.NewRequest(http.MethodPut, path, bytes.NewBuffer(payload))
 			req.Header.Add("Content-Type", "application/json")
+
+			if ifMatch != "" {
+				req.Header.Add("If-Match", ifMatch)
+			}
+
 			resp = ExecuteRequest(logger, req, repo)
 		})
 
-		Context("should update a user correctly", func() {
+		Context("should update a user matching If-Match", func() {
 
-			It("status code should be 200", func() {
-				Expect(resp.Code).To(Equal(http.StatusOK))
+			BeforeEach(func() {
+				ifMatch = `"1"`
 			})
 
-			It("body should not be nil", func() {
-				Expect(resp.Body).ToNot(BeNil())
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
 			})
 
-			It("body should have equivalent values", func() {
-				e, _ := Deserialize(resp.Body.String())
-				Expect(e["id"]).To(Equal(float64(user.UserID)))
-				Expect(e["user_name"]).To(Equal(expectedUser.UserName))
-				Expect(e["first_name"]).To(Equal(expectedUser.FirstName))
-				Expect(e["last_name"]).To(Equal(expectedUser.LastName))
-				Expect(e["email"]).To(Equal(expectedUser.Email))
-				Expect(e["user_status"]).To(Equal(expectedUser.Status))
-				Expect(e["department"]).To(Equal(expectedUser.Department))
+			It("ETag should hold the bumped version", func() {
+				Expect(resp.Header().Get("ETag")).To(Equal(`"2"`))
+			})
+
+		})
+
+		Context("should get a 412 response when If-Match is stale", func() {
+
+			BeforeEach(func() {
+				if _, err := repo.Update(context.Background(), user.UserID, user); err != nil {
+					panic(err)
+				}
+
+				ifMatch = `"1"`
+			})
+
+			It("status code should be 412", func() {
+				Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+			})
+
+			It("user should be left untouched", func() {
+				u, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+				Expect(u.UserName).To(Equal(user.UserName))
+				Expect(u.Version).To(Equal(int64(2)))
+			})
+
+		})
+
+		Context("should get a 400 response when If-Match isn't a version", func() {
+
+			BeforeEach(func() {
+				ifMatch = `"abc"`
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should update a user correctly", func() {
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should not be nil", func() {
+				Expect(resp.Body).ToNot(BeNil())
+			})
+
+			It("body should have equivalent values", func() {
+				e, _ := Deserialize(resp.Body.String())
+				Expect(e["id"]).To(Equal(float64(user.UserID)))
+				Expect(e["user_name"]).To(Equal(expectedUser.UserName))
+				Expect(e["first_name"]).To(Equal(expectedUser.FirstName))
+				Expect(e["last_name"]).To(Equal(expectedUser.LastName))
+				Expect(e["email"]).To(Equal(expectedUser.Email))
+				Expect(e["user_status"]).To(Equal(expectedUser.Status))
+				Expect(e["department"]).To(Equal(expectedUser.Department))
 			})
 
 		})
@@ -332,15 +764,7 @@ var _ = Describe("UserHandler", Ordered, func() {
 					Department: "Explorer",
 				}
 
-				err = sq.StatementBuilder.Insert("users").
-					Columns("user_name", "first_name", "last_name", "email", "user_status", "department").
-					Values(u.UserName, u.FirstName, u.LastName, u.Email, u.Status, u.Department).
-					Suffix("RETURNING *").PlaceholderFormat(sq.Dollar).RunWith(db).QueryRow().
-					Scan(&u.UserID, &u.UserName, &u.FirstName, &u.LastName, &u.Email, &u.Status, &u.Department)
-
-				if err != nil {
-					panic(err)
-				}
+				insertUser(u)
 
 				id = user.UserID
 				payload = []byte(`{
@@ -451,11 +875,192 @@ var _ = Describe("UserHandler", Ordered, func() {
 
 	})
 
+	Describe("Patch", func() {
+		var (
+			resp        *httptest.ResponseRecorder
+			contentType string
+			payload     string
+			id          int64
+			ifMatch     string
+		)
+
+		BeforeEach(func() {
+			contentType = "application/merge-patch+json"
+			payload = `{"department": "Sales"}`
+			id = user.UserID
+			ifMatch = ""
+		})
+
+		JustBeforeEach(func() {
+			path := fmt.Sprintf("%s/%d", url, id)
+
+			req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(payload))
+			req.Header.Add("Content-Type", contentType)
+
+			if ifMatch != "" {
+				req.Header.Add("If-Match", ifMatch)
+			}
+
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		Context("should apply a merge patch", func() {
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should only have the patched field changed", func() {
+				e, err := Deserialize(resp.Body.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(e["department"]).To(Equal("Sales"))
+				Expect(e["user_name"]).To(Equal(user.UserName))
+				Expect(e["email"]).To(Equal(user.Email))
+				Expect(e["user_status"]).To(Equal(user.Status))
+			})
+
+			It("ETag should hold the bumped version", func() {
+				Expect(resp.Header().Get("ETag")).To(Equal(`"2"`))
+			})
+
+		})
+
+		Context("should apply a JSON patch", func() {
+
+			BeforeEach(func() {
+				contentType = "application/json-patch+json"
+				payload = `[
+					{"op": "test", "path": "/user_status", "value": "A"},
+					{"op": "replace", "path": "/user_status", "value": "I"}
+				]`
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("user should be patched", func() {
+				u, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+				Expect(u.Status).To(Equal("I"))
+				Expect(u.Department).To(Equal(user.Department))
+			})
+
+		})
+
+		Context("should get a 422 response when a JSON patch test fails", func() {
+
+			BeforeEach(func() {
+				contentType = "application/json-patch+json"
+				payload = `[
+					{"op": "test", "path": "/user_status", "value": "T"},
+					{"op": "replace", "path": "/user_status", "value": "I"}
+				]`
+			})
+
+			It("status code should be 422", func() {
+				Expect(resp.Code).To(Equal(http.StatusUnprocessableEntity))
+			})
+
+		})
+
+		Context("should get a 400 response when the patched user is invalid", func() {
+
+			BeforeEach(func() {
+				payload = `{"email": "not-an-email", "user_name": null}`
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+			It("user should be left untouched", func() {
+				u, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+				Expect(u.Email).To(Equal(user.Email))
+			})
+
+		})
+
+		Context("should get a 400 response when the merge patch isn't JSON", func() {
+
+			BeforeEach(func() {
+				payload = `{"department": `
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should get a 415 response for a plain JSON body", func() {
+
+			BeforeEach(func() {
+				contentType = "application/json"
+			})
+
+			It("status code should be 415", func() {
+				Expect(resp.Code).To(Equal(http.StatusUnsupportedMediaType))
+			})
+
+		})
+
+		Context("should get a 409 response when patching in a used username", func() {
+
+			BeforeEach(func() {
+				insertUser(&model.User{
+					UserName:   "Kirby",
+					FirstName:  "Kir",
+					LastName:   "By",
+					Email:      "kirby@yahoo.com",
+					Status:     "T",
+					Department: "Explorer",
+				})
+
+				payload = `{"user_name": "kirby"}`
+			})
+
+			It("status code should be 409", func() {
+				Expect(resp.Code).To(Equal(http.StatusConflict))
+			})
+
+		})
+
+		Context("should get a 412 response when If-Match is stale", func() {
+
+			BeforeEach(func() {
+				ifMatch = `"5"`
+			})
+
+			It("status code should be 412", func() {
+				Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+			})
+
+		})
+
+		Context("should get a 404 response when the user does not exist", func() {
+
+			BeforeEach(func() {
+				id = -1
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+		})
+
+	})
+
 	Describe("Delete", func() {
-		var resp *httptest.ResponseRecorder
+		var (
+			resp  *httptest.ResponseRecorder
+			other *model.User
+		)
 
 		BeforeEach(func() {
-			u := &model.User{
+			other = &model.User{
 				UserName:   "Kirby",
 				FirstName:  "Kir",
 				LastName:   "By",
@@ -464,15 +1069,7 @@ var _ = Describe("UserHandler", Ordered, func() {
 				Department: "Explorer",
 			}
 
-			err = sq.StatementBuilder.Insert("users").
-				Columns("user_name", "first_name", "last_name", "email", "user_status", "department").
-				Values(u.UserName, u.FirstName, u.LastName, u.Email, u.Status, u.Department).
-				Suffix("RETURNING *").PlaceholderFormat(sq.Dollar).RunWith(db).QueryRow().
-				Scan(&u.UserID, &u.UserName, &u.FirstName, &u.LastName, &u.Email, &u.Status, &u.Department)
-
-			if err != nil {
-				panic(err)
-			}
+			insertUser(other)
 		})
 
 		JustBeforeEach(func() {
@@ -495,6 +1092,21 @@ var _ = Describe("UserHandler", Ordered, func() {
 
 		})
 
+		Context("should get a 412 response when If-Match is stale", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d", url, other.UserID)
+				req, _ := http.NewRequest(http.MethodDelete, path, nil)
+				req.Header.Add("If-Match", `"7"`)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 412", func() {
+				Expect(resp.Code).To(Equal(http.StatusPreconditionFailed))
+			})
+
+		})
+
 		Context("should get a 400 response when sending request with invalid id", func() {
 
 			JustBeforeEach(func() {
@@ -533,4 +1145,1208 @@ var _ = Describe("UserHandler", Ordered, func() {
 
 	})
 
-})
+	Describe("Trash", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			if err := repo.Delete(context.Background(), user.UserID, 0); err != nil {
+				panic(err)
+			}
+		})
+
+		Context("should list deleted users", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, url+"/trash", nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should hold the deleted user", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(1))
+				Expect(items[0]["user_name"]).To(Equal(user.UserName))
+				Expect(items[0]["deleted_at"]).ToNot(BeEmpty())
+			})
+
+		})
+
+		Context("should leave deleted users out of the listing", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, url, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("body should be empty", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(BeEmpty())
+			})
+
+		})
+
+		Context("should get a 404 response when getting a deleted user", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d", url, user.UserID)
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+		})
+
+		Context("should get a 409 response when reusing the username of a deleted user", func() {
+
+			JustBeforeEach(func() {
+				u := *user
+				u.Email = "other@yahoo.com"
+				body, _ := json.Marshal(u)
+
+				req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+				req.Header.Add("Content-Type", "application/json")
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 409", func() {
+				Expect(resp.Code).To(Equal(http.StatusConflict))
+			})
+
+		})
+
+	})
+
+	Describe("Restore", func() {
+		var (
+			resp *httptest.ResponseRecorder
+			id   int64
+		)
+
+		BeforeEach(func() {
+			id = user.UserID
+
+			if err := repo.Delete(context.Background(), user.UserID, 0); err != nil {
+				panic(err)
+			}
+		})
+
+		JustBeforeEach(func() {
+			path := fmt.Sprintf("%s/%d/restore", url, id)
+			req, _ := http.NewRequest(http.MethodPost, path, nil)
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		Context("should restore a deleted user", func() {
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should have equivalent values", func() {
+				u, err := Deserialize(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(u["user_name"]).To(Equal(user.UserName))
+				Expect(u).ToNot(HaveKey("deleted_at"))
+			})
+
+			It("user should be back in the listing", func() {
+				_, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+			})
+
+		})
+
+		Context("should get a 404 response when the user isn't deleted", func() {
+
+			BeforeEach(func() {
+				if _, err := repo.Restore(context.Background(), user.UserID); err != nil {
+					panic(err)
+				}
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+		})
+
+		Context("should get a 404 response when the user doesn't exist", func() {
+
+			BeforeEach(func() {
+				id = -1
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+		})
+
+	})
+
+	Describe("Batch", func() {
+		var (
+			resp  *httptest.ResponseRecorder
+			body  string
+			query string
+		)
+
+		BeforeEach(func() {
+			query = ""
+		})
+
+		JustBeforeEach(func() {
+			req, _ := http.NewRequest(http.MethodPost, url+":batch"+query, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		results := func() []map[string]interface{} {
+			var b struct {
+				Results []map[string]interface{} `json:"results"`
+			}
+			Expect(json.Unmarshal(resp.Body.Bytes(), &b)).To(Succeed())
+
+			return b.Results
+		}
+
+		Context("should apply every valid operation in best-effort mode", func() {
+
+			BeforeEach(func() {
+				body = fmt.Sprintf(`{"operations":[
+					{"op":"create","user":{"user_name":"alice","first_name":"Alice","last_name":"Smith","email":"alice@example.com","user_status":"A","department":"Sales"}},
+					{"op":"create","user":{"user_name":"bob","first_name":"Bob","last_name":"Smith","email":"not-an-email","user_status":"A"}},
+					{"op":"update","id":%[1]d,"user":{"user_name":"JohnDoe","first_name":"John","last_name":"Doe","email":"john@example.com","user_status":"I"}},
+					{"op":"delete","id":%[1]d,"version":1}
+				]}`, user.UserID)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should hold the status of every operation", func() {
+				r := results()
+				Expect(r).To(HaveLen(4))
+				Expect(r[0]["status"]).To(BeEquivalentTo(http.StatusCreated))
+				Expect(r[0]["user"]).To(HaveKeyWithValue("user_name", "alice"))
+				Expect(r[1]["status"]).To(BeEquivalentTo(http.StatusBadRequest))
+				Expect(r[1]["error"]).To(ContainSubstring("email"))
+				Expect(r[2]["status"]).To(BeEquivalentTo(http.StatusOK))
+				Expect(r[2]["user"]).To(HaveKeyWithValue("user_status", "I"))
+				Expect(r[3]["status"]).To(BeEquivalentTo(http.StatusPreconditionFailed))
+			})
+
+			It("should store the applied operations", func() {
+				_, err := repo.GetByUserName(context.Background(), "alice")
+				Expect(err).To(BeNil())
+
+				u, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+				Expect(u.Status).To(Equal("I"))
+			})
+
+		})
+
+		Context("should roll back an atomic batch when an operation fails", func() {
+
+			BeforeEach(func() {
+				body = fmt.Sprintf(`{"atomic":true,"operations":[
+					{"op":"create","user":{"user_name":"alice","first_name":"Alice","last_name":"Smith","email":"alice@example.com","user_status":"A"}},
+					{"op":"delete","id":%d},
+					{"op":"create","user":{"user_name":"johndoe","first_name":"John","last_name":"Doe","email":"other@example.com","user_status":"A"}}
+				]}`, user.UserID)
+			})
+
+			It("status code should be the failing operation's", func() {
+				Expect(resp.Code).To(Equal(http.StatusConflict))
+			})
+
+			It("body should mark the other operations as aborted", func() {
+				r := results()
+				Expect(r).To(HaveLen(3))
+				Expect(r[0]["status"]).To(BeEquivalentTo(http.StatusFailedDependency))
+				Expect(r[1]["status"]).To(BeEquivalentTo(http.StatusFailedDependency))
+				Expect(r[2]["status"]).To(BeEquivalentTo(http.StatusConflict))
+			})
+
+			It("should leave the users untouched", func() {
+				_, err := repo.GetByUserName(context.Background(), "alice")
+				Expect(err).To(MatchError(storage.ErrUserNotFound))
+
+				_, err = repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+			})
+
+		})
+
+		Context("should get a 400 response when an atomic batch holds an invalid operation", func() {
+
+			BeforeEach(func() {
+				body = fmt.Sprintf(`{"operations":[{"op":"delete","id":%d},{"op":"rename","id":1}]}`, user.UserID)
+				query = "?atomic=true"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+			It("body should tell which operation is invalid", func() {
+				r := results()
+				Expect(r[0]["status"]).To(BeEquivalentTo(http.StatusFailedDependency))
+				Expect(r[1]["status"]).To(BeEquivalentTo(http.StatusBadRequest))
+				Expect(r[1]["error"]).To(ContainSubstring("rename"))
+			})
+
+			It("should not delete the user", func() {
+				_, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+			})
+
+		})
+
+		Context("should get a 400 response when atomic isn't a boolean", func() {
+
+			BeforeEach(func() {
+				body = fmt.Sprintf(`{"operations":[{"op":"delete","id":%d}]}`, user.UserID)
+				query = "?atomic=yes"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				Expect(resp.Body.String()).To(ContainSubstring("'atomic' is not a boolean"))
+			})
+
+			It("should not delete the user", func() {
+				_, err := repo.Get(context.Background(), user.UserID)
+				Expect(err).To(BeNil())
+			})
+
+		})
+
+		Context("should get a 400 response for an empty batch", func() {
+
+			BeforeEach(func() {
+				body = `{"operations":[]}`
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+	})
+
+	Describe("Import", func() {
+		var (
+			resp        *httptest.ResponseRecorder
+			body        string
+			query       string
+			contentType string
+			importRepo  storage.UserRepository
+		)
+
+		BeforeEach(func() {
+			query, contentType, importRepo = "", "text/csv", repo
+		})
+
+		JustBeforeEach(func() {
+			req, _ := http.NewRequest(http.MethodPost, url+"/import"+query, strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			resp = ExecuteRequest(logger, req, importRepo)
+		})
+
+		report := func() model.ImportReport {
+			var r model.ImportReport
+			Expect(json.Unmarshal(resp.Body.Bytes(), &r)).To(Succeed())
+
+			return r
+		}
+
+		Context("should create a user from every row", func() {
+
+			BeforeEach(func() {
+				query = "?map=Login=user_name&map=E-mail=email"
+				body = "Login,First Name,Last Name,E-mail,Department,Start Date\n" +
+					"alice,Alice,Smith,alice@example.com,Sales,2024-01-02\n" +
+					"bob,Bob,Jones,bob@example.com,,2024-01-03\n"
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should sum up the import", func() {
+				Expect(report()).To(Equal(model.ImportReport{Rows: 2, Imported: 2, Errors: []model.ImportError{}}))
+			})
+
+			It("should store the mapped fields", func() {
+				u, err := repo.GetByUserName(context.Background(), "alice")
+				Expect(err).To(BeNil())
+				Expect(u.Email).To(Equal("alice@example.com"))
+				Expect(u.Department).To(Equal("Sales"))
+				Expect(u.Status).To(Equal("A"))
+			})
+
+		})
+
+		Context("should report the rows that would fail on a dry run", func() {
+
+			BeforeEach(func() {
+				query = "?dry_run=true"
+				body = "user_name,first_name,last_name,email,user_status\n" +
+					"alice,Alice,Smith,alice@example.com,A\n" +
+					"bob,Bob,Jones,not-an-email,A\n" +
+					"johndoe,John,Doe,john@example.com,I\n" +
+					"carol,Carol,White,ALICE@example.com,A\n" +
+					"dave,Dave,Brown,dave@example.com,X\n"
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should tell why each row would fail", func() {
+				r := report()
+				Expect(r.DryRun).To(BeTrue())
+				Expect(r.Rows).To(Equal(5))
+				Expect(r.Imported).To(Equal(1))
+				Expect(r.Failed).To(Equal(4))
+				Expect(r.Errors).To(ConsistOf(
+					model.ImportError{Line: 3, UserName: "bob", Status: http.StatusBadRequest, Error: "'email' is invalid"},
+					model.ImportError{Line: 4, UserName: "johndoe", Status: http.StatusConflict, Error: storage.ErrAlreadyExist.Error()},
+					model.ImportError{Line: 5, UserName: "carol", Status: http.StatusConflict, Error: storage.ErrEmailInUse.Error() + " by the row on line 2"},
+					model.ImportError{Line: 6, UserName: "dave", Status: http.StatusBadRequest, Error: "'user_status' is invalid"},
+				))
+			})
+
+			It("should not create any user", func() {
+				_, err := repo.GetByUserName(context.Background(), "alice")
+				Expect(err).To(MatchError(storage.ErrUserNotFound))
+			})
+
+		})
+
+		Context("should import a file spanning several chunks", func() {
+
+			BeforeEach(func() {
+				var b strings.Builder
+				b.WriteString("user_name,first_name,last_name,email\n")
+
+				for i := 0; i < 1200; i++ {
+					fmt.Fprintf(&b, "user%[1]d,First,Last,user%[1]d@example.com\n", i)
+				}
+
+				// a duplicate of a row of the first chunk
+				b.WriteString("user1,First,Last,other@example.com\n")
+				body = b.String()
+			})
+
+			It("should create every user but the duplicate", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+
+				r := report()
+				Expect(r.Rows).To(Equal(1201))
+				Expect(r.Imported).To(Equal(1200))
+				Expect(r.Errors).To(ConsistOf(model.ImportError{Line: 1202, UserName: "user1", Status: http.StatusConflict, Error: storage.ErrAlreadyExist.Error()}))
+
+				_, total, err := repo.List(context.Background(), storage.ListOptions{Limit: 1})
+				Expect(err).To(BeNil())
+				Expect(total).To(BeEquivalentTo(1201))
+			})
+
+		})
+
+		Context("should only list the first 1000 failed rows", func() {
+
+			BeforeEach(func() {
+				query = "?dry_run=1"
+
+				var b strings.Builder
+				b.WriteString("user_name,first_name,last_name,email\n")
+
+				for i := 0; i < 1005; i++ {
+					fmt.Fprintf(&b, "user%d,First,Last,not-an-email\n", i)
+				}
+
+				body = b.String()
+			})
+
+			It("body should count every failed row", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+
+				r := report()
+				Expect(r.DryRun).To(BeTrue())
+				Expect(r.Failed).To(Equal(1005))
+				Expect(r.Errors).To(HaveLen(1000))
+				Expect(r.Truncated).To(BeTrue())
+			})
+
+		})
+
+		Context("should get a 413 response for a dry run of too many rows", func() {
+
+			BeforeEach(func() {
+				query = "?dry_run=true"
+
+				var b strings.Builder
+				b.WriteString("user_name,first_name,last_name,email\n")
+
+				for i := 0; i < 10001; i++ {
+					fmt.Fprintf(&b, "user%[1]d,First,Last,user%[1]d@example.com\n", i)
+				}
+
+				body = b.String()
+			})
+
+			It("status code should be 413", func() {
+				Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+				Expect(resp.Body.String()).To(ContainSubstring("a dry run checks up to 10000 rows"))
+			})
+
+		})
+
+		Context("should tell how many users were created when an import fails midway", func() {
+
+			BeforeEach(func() {
+				importRepo = &failingBatch{UserRepository: repo, succeed: 1}
+
+				var b strings.Builder
+				b.WriteString("user_name,first_name,last_name,email\n")
+
+				for i := 0; i < 600; i++ {
+					fmt.Fprintf(&b, "user%[1]d,First,Last,user%[1]d@example.com\n", i)
+				}
+
+				body = b.String()
+			})
+
+			It("status code should be 500", func() {
+				Expect(resp.Code).To(Equal(http.StatusInternalServerError))
+				Expect(resp.Body.String()).To(ContainSubstring("500 users were created from the rows up to line 501"))
+			})
+
+		})
+
+		Context("should get a 400 response when dry_run isn't a boolean", func() {
+
+			BeforeEach(func() {
+				query = "?dry_run=maybe"
+				body = "user_name,first_name,last_name,email\nalice,Alice,Smith,alice@example.com\n"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				Expect(resp.Body.String()).To(ContainSubstring("'dry_run' is not a boolean"))
+			})
+
+			It("should not create any user", func() {
+				_, err := repo.GetByUserName(context.Background(), "alice")
+				Expect(err).To(MatchError(storage.ErrUserNotFound))
+			})
+
+		})
+
+		Context("should read the file field of a form", func() {
+
+			BeforeEach(func() {
+				var buf bytes.Buffer
+				form := multipart.NewWriter(&buf)
+				part, _ := form.CreateFormFile("file", "hires.csv")
+				_, _ = part.Write([]byte("\ufeffUser Name,First Name,Last Name,Email\nalice,Alice,Smith,alice@example.com\n"))
+				_ = form.Close()
+
+				body, contentType = buf.String(), form.FormDataContentType()
+			})
+
+			It("should create the user", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(report().Imported).To(Equal(1))
+
+				_, err := repo.GetByUserName(context.Background(), "alice")
+				Expect(err).To(BeNil())
+			})
+
+		})
+
+		Context("should get a 400 response when a required field has no column", func() {
+
+			BeforeEach(func() {
+				body = "user_name,first_name,last_name\nalice,Alice,Smith\n"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				Expect(resp.Body.String()).To(ContainSubstring("no column is mapped to 'email'"))
+			})
+
+		})
+
+		Context("should get a 400 response when mapping to an unknown field", func() {
+
+			BeforeEach(func() {
+				query = "?map=Login=login"
+				body = "Login\nalice\n"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should get a 415 response for a JSON body", func() {
+
+			BeforeEach(func() {
+				body, contentType = `[]`, "application/json"
+			})
+
+			It("status code should be 415", func() {
+				Expect(resp.Code).To(Equal(http.StatusUnsupportedMediaType))
+			})
+
+		})
+
+	})
+
+	Describe("Export", func() {
+		var (
+			resp   *httptest.ResponseRecorder
+			query  string
+			accept string
+		)
+
+		BeforeEach(func() {
+			query, accept = "", ""
+
+			insertUser(&model.User{UserName: "alice", FirstName: "Alice", LastName: "Smith, Jr.", Email: "alice@example.com", Status: "A", Department: "Sales"})
+			insertUser(&model.User{UserName: "bob", FirstName: "Bob", LastName: "Jones", Email: "bob@example.com", Status: "I", Department: "Sales"})
+		})
+
+		JustBeforeEach(func() {
+			req, _ := http.NewRequest(http.MethodGet, url+"/export"+query, nil)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		Context("should export CSV by default", func() {
+
+			BeforeEach(func() {
+				query = "?department=Sales&sort=-user_name"
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(resp.Header().Get("Content-Type")).To(Equal(handler.MIMETextCSV))
+				Expect(resp.Header().Get("Content-Disposition")).To(MatchRegexp(`^attachment; filename=users-\d{4}-\d{2}-\d{2}\.csv$`))
+			})
+
+			It("body should hold the filtered users in order", func() {
+				records, err := csv.NewReader(resp.Body).ReadAll()
+				Expect(err).To(BeNil())
+				Expect(records).To(HaveLen(3))
+				Expect(records[0]).To(Equal([]string{"id", "user_name", "first_name", "last_name", "email", "user_status", "department", "version"}))
+				Expect(records[1][1]).To(Equal("bob"))
+				Expect(records[2][1:7]).To(Equal([]string{"alice", "Alice", "Smith, Jr.", "alice@example.com", "A", "Sales"}))
+			})
+
+		})
+
+		Context("should quote the cells a spreadsheet would evaluate", func() {
+
+			BeforeEach(func() {
+				insertUser(&model.User{UserName: "carol", FirstName: "=HYPERLINK(\"http://evil.example.com\")", LastName: "+White", Email: "carol@example.com", Status: "A", Department: "@Finance"})
+				query = "?department=@Finance"
+			})
+
+			It("body should hold the cells prefixed with a quote", func() {
+				records, err := csv.NewReader(resp.Body).ReadAll()
+				Expect(err).To(BeNil())
+				Expect(records).To(HaveLen(2))
+				Expect(records[1][1:7]).To(Equal([]string{"carol", `'=HYPERLINK("http://evil.example.com")`, "'+White", "carol@example.com", "A", "'@Finance"}))
+			})
+
+		})
+
+		Context("should export NDJSON picked by the Accept header", func() {
+
+			BeforeEach(func() {
+				query = "?user_status=I"
+				accept = "application/x-ndjson, text/csv;q=0.5"
+			})
+
+			It("body should hold a user per line", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(resp.Header().Get("Content-Type")).To(Equal(handler.MIMENDJSON))
+
+				lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+				Expect(lines).To(HaveLen(1))
+
+				u, err := Deserialize(lines[0])
+				Expect(err).To(BeNil())
+				Expect(u["user_name"]).To(Equal("bob"))
+			})
+
+		})
+
+		Context("should export XLSX", func() {
+
+			BeforeEach(func() {
+				query = "?format=xlsx&sort=user_id"
+			})
+
+			It("body should be a workbook with a row per user", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(resp.Header().Get("Content-Disposition")).To(HaveSuffix(".xlsx"))
+
+				archive, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+				Expect(err).To(BeNil())
+
+				sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+				Expect(err).To(BeNil())
+
+				var worksheet struct {
+					Rows []struct {
+						Cells []struct {
+							Value  string `xml:"v"`
+							Inline string `xml:"is>t"`
+						} `xml:"c"`
+					} `xml:"sheetData>row"`
+				}
+				Expect(xml.NewDecoder(sheet).Decode(&worksheet)).To(Succeed())
+
+				Expect(worksheet.Rows).To(HaveLen(4))
+				Expect(worksheet.Rows[0].Cells[1].Inline).To(Equal("user_name"))
+				Expect(worksheet.Rows[1].Cells[0].Value).To(Equal(fmt.Sprint(user.UserID)))
+				Expect(worksheet.Rows[2].Cells[3].Inline).To(Equal("Smith, Jr."))
+			})
+
+		})
+
+		Context("should get a 400 response for an unknown format", func() {
+
+			BeforeEach(func() {
+				query = "?format=pdf"
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should get a 406 response when no format is acceptable", func() {
+
+			BeforeEach(func() {
+				accept = "application/pdf"
+			})
+
+			It("status code should be 406", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotAcceptable))
+				Expect(resp.Header().Get("Content-Disposition")).To(BeEmpty())
+			})
+
+		})
+
+	})
+
+	Describe("Audit", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			path := fmt.Sprintf("%s/%d", url, user.UserID)
+			req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(`{"department": "Sales"}`))
+			req.Header.Add("Content-Type", "application/merge-patch+json")
+			req.Header.Add("X-Actor", "hr-sync")
+
+			identity := router.WithIdentity(func(*http.Request) string { return "admin" })
+			Expect(ExecuteRequest(logger, req, repo, identity).Code).To(Equal(http.StatusOK))
+		})
+
+		Context("should get the history of a user", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/history", url, user.UserID)
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should hold every change, oldest first", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(2))
+				Expect(items[0]["action"]).To(Equal("create"))
+				Expect(items[1]["action"]).To(Equal("update"))
+				Expect(items[1]["actor"]).To(Equal("admin"))
+				Expect(items[1]["claimed_actor"]).To(Equal("hr-sync"))
+				Expect(items[1]["request_id"]).ToNot(BeEmpty())
+				Expect(items[1]["changes"]).To(Equal(map[string]interface{}{
+					"department": map[string]interface{}{"before": user.Department, "after": "Sales"},
+				}))
+			})
+
+		})
+
+		Context("should record the actor of an unauthenticated request as claimed only", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d", url, user.UserID)
+				req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(`{"department": "Support"}`))
+				req.Header.Add("Content-Type", "application/merge-patch+json")
+				req.Header.Add("X-Actor", "admin")
+				Expect(ExecuteRequest(logger, req, repo).Code).To(Equal(http.StatusOK))
+
+				req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%d/history", url, user.UserID), nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("body should hold the anonymous actor", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(3))
+				Expect(items[2]["actor"]).To(Equal("anonymous"))
+				Expect(items[2]["claimed_actor"]).To(Equal("admin"))
+			})
+
+		})
+
+		Context("should filter the audit log by actor", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, "/api/v1/audit?actor=admin&since=2000-01-01T00:00:00Z", nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should only hold changes made by the actor", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(1))
+				Expect(items[0]["actor"]).To(Equal("admin"))
+			})
+
+		})
+
+		Context("should find the changes of a caller authenticated with a token", func() {
+
+			JustBeforeEach(func() {
+				GinkgoT().Setenv("SERVER_PORT", "8080")
+				GinkgoT().Setenv("DB_DRIVER", "memory")
+				GinkgoT().Setenv("AUTH_TOKENS", "alice:alice-token")
+
+				cfg, err := config.Load("missing.env")
+				Expect(err).To(BeNil())
+
+				// wired like by main
+				identity := router.WithIdentity(router.AuthorizationIdentity(cfg.Auth.Identity))
+
+				path := fmt.Sprintf("%s/%d", url, user.UserID)
+				req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(`{"department": "Support"}`))
+				req.Header.Add("Content-Type", "application/merge-patch+json")
+				req.Header.Add("Authorization", "Bearer alice-token")
+				req.Header.Add("X-Actor", "hr-sync")
+				Expect(ExecuteRequest(logger, req, repo, identity).Code).To(Equal(http.StatusOK))
+
+				req, _ = http.NewRequest(http.MethodGet, "/api/v1/audit?actor=alice", nil)
+				resp = ExecuteRequest(logger, req, repo, identity)
+			})
+
+			It("body should hold the change made by the caller", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(1))
+				Expect(items[0]["actor"]).To(Equal("alice"))
+				Expect(items[0]["claimed_actor"]).To(Equal("hr-sync"))
+			})
+
+		})
+
+		Context("should filter the audit log by claimed actor", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, "/api/v1/audit?claimed_actor=hr-sync", nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("body should only hold changes claimed for the actor", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(1))
+				Expect(items[0]["actor"]).To(Equal("admin"))
+				Expect(items[0]["claimed_actor"]).To(Equal("hr-sync"))
+			})
+
+		})
+
+		Context("should get a 400 response when since isn't a time", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, "/api/v1/audit?since=yesterday", nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+	})
+
+	Describe("History", func() {
+		var (
+			resp    *httptest.ResponseRecorder
+			created time.Time
+		)
+
+		BeforeEach(func() {
+			time.Sleep(5 * time.Millisecond)
+			created = time.Now()
+			time.Sleep(5 * time.Millisecond)
+
+			renamed := *user
+			renamed.UserName = "JohnDoe2"
+			renamed.Department = "Sales"
+
+			if _, err := repo.Update(context.Background(), user.UserID, &renamed); err != nil {
+				panic(err)
+			}
+		})
+
+		asOf := func() string {
+			return created.UTC().Format(time.RFC3339Nano)
+		}
+
+		Context("should get a user as it was at as_of", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d?as_of=%s", url, user.UserID, asOf())
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should hold the former values", func() {
+				u, err := Deserialize(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(u["user_name"]).To(Equal(user.UserName))
+				Expect(u["department"]).To(Equal(user.Department))
+				Expect(resp.Header().Get("ETag")).To(Equal(`"1"`))
+			})
+
+		})
+
+		Context("should list users as they were at as_of", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, url+"?department=Accounts&as_of="+asOf(), nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should hold the former values", func() {
+				page, err := Deserialize(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(page["items"]).To(HaveLen(1))
+				Expect(page["items"].([]interface{})[0].(map[string]interface{})["user_name"]).To(Equal(user.UserName))
+			})
+
+		})
+
+		Context("should get a 400 response when as_of isn't a time", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, url+"?as_of=yesterday", nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Describe("Revert", func() {
+			var version string
+
+			BeforeEach(func() {
+				version = "1"
+			})
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/revert?to_version=%s", url, user.UserID, version)
+				req, _ := http.NewRequest(http.MethodPost, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			Context("should revert a user to a former version", func() {
+
+				It("status code should be 200", func() {
+					Expect(resp.Code).To(Equal(http.StatusOK))
+				})
+
+				It("body should hold the former values under a new version", func() {
+					u, err := Deserialize(resp.Body.String())
+					Expect(err).To(BeNil())
+					Expect(u["user_name"]).To(Equal(user.UserName))
+					Expect(u["department"]).To(Equal(user.Department))
+					Expect(u["version"]).To(BeEquivalentTo(3))
+					Expect(resp.Header().Get("ETag")).To(Equal(`"3"`))
+				})
+
+			})
+
+			Context("should get a 404 response when the version doesn't exist", func() {
+
+				BeforeEach(func() {
+					version = "9"
+				})
+
+				It("status code should be 404", func() {
+					Expect(resp.Code).To(Equal(http.StatusNotFound))
+				})
+
+			})
+
+			Context("should get a 400 response when to_version isn't a version", func() {
+
+				BeforeEach(func() {
+					version = "latest"
+				})
+
+				It("status code should be 400", func() {
+					Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				})
+
+			})
+
+			Context("should get a 409 response when the former username is taken", func() {
+
+				BeforeEach(func() {
+					insertUser(&model.User{
+						UserName:   user.UserName,
+						FirstName:  "Johnny",
+						LastName:   "Doe",
+						Email:      "johnny@yahoo.com",
+						Status:     "A",
+						Department: "Accounts",
+					})
+				})
+
+				It("status code should be 409", func() {
+					Expect(resp.Code).To(Equal(http.StatusConflict))
+				})
+
+			})
+
+		})
+
+	})
+
+	Describe("Events", func() {
+		var (
+			broker *events.Broker
+			resp   *httptest.ResponseRecorder
+			header http.Header
+			query  string
+		)
+
+		execute := func(req *http.Request) *httptest.ResponseRecorder {
+			return ExecuteRequest(logger, req, repo, router.WithEventBroker(broker))
+		}
+
+		// streamed returns the event types written to the stream, in order
+		streamed := func() []string {
+			var types []string
+
+			for _, line := range strings.Split(resp.Body.String(), "\n") {
+				if eventType, ok := strings.CutPrefix(line, "event: "); ok {
+					types = append(types, eventType)
+				}
+			}
+
+			return types
+		}
+
+		BeforeEach(func() {
+			broker = events.NewBroker(3)
+			header = http.Header{}
+			query = ""
+
+			path := fmt.Sprintf("%s/%d", url, user.UserID)
+			req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(`{"department": "Sales"}`))
+			req.Header.Add("Content-Type", "application/merge-patch+json")
+			Expect(execute(req).Code).To(Equal(http.StatusOK))
+
+			body := `{"user_name": "JaneDoe", "first_name": "Jane", "last_name": "Doe",
+				"email": "janedoe@yahoo.com", "user_status": "A", "department": "Accounts"}`
+			req, _ = http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+			req.Header.Add("Content-Type", "application/json")
+			Expect(execute(req).Code).To(Equal(http.StatusCreated))
+
+			req, _ = http.NewRequest(http.MethodDelete, path, nil)
+			Expect(execute(req).Code).To(Equal(http.StatusNoContent))
+		})
+
+		JustBeforeEach(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events"+query, nil)
+			req.Header = header
+			resp = execute(req)
+		})
+
+		Context("should resume after Last-Event-ID", func() {
+
+			BeforeEach(func() {
+				header.Set("Last-Event-ID", "1")
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(resp.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+			})
+
+			It("body should hold the missed events", func() {
+				Expect(streamed()).To(Equal([]string{model.EventUserCreated, model.EventUserDeleted}))
+				Expect(resp.Body.String()).To(HavePrefix("id: 2\n"))
+				Expect(resp.Body.String()).To(ContainSubstring(`"user_name":"JaneDoe"`))
+			})
+
+		})
+
+		Context("should filter by department", func() {
+
+			BeforeEach(func() {
+				query = "?department=Sales&last_event_id=1"
+			})
+
+			It("body should only hold the changes of the department", func() {
+				Expect(streamed()).To(Equal([]string{model.EventUserDeleted}))
+			})
+
+		})
+
+		Context("should stream the changes moving a user out of the department", func() {
+
+			BeforeEach(func() {
+				query = "?department=Sales"
+
+				go func() {
+					defer GinkgoRecover()
+
+					time.Sleep(10 * time.Millisecond)
+
+					path := fmt.Sprintf("%s/%d", url, user.UserID)
+					req, _ := http.NewRequest(http.MethodPost, path+"/restore", nil)
+					Expect(execute(req).Code).To(Equal(http.StatusOK))
+
+					req, _ = http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(`{"department": "Accounts"}`))
+					req.Header.Add("Content-Type", "application/merge-patch+json")
+					Expect(execute(req).Code).To(Equal(http.StatusOK))
+				}()
+			})
+
+			It("body should hold the change of department", func() {
+				Expect(streamed()).To(Equal([]string{model.EventUserUpdated, model.EventUserUpdated}))
+				Expect(resp.Body.String()).To(ContainSubstring(`"department":"Accounts","version":3},"previous_department":"Sales"`))
+			})
+
+		})
+
+		Context("should ask to reload when the missed events were dropped", func() {
+
+			BeforeEach(func() {
+				for _, name := range []string{"JimDoe", "JoeDoe"} {
+					body := fmt.Sprintf(`{"user_name": "%s", "first_name": "J", "last_name": "Doe",
+						"email": "%s@yahoo.com", "user_status": "A", "department": "Accounts"}`, name, name)
+					req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+					req.Header.Add("Content-Type", "application/json")
+					Expect(execute(req).Code).To(Equal(http.StatusCreated))
+				}
+
+				header.Set("Last-Event-ID", "1")
+			})
+
+			It("body should start with a reset event", func() {
+				Expect(streamed()).To(Equal([]string{"reset", model.EventUserDeleted, model.EventUserCreated, model.EventUserCreated}))
+			})
+
+		})
+
+		Context("should stream changes made while connected", func() {
+
+			BeforeEach(func() {
+				go func() {
+					defer GinkgoRecover()
+
+					time.Sleep(10 * time.Millisecond)
+
+					path := fmt.Sprintf("%s/%d/restore", url, user.UserID)
+					req, _ := http.NewRequest(http.MethodPost, path, nil)
+					Expect(execute(req).Code).To(Equal(http.StatusOK))
+				}()
+			})
+
+			It("body should hold the change", func() {
+				Expect(streamed()).To(Equal([]string{model.EventUserUpdated}))
+				Expect(resp.Body.String()).To(HavePrefix("id: 4\n"))
+			})
+
+		})
+
+		Context("should get a 400 response when Last-Event-ID isn't an id", func() {
+
+			BeforeEach(func() {
+				header.Set("Last-Event-ID", "latest")
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+	})
+}
+
+// failingBatch fails the batches following the first succeed ones
+type failingBatch struct {
+	storage.UserRepository
+	succeed int
+}
+
+func (f *failingBatch) Batch(ctx context.Context, ops []storage.BatchOp, opts storage.BatchOptions) ([]storage.BatchResult, error) {
+	if f.succeed == 0 {
+		return nil, errors.New("connection lost")
+	}
+
+	f.succeed--
+
+	return f.UserRepository.Batch(ctx, ops, opts)
+}
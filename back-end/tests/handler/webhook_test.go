@@ -0,0 +1,304 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebhookHandler", func() {
+	Describe("with memory storage", Ordered, func() {
+		webhookHandlerSpecs(&MemoryStorage{})
+	})
+
+	Describe("with sqlite storage", Ordered, func() {
+		webhookHandlerSpecs(&DatabaseStorage{
+			Database: &config.Database{Driver: "sqlite", Name: filepath.Join(os.TempDir(), "webhooks_handler_test.db")},
+		})
+	})
+})
+
+func webhookHandlerSpecs(testStorage TestStorage) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	var (
+		repo     storage.UserRepository
+		webhooks storage.WebhookRepository
+		webhook  *model.Webhook
+	)
+
+	BeforeAll(func() {
+		testStorage.Setup(logger)
+	})
+
+	AfterAll(func() {
+		testStorage.Teardown()
+	})
+
+	BeforeEach(func() {
+		repo = testStorage.Fresh()
+		webhooks = repo.(storage.WebhookRepository)
+
+		webhook = &model.Webhook{
+			URL:        "https://payroll.example.com/hooks",
+			Secret:     "0123456789abcdef",
+			EventTypes: []string{model.EventUserStatusChanged},
+		}
+
+		if err := webhooks.CreateWebhook(context.Background(), webhook); err != nil {
+			panic(err)
+		}
+	})
+
+	url := "/api/v1/webhooks"
+
+	Describe("Create", func() {
+		var (
+			resp *httptest.ResponseRecorder
+			body string
+		)
+
+		JustBeforeEach(func() {
+			req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+			req.Header.Add("Content-Type", "application/json")
+			resp = ExecuteRequest(logger, req, repo)
+		})
+
+		Context("should create a webhook with a generated secret", func() {
+
+			BeforeEach(func() {
+				body = `{"url": "https://mail.example.com/hooks", "event_types": ["UserCreated"], "department": "Sales"}`
+			})
+
+			It("status code should be 201", func() {
+				Expect(resp.Code).To(Equal(http.StatusCreated))
+			})
+
+			It("body should hold the webhook and its secret", func() {
+				w, err := Deserialize(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(w["id"]).ToNot(BeZero())
+				Expect(w["department"]).To(Equal("Sales"))
+				Expect(w["secret"]).To(HaveLen(64))
+			})
+
+		})
+
+		Context("should get a 400 response when the url is invalid", func() {
+
+			BeforeEach(func() {
+				body = `{"url": "payroll"}`
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				Expect(resp.Body.String()).To(ContainSubstring(`'url' is invalid`))
+			})
+
+		})
+
+		Context("should get a 400 response when an event type is unknown", func() {
+
+			BeforeEach(func() {
+				body = `{"url": "https://mail.example.com/hooks", "event_types": ["UserRenamed"]}`
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				Expect(resp.Body.String()).To(ContainSubstring(`'event_types' is invalid`))
+			})
+
+		})
+
+	})
+
+	Describe("List", func() {
+
+		It("should list webhooks without their secret", func() {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			resp := ExecuteRequest(logger, req, repo)
+
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			items, err := DeserializeList(resp.Body.String())
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(1))
+			Expect(items[0]["url"]).To(Equal(webhook.URL))
+			Expect(items[0]).ToNot(HaveKey("secret"))
+		})
+
+	})
+
+	Describe("Delete", func() {
+
+		It("should delete a webhook", func() {
+			path := fmt.Sprintf("%s/%d", url, webhook.WebhookID)
+			req, _ := http.NewRequest(http.MethodDelete, path, nil)
+			Expect(ExecuteRequest(logger, req, repo).Code).To(Equal(http.StatusNoContent))
+
+			req, _ = http.NewRequest(http.MethodGet, path, nil)
+			Expect(ExecuteRequest(logger, req, repo).Code).To(Equal(http.StatusNotFound))
+		})
+
+	})
+
+	Describe("Deliveries", func() {
+		var (
+			resp   *httptest.ResponseRecorder
+			failed model.Delivery
+		)
+
+		BeforeEach(func() {
+			err := webhooks.EnqueueDeliveries(context.Background(),
+				model.Delivery{WebhookID: webhook.WebhookID, EventID: 1, EventType: model.EventUserStatusChanged,
+					Payload: []byte(`{"id":1}`), NextAttemptAt: time.Now()},
+				model.Delivery{WebhookID: webhook.WebhookID, EventID: 2, EventType: model.EventUserStatusChanged,
+					Payload: []byte(`{"id":2}`), NextAttemptAt: time.Now()},
+			)
+			if err != nil {
+				panic(err)
+			}
+
+			due, err := webhooks.ClaimDeliveries(context.Background(), time.Now(), 1)
+			if err != nil {
+				panic(err)
+			}
+
+			failed = due[0]
+			failed.Status = model.DeliveryFailed
+			failed.Attempts = 8
+			failed.LastError = "webhook responded with status 500"
+
+			if err = webhooks.SaveDelivery(context.Background(), &failed); err != nil {
+				panic(err)
+			}
+		})
+
+		Context("should list the dead-letter deliveries", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/deliveries?status=failed", url, webhook.WebhookID)
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("body should only hold the failed delivery", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(1))
+				Expect(items[0]["event_id"]).To(BeEquivalentTo(1))
+				Expect(items[0]["last_error"]).To(Equal(failed.LastError))
+				Expect(items[0]["payload"]).To(Equal(map[string]interface{}{"id": float64(1)}))
+			})
+
+		})
+
+		Context("should get a 400 response when the status is unknown", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/deliveries?status=lost", url, webhook.WebhookID)
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 400", func() {
+				Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			})
+
+		})
+
+		Context("should get a 404 response when the webhook doesn't exist", func() {
+
+			JustBeforeEach(func() {
+				req, _ := http.NewRequest(http.MethodGet, url+"/-1/deliveries", nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+		})
+
+		Context("should replay a delivery", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/deliveries/%d/replay", url, webhook.WebhookID, failed.DeliveryID)
+				req, _ := http.NewRequest(http.MethodPost, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("delivery should be due again", func() {
+				d, err := Deserialize(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(d["status"]).To(Equal(model.DeliveryPending))
+				Expect(d["attempts"]).To(BeEquivalentTo(0))
+
+				due, err := webhooks.ClaimDeliveries(context.Background(), time.Now().Add(time.Second), 10)
+				Expect(err).To(BeNil())
+				Expect(due).To(HaveLen(2))
+			})
+
+		})
+
+		Context("should get a 404 response when replaying a delivery of another webhook", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/deliveries/%d/replay", url, webhook.WebhookID+1, failed.DeliveryID)
+				req, _ := http.NewRequest(http.MethodPost, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 404", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotFound))
+			})
+
+		})
+
+		Context("should replay every failed delivery", func() {
+
+			JustBeforeEach(func() {
+				path := fmt.Sprintf("%s/%d/deliveries/replay", url, webhook.WebhookID)
+				req, _ := http.NewRequest(http.MethodPost, path, nil)
+				resp = ExecuteRequest(logger, req, repo)
+			})
+
+			It("status code should be 200", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+			})
+
+			It("dead-letter list should be empty", func() {
+				items, err := DeserializeList(resp.Body.String())
+				Expect(err).To(BeNil())
+				Expect(items).To(HaveLen(1))
+
+				left, err := webhooks.ListDeliveries(context.Background(), webhook.WebhookID, model.DeliveryFailed)
+				Expect(err).To(BeNil())
+				Expect(left).To(BeEmpty())
+			})
+
+		})
+
+	})
+}
@@ -0,0 +1,378 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/events"
+	"github.com/andrii-stp/users-crud/model"
+	usersv1 "github.com/andrii-stp/users-crud/proto/users/v1"
+	"github.com/andrii-stp/users-crud/router"
+	"github.com/andrii-stp/users-crud/rpc"
+	"github.com/andrii-stp/users-crud/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient serves the UserService from a fresh memory repository holding
+// users and returns a client connected to it
+func newClient(t *testing.T, users ...model.User) (usersv1.UserServiceClient, storage.UserRepository, *events.Broker) {
+	t.Helper()
+
+	return newClientWithOptions(t, nil, users...)
+}
+
+// newClientWithOptions is newClient for a server built with opts
+func newClientWithOptions(t *testing.T, opts []rpc.Option, users ...model.User) (usersv1.UserServiceClient, storage.UserRepository, *events.Broker) {
+	t.Helper()
+
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	repo := storage.NewMemoryRepository()
+	broker := events.NewBroker(router.DefaultEventBuffer)
+
+	for _, user := range users {
+		if err := repo.Create(context.Background(), &user); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := rpc.NewServer(logger, repo, broker, router.NewUserValidator(logger), opts...)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return usersv1.NewUserServiceClient(conn), repo, broker
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if status.Code(err) != code {
+		t.Errorf("Expected code %v but got %v", code, err)
+	}
+}
+
+var testUsers = []model.User{
+	{UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", Status: "A", Department: "Sales"},
+	{UserName: "asmith", FirstName: "Anna", LastName: "Smith", Email: "asmith@example.org", Status: "I", Department: "IT"},
+	{UserName: "bbrown", FirstName: "Bob", LastName: "Brown", Email: "bbrown@example.com", Status: "A", Department: "IT"},
+}
+
+func TestGet(t *testing.T) {
+	client, _, _ := newClient(t, testUsers...)
+	ctx := context.Background()
+
+	user, err := client.Get(ctx, &usersv1.GetRequest{Key: &usersv1.GetRequest_Id{Id: 1}})
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	if user.GetUserName() != "jdoe" || user.GetEmail() != "jdoe@example.com" || user.GetVersion() != 1 {
+		t.Errorf("Expected jdoe but got %v", user)
+	}
+
+	if user, err = client.Get(ctx, &usersv1.GetRequest{Key: &usersv1.GetRequest_UserName{UserName: "ASmith"}}); err != nil {
+		t.Fatalf("Failed to get user by username: %v", err)
+	}
+
+	if user.GetId() != 2 {
+		t.Errorf("Expected user 2 but got %v", user)
+	}
+
+	_, err = client.Get(ctx, &usersv1.GetRequest{Key: &usersv1.GetRequest_Id{Id: 42}})
+	expectCode(t, err, codes.NotFound)
+
+	_, err = client.Get(ctx, &usersv1.GetRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestList(t *testing.T) {
+	client, _, _ := newClient(t, testUsers...)
+
+	receive := func(req *usersv1.ListRequest) ([]string, error) {
+		stream, err := client.List(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+
+		names := []string{}
+
+		for {
+			user, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return names, nil
+			}
+
+			if err != nil {
+				return names, err
+			}
+
+			names = append(names, user.GetUserName())
+		}
+	}
+
+	tests := []struct {
+		name     string
+		req      *usersv1.ListRequest
+		expected []string
+	}{
+		{"every user", &usersv1.ListRequest{}, []string{"jdoe", "asmith", "bbrown"}},
+		{"sorted", &usersv1.ListRequest{Sort: "last_name"}, []string{"bbrown", "jdoe", "asmith"}},
+		{"filtered", &usersv1.ListRequest{Department: "IT", UserStatus: "A"}, []string{"bbrown"}},
+		{"by email domain", &usersv1.ListRequest{EmailDomain: "example.com", Sort: "-user_id"}, []string{"bbrown", "jdoe"}},
+		{"paginated", &usersv1.ListRequest{Limit: 1, Offset: 1}, []string{"asmith"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names, err := receive(test.req)
+			if err != nil {
+				t.Fatalf("Failed to list users: %v", err)
+			}
+
+			if len(names) != len(test.expected) {
+				t.Fatalf("Expected %v but got %v", test.expected, names)
+			}
+
+			for i := range names {
+				if names[i] != test.expected[i] {
+					t.Errorf("Expected %v but got %v", test.expected, names)
+				}
+			}
+		})
+	}
+
+	for _, req := range []*usersv1.ListRequest{{UserStatus: "X"}, {Sort: "password"}, {Limit: -1}, {EmailDomain: "%"}} {
+		_, err := receive(req)
+		expectCode(t, err, codes.InvalidArgument)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	client, repo, _ := newClient(t, testUsers...)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-actor", "hr-sync", "x-request-id", "req-1")
+
+	user, err := client.Create(ctx, &usersv1.CreateRequest{User: &usersv1.User{
+		UserName: "cwhite", FirstName: "Carol", LastName: "White", Email: "cwhite@example.com", UserStatus: "A",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if user.GetId() != 4 || user.GetVersion() != 1 {
+		t.Errorf("Expected user 4 at version 1 but got %v", user)
+	}
+
+	entries, err := repo.ListAudit(context.Background(), storage.AuditFilter{UserID: user.GetId()})
+	if err != nil {
+		t.Fatalf("Failed to list audit: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Actor != "anonymous" || entries[0].ClaimedActor != "hr-sync" || entries[0].RequestID != "req-1" {
+		t.Errorf("Expected the change to be recorded as claimed by hr-sync but got %+v", entries)
+	}
+
+	_, err = client.Create(ctx, &usersv1.CreateRequest{User: &usersv1.User{
+		UserName: "JDOE", FirstName: "John", LastName: "Doe", Email: "john@example.com", UserStatus: "A",
+	}})
+	expectCode(t, err, codes.AlreadyExists)
+
+	_, err = client.Create(ctx, &usersv1.CreateRequest{User: &usersv1.User{
+		UserName: "dgreen", FirstName: "Dan", LastName: "Green", Email: "dgreen", UserStatus: "A",
+	}})
+	expectCode(t, err, codes.InvalidArgument)
+
+	if err != nil && status.Convert(err).Message() != `'email' is invalid` {
+		t.Errorf("Expected the validation message but got %v", err)
+	}
+
+	_, err = client.Create(ctx, &usersv1.CreateRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestIdentity(t *testing.T) {
+	identity := rpc.WithIdentity(func(context.Context) string { return "admin" })
+	client, repo, _ := newClientWithOptions(t, []rpc.Option{identity}, testUsers...)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-actor", "hr-sync")
+
+	user, err := client.Create(ctx, &usersv1.CreateRequest{User: &usersv1.User{
+		UserName: "cwhite", FirstName: "Carol", LastName: "White", Email: "cwhite@example.com", UserStatus: "A",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	entries, err := repo.ListAudit(context.Background(), storage.AuditFilter{UserID: user.GetId()})
+	if err != nil {
+		t.Fatalf("Failed to list audit: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Actor != "admin" || entries[0].ClaimedActor != "hr-sync" {
+		t.Errorf("Expected the change to be recorded as made by admin but got %+v", entries)
+	}
+}
+
+func TestAuthorizationIdentity(t *testing.T) {
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("DB_DRIVER", "memory")
+	t.Setenv("AUTH_TOKENS", "alice:alice-token")
+
+	cfg, err := config.Load("missing.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// wired like by main
+	identity := rpc.WithIdentity(rpc.AuthorizationIdentity(cfg.Auth.Identity))
+	client, repo, _ := newClientWithOptions(t, []rpc.Option{identity}, testUsers...)
+
+	for _, token := range []string{"alice-token", "forged"} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token, "x-actor", "hr-sync")
+
+		if _, err := client.Create(ctx, &usersv1.CreateRequest{User: &usersv1.User{
+			UserName: "u-" + token, FirstName: "Carol", LastName: "White", Email: token + "@example.com", UserStatus: "A",
+		}}); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	entries, err := repo.ListAudit(context.Background(), storage.AuditFilter{Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to list audit: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].ClaimedActor != "hr-sync" {
+		t.Errorf("Expected a single change made by alice but got %+v", entries)
+	}
+
+	if entries, _ = repo.ListAudit(context.Background(), storage.AuditFilter{Actor: "anonymous"}); len(entries) != 1 {
+		t.Errorf("Expected the change made with a forged token to be anonymous but got %+v", entries)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	client, _, _ := newClient(t, testUsers...)
+	ctx := context.Background()
+
+	changed := &usersv1.User{
+		UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", UserStatus: "T", Department: "Sales",
+	}
+
+	user, err := client.Update(ctx, &usersv1.UpdateRequest{Id: 1, User: changed, Version: 1})
+	if err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	if user.GetUserStatus() != "T" || user.GetVersion() != 2 {
+		t.Errorf("Expected a terminated user at version 2 but got %v", user)
+	}
+
+	_, err = client.Update(ctx, &usersv1.UpdateRequest{Id: 1, User: changed, Version: 1})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	_, err = client.Update(ctx, &usersv1.UpdateRequest{Id: 42, User: changed})
+	expectCode(t, err, codes.NotFound)
+
+	changed.Email = "asmith@example.org"
+	_, err = client.Update(ctx, &usersv1.UpdateRequest{Id: 1, User: changed})
+	expectCode(t, err, codes.AlreadyExists)
+}
+
+func TestDelete(t *testing.T) {
+	client, _, _ := newClient(t, testUsers...)
+	ctx := context.Background()
+
+	_, err := client.Delete(ctx, &usersv1.DeleteRequest{Id: 1, Version: 2})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	if _, err = client.Delete(ctx, &usersv1.DeleteRequest{Id: 1, Version: 1}); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	_, err = client.Get(ctx, &usersv1.GetRequest{Key: &usersv1.GetRequest_Id{Id: 1}})
+	expectCode(t, err, codes.NotFound)
+
+	_, err = client.Delete(ctx, &usersv1.DeleteRequest{Id: 1})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestWatch(t *testing.T) {
+	client, _, broker := newClient(t, testUsers...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &usersv1.WatchRequest{Department: "IT"})
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+
+	// the header is only sent once the watcher is subscribed
+	if _, err = stream.Header(); err != nil {
+		t.Fatalf("Failed to get the header: %v", err)
+	}
+
+	_, err = client.Update(ctx, &usersv1.UpdateRequest{Id: 1, User: &usersv1.User{
+		UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", UserStatus: "I", Department: "Sales",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	if _, err = client.Delete(ctx, &usersv1.DeleteRequest{Id: 2}); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive an event: %v", err)
+	}
+
+	if event.GetType() != model.EventUserDeleted || event.GetUserId() != 2 || event.GetUser().GetDeletedAt() == nil {
+		t.Errorf("Expected the deletion of user 2 but got %v", event)
+	}
+
+	if event.GetRequestId() == "" {
+		t.Errorf("Expected the event to hold the generated request id")
+	}
+
+	// a watcher resuming after events that are no longer kept is told to reset
+	for i := 0; i < router.DefaultEventBuffer; i++ {
+		broker.Publish(model.Event{Type: model.EventUserUpdated, User: model.User{Department: "Sales"}})
+	}
+
+	resumed, err := client.Watch(ctx, &usersv1.WatchRequest{LastEventId: 1})
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+
+	if event, err = resumed.Recv(); err != nil || event.GetType() != "reset" {
+		t.Errorf("Expected a reset event but got %v, %v", event, err)
+	}
+}
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/andrii-stp/users-crud/storage/storagetest"
+)
+
+func TestMemoryUserRepository(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) storage.UserRepository {
+		return storage.NewMemoryRepository()
+	})
+}
@@ -0,0 +1,97 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/storage"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := storage.Connect(&config.Database{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "users.db")})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(slog.New(slog.NewJSONHandler(os.Stdout, nil)), db, "sqlite")
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	applied := func() []int64 {
+		t.Helper()
+
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get migration status: %v", err)
+		}
+
+		var versions []int64
+
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				versions = append(versions, status.Version)
+			}
+		}
+
+		return versions
+	}
+
+	if versions := applied(); len(versions) != 0 {
+		t.Fatalf("Expected no applied migrations but got %v", versions)
+	}
+
+	if err = migrator.Up(ctx); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+
+	up := applied()
+	if len(up) < 2 {
+		t.Fatalf("Expected every migration to be applied but got %v", up)
+	}
+
+	if _, err = db.Exec("SELECT user_id FROM users"); err != nil {
+		t.Errorf("Expected users table to exist: %v", err)
+	}
+
+	if err = migrator.Up(ctx); err != nil {
+		t.Fatalf("Expected migrating up twice to be a no-op but got %v", err)
+	}
+
+	if err = migrator.Down(ctx); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+
+	if versions := applied(); len(versions) != len(up)-1 {
+		t.Fatalf("Expected the latest migration to be reverted but got %v", versions)
+	}
+
+	if err = migrator.Goto(ctx, 1); err != nil {
+		t.Fatalf("Failed to go to version 1: %v", err)
+	}
+
+	if versions := applied(); len(versions) != 1 || versions[0] != 1 {
+		t.Fatalf("Expected only version 1 to be applied but got %v", versions)
+	}
+
+	if err = migrator.Goto(ctx, 999); !errors.Is(err, storage.ErrUnknownVersion) {
+		t.Fatalf("Expected ErrUnknownVersion but got %v", err)
+	}
+
+	if err = migrator.Goto(ctx, 0); err != nil {
+		t.Fatalf("Failed to revert every migration: %v", err)
+	}
+
+	if _, err = db.Exec("SELECT user_id FROM users"); err == nil {
+		t.Errorf("Expected users table to be dropped")
+	}
+}
@@ -0,0 +1,24 @@
+package storage_test
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/andrii-stp/users-crud/storage/storagetest"
+)
+
+func TestMySQLUserRepository(t *testing.T) {
+	db := connect(t, mysqlTestDatabase(t))
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := storage.NewMySQLRepository(logger, db)
+
+	storagetest.Run(t, func(t *testing.T) storage.UserRepository {
+		if _, err := db.Exec("DELETE FROM user_audit; DELETE FROM user_events; DELETE FROM webhook_deliveries; DELETE FROM webhooks; DELETE FROM users_history; DELETE FROM users; UPDATE leases SET holder = '', expires_at = '1970-01-01 00:00:00';"); err != nil {
+			t.Fatalf("Failed to delete users: %v", err)
+		}
+
+		return repo
+	})
+}
@@ -0,0 +1,83 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/andrii-stp/users-crud/storage/storagetest"
+	"github.com/joho/godotenv"
+)
+
+func TestPostgresUserRepository(t *testing.T) {
+	cfg, err := config.Load("../test.env")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	db := connect(t, cfg.Database)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := storage.NewPostgresRepository(logger, db)
+
+	storagetest.Run(t, func(t *testing.T) storage.UserRepository {
+		if _, err := db.Exec("DELETE FROM user_audit; DELETE FROM user_events; DELETE FROM webhook_deliveries; DELETE FROM webhooks; DELETE FROM users_history; DELETE FROM users; UPDATE leases SET holder = '', expires_at = '1970-01-01 00:00:00';"); err != nil {
+			t.Fatalf("Failed to delete users: %v", err)
+		}
+
+		return repo
+	})
+}
+
+// mysqlTestDatabase returns the MySQL or MariaDB database the tests run
+// against. It's configured by the MYSQL_* variables, test.env holding the
+// DB_* ones for Postgres, and mysql.test.env fills in those left unset.
+func mysqlTestDatabase(t *testing.T) *config.Database {
+	t.Helper()
+
+	if err := godotenv.Load("../mysql.test.env"); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	return &config.Database{
+		Driver:   "mysql",
+		Host:     os.Getenv("MYSQL_HOST"),
+		User:     os.Getenv("MYSQL_USERNAME"),
+		Password: os.Getenv("MYSQL_PASSWORD"),
+		Name:     os.Getenv("MYSQL_DATABASE"),
+		SSLMode:  "disable",
+	}
+}
+
+// connect opens the database configured in cfg and migrates it up, the
+// test is skipped when the database is unavailable.
+func connect(t *testing.T, cfg *config.Database) *sql.DB {
+	t.Helper()
+
+	db, err := storage.Connect(cfg)
+	if err != nil {
+		t.Skipf("Database is unavailable: %v", err)
+	}
+
+	migrator, err := storage.NewMigrator(slog.New(slog.NewJSONHandler(os.Stdout, nil)), db, cfg.Driver)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	if err = migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := migrator.Goto(context.Background(), 0); err != nil {
+			t.Errorf("Failed to revert migrations: %v", err)
+		}
+
+		db.Close()
+	})
+
+	return db
+}
@@ -0,0 +1,97 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrii-stp/users-crud/config"
+	"github.com/andrii-stp/users-crud/model"
+	"github.com/andrii-stp/users-crud/storage"
+	"github.com/andrii-stp/users-crud/storage/storagetest"
+)
+
+func TestSQLiteUserRepository(t *testing.T) {
+	db := connect(t, &config.Database{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "users.db")})
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	repo := storage.NewSQLiteRepository(logger, db)
+
+	storagetest.Run(t, func(t *testing.T) storage.UserRepository {
+		if _, err := db.Exec("DELETE FROM user_audit; DELETE FROM user_events; DELETE FROM webhook_deliveries; DELETE FROM webhooks; DELETE FROM users_history; DELETE FROM users; UPDATE leases SET holder = '', expires_at = '1970-01-01 00:00:00';"); err != nil {
+			t.Fatalf("Failed to delete users: %v", err)
+		}
+
+		return repo
+	})
+}
+
+func TestSQLiteUndecodableEvent(t *testing.T) {
+	db := connect(t, &config.Database{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "users.db")})
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	repo := storage.NewSQLiteRepository(logger, db)
+
+	if err := repo.Create(context.Background(), storagetest.NewUser("alice")); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO user_events (event_type, user_id, occurred_at, payload) VALUES ('user.created', 1, ?, 'not json')", time.Now().UTC()); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+
+	if err := repo.Create(context.Background(), storagetest.NewUser("bob")); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	events, err := repo.ClaimEvents(context.Background(), "dispatcher", time.Now(), 10)
+	if err != nil {
+		t.Fatalf("Expected the undecodable event to be skipped but got %v", err)
+	}
+
+	if len(events) != 2 || events[0].User.UserName != "alice" || events[1].User.UserName != "bob" {
+		t.Fatalf("Expected the events of alice and bob but got %+v", events)
+	}
+
+	var pending int
+	if err = db.QueryRow("SELECT COUNT(*) FROM user_events").Scan(&pending); err != nil {
+		t.Fatalf("Failed to count events: %v", err)
+	}
+
+	if pending != 2 {
+		t.Errorf("Expected the undecodable event to be dropped but %d events are pending", pending)
+	}
+}
+
+func TestSQLiteReadsDontBlockWrites(t *testing.T) {
+	db := connect(t, &config.Database{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "users.db")})
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	repo := storage.NewSQLiteRepository(logger, db)
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := repo.Create(context.Background(), storagetest.NewUser(name)); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	// the listing is still being read while carol is created
+	err := repo.Each(context.Background(), storage.ListOptions{}, func(user model.User) error {
+		if user.UserName != "alice" {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		return repo.Create(ctx, storagetest.NewUser("carol"))
+	})
+	if err != nil {
+		t.Fatalf("Expected the write to go through while reading but got %v", err)
+	}
+
+	if _, err = repo.GetByUserName(context.Background(), "carol"); err != nil {
+		t.Errorf("Expected carol to be created but got %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 import { Component, OnDestroy, OnInit } from '@angular/core';
 import { MatDialog } from "@angular/material/dialog";
 import { UserDialog } from "./user-dialog/user-dialog.component";
-import { User } from './user';
+import { User, UserEvent } from './user';
 import { Subscription } from 'rxjs';
 import { UserService } from './user.service';
 
@@ -15,11 +15,12 @@ export class AppComponent implements OnInit, OnDestroy {
   dataSource: User[] = [];
   getAllSubscription!: Subscription;
   dialogSubscription!: Subscription;
+  eventsSubscription!: Subscription;
 
   constructor(public dialog: MatDialog, public service: UserService) {}
 
   openEditDialog(user: User) {
-    this.openDialog(new User(user.user_id, user.user_name, user.first_name, user.last_name, user.email, user.user_status, user.department));
+    this.openDialog(new User(user.id, user.user_name, user.first_name, user.last_name, user.email, user.user_status, user.department, user.version));
   }
 
   openNewDialog(): void {
@@ -27,11 +28,30 @@ export class AppComponent implements OnInit, OnDestroy {
   }
 
   private openDialog(user: User): void {
+    // the list follows the changes streamed by the server, it's reloaded
+    // anyway in case the stream is down
     this.dialogSubscription = this.dialog
       .open(UserDialog, {data: user, minWidth: '30%'})
       .afterClosed().subscribe(() => this.loadUserList());
   }
 
+  private applyEvent(event: UserEvent): void {
+    switch (event.type) {
+      // a user created while the list loads may be in it already
+      case 'UserCreated':
+      case 'UserUpdated':
+        this.dataSource = this.dataSource.some(user => user.id === event.user_id)
+          ? this.dataSource.map(user => user.id === event.user_id ? event.user : user)
+          : [...this.dataSource, event.user];
+        break;
+      case 'UserDeleted':
+        this.dataSource = this.dataSource.filter(user => user.id !== event.user_id);
+        break;
+      default:
+        this.loadUserList();
+    }
+  }
+
   private loadUserList(): void {
     this.getAllSubscription = this.service.getAll()
       .subscribe(users => this.dataSource = users);
@@ -39,10 +59,13 @@ export class AppComponent implements OnInit, OnDestroy {
 
   ngOnInit(): void {
     this.loadUserList();
+    this.eventsSubscription = this.service.events()
+      .subscribe(event => this.applyEvent(event));
   }
 
   ngOnDestroy(): void {
     this.getAllSubscription.unsubscribe();
+    this.eventsSubscription.unsubscribe();
     if (this.dialogSubscription) {
       this.dialogSubscription.unsubscribe();
     }
@@ -1,4 +1,5 @@
 import { Component, Inject, OnDestroy } from '@angular/core';
+import {HttpErrorResponse} from "@angular/common/http";
 import {MAT_DIALOG_DATA, MatDialogRef} from "@angular/material/dialog";
 import { Subscription } from 'rxjs';
 import { UserService } from '../user.service';
@@ -18,7 +19,10 @@ export class UserDialog implements OnDestroy {
   addSubscription!: Subscription;
   updateSubscription!: Subscription;
   deleteSubscription!: Subscription;
+  reloadSubscription!: Subscription;
   statuses: string[] = ['Active', 'Inactive', 'Terminated']
+  // set when someone else changed the user since the dialog was opened
+  conflict = false;
 
   constructor(
     @Inject(MAT_DIALOG_DATA) public user: User,
@@ -43,18 +47,44 @@ export class UserDialog implements OnDestroy {
     this.user.user_status = this.formValue('status');
     this.user.department = this.formValue('department')
 
-    if (!this.user.user_id) {
+    if (!this.user.id) {
       this.addSubscription = this.service.add(this.user)
-        .subscribe(this.dialogRef.close);
+        .subscribe(() => this.dialogRef.close());
     } else {
       this.updateSubscription = this.service.update(this.user)
-        .subscribe(this.dialogRef.close);
+        .subscribe({next: () => this.dialogRef.close(), error: error => this.failed(error)});
     }
   }
 
   delete(): void {
-    this.deleteSubscription = this.service.delete(this.user.user_id!)
-      .subscribe(this.dialogRef.close);
+    this.deleteSubscription = this.service.delete(this.user.id!, this.user.version)
+      .subscribe({next: () => this.dialogRef.close(), error: error => this.failed(error)});
+  }
+
+  // reload gets the user as someone else left it, the changes made in the
+  // dialog are lost
+  reload(): void {
+    this.reloadSubscription = this.service.get(this.user.id!)
+      .subscribe(user => {
+        Object.assign(this.user, user);
+        this.controlGroup.reset({
+          user_name: user.user_name,
+          first_name: user.first_name,
+          last_name: user.last_name,
+          email: user.email,
+          status: user.user_status,
+          department: user.department,
+        });
+        this.conflict = false;
+      });
+  }
+
+  private failed(error: HttpErrorResponse): void {
+    if (error.status !== 412) {
+      throw error;
+    }
+
+    this.conflict = true;
   }
 
   hasError(controlName: string, errorCode: string): boolean {
@@ -71,6 +101,9 @@ export class UserDialog implements OnDestroy {
     if (this.deleteSubscription) {
       this.deleteSubscription.unsubscribe();
     }
+    if (this.reloadSubscription) {
+      this.reloadSubscription.unsubscribe();
+    }
   }
 
   private formValue(controlName: string): any {
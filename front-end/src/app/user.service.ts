@@ -1,7 +1,10 @@
-import { Injectable } from '@angular/core';
+import { Injectable, NgZone } from '@angular/core';
 import {HttpClient, HttpHeaders} from "@angular/common/http";
-import {Observable} from "rxjs";
-import {User} from "./user";
+import {EMPTY, Observable, expand, reduce} from "rxjs";
+import {User, UserEvent, UserPage} from "./user";
+
+// pageLimit is the largest page the API serves
+const pageLimit = 1000;
 
 @Injectable({
   providedIn: 'root'
@@ -11,23 +14,84 @@ export class UserService {
   readonly headers = new HttpHeaders()
     .set('Content-Type', 'application/json');
 
-  constructor(private http: HttpClient) { }
+  constructor(private http: HttpClient, private zone: NgZone) { }
 
   getAll(): Observable<User[]> {
-    return this.http.get<User[]>(this.baseUrl, {headers: this.headers});
+    return this.allPages(this.baseUrl);
+  }
+
+  get(id: number): Observable<User> {
+    return this.http.get<User>(`${this.baseUrl}/${id}`, {headers: this.headers});
   }
 
   add(user: User): Observable<User> {
     return this.http.post<User>(this.baseUrl, user, {headers: this.headers});
   }
 
+  // a 412 response to update or delete means someone else changed the user
+  // since version was read
   update(user: User): Observable<User> {
     return this.http.put<User>(
-      `${this.baseUrl}/${user.user_id}`, user, {headers: this.headers}
+      `${this.baseUrl}/${user.id}`, user, {headers: this.ifMatch(user.version)}
+    );
+  }
+
+  patch(id: number, changes: Partial<User>): Observable<User> {
+    const headers = this.headers.set('Content-Type', 'application/merge-patch+json');
+
+    return this.http.patch<User>(`${this.baseUrl}/${id}`, changes, {headers});
+  }
+
+  delete(id: number, version?: number): Observable<User> {
+    return this.http.delete<User>(`${this.baseUrl}/${id}`, {headers: this.ifMatch(version)});
+  }
+
+  getTrash(): Observable<User[]> {
+    return this.allPages(`${this.baseUrl}/trash`);
+  }
+
+  restore(id: number): Observable<User> {
+    return this.http.post<User>(`${this.baseUrl}/${id}/restore`, null, {headers: this.headers});
+  }
+
+  // events streams the changes made to users, the browser reconnects on its
+  // own and resumes after the last event it got. A 'reset' event means some
+  // changes were missed and the list has to be reloaded.
+  events(): Observable<UserEvent> {
+    return new Observable<UserEvent>(subscriber => {
+      const source = new EventSource(`${this.baseUrl}/events`);
+      const next = (message: MessageEvent) => this.zone.run(() => subscriber.next(
+        message.type === 'reset' ? {type: 'reset'} as UserEvent : JSON.parse(message.data)
+      ));
+
+      for (const type of ['UserCreated', 'UserUpdated', 'UserDeleted', 'reset']) {
+        source.addEventListener(type, next);
+      }
+
+      return () => source.close();
+    });
+  }
+
+  revert(id: number, version: number): Observable<User> {
+    return this.http.post<User>(`${this.baseUrl}/${id}/revert`, null,
+      {headers: this.headers, params: {to_version: version}});
+  }
+
+  // allPages gets every user of a listing, following the cursor of each page
+  // until the last one
+  private allPages(url: string): Observable<User[]> {
+    const page = (cursor?: string) => this.http.get<UserPage>(url, {
+      headers: this.headers,
+      params: cursor ? {limit: pageLimit, cursor} : {limit: pageLimit},
+    });
+
+    return page().pipe(
+      expand(p => p.next_cursor ? page(p.next_cursor) : EMPTY),
+      reduce((users, p) => users.concat(p.items), [] as User[]),
     );
   }
 
-  delete(id: number): Observable<User> {
-    return this.http.delete<User>(`${this.baseUrl}/${id}`, {headers: this.headers});
+  private ifMatch(version?: number): HttpHeaders {
+    return version === undefined ? this.headers : this.headers.set('If-Match', `"${version}"`);
   }
 }
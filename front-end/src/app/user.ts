@@ -1,11 +1,30 @@
 export class User {
     constructor(
-      public user_id?: number,
+      public id?: number,
       public user_name?: string,
       public first_name?: string,
       public last_name?: string,
       public email?: string,
       public user_status?: string,
       public department?: string,
+      public version?: number,
+      public deleted_at?: string,
     ) {}
-  }
\ No newline at end of file
+  }
+
+export interface UserPage {
+  items: User[];
+  // left out of the pages fetched with a cursor
+  total?: number;
+  limit: number;
+  offset: number;
+  next_cursor?: string;
+}
+
+export interface UserEvent {
+  id: number;
+  type: 'UserCreated' | 'UserUpdated' | 'UserDeleted' | 'reset';
+  user_id: number;
+  occurred_at: string;
+  user: User;
+}